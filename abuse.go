@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AbuseSettings control temporary IP bans. A client is banned when, within
+// one window, it fails authentication AuthFailures times or gets Errors 4xx
+// responses. Zero disables a threshold; a zero window or ban duration keeps
+// the default.
+type AbuseSettings struct {
+	AuthFailures int      `json:"auth_failures"`
+	Errors       int      `json:"errors"`
+	Window       Duration `json:"window"`
+	BanDuration  Duration `json:"ban_duration"`
+	Allowlist    []string `json:"allowlist"` // IPs or CIDRs that are never banned
+}
+
+func (s AbuseSettings) validate() error {
+	if s.AuthFailures < 0 || s.Errors < 0 {
+		return fmt.Errorf("abuse: thresholds must not be negative")
+	}
+	if s.Window < 0 || s.BanDuration < 0 {
+		return fmt.Errorf("abuse: window and ban_duration must not be negative")
+	}
+	_, err := parsePrefixes(s.Allowlist)
+	return err
+}
+
+// parsePrefixes reads IPs and CIDRs; a bare IP is a single-address prefix
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the connecting client
+func clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+type clientActivity struct {
+	windowStart  time.Time
+	authFailures int
+	errors       int
+}
+
+// Ban is a temporarily blocked client
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+var defaultAbuseSettings = AbuseSettings{
+	AuthFailures: 20,
+	Errors:       300,
+	Window:       Duration(5 * time.Minute),
+	BanDuration:  Duration(15 * time.Minute),
+}
+
+var abuse = struct {
+	mu        sync.Mutex
+	settings  AbuseSettings
+	allowlist []netip.Prefix
+	activity  map[netip.Addr]*clientActivity
+	bans      map[netip.Addr]Ban
+	lastSweep time.Time
+}{
+	settings: defaultAbuseSettings,
+	activity: map[netip.Addr]*clientActivity{},
+	bans:     map[netip.Addr]Ban{},
+}
+
+func setAbuseSettings(settings AbuseSettings) {
+	if settings.Window == 0 {
+		settings.Window = defaultAbuseSettings.Window
+	}
+	if settings.BanDuration == 0 {
+		settings.BanDuration = defaultAbuseSettings.BanDuration
+	}
+	allowlist, _ := parsePrefixes(settings.Allowlist)
+	abuse.mu.Lock()
+	abuse.settings = settings
+	abuse.allowlist = allowlist
+	abuse.mu.Unlock()
+}
+
+// activeBan returns the client's ban, if it has one
+func activeBan(addr netip.Addr, now time.Time) (Ban, bool) {
+	abuse.mu.Lock()
+	defer abuse.mu.Unlock()
+	ban, ok := abuse.bans[addr]
+	if ok && now.After(ban.Until) {
+		delete(abuse.bans, addr)
+		return Ban{}, false
+	}
+	return ban, ok
+}
+
+// recordResponse counts a failed response against the client and bans it if
+// it crosses a threshold
+func recordResponse(addr netip.Addr, status int, now time.Time) {
+	if status < 400 || status >= 500 || status == http.StatusTooManyRequests {
+		return
+	}
+	abuse.mu.Lock()
+	defer abuse.mu.Unlock()
+	settings := abuse.settings
+	if prefixesContain(abuse.allowlist, addr) {
+		return
+	}
+	window := time.Duration(settings.Window)
+	if now.Sub(abuse.lastSweep) >= window {
+		for client, activity := range abuse.activity {
+			if now.Sub(activity.windowStart) >= window {
+				delete(abuse.activity, client)
+			}
+		}
+		abuse.lastSweep = now
+	}
+
+	activity := abuse.activity[addr]
+	if activity == nil || now.Sub(activity.windowStart) >= window {
+		activity = &clientActivity{windowStart: now}
+		abuse.activity[addr] = activity
+	}
+	activity.errors++
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		activity.authFailures++
+	}
+
+	var reason string
+	switch {
+	case settings.AuthFailures > 0 && activity.authFailures >= settings.AuthFailures:
+		reason = fmt.Sprintf("%d authentication failures in %v", activity.authFailures, window)
+	case settings.Errors > 0 && activity.errors >= settings.Errors:
+		reason = fmt.Sprintf("%d client errors in %v", activity.errors, window)
+	default:
+		return
+	}
+	ban := Ban{IP: addr.String(), Reason: reason, Since: now.UTC(), Until: now.Add(time.Duration(settings.BanDuration)).UTC()}
+	abuse.bans[addr] = ban
+	delete(abuse.activity, addr)
+	slog.Warn("Client banned", "ip", ban.IP, "reason", reason, "until", ban.Until)
+}
+
+// detectAbuse refuses banned clients and watches everyone else's responses
+// for brute-force attempts and error floods
+func detectAbuse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := clientIP(r)
+		now := time.Now()
+		if ban, ok := activeBan(addr, now); ok {
+			enableCORS(w)
+			w.Header().Set("Retry-After", strconv.Itoa(int(ban.Until.Sub(now).Seconds())+1))
+			http.Error(w, "Too many failed requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		recordResponse(addr, recorder.status, now)
+	})
+}
+
+func handleBanList(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	abuse.mu.Lock()
+	result := []Ban{}
+	for addr, ban := range abuse.bans {
+		if now.After(ban.Until) {
+			delete(abuse.bans, addr)
+			continue
+		}
+		result = append(result, ban)
+	}
+	abuse.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Since.Before(result[j].Since) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleUnban lifts a ban early
+func handleUnban(w http.ResponseWriter, r *http.Request) {
+	addr, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		http.Error(w, "Invalid IP", http.StatusBadRequest)
+		return
+	}
+	abuse.mu.Lock()
+	_, ok := abuse.bans[addr.Unmap()]
+	delete(abuse.bans, addr.Unmap())
+	abuse.mu.Unlock()
+	if !ok {
+		http.Error(w, "Ban not found", http.StatusNotFound)
+		return
+	}
+	slog.Info("Client unbanned", "ip", addr.String())
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The anomaly check compares each tenant's last day of roster changes with
+// the days before it, as far back as anomalyBaselineDays and the change feed
+// reach, and flags what simple statistics say is unusual:
+//
+//   - mass deletions: a large share of the roster, or far more than usual
+//   - creation spikes: far more students created than usual
+//   - age outliers: students written that day whose age is far from the
+//     tenant's median, by modified z-score
+//
+// Admins are emailed what it finds. Only counts and student IDs are used, so
+// the optional LLM explanation sees no names or emails.
+
+const (
+	anomalyWindow          = 24 * time.Hour
+	anomalyBaselineDays    = 28
+	anomalyMinBaselineDays = 7   // spikes need this much history to compare with
+	anomalyMinCount        = 10  // fewer changes in a day are never a spike or mass deletion
+	anomalySigmas          = 3.0 // standard deviations above the baseline mean that make a spike
+	anomalyDeletionShare   = 0.1 // deleting this share of a roster in a day is a mass deletion
+	anomalyAgeScore        = 3.5 // modified z-score beyond which an age is an outlier
+	anomalyMinMAD          = 1.0 // years; keeps rosters of one age from flagging every other age
+	maxAnomalyRuns         = 30  // runs kept for GET /admin/anomalies
+	maxAnomalyStudents     = 20  // outliers listed per tenant
+)
+
+const (
+	AnomalyMassDeletion  = "mass_deletion"
+	AnomalyCreationSpike = "creation_spike"
+	AnomalyAgeOutlier    = "age_outlier"
+)
+
+var (
+	anomalySchedule string   // cron, in UTC; empty disables the check
+	anomalyEmails   []string // admins notified of anomalies
+	anomalyExplain  bool     // ask the LLM to explain what was found
+
+	anomalyRuns      []AnomalyRun // that found anomalies, newest last
+	anomalyLastCheck time.Time
+	anomalyRunning   bool
+	anomalyMutex     sync.Mutex
+)
+
+type Anomaly struct {
+	Kind      string  `json:"kind"`
+	Tenant    string  `json:"tenant,omitempty"`
+	Count     int     `json:"count"`                   // deletions, creations or outliers that day
+	Baseline  float64 `json:"baseline_mean,omitempty"` // per day, for spikes
+	Threshold float64 `json:"threshold,omitempty"`
+	Students  []int64 `json:"students,omitempty"` // the outliers, up to maxAnomalyStudents
+	Message   string  `json:"message"`
+}
+
+// AnomalyRun is one check of the day up to To
+type AnomalyRun struct {
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	BaselineDays int       `json:"baseline_days"` // days before From the feed covers
+	Anomalies    []Anomaly `json:"anomalies"`
+	Explanation  string    `json:"explanation,omitempty"`
+	Notified     []string  `json:"notified,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DryRun       bool      `json:"dry_run,omitempty"`
+}
+
+// tenantActivity counts a tenant's creations and deletions per day, day 0
+// being the day checked
+type tenantActivity struct {
+	created, deleted [anomalyBaselineDays + 1]int
+	written          map[int64]Student // created or updated on day 0, as last written
+}
+
+// meanStddev describes the counts of the baseline days
+func meanStddev(counts []int) (mean, stddev float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+	for _, count := range counts {
+		mean += float64(count)
+	}
+	mean /= float64(len(counts))
+	for _, count := range counts {
+		stddev += (float64(count) - mean) * (float64(count) - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(counts)))
+}
+
+// medianAge is the median of an age histogram
+func medianAge(ages []int, count int) int {
+	seen := 0
+	for age, n := range ages {
+		if seen += n; seen >= (count+1)/2 {
+			return age
+		}
+	}
+	return 0
+}
+
+// ageSpreadLocked is the median age of a tenant's students and the median
+// absolute deviation from it, read from the running stats. Callers must hold
+// mutex.
+func ageSpreadLocked(stats *rosterStats) (median int, mad float64) {
+	median = medianAge(stats.ages[:], stats.count)
+	var deviations [maxStudentAge + 1]int
+	for age, n := range stats.ages {
+		deviations[max(age-median, median-age)] += n
+	}
+	return median, max(float64(medianAge(deviations[:], stats.count)), anomalyMinMAD)
+}
+
+// detectAnomalies checks the day up to now
+func detectAnomalies(now time.Time) AnomalyRun {
+	run := AnomalyRun{From: now.Add(-anomalyWindow), To: now, Anomalies: []Anomaly{}}
+	earliest := now.Add(-anomalyWindow * (anomalyBaselineDays + 1))
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if len(changes) > 0 && changes[0].OccurredAt.After(earliest) {
+		// days before the feed starts would count as empty
+		run.BaselineDays = max(int(run.From.Sub(changes[0].OccurredAt)/anomalyWindow), 0)
+	} else {
+		run.BaselineDays = anomalyBaselineDays
+	}
+	activity := map[string]*tenantActivity{}
+	for i := len(changes) - 1; i >= 0 && !changes[i].OccurredAt.Before(earliest); i-- {
+		change := changes[i]
+		if change.OccurredAt.After(now) {
+			continue
+		}
+		day := int(now.Sub(change.OccurredAt) / anomalyWindow)
+		if day > run.BaselineDays {
+			continue
+		}
+		tenant := activity[change.Student.Tenant]
+		if tenant == nil {
+			tenant = &tenantActivity{written: map[int64]Student{}}
+			activity[change.Student.Tenant] = tenant
+		}
+		switch change.Event {
+		case EventStudentCreated:
+			tenant.created[day]++
+		case EventStudentDeleted:
+			tenant.deleted[day]++
+		}
+		if _, seen := tenant.written[change.Student.ID]; day == 0 && !seen {
+			if change.Event == EventStudentDeleted {
+				tenant.written[change.Student.ID] = Student{} // gone since; not an outlier
+			} else {
+				tenant.written[change.Student.ID] = change.Student
+			}
+		}
+	}
+
+	names := make([]string, 0, len(activity))
+	for name := range activity {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tenant := activity[name]
+		stats := tenantStats[name]
+		current := 0
+		if stats != nil {
+			current = stats.count
+		}
+		label := name
+		if label == "" {
+			label = "the default tenant"
+		}
+
+		deleted, created := tenant.deleted[0], tenant.created[0]
+		had := current + deleted // at the start of the day, or created during it
+		deletedMean, deletedStddev := meanStddev(tenant.deleted[1 : run.BaselineDays+1])
+		createdMean, createdStddev := meanStddev(tenant.created[1 : run.BaselineDays+1])
+		deletedThreshold := deletedMean + anomalySigmas*deletedStddev
+		createdThreshold := createdMean + anomalySigmas*createdStddev
+		enoughHistory := run.BaselineDays >= anomalyMinBaselineDays
+
+		if deleted >= anomalyMinCount && (float64(deleted) >= anomalyDeletionShare*float64(had) ||
+			enoughHistory && float64(deleted) > deletedThreshold) {
+			anomaly := Anomaly{Kind: AnomalyMassDeletion, Tenant: name, Count: deleted,
+				Message: fmt.Sprintf("%d of %d students in %s were deleted", deleted, had, label)}
+			if enoughHistory {
+				anomaly.Baseline, anomaly.Threshold = deletedMean, deletedThreshold
+				anomaly.Message += fmt.Sprintf("; usually %.1f a day", deletedMean)
+			}
+			run.Anomalies = append(run.Anomalies, anomaly)
+		}
+		if created >= anomalyMinCount && enoughHistory && float64(created) > createdThreshold {
+			run.Anomalies = append(run.Anomalies, Anomaly{
+				Kind: AnomalyCreationSpike, Tenant: name, Count: created, Baseline: createdMean, Threshold: createdThreshold,
+				Message: fmt.Sprintf("%d students were created in %s; usually %.1f a day", created, label, createdMean),
+			})
+		}
+
+		if stats == nil || stats.count < anomalyMinCount {
+			continue
+		}
+		median, mad := ageSpreadLocked(stats)
+		outliers := []int64{}
+		for id, student := range tenant.written {
+			if student.ID != 0 && math.Abs(0.6745*float64(student.Age-median)/mad) > anomalyAgeScore {
+				outliers = append(outliers, id)
+			}
+		}
+		if len(outliers) > 0 {
+			sort.Slice(outliers, func(i, j int) bool { return outliers[i] < outliers[j] })
+			run.Anomalies = append(run.Anomalies, Anomaly{
+				Kind: AnomalyAgeOutlier, Tenant: name, Count: len(outliers), Students: outliers[:min(len(outliers), maxAnomalyStudents)],
+				Message: fmt.Sprintf("students written in %s with ages far from the median of %d: %d", label, median, len(outliers)),
+			})
+		}
+	}
+	return run
+}
+
+// explainAnomalies asks the model for likely causes and what to check. It
+// isn't cancelled with the request that started the check: the run is
+// recorded and notified either way.
+func explainAnomalies(anomalies []Anomaly) (string, error) {
+	if err := reserveLLMCall(); err != nil {
+		return "", err
+	}
+	if simulateOllamaFailure() {
+		return "", errSimulatedOllamaFailure
+	}
+	facts := make([]string, len(anomalies))
+	for i, anomaly := range anomalies {
+		facts[i] = anomaly.Message
+	}
+	prompt := "A student roster system flagged these unusual changes in the last day. For school administrators, " +
+		"briefly suggest likely causes, such as a bulk import or a faulty integration, and what to check.\n- " +
+		strings.Join(facts, "\n- ")
+	canned := "Worth checking: " + strings.Join(facts, "; ") + "."
+	return llmGenerate(context.Background(), LLMRequest{Prompt: prompt, Canned: canned}, "")
+}
+
+// notifyAnomalies emails a run's findings to the admins
+func notifyAnomalies(run *AnomalyRun) {
+	subject := fmt.Sprintf("%d roster anomalies, %s", len(run.Anomalies), run.To.Format("2 Jan 2006"))
+	lines := []string{fmt.Sprintf("Roster changes from %s to %s:", run.From.Format(time.RFC3339), run.To.Format(time.RFC3339)), ""}
+	for _, anomaly := range run.Anomalies {
+		line := "- " + anomaly.Message
+		if len(anomaly.Students) > 0 {
+			ids := make([]string, len(anomaly.Students))
+			for i, id := range anomaly.Students {
+				ids[i] = fmt.Sprint(id)
+			}
+			line += " (students " + strings.Join(ids, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	if run.Explanation != "" {
+		lines = append(lines, "", run.Explanation)
+	}
+	body := strings.Join(lines, "\n")
+	var failed []string
+	for _, to := range anomalyEmails {
+		if err := sendMail(to, subject, body); err != nil {
+			failed = append(failed, to+": "+err.Error())
+			continue
+		}
+		run.Notified = append(run.Notified, to)
+	}
+	if len(failed) > 0 {
+		run.Error = "failed to notify " + strings.Join(failed, "; ")
+	}
+}
+
+var errAnomalyCheckRunning = newError(ErrConflict, "an anomaly check is already running")
+
+// runAnomalyCheck checks the last day, explains and notifies what it finds
+// and records the run. A dry run only detects.
+func runAnomalyCheck(now time.Time, dryRun bool) (AnomalyRun, error) {
+	anomalyMutex.Lock()
+	if anomalyRunning {
+		anomalyMutex.Unlock()
+		return AnomalyRun{}, errAnomalyCheckRunning
+	}
+	anomalyRunning = true
+	anomalyMutex.Unlock()
+	defer func() {
+		anomalyMutex.Lock()
+		anomalyRunning = false
+		anomalyMutex.Unlock()
+	}()
+
+	run := detectAnomalies(now.UTC())
+	run.DryRun = dryRun
+	if dryRun {
+		return run, nil
+	}
+	anomalyMutex.Lock()
+	anomalyLastCheck = run.To
+	anomalyMutex.Unlock()
+	if len(run.Anomalies) == 0 {
+		return run, nil
+	}
+	slog.Warn("Roster anomalies detected", "count", len(run.Anomalies), "from", run.From, "to", run.To)
+	if anomalyExplain {
+		explanation, err := explainAnomalies(run.Anomalies)
+		if err != nil {
+			slog.Warn("Failed to explain roster anomalies", "error", err)
+		}
+		run.Explanation = explanation
+	}
+	notifyAnomalies(&run)
+
+	anomalyMutex.Lock()
+	anomalyRuns = append(anomalyRuns, run)
+	if len(anomalyRuns) > maxAnomalyRuns {
+		anomalyRuns = anomalyRuns[len(anomalyRuns)-maxAnomalyRuns:]
+	}
+	anomalyMutex.Unlock()
+	return run, nil
+}
+
+// runAnomalyScheduler runs the check on its schedule
+func runAnomalyScheduler(schedule cronSchedule) {
+	for {
+		next := schedule.next(time.Now().UTC())
+		if next.IsZero() {
+			return
+		}
+		time.Sleep(time.Until(next))
+		if _, err := runAnomalyCheck(time.Now(), false); err != nil {
+			slog.Warn("Skipped the anomaly check", "error", err)
+		}
+	}
+}
+
+type AnomalyStatus struct {
+	Schedule  string       `json:"schedule,omitempty"`
+	Notifies  int          `json:"notifies"` // admin addresses
+	Explain   bool         `json:"explain"`
+	LastCheck *time.Time   `json:"last_check,omitempty"`
+	Runs      []AnomalyRun `json:"runs"` // that found anomalies, newest first
+}
+
+func handleAnomalyList(w http.ResponseWriter, r *http.Request) {
+	status := AnomalyStatus{Schedule: anomalySchedule, Notifies: len(anomalyEmails), Explain: anomalyExplain, Runs: []AnomalyRun{}}
+	anomalyMutex.Lock()
+	if !anomalyLastCheck.IsZero() {
+		lastCheck := anomalyLastCheck
+		status.LastCheck = &lastCheck
+	}
+	for i := len(anomalyRuns) - 1; i >= 0; i-- {
+		status.Runs = append(status.Runs, anomalyRuns[i])
+	}
+	anomalyMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleAnomalyRun checks the last day now. ?dry_run=true reports without
+// explaining, notifying or recording.
+func handleAnomalyRun(w http.ResponseWriter, r *http.Request) {
+	run, err := runAnomalyCheck(time.Now(), r.URL.Query().Get("dry_run") == "true")
+	if err != nil {
+		writeError(w, r, err, "Failed to check for anomalies")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
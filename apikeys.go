@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleWrite = "write"
+	RoleRead  = "read"
+)
+
+// APIKey is a scoped credential. Only the SHA-256 hash of the secret is kept;
+// the plaintext is returned once, when the key is created or rotated.
+type APIKey struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	Scopes    []string   `json:"scopes,omitempty"` // narrows the role; see scopes.go
+	Tenant    string     `json:"tenant,omitempty"`
+	Prefix    string     `json:"prefix"`
+	RateLimit int        `json:"rate_limit"` // requests per minute, 0 is unlimited
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	// Compatibility overrides the global strict/lenient JSON mode for this key
+	Compatibility string `json:"compatibility,omitempty"`
+	// Envelope and Naming override the global response shape for this key;
+	// see responseshape.go
+	Envelope string `json:"envelope,omitempty"`
+	Naming   string `json:"naming,omitempty"`
+	// UserID and SessionID are set on access keys issued by signing in
+	UserID    int `json:"user_id,omitempty"`
+	SessionID int `json:"session_id,omitempty"`
+	// ImpersonatedBy is set on keys from POST /admin/impersonate
+	ImpersonatedBy      string `json:"impersonated_by,omitempty"`
+	ImpersonationReason string `json:"impersonation_reason,omitempty"`
+
+	hash        string
+	enrollOnly  bool // an admin's sign-in before they enrolled a second factor
+	windowStart time.Time
+	windowCount int
+}
+
+// IssuedAPIKey is the response to create and rotate, the only time the secret is shown
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type apiKeyContextKey struct{}
+
+// publicPaths never need an API key, even with -require-api-key, and neither
+// do the scripts under /static/
+var publicPaths = map[string]bool{
+	"/signup":                      true,
+	"/signup/verify":               true,
+	"/auth/login":                  true,
+	"/auth/refresh":                true,
+	"/auth/password-reset":         true,
+	"/auth/password-reset/confirm": true,
+	"/public/roster":               true,
+}
+
+var (
+	apiKeys       []*APIKey
+	apiKeySeq     int
+	apiKeysMutex  sync.Mutex
+	requireAPIKey bool
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "fx_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func validRole(role string) bool {
+	return role == RoleAdmin || role == RoleWrite || role == RoleRead
+}
+
+// keyFromContext returns the API key that authenticated the request, if any
+func keyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// lookupAPIKey resolves a presented secret. The ADMIN_API_KEY secret acts as
+// a bootstrap admin key so the first scoped keys can be created.
+func lookupAPIKey(secret string) *APIKey {
+	hash := hashAPIKey(secret)
+	if bootstrap := getSecret("ADMIN_API_KEY"); bootstrap != "" &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKey(bootstrap))) == 1 {
+		return &APIKey{Name: "bootstrap", Role: RoleAdmin}
+	}
+
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(key.hash)) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+// allow applies the key's per-minute rate limit
+func (k *APIKey) allow(now time.Time) bool {
+	if k.RateLimit <= 0 {
+		return true
+	}
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	if now.Sub(k.windowStart) >= time.Minute {
+		k.windowStart = now
+		k.windowCount = 0
+	}
+	if k.windowCount >= k.RateLimit {
+		return false
+	}
+	k.windowCount++
+	return true
+}
+
+// authenticate checks the API key on every request. Admin and debug routes
+// always need a key; other routes accept anonymous requests unless
+// -require-api-key is set. Signed links (see signedurls.go) stand in for a
+// key. What a key may do is checked by authorize.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := requestAPIKey(r)
+		fromCookie := false
+		if cookie, err := r.Cookie(sessionCookie); secret == "" && err == nil {
+			secret, fromCookie = cookie.Value, true
+		}
+		if secret == "" && r.URL.Query().Get("sig") != "" {
+			key, err := signedURLKey(r, time.Now())
+			if err != nil {
+				enableCORS(w)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			meterAPICall(key.Tenant)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+			return
+		}
+		if secret == "" {
+			if (requireAPIKey && !publicPaths[r.URL.Path] && !strings.HasPrefix(r.URL.Path, "/static/")) || isAdminPath(r.URL.Path) {
+				enableCORS(w)
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := lookupAPIKey(secret)
+		now := time.Now()
+		if key == nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+			enableCORS(w)
+			http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
+			return
+		}
+		if fromCookie && !safeMethod(r.Method) {
+			if err := checkCSRF(r, key.SessionID); err != nil {
+				enableCORS(w)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if key.enrollOnly && !strings.HasPrefix(r.URL.Path, "/auth/totp/") {
+			enableCORS(w)
+			http.Error(w, "Enroll two-factor authentication first: POST /auth/totp/enroll", http.StatusForbidden)
+			return
+		}
+		if key.ImpersonatedBy != "" && strings.HasPrefix(r.URL.Path, "/auth/") {
+			enableCORS(w)
+			http.Error(w, "Impersonation keys can't manage accounts", http.StatusForbidden)
+			return
+		}
+		if key.Tenant != "" {
+			// A deleted tenant's leftover keys must not come back to life if
+			// the name is reused
+			tenant, ok := lookupTenant(key.Tenant)
+			if !ok {
+				enableCORS(w)
+				http.Error(w, "Tenant has been deleted", http.StatusForbidden)
+				return
+			}
+			if tenant.Status != TenantActive {
+				enableCORS(w)
+				http.Error(w, "Tenant is "+tenant.Status, http.StatusForbidden)
+				return
+			}
+		}
+		if !key.allow(now) {
+			enableCORS(w)
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		meterAPICall(key.Tenant)
+
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key))
+		if key.ImpersonatedBy != "" {
+			auditImpersonation(key, next, w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIKeyRequest is the body of POST /admin/api-keys
+type APIKeyRequest struct {
+	Name          string     `json:"name"`
+	Role          string     `json:"role"`
+	Scopes        []string   `json:"scopes"`
+	Tenant        string     `json:"tenant"`
+	RateLimit     int        `json:"rate_limit"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	Compatibility string     `json:"compatibility"`
+	Envelope      string     `json:"envelope"`
+	Naming        string     `json:"naming"`
+}
+
+func handleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	var input APIKeyRequest
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &input); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		input.Name = r.FormValue("name")
+		input.Role = r.FormValue("role")
+		if value := r.FormValue("scopes"); value != "" {
+			input.Scopes = strings.Split(value, ",")
+		}
+		input.Tenant = r.FormValue("tenant")
+		input.Compatibility = r.FormValue("compatibility")
+		input.Envelope = r.FormValue("envelope")
+		input.Naming = r.FormValue("naming")
+		if value := r.FormValue("rate_limit"); value != "" {
+			rateLimit, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid rate_limit: must be a number", http.StatusBadRequest)
+				return
+			}
+			input.RateLimit = rateLimit
+		}
+		if value := r.FormValue("expires_at"); value != "" {
+			expiresAt, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid expires_at: must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			input.ExpiresAt = &expiresAt
+		}
+	}
+
+	if input.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if !validRole(input.Role) {
+		http.Error(w, "role must be one of admin, write or read", http.StatusBadRequest)
+		return
+	}
+	if err := validateScopes(input.Role, input.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller := keyFromContext(r.Context())
+	if caller != nil && input.Tenant == "" {
+		input.Tenant = caller.Tenant
+	}
+	if _, ok := lookupTenant(input.Tenant); input.Tenant != "" && !ok {
+		http.Error(w, "Unknown tenant", http.StatusBadRequest)
+		return
+	}
+	if err := checkGrant(caller, input.Role, input.Scopes, input.Tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if input.RateLimit < 0 {
+		http.Error(w, "rate_limit must not be negative", http.StatusBadRequest)
+		return
+	}
+	if input.Compatibility != "" && !validCompatibility(input.Compatibility) {
+		http.Error(w, "compatibility must be strict or lenient", http.StatusBadRequest)
+		return
+	}
+	if input.Envelope != "" && !validEnvelope(input.Envelope) {
+		http.Error(w, "envelope must be none or data", http.StatusBadRequest)
+		return
+	}
+	if input.Naming != "" && !validNaming(input.Naming) {
+		http.Error(w, "naming must be snake or camel", http.StatusBadRequest)
+		return
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+	key := &APIKey{
+		Name:      input.Name,
+		Role:      input.Role,
+		Scopes:    input.Scopes,
+		Tenant:    input.Tenant,
+		Prefix:    secret[:8],
+		RateLimit: input.RateLimit,
+		ExpiresAt: input.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+		hash:      hashAPIKey(secret),
+
+		Compatibility: input.Compatibility,
+		Envelope:      input.Envelope,
+		Naming:        input.Naming,
+	}
+
+	apiKeysMutex.Lock()
+	apiKeySeq++
+	key.ID = apiKeySeq
+	apiKeys = append(apiKeys, key)
+	issued := IssuedAPIKey{APIKey: *key, Key: secret}
+	apiKeysMutex.Unlock()
+	saveAuthState()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issued)
+}
+
+// handleAPIKeyList lists the keys, only its own tenant's to a tenant's key
+func handleAPIKeyList(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenantName(r)
+	apiKeysMutex.Lock()
+	result := make([]APIKey, 0, len(apiKeys))
+	for _, key := range apiKeys {
+		if tenant == "" || key.Tenant == tenant {
+			result = append(result, *key)
+		}
+	}
+	apiKeysMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// apiKeyTenant is the tenant of the key named by the request's {id}
+func apiKeyTenant(r *http.Request) (string, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return "", false
+	}
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	for _, key := range apiKeys {
+		if key.ID == id {
+			return key.Tenant, true
+		}
+	}
+	return "", false
+}
+
+// handleAPIKeyRotate issues a new secret for an existing key. The old secret
+// stops working immediately.
+func handleAPIKeyRotate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	secret, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	apiKeysMutex.Lock()
+	var issued *IssuedAPIKey
+	for _, key := range apiKeys {
+		if key.ID == id {
+			key.hash = hashAPIKey(secret)
+			key.Prefix = secret[:8]
+			issued = &IssuedAPIKey{APIKey: *key, Key: secret}
+			break
+		}
+	}
+	apiKeysMutex.Unlock()
+	if issued == nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	saveAuthState()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issued)
+}
+
+func handleAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	apiKeysMutex.Lock()
+	found := false
+	for i, key := range apiKeys {
+		if key.ID == id {
+			apiKeys = append(apiKeys[:i], apiKeys[i+1:]...)
+			found = true
+			break
+		}
+	}
+	apiKeysMutex.Unlock()
+	if !found {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	saveAuthState()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// The pages' CSS and scripts are embedded and served under names that
+// include a hash of their content, e.g. /static/app.1a2b3c4d.css, so they can
+// be cached forever and a new build is picked up immediately. Templates link
+// them with {{asset "app.css"}}.
+//
+//go:generate sh scripts/vendor-htmx.sh
+//go:embed static
+var staticFiles embed.FS
+
+type asset struct {
+	name string
+	data []byte
+	etag string
+}
+
+var (
+	assetURLs = map[string]string{} // name -> hashed URL
+	assets    = map[string]asset{}  // hashed or plain name -> asset
+)
+
+func init() {
+	entries, err := fs.ReadDir(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		data, err := staticFiles.ReadFile("static/" + name)
+		if err != nil {
+			panic(err)
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:4])
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hash + ext
+		a := asset{name: name, data: data, etag: `"` + hash + `"`}
+		assets[name], assets[hashed] = a, a
+		assetURLs[name] = "/static/" + hashed
+	}
+}
+
+// assetURL is the template helper for linking an embedded asset
+func assetURL(name string) (string, error) {
+	url, ok := assetURLs[name]
+	if !ok {
+		return "", fmt.Errorf("unknown asset %q", name)
+	}
+	return url, nil
+}
+
+// htmxURL links the vendored htmx release, or the hx-lite.js fallback when
+// `go generate` hasn't fetched it
+func htmxURL() string {
+	if url, ok := assetURLs["htmx.min.js"]; ok {
+		return url
+	}
+	return assetURLs["hx-lite.js"]
+}
+
+// handleStatic serves embedded assets. Hashed names never change content and
+// are cached for a year; plain names still work but must be revalidated.
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/static/")
+	a, ok := assets[name]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+	if assetURLs[name] != "" {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Header().Set("ETag", a.etag)
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.data))
+}
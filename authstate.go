@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// authState is what the auth system keeps between restarts: users, their
+// sessions, API keys and tenants. It is small, so it is saved whole after
+// every change, wherever the roster is kept: beside the write-ahead log with
+// -wal, and in the store's database with a SQL -store. Without either it is
+// lost on restart, like the roster. Password reset tokens and pending signups
+// are not saved; they have to be asked for again.
+type authState struct {
+	// Version increases with every save, so the newer of two copies wins
+	Version    int64           `json:"version"`
+	Users      []storedUser    `json:"users"`
+	UserSeq    int             `json:"user_seq"`
+	Sessions   []storedSession `json:"sessions"`
+	SessionSeq int             `json:"session_seq"`
+	APIKeys    []storedAPIKey  `json:"api_keys"`
+	APIKeySeq  int             `json:"api_key_seq"`
+	Tenants    []Tenant        `json:"tenants"`
+}
+
+// storedUser, storedSession and storedAPIKey add the secrets' hashes, which
+// responses never include
+type storedUser struct {
+	User
+	PasswordHash      string   `json:"password_hash"`
+	TOTPSecret        []byte   `json:"totp_secret,omitempty"`
+	PendingTOTPSecret []byte   `json:"pending_totp_secret,omitempty"`
+	TOTPLastStep      int64    `json:"totp_last_step,omitempty"`
+	BackupCodes       []string `json:"backup_codes,omitempty"`
+}
+
+type storedSession struct {
+	Session
+	RefreshHash     string `json:"refresh_hash"`
+	PrevRefreshHash string `json:"prev_refresh_hash,omitempty"`
+	CSRFToken       string `json:"csrf_token"`
+}
+
+type storedAPIKey struct {
+	APIKey
+	Hash       string `json:"hash"`
+	EnrollOnly bool   `json:"enroll_only,omitempty"`
+}
+
+var (
+	// authSaveMutex makes saves take turns, so the last one written holds
+	// the latest state
+	authSaveMutex sync.Mutex
+	authVersion   int64
+)
+
+// authStatePath is where the auth state is kept beside the write-ahead log
+func (l *writeAheadLog) authStatePath() string {
+	return l.path + ".auth"
+}
+
+// snapshotAuthState copies the auth state, taking each lock in turn
+func snapshotAuthState() authState {
+	var state authState
+	usersMutex.Lock()
+	for _, user := range users {
+		state.Users = append(state.Users, storedUser{User: *user, PasswordHash: user.passwordHash,
+			TOTPSecret: user.totpSecret, PendingTOTPSecret: user.pendingTOTPSecret,
+			TOTPLastStep: user.totpLastStep, BackupCodes: user.backupCodes})
+	}
+	state.UserSeq = userSeq
+	usersMutex.Unlock()
+
+	sessionsMutex.Lock()
+	for _, session := range sessions {
+		state.Sessions = append(state.Sessions, storedSession{Session: *session, RefreshHash: session.refreshHash,
+			PrevRefreshHash: session.prevRefreshHash, CSRFToken: session.csrfToken})
+	}
+	state.SessionSeq = sessionSeq
+	sessionsMutex.Unlock()
+
+	apiKeysMutex.Lock()
+	for _, key := range apiKeys {
+		state.APIKeys = append(state.APIKeys, storedAPIKey{APIKey: *key, Hash: key.hash, EnrollOnly: key.enrollOnly})
+	}
+	state.APIKeySeq = apiKeySeq
+	apiKeysMutex.Unlock()
+
+	// Pending signups aren't saved, so neither are the tenants they hold
+	tenantsMutex.RLock()
+	for _, tenant := range tenants {
+		if tenant.Status != TenantPending {
+			state.Tenants = append(state.Tenants, *tenant)
+		}
+	}
+	tenantsMutex.RUnlock()
+	return state
+}
+
+// saveAuthState saves the auth state after a change. Callers must not hold
+// the users, sessions, API keys or tenants locks. A failed save is logged;
+// the next change saves everything again.
+func saveAuthState() {
+	store, inStore := studentStore.(authStateStore)
+	if wal == nil && !inStore {
+		return
+	}
+	authSaveMutex.Lock()
+	defer authSaveMutex.Unlock()
+	state := snapshotAuthState()
+	authVersion++
+	state.Version = authVersion
+	data, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("Failed to save auth state", "error", err)
+		return
+	}
+	if wal != nil {
+		if err := writeFileAtomic(wal.authStatePath(), data); err != nil {
+			slog.Error("Failed to save auth state", "path", wal.authStatePath(), "error", err)
+		}
+	}
+	if inStore {
+		if err := store.SaveAuthState(data); err != nil {
+			slog.Error("Failed to save auth state", "store", studentStore.Name(), "error", err)
+		}
+	}
+}
+
+// restoreAuthState loads the newer of the auth states saved beside the
+// write-ahead log and in the store. Tenants the config file set up keep its
+// timezone and locale.
+func restoreAuthState(ctx context.Context) error {
+	var copies [][]byte
+	if wal != nil {
+		data, err := os.ReadFile(wal.authStatePath())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		copies = append(copies, data)
+	}
+	if store, ok := studentStore.(authStateStore); ok {
+		data, err := store.LoadAuthState(ctx)
+		if err != nil {
+			return err
+		}
+		copies = append(copies, data)
+	}
+
+	var state authState
+	found := false
+	for _, data := range copies {
+		if len(data) == 0 {
+			continue
+		}
+		var candidate authState
+		if err := json.Unmarshal(data, &candidate); err != nil {
+			return fmt.Errorf("corrupt auth state: %v", err)
+		}
+		if !found || candidate.Version > state.Version {
+			state, found = candidate, true
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	restored := make([]*User, 0, len(state.Users))
+	for _, stored := range state.Users {
+		user := stored.User
+		user.passwordHash, user.totpSecret, user.pendingTOTPSecret = stored.PasswordHash, stored.TOTPSecret, stored.PendingTOTPSecret
+		user.totpLastStep, user.backupCodes = stored.TOTPLastStep, stored.BackupCodes
+		restored = append(restored, &user)
+	}
+	usersMutex.Lock()
+	users, userSeq = restored, state.UserSeq
+	usersMutex.Unlock()
+
+	restoredSessions := make([]*Session, 0, len(state.Sessions))
+	for _, stored := range state.Sessions {
+		session := stored.Session
+		session.refreshHash, session.prevRefreshHash, session.csrfToken = stored.RefreshHash, stored.PrevRefreshHash, stored.CSRFToken
+		restoredSessions = append(restoredSessions, &session)
+	}
+	sessionsMutex.Lock()
+	sessions, sessionSeq = restoredSessions, state.SessionSeq
+	sessionsMutex.Unlock()
+
+	restoredKeys := make([]*APIKey, 0, len(state.APIKeys))
+	for _, stored := range state.APIKeys {
+		key := stored.APIKey
+		key.hash, key.enrollOnly = stored.Hash, stored.EnrollOnly
+		restoredKeys = append(restoredKeys, &key)
+	}
+	apiKeysMutex.Lock()
+	apiKeys, apiKeySeq = restoredKeys, state.APIKeySeq
+	apiKeysMutex.Unlock()
+
+	tenantsMutex.Lock()
+	for _, tenant := range state.Tenants {
+		if configured, ok := tenants[tenant.Name]; ok {
+			tenant.Timezone, tenant.Locale = configured.Timezone, configured.Locale
+		}
+		if err := tenant.compile(); err != nil {
+			tenantsMutex.Unlock()
+			return fmt.Errorf("tenant %s: %v", tenant.Name, err)
+		}
+		tenants[tenant.Name] = &tenant
+	}
+	tenantsMutex.Unlock()
+
+	authSaveMutex.Lock()
+	authVersion = state.Version
+	authSaveMutex.Unlock()
+	slog.Info("Restored users, sessions, API keys and tenants", "users", len(state.Users),
+		"sessions", len(state.Sessions), "api_keys", len(state.APIKeys), "tenants", len(state.Tenants))
+	return nil
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	backupFormat       = 2
+	backupManifestName = "manifest.json"
+	backupRosterName   = "roster.json" // format 1 kept the whole roster in one file
+	backupDeletedName  = "deleted.json"
+	defaultBackupChunk = 5000
+)
+
+// BackupManifest describes a backup or export: the checksum and size of every
+// file in it and, when BACKUP_SIGNING_KEY is set, an Ed25519 signature over
+// the manifest itself. Restore checks all of it before loading anything, so a
+// truncated or edited backup is refused.
+//
+// A full backup holds the roster in chunks of students. An incremental one
+// holds the students changed since its base, and the IDs deleted, and only
+// restores on top of exactly that base.
+type BackupManifest struct {
+	Format       int          `json:"format"`
+	Kind         string       `json:"kind,omitempty"` // full, incremental or export
+	CreatedAt    time.Time    `json:"created_at"`
+	Source       string       `json:"source"`
+	Revision     int64        `json:"revision"`
+	Students     int          `json:"students"`           // in the roster once restored
+	Checksum     string       `json:"checksum,omitempty"` // of the roster once restored
+	BaseRevision int64        `json:"base_revision,omitempty"`
+	BaseChecksum string       `json:"base_checksum,omitempty"`
+	Files        []BackupFile `json:"files"`
+	KeyID        string       `json:"key_id,omitempty"`
+	Signature    string       `json:"signature,omitempty"` // base64, over the manifest without it
+}
+
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func newBackupFile(name string, data []byte) BackupFile {
+	sum := sha256.Sum256(data)
+	return BackupFile{Name: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// backupState is a roster rebuilt from a backup and the ones before it
+type backupState struct {
+	roster   []Student // ordered by ID
+	revision int64
+	checksum string
+}
+
+func newBackupState(roster []Student, revision int64) (backupState, error) {
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	checksum, err := rosterChecksum(roster)
+	return backupState{roster: roster, revision: revision, checksum: checksum}, err
+}
+
+// parseBackupKey decodes a base64 key of one of the given lengths
+func parseBackupKey(name string, sizes ...int) ([]byte, error) {
+	value := getSecret(name)
+	if value == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %v", name, err)
+	}
+	for _, size := range sizes {
+		if len(key) == size {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has the wrong length; generate one with: fealtyx backup -keygen", name)
+}
+
+// backupSigningKey is the BACKUP_SIGNING_KEY secret, an Ed25519 seed or
+// private key, or nil when backups aren't signed
+func backupSigningKey() (ed25519.PrivateKey, error) {
+	key, err := parseBackupKey("BACKUP_SIGNING_KEY", ed25519.SeedSize, ed25519.PrivateKeySize)
+	if key == nil || err != nil {
+		return nil, err
+	}
+	if len(key) == ed25519.SeedSize {
+		return ed25519.NewKeyFromSeed(key), nil
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// backupVerifyKey is the BACKUP_VERIFY_KEY public key, or the public half of
+// the signing key. With either set, restore only accepts signed backups.
+func backupVerifyKey() (ed25519.PublicKey, error) {
+	key, err := parseBackupKey("BACKUP_VERIFY_KEY", ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		return ed25519.PublicKey(key), nil
+	}
+	private, err := backupSigningKey()
+	if private == nil || err != nil {
+		return nil, err
+	}
+	return private.Public().(ed25519.PublicKey), nil
+}
+
+func backupKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// signedBytes is what the signature covers: the manifest as JSON with the
+// signature left out. Struct fields marshal in a fixed order, so this is
+// deterministic.
+func (m BackupManifest) signedBytes() []byte {
+	m.Signature = ""
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// sign signs the manifest if a signing key is configured
+func (m *BackupManifest) sign() error {
+	key, err := backupSigningKey()
+	if key == nil || err != nil {
+		return err
+	}
+	m.KeyID = backupKeyID(key.Public().(ed25519.PublicKey))
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.signedBytes()))
+	return nil
+}
+
+// verify checks the files against the manifest and the signature against the
+// verify key. It returns a warning when the signature couldn't be checked.
+func (m BackupManifest) verify(files map[string][]byte) (string, error) {
+	if m.Format < 1 || m.Format > backupFormat {
+		return "", fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+	for _, file := range m.Files {
+		data, ok := files[file.Name]
+		if !ok {
+			return "", fmt.Errorf("%s is missing", file.Name)
+		}
+		if int64(len(data)) != file.Size {
+			return "", fmt.Errorf("%s is %d bytes, the manifest says %d; it may be truncated", file.Name, len(data), file.Size)
+		}
+		if actual := newBackupFile(file.Name, data); actual.SHA256 != file.SHA256 {
+			return "", fmt.Errorf("%s has sha256 %s, the manifest says %s", file.Name, actual.SHA256, file.SHA256)
+		}
+	}
+
+	key, err := backupVerifyKey()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case key == nil && m.Signature == "":
+		return "", nil
+	case key == nil:
+		return "signature not checked; set BACKUP_VERIFY_KEY to check it", nil
+	case m.Signature == "":
+		return "", fmt.Errorf("backup is not signed, but BACKUP_VERIFY_KEY is set")
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(key, m.signedBytes(), signature) {
+		return "", fmt.Errorf("bad signature: the manifest was changed or signed by another key (key %s)", m.KeyID)
+	}
+	return "", nil
+}
+
+// backupFileData is one file of a backup, in the order it's written
+type backupFileData struct {
+	name string
+	data []byte
+}
+
+// buildBackup splits a roster into chunk files and describes them in a signed
+// manifest. With a base, only the students changed since it are included.
+func buildBackup(source string, current backupState, base *backupState, chunkSize int) (BackupManifest, []backupFileData, error) {
+	manifest := BackupManifest{
+		Format:    backupFormat,
+		Kind:      "full",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Source:    source,
+		Revision:  current.revision,
+		Students:  len(current.roster),
+		Checksum:  current.checksum,
+	}
+	changed := current.roster
+	var files []backupFileData
+	if base != nil {
+		manifest.Kind = "incremental"
+		manifest.BaseRevision = base.revision
+		manifest.BaseChecksum = base.checksum
+		previous := make(map[int64]Student, len(base.roster))
+		for _, student := range base.roster {
+			previous[student.ID] = student
+		}
+		changed = nil
+		for _, student := range current.roster {
+			if old, ok := previous[student.ID]; !ok || !old.equal(student) {
+				changed = append(changed, student)
+			}
+			delete(previous, student.ID)
+		}
+		deleted := make([]int64, 0, len(previous))
+		for id := range previous {
+			deleted = append(deleted, id)
+		}
+		sort.Slice(deleted, func(i, j int) bool { return deleted[i] < deleted[j] })
+		data, err := json.Marshal(deleted)
+		if err != nil {
+			return manifest, nil, err
+		}
+		files = append(files, backupFileData{backupDeletedName, data})
+	}
+
+	for i := 0; i == 0 || i < len(changed); i += chunkSize {
+		data, err := json.Marshal(changed[i:min(i+chunkSize, len(changed))])
+		if err != nil {
+			return manifest, nil, err
+		}
+		files = append(files, backupFileData{fmt.Sprintf("students-%05d.json", i/chunkSize+1), data})
+	}
+	for _, file := range files {
+		manifest.Files = append(manifest.Files, newBackupFile(file.name, file.data))
+	}
+	return manifest, files, manifest.sign()
+}
+
+// isBackupArchive reports whether path names a tar archive rather than a
+// directory. "-" is an uncompressed tar on stdout or stdin.
+func isBackupArchive(path string) bool {
+	return path == "-" || strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// writeBackup writes the files and then the manifest, to a directory or as a
+// tar archive streamed to the file. Either way a backup without a manifest is
+// incomplete.
+func writeBackup(path string, manifest BackupManifest, files []backupFileData) error {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append(files, backupFileData{backupManifestName, manifestData})
+
+	if !isBackupArchive(path) {
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := os.WriteFile(filepath.Join(path, file.name), file.data, 0o600); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	var stream io.Writer = out
+	var compressor *gzip.Writer
+	if strings.HasSuffix(path, "gz") {
+		compressor = gzip.NewWriter(out)
+		stream = compressor
+	}
+	archive := tar.NewWriter(stream)
+	for _, file := range files {
+		header := &tar.Header{Name: file.name, Mode: 0o600, Size: int64(len(file.data)), ModTime: manifest.CreatedAt}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(file.data); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+	}
+	if path != "-" {
+		return out.Sync()
+	}
+	return nil
+}
+
+// readBackupFiles reads every file of a backup directory or archive. An
+// archive may be gzipped or not; the first bytes tell. It returns nil files
+// for a saved export.
+func readBackupFiles(path string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				if files[entry.Name()], err = os.ReadFile(filepath.Join(path, entry.Name())); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return files, nil
+	}
+
+	in := os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+	reader := bufio.NewReader(in)
+	magic, _ := reader.Peek(2)
+	var stream io.Reader = reader
+	switch {
+	case len(magic) > 0 && magic[0] == '{':
+		return nil, nil
+	case len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		stream = decompressor
+	}
+	archive := tar.NewReader(stream)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if files[header.Name], err = io.ReadAll(archive); err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %v", header.Name, err)
+		}
+	}
+}
+
+// readBackup verifies one backup and applies it on top of the state rebuilt
+// from the backups before it, if any
+func readBackup(path string, previous *backupState) (backupState, BackupManifest, string, error) {
+	var manifest BackupManifest
+	files, err := readBackupFiles(path)
+	if err != nil {
+		return backupState{}, manifest, "", err
+	}
+	if files == nil {
+		return readExportFile(path)
+	}
+	data, ok := files[backupManifestName]
+	if !ok {
+		return backupState{}, manifest, "", fmt.Errorf("no %s; the backup is incomplete", backupManifestName)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return backupState{}, manifest, "", fmt.Errorf("corrupt manifest: %v", err)
+	}
+	warning, err := manifest.verify(files)
+	if err != nil {
+		return backupState{}, manifest, "", err
+	}
+
+	var roster []Student
+	switch {
+	case manifest.Format == 1:
+		var snapshot walSnapshot
+		if err := json.Unmarshal(files[backupRosterName], &snapshot); err != nil {
+			return backupState{}, manifest, "", fmt.Errorf("corrupt %s: %v", backupRosterName, err)
+		}
+		roster = snapshot.Students
+	case manifest.Kind == "incremental":
+		if previous == nil {
+			return backupState{}, manifest, "", fmt.Errorf("backup is incremental; list the backups it builds on before it")
+		}
+		if previous.revision != manifest.BaseRevision || previous.checksum != manifest.BaseChecksum {
+			return backupState{}, manifest, "", fmt.Errorf("backup builds on revision %d, not on the backups before it (revision %d)",
+				manifest.BaseRevision, previous.revision)
+		}
+		var deleted []int64
+		if err := json.Unmarshal(files[backupDeletedName], &deleted); err != nil {
+			return backupState{}, manifest, "", fmt.Errorf("corrupt %s: %v", backupDeletedName, err)
+		}
+		byID := make(map[int64]Student, len(previous.roster))
+		for _, student := range previous.roster {
+			byID[student.ID] = student
+		}
+		for _, id := range deleted {
+			delete(byID, id)
+		}
+		changed, err := readBackupChunks(manifest, files)
+		if err != nil {
+			return backupState{}, manifest, "", err
+		}
+		for _, student := range changed {
+			byID[student.ID] = student
+		}
+		for _, student := range byID {
+			roster = append(roster, student)
+		}
+	default:
+		if roster, err = readBackupChunks(manifest, files); err != nil {
+			return backupState{}, manifest, "", err
+		}
+	}
+
+	state, err := newBackupState(roster, manifest.Revision)
+	if err != nil {
+		return backupState{}, manifest, "", err
+	}
+	if len(state.roster) != manifest.Students || (manifest.Checksum != "" && state.checksum != manifest.Checksum) {
+		return backupState{}, manifest, "", fmt.Errorf("the restored roster doesn't match the manifest")
+	}
+	return state, manifest, warning, nil
+}
+
+// readBackupChunks reads the student chunks in the order the manifest lists them
+func readBackupChunks(manifest BackupManifest, files map[string][]byte) ([]Student, error) {
+	var roster []Student
+	for _, file := range manifest.Files {
+		if !strings.HasPrefix(file.Name, "students-") {
+			continue
+		}
+		var chunk []Student
+		if err := json.Unmarshal(files[file.Name], &chunk); err != nil {
+			return nil, fmt.Errorf("corrupt %s: %v", file.Name, err)
+		}
+		roster = append(roster, chunk...)
+	}
+	return roster, nil
+}
+
+func readExportFile(path string) (backupState, BackupManifest, string, error) {
+	var export struct {
+		Anonymized bool            `json:"anonymized"`
+		Students   json.RawMessage `json:"students"`
+		Manifest   *BackupManifest `json:"manifest"`
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return backupState{}, BackupManifest{}, "", err
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return backupState{}, BackupManifest{}, "", fmt.Errorf("corrupt export: %v", err)
+	}
+	if export.Manifest == nil {
+		return backupState{}, BackupManifest{}, "", fmt.Errorf("export has no manifest")
+	}
+	if export.Anonymized {
+		return backupState{}, *export.Manifest, "", fmt.Errorf("anonymized exports can't be restored")
+	}
+	warning, err := export.Manifest.verify(map[string][]byte{"students": export.Students})
+	if err != nil {
+		return backupState{}, *export.Manifest, "", err
+	}
+	var roster []Student
+	if err := json.Unmarshal(export.Students, &roster); err != nil {
+		return backupState{}, *export.Manifest, "", fmt.Errorf("corrupt students: %v", err)
+	}
+	if len(roster) != export.Manifest.Students {
+		return backupState{}, *export.Manifest, "", fmt.Errorf("export doesn't match its manifest")
+	}
+	state, err := newBackupState(roster, export.Manifest.Revision)
+	return state, *export.Manifest, warning, err
+}
+
+// readBackupChain restores a full backup and the incremental backups after
+// it, in order, verifying each one
+func readBackupChain(paths []string) (backupState, []BackupManifest, error) {
+	var state *backupState
+	var manifests []BackupManifest
+	for _, path := range paths {
+		next, manifest, warning, err := readBackup(path, state)
+		if err != nil {
+			return backupState{}, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if warning != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, warning)
+		}
+		state = &next
+		manifests = append(manifests, manifest)
+	}
+	if state == nil {
+		return backupState{}, nil, fmt.Errorf("no backups given")
+	}
+	return *state, manifests, nil
+}
+
+// backupPaths splits a comma-separated list of backups
+func backupPaths(list string) []string {
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// runBackup implements the backup subcommand, which copies a storage backend
+// to a backup directory or archive with the server stopped
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	from := fs.String("from", "", "backend to back up, e.g. wal:/var/lib/fealtyx/students.wal")
+	out := fs.String("out", "", "directory, .tar or .tar.gz archive to write, or - for a tar on stdout")
+	since := fs.String("since", "", "comma-separated full and incremental backups to build on; only changes since them are written")
+	chunkSize := fs.Int("chunk", defaultBackupChunk, "students per file in the backup")
+	keygen := fs.Bool("keygen", false, "print a new signing key pair and exit")
+	fs.Parse(args)
+
+	if *keygen {
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("BACKUP_SIGNING_KEY=%s\n", base64.StdEncoding.EncodeToString(private.Seed()))
+		fmt.Printf("BACKUP_VERIFY_KEY=%s\n", base64.StdEncoding.EncodeToString(public))
+		return nil
+	}
+	if *from == "" || *out == "" {
+		return fmt.Errorf("usage: backup -from kind:location -out dir|file.tar.gz|- [-since backups]")
+	}
+	if *chunkSize <= 0 {
+		return fmt.Errorf("-chunk must be positive")
+	}
+	if _, err := os.Stat(filepath.Join(*out, backupManifestName)); err == nil {
+		return fmt.Errorf("%s already holds a backup", *out)
+	}
+	// Progress goes to stderr when the archive goes to stdout
+	report := os.Stdout
+	if *out == "-" {
+		report = os.Stderr
+	}
+
+	var base *backupState
+	if *since != "" {
+		state, _, err := readBackupChain(backupPaths(*since))
+		if err != nil {
+			return fmt.Errorf("failed to read the base backups: %v", err)
+		}
+		base = &state
+	}
+
+	offlineStorage = true
+	source, err := openBackend(*from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	roster, revision, err := source.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", source.Name(), err)
+	}
+	current, err := newBackupState(roster, revision)
+	if err != nil {
+		return err
+	}
+	if base != nil && base.revision > revision {
+		return fmt.Errorf("the base backups are at revision %d, ahead of %s at %d", base.revision, source.Name(), revision)
+	}
+	manifest, files, err := buildBackup(source.Name(), current, base, *chunkSize)
+	if err != nil {
+		return err
+	}
+	if err := writeBackup(*out, manifest, files); err != nil {
+		return fmt.Errorf("failed to write backup: %v", err)
+	}
+	signed := "unsigned"
+	if manifest.Signature != "" {
+		signed = "signed with key " + manifest.KeyID
+	}
+	fmt.Fprintf(report, "Backed up %d students at revision %d to %s (%s, %d files, %s)\n",
+		manifest.Students, manifest.Revision, *out, manifest.Kind, len(manifest.Files), signed)
+	return nil
+}
+
+// runRestore implements the restore subcommand. Every backup is verified in
+// full before the target is touched.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "comma-separated backups to restore in order: a full backup, then incremental ones. Each is a directory, an archive, - for a tar on stdin, or a file saved from GET /students/export")
+	to := fs.String("to", "", "backend to restore into, e.g. wal:/var/lib/fealtyx/students.wal")
+	tenant := fs.String("tenant", "", "restore only this tenant's students, leaving the rest of the target alone")
+	overwrite := fs.Bool("overwrite", false, "replace students the target already holds")
+	verifyOnly := fs.Bool("verify", false, "only verify the backups")
+	fs.Parse(args)
+	if *in == "" || (*to == "" && !*verifyOnly) {
+		return fmt.Errorf("usage: restore -in backups -to kind:location [-tenant name]")
+	}
+
+	state, manifests, err := readBackupChain(backupPaths(*in))
+	if err != nil {
+		return fmt.Errorf("backup failed verification: %v", err)
+	}
+	for _, manifest := range manifests {
+		fmt.Printf("Verified %s backup at revision %d, taken %s from %s\n",
+			manifest.Kind, manifest.Revision, manifest.CreatedAt.Format(time.RFC3339), manifest.Source)
+	}
+	roster := state.roster
+	if *tenant != "" {
+		roster = nil
+		for _, student := range state.roster {
+			if student.Tenant == *tenant {
+				roster = append(roster, student)
+			}
+		}
+	}
+	fmt.Printf("%d students to restore\n", len(roster))
+	if *verifyOnly {
+		return nil
+	}
+
+	offlineStorage = true
+	target, err := openBackend(*to)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	existing, targetRevision, err := target.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read target: %v", err)
+	}
+
+	revision := state.revision
+	if *tenant != "" {
+		// Keep the other tenants' students; the restored ones must not
+		// collide with them
+		kept := existing[:0]
+		replaced := 0
+		taken := map[int64]bool{}
+		for _, student := range existing {
+			if student.Tenant == *tenant {
+				replaced++
+				continue
+			}
+			kept = append(kept, student)
+			taken[student.ID] = true
+		}
+		if replaced > 0 && !*overwrite {
+			return fmt.Errorf("target already holds %d students of tenant %s; pass -overwrite to replace them", replaced, *tenant)
+		}
+		for _, student := range roster {
+			if taken[student.ID] {
+				return fmt.Errorf("student %d belongs to another tenant in the target", student.ID)
+			}
+		}
+		roster = append(kept, roster...)
+		sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+		revision = max(revision, targetRevision)
+	} else if len(existing) > 0 && !*overwrite {
+		return fmt.Errorf("target already holds %d students; pass -overwrite to replace them", len(existing))
+	}
+	if err := target.Replace(roster, revision); err != nil {
+		return fmt.Errorf("failed to restore: %v", err)
+	}
+	fmt.Printf("Restored into %s, which now holds %d students at revision %d\n", target.Name(), len(roster), revision)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Birthdays and cohorts are worked out from birth_date and enrolled_on, in
+// the tenant's timezone. Students without a birth date have no birthday and
+// fall back to their age for age cohorts.
+
+// Birthday is a student's birthday in the year asked about
+type Birthday struct {
+	Date    string  `json:"date"`    // YYYY-MM-DD; 29 February is the 28th in other years
+	Turning int     `json:"turning"` // the age they'll be on the day
+	Student Student `json:"student"`
+}
+
+// Cohort is the students sharing an age or enrollment year
+type Cohort struct {
+	Cohort   string    `json:"cohort"`
+	Count    int       `json:"count"`
+	Students []Student `json:"students"`
+}
+
+// birthdayIn returns the day a student born on birthDate has their birthday
+// in a year
+func birthdayIn(birthDate time.Time, year int) time.Time {
+	day := birthDate.Day()
+	if birthDate.Month() == time.February && day == 29 && !isLeapYear(year) {
+		day = 28
+	}
+	return time.Date(year, birthDate.Month(), day, 0, 0, 0, 0, time.UTC)
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// currentAge is a student's age on a day, from their birth date if they have
+// one and their age field otherwise
+func currentAge(student Student, today time.Time) int {
+	birthDate, err := time.Parse(time.DateOnly, student.BirthDate)
+	if err != nil {
+		return student.Age
+	}
+	age := today.Year() - birthDate.Year()
+	if today.Before(birthdayIn(birthDate, today.Year())) {
+		age--
+	}
+	return age
+}
+
+// requestToday is the current date in the request's tenant timezone, as
+// midnight UTC so it compares with parsed dates
+func requestToday(r *http.Request) time.Time {
+	now := time.Now().In(tenantLocation(requestTenantName(r)))
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// birthdayRoster is the part of the roster the request can see, narrowed by
+// any filters in its query
+func birthdayRoster(w http.ResponseWriter, r *http.Request) ([]Student, bool) {
+	roster, _ := snapshotRoster()
+	return filterRequestRoster(w, r, visibleStudents(requestTenantName(r), roster))
+}
+
+// birthdaysBetween lists the birthdays falling from first to last inclusive,
+// soonest first
+func birthdaysBetween(roster []Student, first, last time.Time) []Birthday {
+	birthdays := []Birthday{}
+	for _, student := range roster {
+		birthDate, err := time.Parse(time.DateOnly, student.BirthDate)
+		if err != nil {
+			continue
+		}
+		for year := first.Year(); year <= last.Year(); year++ {
+			day := birthdayIn(birthDate, year)
+			if day.Before(first) || day.After(last) || !day.After(birthDate) {
+				continue
+			}
+			birthdays = append(birthdays, Birthday{Date: day.Format(time.DateOnly), Turning: year - birthDate.Year(), Student: student})
+		}
+	}
+	sort.SliceStable(birthdays, func(i, j int) bool {
+		if birthdays[i].Date != birthdays[j].Date {
+			return birthdays[i].Date < birthdays[j].Date
+		}
+		return birthdays[i].Student.Name < birthdays[j].Student.Name
+	})
+	return birthdays
+}
+
+// handleBirthdays lists the birthdays in a month of this year. ?month=
+// defaults to the current month.
+func handleBirthdays(w http.ResponseWriter, r *http.Request) {
+	today := requestToday(r)
+	month := today.Month()
+	if value := r.URL.Query().Get("month"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > 12 {
+			http.Error(w, "Invalid month: must be 1-12", http.StatusBadRequest)
+			return
+		}
+		month = time.Month(number)
+	}
+	roster, ok := birthdayRoster(w, r)
+	if !ok {
+		return
+	}
+	first := time.Date(today.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+	birthdays := birthdaysBetween(roster, first, first.AddDate(0, 1, -1))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"month": int(month), "year": today.Year(), "birthdays": birthdays})
+}
+
+// handleUpcomingBirthdays lists the birthdays from today through the next
+// ?days= days (30 by default)
+func handleUpcomingBirthdays(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		if days, err = strconv.Atoi(value); err != nil || days < 1 || days > 366 {
+			http.Error(w, "Invalid days: must be 1-366", http.StatusBadRequest)
+			return
+		}
+	}
+	roster, ok := birthdayRoster(w, r)
+	if !ok {
+		return
+	}
+	today := requestToday(r)
+	last := today.AddDate(0, 0, days)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":      today.Format(time.DateOnly),
+		"to":        last.Format(time.DateOnly),
+		"birthdays": birthdaysBetween(roster, today, last),
+	})
+}
+
+// handleCohorts groups students by ?by=age (the default) or
+// enrollment_year. Students enrolled on an unknown date are in the
+// "unknown" cohort, which comes last.
+func handleCohorts(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "age"
+	}
+	if by != "age" && by != "enrollment_year" {
+		http.Error(w, "Invalid by: must be age or enrollment_year", http.StatusBadRequest)
+		return
+	}
+	roster, ok := birthdayRoster(w, r)
+	if !ok {
+		return
+	}
+	today := requestToday(r)
+
+	keys := map[string]int{} // cohort name to sort key
+	members := map[string][]Student{}
+	for _, student := range roster {
+		var name string
+		var key int
+		if by == "age" {
+			key = currentAge(student, today)
+			name = strconv.Itoa(key)
+		} else if enrolled, err := time.Parse(time.DateOnly, student.EnrolledOn); err == nil {
+			key = enrolled.Year()
+			name = strconv.Itoa(key)
+		} else {
+			name, key = "unknown", int(^uint(0)>>1)
+		}
+		keys[name] = key
+		members[name] = append(members[name], student)
+	}
+	cohorts := make([]Cohort, 0, len(members))
+	for name, students := range members {
+		cohorts = append(cohorts, Cohort{Cohort: name, Count: len(students), Students: students})
+	}
+	sort.Slice(cohorts, func(i, j int) bool { return keys[cohorts[i].Cohort] < keys[cohorts[j].Cohort] })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"by": by, "cohorts": cohorts})
+}
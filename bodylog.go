@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bodyLogMaxBytes  = 4096 // bodies are truncated to this many bytes
+	bodyLogPerMinute = 60   // further bodies are dropped until the next minute
+)
+
+// bodyLogPrefixes selects the paths whose bodies are logged (-log-bodies)
+var bodyLogPrefixes []string
+
+var bodyLogLimiter = struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	dropped     int
+}{}
+
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// API keys and refresh tokens
+	{regexp.MustCompile(`fxr?_[A-Za-z0-9_-]{8,}`), "[REDACTED KEY]"},
+	// Any field or parameter naming a credential, such as refresh_token,
+	// csrf_token, totp_code or backup_codes, whether its value is a string,
+	// a list or a number
+	{regexp.MustCompile(`(?i)("(?:[^"]*(?:password|secret|token|code|totp)[^"]*|api_key|key)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"|\[[^\]]*\]|[^,}\s]+)`), `$1"[REDACTED]"`},
+	{regexp.MustCompile(`(?i)([\w.-]*(?:password|secret|token|code|totp|key)[\w.-]*=)[^&\s]*`), "$1[REDACTED]"},
+	{regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer [REDACTED]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[REDACTED EMAIL]"},
+	{regexp.MustCompile(`\+?\d[\d ().-]{7,}\d`), "[REDACTED PHONE]"},
+}
+
+// redact masks emails, phone numbers, API keys and credential fields
+func redact(body []byte) string {
+	text := string(body)
+	for _, r := range redactions {
+		text = r.pattern.ReplaceAllString(text, r.replacement)
+	}
+	return text
+}
+
+// allowBodyLog rate-limits body logging so a busy route cannot flood the logs
+func allowBodyLog() bool {
+	bodyLogLimiter.mu.Lock()
+	defer bodyLogLimiter.mu.Unlock()
+
+	if time.Since(bodyLogLimiter.windowStart) >= time.Minute {
+		if bodyLogLimiter.dropped > 0 {
+			slog.Warn("Body logging rate limit hit", "dropped", bodyLogLimiter.dropped)
+		}
+		bodyLogLimiter.windowStart = time.Now()
+		bodyLogLimiter.logged = 0
+		bodyLogLimiter.dropped = 0
+	}
+	if bodyLogLimiter.logged >= bodyLogPerMinute {
+		bodyLogLimiter.dropped++
+		return false
+	}
+	bodyLogLimiter.logged++
+	return true
+}
+
+func bodyLogSelected(path string) bool {
+	for _, prefix := range bodyLogPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyRecorder keeps the first bodyLogMaxBytes of a response
+type bodyRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(data []byte) (int, error) {
+	if room := bodyLogMaxBytes - r.body.Len(); room > 0 {
+		r.body.Write(data[:min(room, len(data))])
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// logBodies logs the redacted request and response bodies of the selected
+// routes, for diagnosing malformed client payloads
+func logBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !bodyLogSelected(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var requestBody []byte
+		if r.Body != nil {
+			requestBody, _ = io.ReadAll(io.LimitReader(r.Body, bodyLogMaxBytes))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(requestBody), r.Body), r.Body}
+		}
+		recorder := &bodyRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		next.ServeHTTP(recorder, r)
+
+		if !allowBodyLog() {
+			return
+		}
+		slog.Info("http bodies", "method", r.Method, "path", r.URL.Path, "status", recorder.status,
+			"content_type", r.Header.Get("Content-Type"),
+			"request", redact(requestBody), "response", redact(recorder.body.Bytes()))
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for _, c := range []struct {
+		body    string
+		secrets []string
+	}{
+		{`{"email":"ada@example.com","password":"correct horse battery"}`, []string{"ada@example.com", "correct horse"}},
+		{`{"key":"fx_0123456789abcdefghij","refresh_token":"fxr_0123456789abcdefghij","refresh_expires_at":"2026-01-01T00:00:00Z"}`,
+			[]string{"fx_0123456789", "fxr_0123456789"}},
+		{`{"refresh_token": "opaque-refresh-value"}`, []string{"opaque-refresh-value"}},
+		{`{"session_id":4,"csrf_token":"Zm9vYmFyYmF6cXV4"}`, []string{"Zm9vYmFyYmF6cXV4"}},
+		{`{"email":"ada@example.com","password":"correct horse battery","totp_code":"123456"}`, []string{"123456", "correct horse"}},
+		{`{"email":"ada@example.com","password":"correct horse battery","totp_code":123456}`, []string{"123456"}},
+		{`{"email":"ada@example.com","password":"correct horse battery","backup_code":"ABCDE-FGHIJ"}`, []string{"ABCDE-FGHIJ"}},
+		{`{"enabled":true,"backup_codes":["ABCDE-FGHIJ","KLMNO-PQRST"]}`, []string{"ABCDE", "KLMNO"}},
+		{`{"code":"654321"}`, []string{"654321"}},
+		{`{"secret":"JBSWY3DPEHPK3PXP","provisioning_uri":"otpauth://totp/Fealtyx:ada?secret=JBSWY3DPEHPK3PXP&issuer=Fealtyx"}`,
+			[]string{"JBSWY3DPEHPK3PXP"}},
+		{`{"token":"reset-token-value","password":"correct horse battery"}`, []string{"reset-token-value", "correct horse"}},
+		{`{"client_secret":"s3cr3t","new_password":"p4ssw0rd!"}`, []string{"s3cr3t", "p4ssw0rd"}},
+		{`refresh_token=fxr_0123456789abcdefghij&csrf_token=abc123&totp_code=123456`, []string{"fxr_", "abc123", "123456"}},
+		{`email=ada%40example.com&password=hunter2hunter2`, []string{"hunter2"}},
+	} {
+		redacted := redact([]byte(c.body))
+		for _, secret := range c.secrets {
+			if strings.Contains(redacted, secret) {
+				t.Errorf("redacting %s left %q: %s", c.body, secret, redacted)
+			}
+		}
+		if len(c.secrets) > 0 && !strings.Contains(redacted, "REDACTED") {
+			t.Errorf("redacting %s marked nothing: %s", c.body, redacted)
+		}
+	}
+
+	// Fields that aren't credentials are kept
+	if redacted := redact([]byte(`{"id":7,"name":"Ada","age":20,"tags":["a","b"]}`)); redacted != `{"id":7,"name":"Ada","age":20,"tags":["a","b"]}` {
+		t.Errorf("a student was redacted to %s", redacted)
+	}
+}
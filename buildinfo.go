@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// Build information is stamped at link time:
+//
+//	go build -ldflags "-X main.version=1.4.2 -X main.commit=$(git rev-parse HEAD) \
+//	  -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds that aren't fall back to what the Go toolchain embedded from the
+// git checkout, if anything.
+var (
+	version   = "dev"
+	commit    string
+	buildDate string
+)
+
+type BuildInfo struct {
+	Version      string    `json:"version"` // semantic version of the server
+	Commit       string    `json:"commit,omitempty"`
+	Modified     bool      `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+	BuildDate    string    `json:"build_date,omitempty"`
+	GoVersion    string    `json:"go_version"`
+	APIVersion   string    `json:"api_version"` // see GET /meta/changes
+	StartedAt    time.Time `json:"started_at"`
+	FeatureFlags []string  `json:"feature_flags"` // enabled for the caller
+}
+
+// buildInfo is worked out once, at startup
+var buildInfo = func() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version(), APIVersion: apiVersion, StartedAt: startedAt.UTC()}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range embedded.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value // of the commit, the closest there is
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true" && commit == ""
+			}
+		}
+	}
+	return info
+}()
+
+// logBuildInfo records what is running at startup, to correlate logs with
+// deployments
+func logBuildInfo() {
+	slog.Info("Build", "version", buildInfo.Version, "commit", buildInfo.Commit, "build_date", buildInfo.BuildDate, "go", buildInfo.GoVersion)
+}
+
+// handleVersion reports the build and the feature flags enabled for the
+// caller
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := buildInfo
+	info.FeatureFlags = []string{}
+	flagsMutex.RLock()
+	names := make([]string, 0, len(featureFlags))
+	for name := range featureFlags {
+		names = append(names, name)
+	}
+	flagsMutex.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if featureEnabled(r.Context(), name) {
+			info.FeatureFlags = append(info.FeatureFlags, name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// ttlCache is a least-recently-used cache whose entries also expire after a
+// TTL. It is safe for concurrent use.
+type ttlCache[K comparable, V any] struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[K]*list.Element
+	order      *list.List // most recently used at the front
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration, maxEntries int) *ttlCache[K, V] {
+	return &ttlCache[K, V]{ttl: ttl, maxEntries: max(maxEntries, 1), entries: map[K]*list.Element{}, order: list.New()}
+}
+
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if ok && time.Now().Before(element.Value.(*cacheEntry[K, V]).expires) {
+		c.hits++
+		c.order.MoveToFront(element)
+		return element.Value.(*cacheEntry[K, V]).value, true
+	}
+	if ok {
+		c.removeLocked(element)
+	}
+	c.misses++
+	var zero V
+	return zero, false
+}
+
+func (c *ttlCache[K, V]) put(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry[K, V])
+		entry.value, entry.expires = value, expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry[K, V]{key: key, value: value, expires: expires})
+	for len(c.entries) > c.maxEntries {
+		c.removeLocked(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *ttlCache[K, V]) remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.removeLocked(element)
+	}
+}
+
+func (c *ttlCache[K, V]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[K]*list.Element{}
+	c.order.Init()
+}
+
+func (c *ttlCache[K, V]) removeLocked(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry[K, V]).key)
+}
+
+func (c *ttlCache[K, V]) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hitRate := 0.0
+	if total := c.hits + c.misses; total > 0 {
+		hitRate = float64(c.hits) / float64(total)
+	}
+	return map[string]interface{}{
+		"ttl":         c.ttl.String(),
+		"max_entries": c.maxEntries,
+		"entries":     len(c.entries),
+		"hits":        c.hits,
+		"misses":      c.misses,
+		"hit_rate":    hitRate,
+		"evictions":   c.evictions,
+	}
+}
+
+// cachedStudent is a cached lookup, including lookups that found nothing
+type cachedStudent struct {
+	student Student
+	found   bool
+}
+
+// studentCache caches findStudent, which every student read goes through, in
+// front of the roster scan (-backend-cache-ttl). It is nil when disabled.
+// applyChange drops the students each change touches, and replacing the whole
+// roster clears it, so reads are never stale.
+var studentCache *ttlCache[int64, cachedStudent]
+
+// forgetCachedStudent drops a student from studentCache. Callers must hold
+// mutex for writing.
+func forgetCachedStudent(id int64) {
+	if studentCache != nil {
+		studentCache.remove(id)
+	}
+}
+
+// clearStudentCache empties studentCache after the roster is replaced.
+// Callers must hold mutex for writing.
+func clearStudentCache() {
+	if studentCache != nil {
+		studentCache.clear()
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStudentCacheFollowsWrites(t *testing.T) {
+	resetTenantState()
+	studentCache = newTTLCache[int64, cachedStudent](time.Minute, 100)
+	t.Cleanup(func() {
+		studentCache = nil
+		resetTenantState()
+	})
+
+	lookup := func(id int64) (Student, bool) {
+		mutex.RLock()
+		defer mutex.RUnlock()
+		return findStudent(id)
+	}
+
+	if _, found := lookup(1); found {
+		t.Fatal("found student 1 before it was created")
+	}
+	created, err := createStudent(Student{Name: "Lisa", Age: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if student, found := lookup(created.ID); !found || student.Name != "Lisa" {
+		t.Fatalf("cached miss survived the create: %+v, %v", student, found)
+	}
+
+	created.Name = "Lisa Simpson"
+	if _, err := updateStudent(created); err != nil {
+		t.Fatal(err)
+	}
+	if student, _ := lookup(created.ID); student.Name != "Lisa Simpson" {
+		t.Fatalf("lookup after update = %q, want the new name", student.Name)
+	}
+
+	// An aborted batch puts the old student back, and the cache with it
+	mutex.Lock()
+	if err := beginBatchLocked(); err != nil {
+		mutex.Unlock()
+		t.Fatal(err)
+	}
+	if err := commitChange(EventStudentDeleted, created); err != nil {
+		mutex.Unlock()
+		t.Fatal(err)
+	}
+	if _, found := findStudent(created.ID); found {
+		mutex.Unlock()
+		t.Fatal("found the student after deleting it")
+	}
+	abortBatchLocked()
+	mutex.Unlock()
+	if student, found := lookup(created.ID); !found || student.Name != "Lisa Simpson" {
+		t.Fatalf("lookup after the aborted delete = %+v, %v", student, found)
+	}
+
+	stats := studentCache.stats()
+	if stats["hits"].(int64) == 0 || stats["misses"].(int64) == 0 {
+		t.Errorf("cache stats = %v, want both hits and misses", stats)
+	}
+}
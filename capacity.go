@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	capacitySamples = 30 * 24 // hourly samples kept, 30 days
+	capacityTop     = 10      // tenants and students listed by size
+)
+
+// StorageSample is the size of the roster at one point in time
+type StorageSample struct {
+	At       time.Time `json:"at"`
+	Students int       `json:"students"`
+	Bytes    int64     `json:"bytes"`
+}
+
+type StorageGrowth struct {
+	Samples        []StorageSample `json:"samples"`
+	StudentsPerDay float64         `json:"students_per_day"`
+	BytesPerDay    float64         `json:"bytes_per_day"`
+	// DaysUntilStudentLimit projects when -max-students is reached at the
+	// current rate; absent without a limit or growth
+	DaysUntilStudentLimit *float64 `json:"days_until_student_limit,omitempty"`
+}
+
+// sizedEntry is a tenant or a student and the bytes it takes
+type sizedEntry struct {
+	Tenant   string `json:"tenant"`
+	ID       int64  `json:"id,omitempty"`       // for students
+	Students int    `json:"students,omitempty"` // for tenants
+	Bytes    int64  `json:"bytes"`
+}
+
+var (
+	storageSamples      []StorageSample
+	storageSamplesMutex sync.Mutex
+)
+
+// sizedBackend is implemented by backends that can report their size on disk
+type sizedBackend interface {
+	Size() (int64, error)
+}
+
+func (b *jsonFileBackend) Size() (int64, error) {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// rosterSize returns the JSON size of every student, the size a backend
+// stores roughly
+func rosterSize(roster []Student) (int64, []int64) {
+	var total int64
+	sizes := make([]int64, len(roster))
+	for i, student := range roster {
+		data, _ := json.Marshal(student)
+		sizes[i] = int64(len(data))
+		total += sizes[i]
+	}
+	return total, sizes
+}
+
+// sampleStorage records the roster's size for the growth report
+func sampleStorage(now time.Time) {
+	roster, _ := snapshotRoster()
+	total, _ := rosterSize(roster)
+	storageSamplesMutex.Lock()
+	defer storageSamplesMutex.Unlock()
+	storageSamples = append(storageSamples, StorageSample{At: now.UTC(), Students: len(roster), Bytes: total})
+	if excess := len(storageSamples) - capacitySamples; excess > 0 {
+		storageSamples = storageSamples[excess:]
+	}
+}
+
+// storageGrowth computes daily growth from the oldest to the newest sample
+func storageGrowth(current StorageSample) StorageGrowth {
+	storageSamplesMutex.Lock()
+	samples := append([]StorageSample{}, storageSamples...)
+	storageSamplesMutex.Unlock()
+
+	growth := StorageGrowth{Samples: samples}
+	if len(samples) == 0 {
+		return growth
+	}
+	oldest := samples[0]
+	days := current.At.Sub(oldest.At).Hours() / 24
+	if days < 1.0/24 {
+		return growth
+	}
+	growth.StudentsPerDay = float64(current.Students-oldest.Students) / days
+	growth.BytesPerDay = float64(current.Bytes-oldest.Bytes) / days
+
+	quotas.mu.Lock()
+	limit := quotas.maxStudents
+	quotas.mu.Unlock()
+	if limit > 0 && growth.StudentsPerDay > 0 {
+		remaining := max(float64(limit-current.Students), 0) / growth.StudentsPerDay
+		growth.DaysUntilStudentLimit = &remaining
+	}
+	return growth
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// largest sorts entries by size and keeps the biggest
+func largest(entries []sizedEntry) []sizedEntry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Bytes > entries[j].Bytes })
+	return entries[:min(len(entries), capacityTop)]
+}
+
+// handleStorage reports record counts, bytes used, the largest tenants and
+// students and how the roster has grown, for capacity planning
+func handleStorage(w http.ResponseWriter, r *http.Request) {
+	roster, revision := snapshotRoster()
+	total, sizes := rosterSize(roster)
+	current := StorageSample{At: time.Now().UTC(), Students: len(roster), Bytes: total}
+
+	mutex.RLock()
+	retainedChanges := len(changes)
+	mutex.RUnlock()
+	tenantsMutex.RLock()
+	tenantCount := len(tenants)
+	tenantsMutex.RUnlock()
+	usersMutex.Lock()
+	userCount := len(users)
+	usersMutex.Unlock()
+	sessionsMutex.Lock()
+	sessionCount := len(sessions)
+	sessionsMutex.Unlock()
+	apiKeysMutex.Lock()
+	keyCount := len(apiKeys)
+	apiKeysMutex.Unlock()
+
+	byTenant := map[string]*sizedEntry{}
+	students := []sizedEntry{}
+	for i, student := range roster {
+		entry := byTenant[student.Tenant]
+		if entry == nil {
+			entry = &sizedEntry{Tenant: student.Tenant}
+			byTenant[student.Tenant] = entry
+		}
+		entry.Students++
+		entry.Bytes += sizes[i]
+		students = append(students, sizedEntry{Tenant: student.Tenant, ID: student.ID, Bytes: sizes[i]})
+	}
+	tenantSizes := []sizedEntry{}
+	for _, entry := range byTenant {
+		tenantSizes = append(tenantSizes, *entry)
+	}
+
+	bytes := map[string]int64{"roster": total}
+	if wal != nil {
+		bytes["wal"] = fileSize(wal.path)
+		bytes["wal_snapshot"] = fileSize(wal.snapshotPath())
+	}
+	if shadow != nil {
+		if sized, ok := shadow.backend.(sizedBackend); ok {
+			if size, err := sized.Size(); err == nil {
+				bytes["shadow"] = size
+			}
+		}
+	}
+
+	report := map[string]interface{}{
+		"revision": revision,
+		"records": map[string]int{
+			"students":         len(roster),
+			"changes_retained": retainedChanges,
+			"tenants":          tenantCount,
+			"users":            userCount,
+			"sessions":         sessionCount,
+			"api_keys":         keyCount,
+		},
+		"bytes":            bytes,
+		"largest_tenants":  largest(tenantSizes),
+		"largest_students": largest(students),
+		"growth":           storageGrowth(current),
+	}
+	if _, inMemory := studentStore.(memoryStore); !inMemory {
+		report["store"] = storeMirrorStats()
+	}
+	if studentCache != nil {
+		report["cache"] = studentCache.stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CDCRecord is the value written to Kafka for every mutation (schema version 1).
+// Records are keyed by student ID, so all changes to one student land on the
+// same partition in commit order.
+type CDCRecord struct {
+	SchemaVersion int       `json:"schema_version"`
+	Entity        string    `json:"entity"` // "student"
+	Op            string    `json:"op"`     // "c", "u" or "d"
+	Seq           int64     `json:"seq"`    // the change ID; consumers dedupe on it
+	Key           string    `json:"key"`
+	After         *Student  `json:"after"` // nil for deletes
+	Before        *Student  `json:"before"`
+	OccurredAt    time.Time `json:"occurred_at"`
+}
+
+var cdcOps = map[string]string{
+	EventStudentCreated: "c",
+	EventStudentUpdated: "u",
+	EventStudentDeleted: "d",
+}
+
+func cdcRecordFor(change Change) CDCRecord {
+	student := change.Student
+	record := CDCRecord{
+		SchemaVersion: 1,
+		Entity:        "student",
+		Op:            cdcOps[change.Event],
+		Seq:           change.ID,
+		Key:           strconv.FormatInt(student.ID, 10),
+		OccurredAt:    change.OccurredAt,
+	}
+	if change.Event == EventStudentDeleted {
+		record.Before = &student
+	} else {
+		record.After = &student
+	}
+	return record
+}
+
+// cdcPublisher sends the change log to Kafka through a Confluent-compatible
+// REST proxy. It delivers at least once: the cursor only advances after the
+// proxy acknowledges a batch, and failed batches are retried in order.
+type cdcPublisher struct {
+	proxyURL    string
+	topicPrefix string
+	client      *http.Client
+
+	mu        sync.Mutex
+	published int64
+	lastError string
+}
+
+var cdc *cdcPublisher
+
+const cdcBatchSize = 100
+
+func (p *cdcPublisher) topic(entity string) string {
+	return p.topicPrefix + entity + "s"
+}
+
+// publish posts one batch of records to the entity's topic
+func (p *cdcPublisher) publish(batch []Change) error {
+	type proxyRecord struct {
+		Key   string    `json:"key"`
+		Value CDCRecord `json:"value"`
+	}
+	records := make([]proxyRecord, len(batch))
+	for i, change := range batch {
+		record := cdcRecordFor(change)
+		records[i] = proxyRecord{Key: record.Key, Value: record}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(p.proxyURL, "/") + "/topics/" + p.topic("student")
+	resp, err := p.client.Post(url, "application/vnd.kafka.json.v2+json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("REST proxy returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid REST proxy response: %v", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("REST proxy rejected a record: %s", offset.Error)
+		}
+	}
+	return nil
+}
+
+// run follows the change log forever, publishing every change after the
+// cursor and backing off while the proxy is unavailable
+func (p *cdcPublisher) run() {
+	backoff := time.Second
+	for {
+		p.mu.Lock()
+		cursor := p.published
+		p.mu.Unlock()
+
+		events, signal, ok := eventsAfter(cursor)
+		if !ok {
+			// Retention or a restart dropped changes that were never published
+			mutex.RLock()
+			oldest := oldestRevisionLocked()
+			mutex.RUnlock()
+			slog.Error("CDC skipped changes that are no longer retained", "from", cursor+1, "to", oldest)
+			p.mu.Lock()
+			p.published = oldest
+			p.mu.Unlock()
+			continue
+		}
+		if len(events) == 0 {
+			<-signal
+			continue
+		}
+
+		batch := events[:min(len(events), cdcBatchSize)]
+		if err := p.publish(batch); err != nil {
+			slog.Warn("CDC publish failed, retrying", "error", err, "retry_in", backoff)
+			p.mu.Lock()
+			p.lastError = err.Error()
+			p.mu.Unlock()
+			time.Sleep(backoff)
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+		p.mu.Lock()
+		p.published = batch[len(batch)-1].ID
+		p.lastError = ""
+		p.mu.Unlock()
+	}
+}
+
+func (p *cdcPublisher) stats() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[string]interface{}{
+		"topic":      p.topic("student"),
+		"published":  p.published,
+		"last_error": p.lastError,
+	}
+}
+
+// startCDC starts publishing to the REST proxy at proxyURL
+func startCDC(proxyURL, topicPrefix string) {
+	mutex.RLock()
+	start := oldestRevisionLocked()
+	mutex.RUnlock()
+
+	cdc = &cdcPublisher{
+		proxyURL:    proxyURL,
+		topicPrefix: topicPrefix,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		published:   start,
+	}
+	slog.Info("Publishing changes to Kafka", "proxy", proxyURL, "topic", cdc.topic("student"))
+	go cdc.run()
+}
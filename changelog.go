@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The API changelog is generated from annotations next to the code they
+// describe: Route.Since for endpoints, Deprecation.Version for deprecated
+// routes and fields, and addedFields for fields added to request and
+// response bodies. Client teams read it from GET /meta/changes to check
+// compatibility automatically. Bump apiVersion with every change.
+
+// apiVersion is the version of the HTTP API, versioned semantically apart
+// from the server. 1.0.0 is everything that predates the changelog.
+const apiVersion = "1.4.0"
+
+const firstAPIVersion = "1.0.0"
+
+// addedFields are body fields added after the type they belong to, by type
+// name and then JSON name, e.g. {"Student": {"pronouns": "1.1.0"}}
+var addedFields = map[string]map[string]string{
+	"SavedFilter":   {"student_name": "1.2.0", "min_age": "1.2.0", "max_age": "1.2.0"},
+	"APIKeyRequest": {"envelope": "1.3.0", "naming": "1.3.0"},
+}
+
+// parseAPIVersion reads a major.minor.patch version
+func parseAPIVersion(version string) ([3]int, error) {
+	var parsed [3]int
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("invalid API version %q: must be major.minor.patch", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid API version %q: must be major.minor.patch", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// compareAPIVersions orders two valid versions like strings.Compare
+func compareAPIVersions(a, b string) int {
+	x, _ := parseAPIVersion(a)
+	y, _ := parseAPIVersion(b)
+	for i := range x {
+		if x[i] != y[i] {
+			if x[i] < y[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+type ChangedEndpoint struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+}
+
+type ChangedField struct {
+	Type  string `json:"type"` // the Go type named in /schemas/, e.g. Student
+	Field string `json:"field"`
+}
+
+// DeprecatedChange is a route or field deprecated in a version
+type DeprecatedChange struct {
+	Method      string `json:"method,omitempty"` // routes
+	Path        string `json:"path,omitempty"`
+	Type        string `json:"type,omitempty"` // fields
+	Field       string `json:"field,omitempty"`
+	Sunset      string `json:"sunset,omitempty"` // YYYY-MM-DD
+	Replacement string `json:"replacement,omitempty"`
+	Note        string `json:"note,omitempty"`
+}
+
+// APIVersionChanges is what one version of the API changed
+type APIVersionChanges struct {
+	Version             string             `json:"version"`
+	AddedEndpoints      []ChangedEndpoint  `json:"added_endpoints"`
+	AddedFields         []ChangedField     `json:"added_fields"`
+	DeprecatedEndpoints []DeprecatedChange `json:"deprecated_endpoints"`
+	DeprecatedFields    []DeprecatedChange `json:"deprecated_fields"`
+}
+
+type APIChangelog struct {
+	Current  string              `json:"current"`
+	Versions []APIVersionChanges `json:"versions"` // newest first
+}
+
+func deprecatedChange(deprecation Deprecation) DeprecatedChange {
+	change := DeprecatedChange{Replacement: deprecation.Replacement, Note: deprecation.Note}
+	if !deprecation.Sunset.IsZero() {
+		change.Sunset = deprecation.Sunset.UTC().Format("2006-01-02")
+	}
+	return change
+}
+
+// apiChangelog collects the annotations into versions, newest first
+func apiChangelog(routes []Route) APIChangelog {
+	versions := map[string]*APIVersionChanges{}
+	version := func(name string) *APIVersionChanges {
+		if versions[name] == nil {
+			versions[name] = &APIVersionChanges{Version: name, AddedEndpoints: []ChangedEndpoint{},
+				AddedFields: []ChangedField{}, DeprecatedEndpoints: []DeprecatedChange{}, DeprecatedFields: []DeprecatedChange{}}
+		}
+		return versions[name]
+	}
+	version(apiVersion)
+
+	for _, route := range routes {
+		since := route.Since
+		if since == "" {
+			since = firstAPIVersion
+		}
+		added := version(since)
+		added.AddedEndpoints = append(added.AddedEndpoints, ChangedEndpoint{Method: route.Method, Path: route.Path, Description: route.Description})
+		if route.Deprecated != nil {
+			change := deprecatedChange(*route.Deprecated)
+			change.Method, change.Path = route.Method, route.Path
+			deprecated := version(route.Deprecated.Version)
+			deprecated.DeprecatedEndpoints = append(deprecated.DeprecatedEndpoints, change)
+		}
+	}
+	for typeName, fields := range addedFields {
+		for name, since := range fields {
+			added := version(since)
+			added.AddedFields = append(added.AddedFields, ChangedField{Type: typeName, Field: name})
+		}
+	}
+	for typeName, fields := range deprecatedFields {
+		for name, deprecation := range fields {
+			change := deprecatedChange(deprecation)
+			change.Type, change.Field = typeName, name
+			deprecated := version(deprecation.Version)
+			deprecated.DeprecatedFields = append(deprecated.DeprecatedFields, change)
+		}
+	}
+
+	changelog := APIChangelog{Current: apiVersion, Versions: []APIVersionChanges{}}
+	for _, changes := range versions {
+		sort.Slice(changes.AddedFields, func(i, j int) bool {
+			a, b := changes.AddedFields[i], changes.AddedFields[j]
+			return a.Type+"."+a.Field < b.Type+"."+b.Field
+		})
+		sort.Slice(changes.DeprecatedFields, func(i, j int) bool {
+			a, b := changes.DeprecatedFields[i], changes.DeprecatedFields[j]
+			return a.Type+"."+a.Field < b.Type+"."+b.Field
+		})
+		changelog.Versions = append(changelog.Versions, *changes)
+	}
+	sort.Slice(changelog.Versions, func(i, j int) bool {
+		return compareAPIVersions(changelog.Versions[i].Version, changelog.Versions[j].Version) > 0
+	})
+	return changelog
+}
+
+// checkAPIChangelog rejects annotations with malformed versions or versions
+// newer than apiVersion, which would mean it wasn't bumped
+func checkAPIChangelog(routes []Route) error {
+	check := func(what, version string) error {
+		if _, err := parseAPIVersion(version); err != nil {
+			return fmt.Errorf("%s: %v", what, err)
+		}
+		if compareAPIVersions(version, apiVersion) > 0 {
+			return fmt.Errorf("%s: version %s is newer than the API's, %s", what, version, apiVersion)
+		}
+		return nil
+	}
+	for _, route := range routes {
+		if route.Since != "" {
+			if err := check(route.Method+" "+route.Path, route.Since); err != nil {
+				return err
+			}
+		}
+		if route.Deprecated != nil {
+			if err := check(route.Method+" "+route.Path+" deprecation", route.Deprecated.Version); err != nil {
+				return err
+			}
+		}
+	}
+	for typeName, fields := range addedFields {
+		for name, since := range fields {
+			if err := check(typeName+"."+name, since); err != nil {
+				return err
+			}
+		}
+	}
+	for typeName, fields := range deprecatedFields {
+		for name, deprecation := range fields {
+			if err := check(typeName+"."+name+" deprecation", deprecation.Version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleAPIChanges serves the changelog. ?since= leaves out that version
+// and older ones, giving what a client built against it hasn't seen.
+func handleAPIChanges(w http.ResponseWriter, r *http.Request) {
+	changelog := apiChangelog(apiRoutes())
+	if since := r.URL.Query().Get("since"); since != "" {
+		if _, err := parseAPIVersion(since); err != nil {
+			http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		newer := []APIVersionChanges{}
+		for _, changes := range changelog.Versions {
+			if compareAPIVersions(changes.Version, since) > 0 {
+				newer = append(newer, changes)
+			}
+		}
+		changelog.Versions = newer
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changelog)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	EventStudentCreated = "student.created"
+	EventStudentUpdated = "student.updated"
+	EventStudentDeleted = "student.deleted"
+)
+
+// Change is one entry in the roster change feed. ID increases monotonically so
+// polling clients (and Zapier's deduper) can tell which changes they have seen.
+type Change struct {
+	ID         int64     `json:"id"`
+	Event      string    `json:"event"`
+	Student    Student   `json:"student"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Hook is a REST hook subscription, following Zapier's subscribe/unsubscribe model
+type Hook struct {
+	ID        int    `json:"id"`
+	TargetURL string `json:"target_url"`
+	Event     string `json:"event,omitempty"` // empty means all events
+	// Tenant is taken from the subscribing API key; the hook only receives
+	// that tenant's changes
+	Tenant string `json:"tenant,omitempty"`
+}
+
+var (
+	changes    []Change
+	changeSeq  int64
+	hooks      []Hook
+	hookSeq    int
+	hooksMutex sync.RWMutex
+
+	// changeSignal is closed and replaced whenever a change is committed, to
+	// wake up streaming readers. It is guarded by mutex.
+	changeSignal = make(chan struct{})
+)
+
+// hookTimeout bounds one delivery, so a slow target can't hold delivery
+// goroutines open
+const hookTimeout = 10 * time.Second
+
+// hookClient delivers hooks. It checks each address as it dials, so neither
+// a redirect nor a name re-pointed after subscribing can reach inside the
+// network.
+var hookClient = &http.Client{
+	Timeout: hookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: hookTimeout, Control: refuseInternalDial}).DialContext,
+	},
+}
+
+var errInternalTarget = errors.New("target_url must not point at a loopback, link-local or private address")
+
+// publicAddr reports whether hooks may be delivered to addr
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+func refuseInternalDial(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !publicAddr(addr) {
+		return errInternalTarget
+	}
+	return nil
+}
+
+// validateHookTarget accepts an absolute http or https URL whose host
+// resolves only to public addresses
+func validateHookTarget(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("target_url must be an absolute http or https URL")
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if !publicAddr(addr) {
+			return errInternalTarget
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("target_url host doesn't resolve: %s", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return errInternalTarget
+		}
+	}
+	return nil
+}
+
+// commitChange durably logs a change, applies it to the roster, adds it to the
+// change feed and notifies subscribed hooks. Callers must hold the students
+// mutex for writing. Inside a batch the log is synced, and readers and hooks
+// notified, only when the batch ends.
+func commitChange(event string, student Student) error {
+	change := Change{
+		ID:         changeSeq + 1,
+		Event:      event,
+		Student:    student,
+		OccurredAt: time.Now().UTC(),
+	}
+	if wal != nil {
+		if err := wal.write(change, openBatch == nil); err != nil {
+			return err
+		}
+	}
+	if openBatch != nil {
+		openBatch.undo = append(openBatch.undo, inverseChange(change))
+	}
+	changeSeq = change.ID
+	applyChange(change)
+	changes = append(changes, change)
+	if openBatch != nil {
+		openBatch.changes = append(openBatch.changes, change)
+		return nil
+	}
+	publishChanges(change)
+	return nil
+}
+
+// publishChanges wakes streaming readers, compacts the log if it's due and
+// notifies hooks, once changes are durable. Callers must hold mutex.
+func publishChanges(committed ...Change) {
+	close(changeSignal)
+	changeSignal = make(chan struct{})
+	if wal != nil {
+		wal.maybeCompact()
+	}
+	for _, change := range committed {
+		go deliverHooks(change)
+	}
+}
+
+// changeBatch groups writes so they share one lock and one sync of the
+// write-ahead log. If the sync fails the whole batch is rolled back.
+type changeBatch struct {
+	changes  []Change
+	undo     []Change // reverses changes, in the same order
+	seq      int64
+	retained int
+	walMark  walMark
+}
+
+// inverseChange returns the change that undoes one about to be applied.
+// Callers must hold mutex.
+func inverseChange(change Change) Change {
+	previous, _ := findStudent(change.Student.ID)
+	switch change.Event {
+	case EventStudentCreated:
+		return Change{Event: EventStudentDeleted, Student: change.Student}
+	case EventStudentDeleted:
+		return Change{Event: EventStudentCreated, Student: previous}
+	default:
+		return Change{Event: EventStudentUpdated, Student: previous}
+	}
+}
+
+// openBatch is the batch in progress, if any. It is guarded by mutex.
+var openBatch *changeBatch
+
+// beginBatchLocked starts a batch. Callers must hold mutex for writing until
+// endBatchLocked.
+func beginBatchLocked() error {
+	batch := &changeBatch{seq: changeSeq, retained: len(changes)}
+	if wal != nil {
+		mark, err := wal.mark()
+		if err != nil {
+			return err
+		}
+		batch.walMark = mark
+	}
+	openBatch = batch
+	return nil
+}
+
+// endBatchLocked makes the batch durable and publishes it, or undoes all of
+// it if the log can't be synced
+func endBatchLocked() error {
+	batch := openBatch
+	openBatch = nil
+	if len(batch.changes) == 0 {
+		return nil
+	}
+	if wal != nil {
+		if err := wal.sync(); err != nil {
+			undoBatchLocked(batch)
+			wal.rollbackOrLog(batch.walMark)
+			return err
+		}
+	}
+	publishChanges(batch.changes...)
+	return nil
+}
+
+// abortBatchLocked ends the batch without publishing it, undoing its changes
+// and truncating the log back to where the batch began
+func abortBatchLocked() {
+	batch := openBatch
+	openBatch = nil
+	undoBatchLocked(batch)
+	if wal != nil {
+		wal.rollbackOrLog(batch.walMark)
+	}
+}
+
+// undoBatchLocked takes a batch's changes back out of the roster and the
+// change feed
+func undoBatchLocked(batch *changeBatch) {
+	for i := len(batch.undo) - 1; i >= 0; i-- {
+		applyChange(batch.undo[i])
+	}
+	changeSeq = batch.seq
+	changes = changes[:batch.retained]
+}
+
+// applyChange replays a single change against the roster
+func applyChange(change Change) {
+	forgetCachedStudent(change.Student.ID)
+	switch change.Event {
+	case EventStudentCreated:
+		students = append(students, change.Student)
+		countStudentLocked(change.Student, 1)
+		noteStudentIDLocked(change.Student.ID)
+	case EventStudentUpdated:
+		for i, student := range students {
+			if student.ID == change.Student.ID {
+				students[i] = change.Student
+				countStudentLocked(student, -1)
+				countStudentLocked(change.Student, 1)
+				return
+			}
+		}
+	case EventStudentDeleted:
+		for i, student := range students {
+			if student.ID == change.Student.ID {
+				students = append(students[:i], students[i+1:]...)
+				countStudentLocked(student, -1)
+				return
+			}
+		}
+	}
+}
+
+// hookTargets are the hooks subscribed to a change
+func hookTargets(change Change) []Hook {
+	hooksMutex.RLock()
+	defer hooksMutex.RUnlock()
+	var targets []Hook
+	for _, hook := range hooks {
+		if (hook.Event == "" || hook.Event == change.Event) && visibleTo(hook.Tenant, change.Student) {
+			targets = append(targets, hook)
+		}
+	}
+	return targets
+}
+
+func deliverHooks(change Change) {
+	targets := hookTargets(change)
+	if len(targets) == 0 {
+		return
+	}
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return
+	}
+	for _, hook := range targets {
+		resp, err := hookClient.Post(hook.TargetURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			slog.Warn("Failed to deliver hook", "hook", hook.ID, "error", err)
+			continue
+		}
+		resp.Body.Close()
+		// Zapier signals an unsubscribed hook with 410 Gone
+		if resp.StatusCode == http.StatusGone {
+			removeHook(hook.ID, "")
+		}
+	}
+}
+
+// removeHook deletes a hook the tenant can see
+func removeHook(id int, tenant string) bool {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	for i, hook := range hooks {
+		if hook.ID == id && (tenant == "" || hook.Tenant == tenant) {
+			hooks = append(hooks[:i], hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func validEvent(event string) bool {
+	switch event {
+	case "", EventStudentCreated, EventStudentUpdated, EventStudentDeleted:
+		return true
+	}
+	return false
+}
+
+// handleChanges serves the polling trigger. Changes are returned newest first,
+// which is the order Zapier expects. `since` accepts a change ID or an RFC 3339 time.
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	var sinceID int64
+	var sinceTime time.Time
+	if since := r.URL.Query().Get("since"); since != "" {
+		if id, err := strconv.ParseInt(since, 10, 64); err == nil {
+			sinceID = id
+		} else if t, err := parseTime(r, since); err == nil {
+			sinceTime = t
+		} else {
+			http.Error(w, "Invalid since: must be a change ID or timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	event := r.URL.Query().Get("event")
+	if !validEvent(event) {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	tenant := requestTenantName(r)
+	mutex.RLock()
+	result := []Change{}
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.ID <= sinceID || !change.OccurredAt.After(sinceTime) {
+			break
+		}
+		if (event == "" || change.Event == event) && visibleTo(tenant, change.Student) {
+			change.OccurredAt = localTime(r, change.OccurredAt)
+			result = append(result, change)
+		}
+	}
+	mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleHookSubscribe(w http.ResponseWriter, r *http.Request) {
+	var hook Hook
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &hook); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		hook.TargetURL = r.FormValue("target_url")
+		hook.Event = r.FormValue("event")
+	}
+
+	if hook.TargetURL == "" {
+		http.Error(w, "target_url is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateHookTarget(r.Context(), hook.TargetURL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validEvent(hook.Event) {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	hook.Tenant = requestTenantName(r)
+	hooksMutex.Lock()
+	hookSeq++
+	hook.ID = hookSeq
+	hooks = append(hooks, hook)
+	hooksMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+func handleHookList(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenantName(r)
+	hooksMutex.RLock()
+	result := []Hook{}
+	for _, hook := range hooks {
+		if tenant == "" || hook.Tenant == tenant {
+			result = append(result, hook)
+		}
+	}
+	hooksMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleHookUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if !removeHook(id, requestTenantName(r)) {
+		http.Error(w, "Hook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestValidateHookTarget(t *testing.T) {
+	for target, ok := range map[string]bool{
+		"https://93.184.216.34/hooks": true,
+		"http://93.184.216.34:8080/":  true,
+		"ftp://93.184.216.34/":        false,
+		"/hooks":                      false,
+		"93.184.216.34/hooks":         false,
+		"http://127.0.0.1:8000/hooks": false,
+		"http://[::1]/":               false,
+		"http://169.254.169.254/":     false,
+		"http://10.0.0.5/":            false,
+		"http://192.168.1.1/":         false,
+		"http://[fd00::1]/":           false,
+		"http://[::ffff:127.0.0.1]/":  false,
+		"http://0.0.0.0/":             false,
+		"http://localhost:8000/hooks": false,
+	} {
+		if err := validateHookTarget(context.Background(), target); (err == nil) != ok {
+			t.Errorf("validateHookTarget(%q) = %v", target, err)
+		}
+	}
+}
+
+func TestHookClientRefusesInternalAddresses(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "169.254.169.254:80"} {
+		if err := refuseInternalDial("tcp", address, nil); err == nil {
+			t.Errorf("dialing %s was allowed", address)
+		}
+	}
+	if err := refuseInternalDial("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("dialing a public address was refused: %v", err)
+	}
+}
+
+func TestHooksAreScopedToTenant(t *testing.T) {
+	handler := tenantServer(t, "acme", "globex")
+	acme := addTestKey(t, RoleWrite, "acme")
+	globex := addTestKey(t, RoleWrite, "globex")
+	for _, secret := range []string{acme, globex} {
+		if w := serveAs(handler, secret, "POST", "/hooks", `{"target_url":"https://93.184.216.34/hooks"}`); w.Code != http.StatusCreated {
+			t.Fatalf("subscribing answered %d: %s", w.Code, w.Body)
+		}
+	}
+
+	var listed []Hook
+	w := serveAs(handler, acme, "GET", "/hooks", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed) != 1 || listed[0].Tenant != "acme" {
+		t.Fatalf("acme listed %s", w.Body)
+	}
+	if w := serveAs(handler, globex, "DELETE", fmt.Sprintf("/hooks/%d", listed[0].ID), ""); w.Code != http.StatusNotFound {
+		t.Fatalf("globex deleting acme's hook answered %d", w.Code)
+	}
+
+	targets := hookTargets(Change{Event: EventStudentCreated, Student: Student{ID: 1, Tenant: "acme"}})
+	if len(targets) != 1 || targets[0].ID != listed[0].ID {
+		t.Fatalf("an acme change goes to %v", targets)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errSimulatedOllamaFailure = newError(ErrLLMUnavailable, "simulated Ollama failure (fault injection)")
+
+// ChaosConfig controls the development-only fault injection middleware. It is
+// off unless the server is started with -chaos.
+type ChaosConfig struct {
+	Latency           Duration `json:"latency"`             // added to every request
+	Jitter            Duration `json:"jitter"`              // random extra latency up to this much
+	ErrorRate         float64  `json:"error_rate"`          // fraction of requests failed with 503
+	OllamaFailureRate float64  `json:"ollama_failure_rate"` // fraction of Ollama calls that fail
+}
+
+// Duration marshals as a Go duration string such as "250ms"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+var (
+	chaosEnabled bool
+	chaos        ChaosConfig
+	chaosMutex   sync.RWMutex
+)
+
+func currentChaos() ChaosConfig {
+	chaosMutex.RLock()
+	defer chaosMutex.RUnlock()
+	return chaos
+}
+
+func (c ChaosConfig) validate() error {
+	if c.Latency < 0 || c.Jitter < 0 {
+		return errors.New("latency and jitter must not be negative")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 || c.OllamaFailureRate < 0 || c.OllamaFailureRate > 1 {
+		return errors.New("rates must be between 0 and 1")
+	}
+	return nil
+}
+
+// injectFaults delays and randomly fails non-admin requests so clients can
+// exercise their retry and degradation paths
+func injectFaults(next http.Handler) http.Handler {
+	if !chaosEnabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		config := currentChaos()
+
+		delay := time.Duration(config.Latency)
+		if config.Jitter > 0 {
+			delay += rand.N(time.Duration(config.Jitter))
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if config.ErrorRate > 0 && rand.Float64() < config.ErrorRate {
+			enableCORS(w)
+			w.Header().Set("X-Fault-Injected", "true")
+			http.Error(w, "Simulated failure (fault injection)", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// simulateOllamaFailure reports whether this Ollama call should fail
+func simulateOllamaFailure() bool {
+	if !chaosEnabled {
+		return false
+	}
+	rate := currentChaos().OllamaFailureRate
+	return rate > 0 && rand.Float64() < rate
+}
+
+func handleChaosGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": chaosEnabled,
+		"config":  currentChaos(),
+	})
+}
+
+func handleChaosSet(w http.ResponseWriter, r *http.Request) {
+	if !chaosEnabled {
+		http.Error(w, "Fault injection is disabled; start the server with -chaos", http.StatusConflict)
+		return
+	}
+	var config ChaosConfig
+	if err := decodeJSON(w, r, &config); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := config.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	chaosMutex.Lock()
+	chaos = config
+	chaosMutex.Unlock()
+
+	handleChaosGet(w, r)
+}
@@ -0,0 +1,323 @@
+// Package client is a Go client for the Student Management API.
+//
+//	c := client.New("http://localhost:8000", os.Getenv("FEALTYX_API_KEY"))
+//	student, err := c.CreateStudent(ctx, client.Student{Name: "Ada", Age: 20, Email: "ada@example.com"})
+//
+// Idempotent requests (GET, PUT, DELETE) are retried with exponential backoff
+// on network errors and 429/502/503/504 responses, honoring Retry-After.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Student struct {
+	ID    int64  `json:"id"`
+	UUID  string `json:"uuid,omitempty"` // a UUIDv7, used in paths when set
+	Name  string `json:"name"`
+	Age   int    `json:"age"`
+	Email string `json:"email"`
+
+	// Phone is as entered and PhoneE164 as the server normalized it
+	Phone     string `json:"phone,omitempty"`
+	PhoneE164 string `json:"phone_e164,omitempty"` // ignored on create and update
+
+	// Location is geocoded from Address when the server has a geocoder
+	Address  string    `json:"address,omitempty"`
+	Location *GeoPoint `json:"location,omitempty"`
+
+	// BirthDate and EnrolledOn are dates (YYYY-MM-DD). Empty keeps them on
+	// update; EnrolledOn defaults to the day the student is created.
+	BirthDate  string `json:"birth_date,omitempty"`
+	EnrolledOn string `json:"enrolled_on,omitempty"`
+
+	// Tags and Attributes hold labels and the tenant's custom fields. Nil
+	// keeps them on update.
+	Tags       []string               `json:"tags,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+
+	LegalHold bool   `json:"legal_hold,omitempty"` // set by admins; ignored on create and update
+	Tenant    string `json:"tenant,omitempty"`     // taken from the API key; ignored on create and update
+}
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Birthday is a student's birthday. One on 29 February is on the 28th in
+// other years.
+type Birthday struct {
+	Date    string  `json:"date"`
+	Turning int     `json:"turning"`
+	Student Student `json:"student"`
+}
+
+type Summary struct {
+	Student Student `json:"student"`
+	Summary string  `json:"summary"`
+}
+
+type Change struct {
+	ID         int64     `json:"id"`
+	Event      string    `json:"event"`
+	Student    Student   `json:"student"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// APIError is returned for any non-2xx response
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("fealtyx: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type Client struct {
+	baseURL    string
+	apiKey     string
+	HTTPClient *http.Client
+	MaxRetries int
+}
+
+// New returns a client for the API at baseURL. apiKey may be empty when the
+// server accepts anonymous requests.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute},
+		MaxRetries: 3,
+	}
+}
+
+func (c *Client) ListStudents(ctx context.Context) ([]Student, error) {
+	var students []Student
+	err := c.do(ctx, http.MethodGet, "/students", nil, &students)
+	return students, err
+}
+
+// StudentPage is one page of the student list
+type StudentPage struct {
+	Total int       `json:"total"` // students on all pages
+	Page  int       `json:"page"`
+	Limit int       `json:"limit"`
+	Items []Student `json:"items"`
+}
+
+// ListOptions picks a page of students. Zero values are left to the server:
+// the first page, 50 students to a page, ordered by ID and unfiltered.
+type ListOptions struct {
+	Page  int
+	Limit int
+	// Sort is id, name or age, with a leading - for descending order
+	Sort   string
+	Name   string // part of the name, in any case
+	MinAge int
+	MaxAge int
+}
+
+// ListStudentsPage fetches a page of students
+func (c *Client) ListStudentsPage(ctx context.Context, options ListOptions) (StudentPage, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(max(options.Page, 1)))
+	if options.Limit != 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+	if options.Sort != "" {
+		query.Set("sort", options.Sort)
+	}
+	if options.Name != "" {
+		query.Set("name", options.Name)
+	}
+	if options.MinAge != 0 {
+		query.Set("min_age", strconv.Itoa(options.MinAge))
+	}
+	if options.MaxAge != 0 {
+		query.Set("max_age", strconv.Itoa(options.MaxAge))
+	}
+	var page StudentPage
+	err := c.do(ctx, http.MethodGet, "/students?"+query.Encode(), nil, &page)
+	return page, err
+}
+
+// studentsPageLimit is the largest page the server returns
+const studentsPageLimit = 500
+
+// Students iterates over every student in ID order, fetching a page at a time
+// and stopping at the first error. Students created while it runs may be
+// included; deleting one moves those after it up a page, so one may be missed.
+func (c *Client) Students(ctx context.Context) iter.Seq2[Student, error] {
+	return func(yield func(Student, error) bool) {
+		for number := 1; ; number++ {
+			page, err := c.ListStudentsPage(ctx, ListOptions{Page: number, Limit: studentsPageLimit})
+			if err != nil {
+				yield(Student{}, err)
+				return
+			}
+			for _, student := range page.Items {
+				if !yield(student, nil) {
+					return
+				}
+			}
+			if len(page.Items) == 0 || number*page.Limit >= page.Total {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) GetStudent(ctx context.Context, id int64) (Student, error) {
+	var student Student
+	err := c.do(ctx, http.MethodGet, "/students/"+strconv.FormatInt(id, 10), nil, &student)
+	return student, err
+}
+
+func (c *Client) CreateStudent(ctx context.Context, student Student) (Student, error) {
+	var created Student
+	err := c.do(ctx, http.MethodPost, "/students", student, &created)
+	return created, err
+}
+
+func (c *Client) UpdateStudent(ctx context.Context, student Student) (Student, error) {
+	var updated Student
+	err := c.do(ctx, http.MethodPut, studentPath(student), student, &updated)
+	return updated, err
+}
+
+func (c *Client) DeleteStudent(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/students/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
+// GetStudentByUUID fetches a student by UUID, which servers accept in paths
+// even once numeric IDs are retired
+func (c *Client) GetStudentByUUID(ctx context.Context, uuid string) (Student, error) {
+	var student Student
+	err := c.do(ctx, http.MethodGet, "/students/"+url.PathEscape(uuid), nil, &student)
+	return student, err
+}
+
+// AddTags adds tags to a student, keeping the ones it has
+func (c *Client) AddTags(ctx context.Context, student Student, tags ...string) (Student, error) {
+	var updated Student
+	err := c.do(ctx, http.MethodPost, studentPath(student)+"/tags", map[string][]string{"tags": tags}, &updated)
+	return updated, err
+}
+
+// UpcomingBirthdays returns the birthdays from today through the next days
+// days, soonest first
+func (c *Client) UpcomingBirthdays(ctx context.Context, days int) ([]Birthday, error) {
+	var page struct {
+		Birthdays []Birthday `json:"birthdays"`
+	}
+	err := c.do(ctx, http.MethodGet, "/students/birthdays/upcoming?days="+strconv.Itoa(days), nil, &page)
+	return page.Birthdays, err
+}
+
+func (c *Client) Summary(ctx context.Context, id int64) (Summary, error) {
+	var summary Summary
+	err := c.do(ctx, http.MethodGet, "/students/"+strconv.FormatInt(id, 10)+"/summary", nil, &summary)
+	return summary, err
+}
+
+// studentPath addresses a student by UUID when it has one, else by ID
+func studentPath(student Student) string {
+	if student.UUID != "" {
+		return "/students/" + url.PathEscape(student.UUID)
+	}
+	return "/students/" + strconv.FormatInt(student.ID, 10)
+}
+
+// Changes returns roster changes newer than since, oldest first
+func (c *Client) Changes(ctx context.Context, since int64) ([]Change, error) {
+	var changes []Change
+	err := c.do(ctx, http.MethodGet, "/students/changes?since="+url.QueryEscape(strconv.FormatInt(since, 10)), nil, &changes)
+	// The server returns newest first for polling triggers
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes, err
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	idempotent := method != http.MethodPost
+
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, payload)
+		wait := backoff
+		if err == nil {
+			if resp.StatusCode < 300 {
+				defer resp.Body.Close()
+				if out == nil || resp.StatusCode == http.StatusNoContent {
+					return nil
+				}
+				return json.NewDecoder(resp.Body).Decode(out)
+			}
+			message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+			if !retryable(resp.StatusCode) {
+				return err
+			}
+			if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !idempotent || attempt >= c.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return c.HTTPClient.Do(req)
+}
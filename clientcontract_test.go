@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/behalnihal/fealtyx/client"
+)
+
+// wireRecorder keeps every request and response body the client exchanges
+// with the server, so tests can check their shapes as well as the decoded
+// values
+type wireRecorder struct {
+	mu        sync.Mutex
+	exchanges []wireExchange
+}
+
+type wireExchange struct {
+	method, path   string
+	status         int
+	request, reply []byte
+}
+
+func (rec *wireRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := wireExchange{method: req.Method, path: req.URL.RequestURI()}
+	if req.Body != nil {
+		exchange.request, _ = io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(exchange.request))
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	exchange.status = resp.StatusCode
+	exchange.reply, _ = io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(exchange.reply))
+	rec.mu.Lock()
+	rec.exchanges = append(rec.exchanges, exchange)
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+func (rec *wireRecorder) last(t *testing.T) wireExchange {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.exchanges) == 0 {
+		t.Fatal("no requests were made")
+	}
+	return rec.exchanges[len(rec.exchanges)-1]
+}
+
+// newContractClient serves the real routes, behind the middleware that shapes
+// what goes over the wire, from an empty roster
+func newContractClient(t *testing.T) (*client.Client, *wireRecorder) {
+	t.Helper()
+	mutex.Lock()
+	students, changes, changeSeq, lastStudentID = nil, nil, 0, 0
+	rebuildStatsLocked()
+	mutex.Unlock()
+	if err := configureSummaryProvider("", true); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, apiRoutes())
+	server := httptest.NewServer(authenticate(shapeResponses(mux)))
+	t.Cleanup(server.Close)
+
+	recorder := &wireRecorder{}
+	c := client.New(server.URL, "")
+	c.HTTPClient = &http.Client{Transport: recorder, Timeout: 10 * time.Second}
+	c.MaxRetries = 0
+	return c, recorder
+}
+
+// decodeStrict decodes data into v, failing on fields v doesn't have
+func decodeStrict(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		t.Fatalf("%T doesn't match the wire: %v\n%s", v, err, data)
+	}
+}
+
+// checkReply asserts the last exchange and that its response decodes into
+// each of types: the client's and, where the server has one, the server's,
+// so neither side has fields the other lacks
+func checkReply(t *testing.T, recorder *wireRecorder, method, path string, status int, types ...interface{}) {
+	t.Helper()
+	exchange := recorder.last(t)
+	if exchange.method != method || exchange.path != path || exchange.status != status {
+		t.Fatalf("got %s %s -> %d, want %s %s -> %d", exchange.method, exchange.path, exchange.status, method, path, status)
+	}
+	for _, v := range types {
+		decodeStrict(t, exchange.reply, v)
+	}
+}
+
+func TestClientStudentLifecycle(t *testing.T) {
+	c, recorder := newContractClient(t)
+	ctx := context.Background()
+
+	created, err := c.CreateStudent(ctx, client.Student{Name: "Ada Lovelace", Age: 20, Email: "ada@example.com",
+		Phone: "+44 20 7946 0958", BirthDate: "2005-12-10", Tags: []string{"Maths"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeStrict(t, recorder.last(t).request, &Student{})
+	checkReply(t, recorder, "POST", "/students", http.StatusCreated, &client.Student{}, &Student{})
+	if created.ID != 1 || len(created.UUID) != 36 || created.EnrolledOn == "" || created.PhoneE164 != "+442079460958" {
+		t.Fatalf("unexpected student %+v", created)
+	}
+
+	got, err := c.GetStudent(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students/1", http.StatusOK, &client.Student{}, &Student{})
+	if got.Name != created.Name || got.UUID != created.UUID {
+		t.Fatalf("got %+v, want %+v", got, created)
+	}
+	if byUUID, err := c.GetStudentByUUID(ctx, created.UUID); err != nil || byUUID.ID != created.ID {
+		t.Fatalf("by UUID: %+v, %v", byUUID, err)
+	}
+
+	got.Age = 21
+	updated, err := c.UpdateStudent(ctx, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeStrict(t, recorder.last(t).request, &Student{})
+	checkReply(t, recorder, "PUT", "/students/"+created.UUID, http.StatusOK, &client.Student{}, &Student{})
+	if updated.Age != 21 || updated.Email != created.Email {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+
+	tagged, err := c.AddTags(ctx, updated, "physics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "POST", "/students/"+created.UUID+"/tags", http.StatusOK, &client.Student{}, &Student{})
+	if strings.Join(tagged.Tags, ",") != "maths,physics" {
+		t.Fatalf("tags %v", tagged.Tags)
+	}
+
+	if err := c.DeleteStudent(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if exchange := recorder.last(t); exchange.status != http.StatusNoContent || len(exchange.reply) != 0 {
+		t.Fatalf("delete answered %d %q", exchange.status, exchange.reply)
+	}
+	_, err = c.GetStudent(ctx, created.ID)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message == "" {
+		t.Fatalf("got %v, want a 404 APIError", err)
+	}
+}
+
+func TestClientValidationError(t *testing.T) {
+	c, _ := newContractClient(t)
+	_, err := c.CreateStudent(context.Background(), client.Student{Name: "", Age: 20, Email: "not-an-email"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %v, want a 400 APIError", err)
+	}
+}
+
+func TestClientListStudentsPage(t *testing.T) {
+	c, recorder := newContractClient(t)
+	ctx := context.Background()
+	for i, name := range []string{"Ada", "Grace", "Alan", "Adele"} {
+		if _, err := c.CreateStudent(ctx, client.Student{Name: name, Age: 18 + i, Email: strings.ToLower(name) + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := c.ListStudentsPage(ctx, client.ListOptions{Limit: 2, Sort: "-age", Name: "ad", MinAge: 18})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students?limit=2&min_age=18&name=ad&page=1&sort=-age", http.StatusOK,
+		&client.StudentPage{}, &StudentPage{})
+	if page.Total != 2 || page.Page != 1 || page.Limit != 2 || len(page.Items) != 2 ||
+		page.Items[0].Name != "Adele" || page.Items[1].Name != "Ada" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	all, err := c.ListStudents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students", http.StatusOK, &[]client.Student{}, &[]Student{})
+	if len(all) != 4 {
+		t.Fatalf("listed %d students, want 4", len(all))
+	}
+}
+
+func TestClientStudentsIteratorPages(t *testing.T) {
+	c, recorder := newContractClient(t)
+	const total = 1203
+	mutex.Lock()
+	for id := int64(1); id <= total; id++ {
+		applyChange(Change{Event: EventStudentCreated,
+			Student: Student{ID: id, Name: fmt.Sprintf("Student %d", id), Age: 20, Email: fmt.Sprintf("s%d@example.com", id)}})
+	}
+	mutex.Unlock()
+
+	var seen int64
+	for student, err := range c.Students(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen++
+		if student.ID != seen {
+			t.Fatalf("student %d came %dth", student.ID, seen)
+		}
+	}
+	if seen != total {
+		t.Fatalf("iterated over %d students, want %d", seen, total)
+	}
+	var pages []string
+	for _, exchange := range recorder.exchanges {
+		pages = append(pages, exchange.path)
+	}
+	want := "/students?limit=500&page=1 /students?limit=500&page=2 /students?limit=500&page=3"
+	if strings.Join(pages, " ") != want {
+		t.Fatalf("requested %v, want %s", pages, want)
+	}
+
+	// stopping early fetches no more pages
+	recorder.exchanges = nil
+	for range c.Students(context.Background()) {
+		break
+	}
+	if len(recorder.exchanges) != 1 {
+		t.Fatalf("made %d requests after breaking out, want 1", len(recorder.exchanges))
+	}
+}
+
+func TestClientChangesOldestFirst(t *testing.T) {
+	c, recorder := newContractClient(t)
+	ctx := context.Background()
+	created, err := c.CreateStudent(ctx, client.Student{Name: "Ada", Age: 20, Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Age = 21
+	if _, err := c.UpdateStudent(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := c.Changes(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students/changes?since=0", http.StatusOK, &[]client.Change{}, &[]Change{})
+	if len(changes) != 2 || changes[0].Event != EventStudentCreated || changes[1].Event != EventStudentUpdated ||
+		changes[1].Student.Age != 21 || changes[0].OccurredAt.IsZero() {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+}
+
+func TestClientSummary(t *testing.T) {
+	c, recorder := newContractClient(t)
+	ctx := context.Background()
+	created, err := c.CreateStudent(ctx, client.Student{Name: "Ada", Age: 20, Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := c.Summary(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students/1/summary", http.StatusOK, &client.Summary{})
+	if summary.Student.ID != created.ID || !strings.Contains(summary.Summary, "Ada") {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
+
+func TestClientUpcomingBirthdays(t *testing.T) {
+	c, recorder := newContractClient(t)
+	ctx := context.Background()
+	birthDate := time.Now().AddDate(-20, 0, 1)
+	if birthDate.Month() == time.February && birthDate.Day() == 29 {
+		birthDate = birthDate.AddDate(0, 0, 1)
+	}
+	if _, err := c.CreateStudent(ctx, client.Student{Name: "Ada", Age: 19, Email: "ada@example.com",
+		BirthDate: birthDate.Format(time.DateOnly)}); err != nil {
+		t.Fatal(err)
+	}
+
+	birthdays, err := c.UpcomingBirthdays(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReply(t, recorder, "GET", "/students/birthdays/upcoming?days=7", http.StatusOK, &struct {
+		From      string            `json:"from"`
+		To        string            `json:"to"`
+		Birthdays []client.Birthday `json:"birthdays"`
+	}{})
+	if len(birthdays) != 1 || birthdays[0].Turning != 20 || birthdays[0].Student.Name != "Ada" {
+		t.Fatalf("unexpected birthdays %+v", birthdays)
+	}
+}
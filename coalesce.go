@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls with the same key: the first
+// caller starts the function and the rest wait for its result. Results aren't
+// kept once the call returns.
+type flightGroup[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn, or waits for the call already running for key. shared is true
+// when the result came from another caller's call. A waiter whose context
+// ends stops waiting, and when the last one has gone the call's context is
+// cancelled, so nothing carries on for nobody.
+func (g *flightGroup[K, V]) do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (value V, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[K]*flightCall[V]{}
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.value, call.err = fn(callCtx)
+			cancel()
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, shared, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			// later callers start afresh rather than join a cancelled call
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return value, shared, ctx.Err()
+	}
+}
+
+// summaryKey identifies summaries that would come out of the same prompt:
+// the same version of a student in the same language
+type summaryKey struct {
+	id          int64
+	locale      string
+	fingerprint uint64
+}
+
+var summaryFlights flightGroup[summaryKey, string]
+
+// generateSummary is summarizeStudent with concurrent requests for the same
+// summary sharing one call, and one reservation of the LLM quotas. The call
+// is cancelled once every request waiting for it has gone away.
+func generateSummary(ctx context.Context, student Student, locale string) (string, error) {
+	hash := fnv.New64a()
+	hash.Write(student.appendJSON(nil))
+	key := summaryKey{id: student.ID, locale: locale, fingerprint: hash.Sum64()}
+	summary, shared, err := summaryFlights.do(ctx, key, func(ctx context.Context) (string, error) {
+		return summarizeStudent(ctx, student, locale)
+	})
+	if shared {
+		slog.Debug("Shared a summary already being generated", "student", student.ID, "locale", locale)
+	}
+	return summary, err
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"text/template"
+)
+
+const defaultPromptTemplate = "Generate a brief, friendly summary of this student: Name: {{.Name}}, Age: {{.Age}}, Email: {{.Email}}. Keep it under 100 words. Don't include any other text like 'Here is the summary' or 'Here is the student' or 'Here is the student summary'. Just the summary."
+
+// Config holds the settings that are safe to change while the server runs.
+// Fields left out of the config file keep their current value.
+type Config struct {
+	LogLevel          *string `json:"log_level"`
+	PromptTemplate    *string `json:"prompt_template"`
+	LLMModel          *string `json:"llm_model"`
+	OllamaModel       *string `json:"ollama_model"` // older name of llm_model
+	MaxStudents       *int    `json:"max_students"`
+	MaxLLMCallsPerDay *int    `json:"max_llm_calls_per_day"`
+	SLOs              []SLO   `json:"slos"`
+
+	Tenants   map[string]TenantSettings `json:"tenants"`
+	Retention *RetentionPolicy          `json:"retention"`
+	Signup    *SignupSettings           `json:"signup"`
+	Abuse     *AbuseSettings            `json:"abuse"`
+
+	SyncConflictPolicy *string `json:"sync_conflict_policy"`
+	PasswordResetURL   *string `json:"password_reset_url"` // page that accepts ?token=
+}
+
+var (
+	configPath string
+
+	settings = struct {
+		mu             sync.RWMutex
+		promptTemplate *template.Template
+		llmModel       string
+	}{
+		promptTemplate: template.Must(template.New("prompt").Parse(defaultPromptTemplate)),
+		llmModel:       "llama3.2",
+	}
+)
+
+// renderPrompt fills the summary prompt template for a student, using their
+// tenant's template if it has one
+func renderPrompt(student Student) (string, error) {
+	settings.mu.RLock()
+	tmpl := settings.promptTemplate
+	settings.mu.RUnlock()
+	if tenant, ok := lookupTenant(student.Tenant); ok && tenant.prompt != nil {
+		tmpl = tenant.prompt
+	}
+
+	var prompt bytes.Buffer
+	if err := tmpl.Execute(&prompt, student); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	return prompt.String(), nil
+}
+
+// llmModel is the model the LLM provider is asked to use
+func llmModel() string {
+	settings.mu.RLock()
+	defer settings.mu.RUnlock()
+	return settings.llmModel
+}
+
+// loadConfig reads the config file and applies it. Everything is validated
+// before anything is applied, so a bad file leaves the running config intact.
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid config file: %v", err)
+	}
+
+	var level slog.Level
+	if config.LogLevel != nil {
+		if level, err = parseLogLevel(*config.LogLevel); err != nil {
+			return err
+		}
+	}
+	var tmpl *template.Template
+	if config.PromptTemplate != nil {
+		if tmpl, err = template.New("prompt").Parse(*config.PromptTemplate); err != nil {
+			return fmt.Errorf("invalid prompt_template: %v", err)
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, Student{}); err != nil {
+			return fmt.Errorf("invalid prompt_template: %v", err)
+		}
+	}
+	if config.OllamaModel != nil {
+		if config.LLMModel != nil && *config.LLMModel != *config.OllamaModel {
+			return fmt.Errorf("ollama_model is the older name of llm_model; set only llm_model")
+		}
+		config.LLMModel = config.OllamaModel
+	}
+	if config.LLMModel != nil && *config.LLMModel == "" {
+		return fmt.Errorf("llm_model must not be empty")
+	}
+	if (config.MaxStudents != nil && *config.MaxStudents < 0) ||
+		(config.MaxLLMCallsPerDay != nil && *config.MaxLLMCallsPerDay < 0) {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	for _, objective := range config.SLOs {
+		if err := objective.validate(); err != nil {
+			return err
+		}
+	}
+	if config.Retention != nil {
+		if err := config.Retention.validate(); err != nil {
+			return err
+		}
+	}
+	if config.Signup != nil {
+		if err := config.Signup.validate(); err != nil {
+			return err
+		}
+	}
+	if config.Abuse != nil {
+		if err := config.Abuse.validate(); err != nil {
+			return err
+		}
+	}
+	if config.PasswordResetURL != nil && *config.PasswordResetURL != "" {
+		if u, err := url.Parse(*config.PasswordResetURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("password_reset_url must be an http or https URL")
+		}
+	}
+	if config.SyncConflictPolicy != nil && !validConflictPolicy(*config.SyncConflictPolicy) {
+		return fmt.Errorf("sync_conflict_policy must be last-write-wins, server-wins or manual")
+	}
+	var tenantSettings map[string]Tenant
+	if config.Tenants != nil {
+		if tenantSettings, err = compileTenantSettings(config.Tenants); err != nil {
+			return err
+		}
+	}
+
+	if config.LogLevel != nil {
+		logLevel.Set(level)
+	}
+	settings.mu.Lock()
+	if tmpl != nil {
+		settings.promptTemplate = tmpl
+	}
+	if config.LLMModel != nil {
+		settings.llmModel = *config.LLMModel
+	}
+	if config.SyncConflictPolicy != nil {
+		syncConflictPolicy = *config.SyncConflictPolicy
+	}
+	if config.PasswordResetURL != nil {
+		passwordResetURL = *config.PasswordResetURL
+	}
+	settings.mu.Unlock()
+
+	quotas.mu.Lock()
+	if config.MaxStudents != nil {
+		quotas.maxStudents = *config.MaxStudents
+	}
+	if config.MaxLLMCallsPerDay != nil {
+		quotas.maxLLMCallsPerDay = *config.MaxLLMCallsPerDay
+	}
+	quotas.mu.Unlock()
+
+	if config.SLOs != nil {
+		setSLOs(config.SLOs)
+	}
+	if tenantSettings != nil {
+		applyTenantSettings(tenantSettings)
+	}
+	if config.Retention != nil {
+		setRetentionPolicy(*config.Retention)
+	}
+	if config.Signup != nil {
+		setSignupSettings(*config.Signup)
+	}
+	if config.Abuse != nil {
+		setAbuseSettings(*config.Abuse)
+	}
+	return nil
+}
+
+// handleConfigReload re-reads the config file (and the feature flags file, if
+// any). Requests already in flight finish with the settings they started with.
+func handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if configPath == "" && featureFlagsPath == "" {
+		http.Error(w, "No config file configured", http.StatusConflict)
+		return
+	}
+	if configPath != "" {
+		if err := loadConfig(configPath); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reload config: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if featureFlagsPath != "" {
+		if err := loadFeatureFlags(featureFlagsPath); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reload feature flags: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	slog.Info("Configuration reloaded", "config", configPath, "flags", featureFlagsPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "reloaded"})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Conflict is a sync conflict waiting for, or resolved by, human review
+type Conflict struct {
+	ID         int          `json:"id"`
+	Conflict   SyncConflict `json:"conflict"`
+	Status     string       `json:"status"` // "open" or "resolved"
+	CreatedAt  time.Time    `json:"created_at"`
+	ResolvedAt *time.Time   `json:"resolved_at,omitempty"`
+	Resolution string       `json:"resolution,omitempty"` // "client" or "server"
+	Tenant     string       `json:"tenant,omitempty"`
+}
+
+var (
+	conflicts      []*Conflict
+	conflictSeq    int
+	conflictsMutex sync.Mutex
+)
+
+// queueConflict stores a conflict for review and returns its ID. It is called
+// with mutex held.
+func queueConflict(conflict SyncConflict, tenant string) int {
+	conflictsMutex.Lock()
+	defer conflictsMutex.Unlock()
+	conflictSeq++
+	conflicts = append(conflicts, &Conflict{ID: conflictSeq, Conflict: conflict, Status: "open", CreatedAt: time.Now().UTC(), Tenant: tenant})
+	return conflictSeq
+}
+
+// handleConflictList lists queued conflicts, open ones only unless ?all=true
+func handleConflictList(w http.ResponseWriter, r *http.Request) {
+	all := r.URL.Query().Get("all") == "true"
+	tenant := requestTenantName(r)
+
+	conflictsMutex.Lock()
+	result := []Conflict{}
+	for _, conflict := range conflicts {
+		if (all || conflict.Status == "open") && (tenant == "" || conflict.Tenant == tenant) {
+			result = append(result, *conflict)
+		}
+	}
+	conflictsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ConflictResolveRequest is the body of POST /conflicts/{id}/resolve
+type ConflictResolveRequest struct {
+	Resolution string `json:"resolution"`
+}
+
+// handleConflictResolve settles a queued conflict: "client" applies the
+// offline edit now, "server" keeps the server version
+func handleConflictResolve(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	var input ConflictResolveRequest
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &input); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		input.Resolution = r.FormValue("resolution")
+	}
+	if input.Resolution != "client" && input.Resolution != "server" {
+		http.Error(w, "resolution must be client or server", http.StatusBadRequest)
+		return
+	}
+
+	// Lock order is mutex, then conflictsMutex, matching applySync. Holding
+	// mutex throughout keeps other resolutions out; conflictsMutex is let go
+	// while the edit is applied, since deleting checks the open conflicts.
+	mutex.Lock()
+	defer mutex.Unlock()
+	conflictsMutex.Lock()
+
+	tenant := requestTenantName(r)
+	var conflict *Conflict
+	for _, c := range conflicts {
+		if c.ID == id && (tenant == "" || c.Tenant == tenant) {
+			conflict = c
+		}
+	}
+	if conflict == nil {
+		conflictsMutex.Unlock()
+		http.Error(w, "Conflict not found", http.StatusNotFound)
+		return
+	}
+	if conflict.Status != "open" {
+		conflictsMutex.Unlock()
+		http.Error(w, "Conflict is already resolved", http.StatusConflict)
+		return
+	}
+	// Resolved before applying, so the conflict doesn't block its own delete
+	now := time.Now().UTC()
+	conflict.Status = "resolved"
+	conflict.Resolution = input.Resolution
+	conflict.ResolvedAt = &now
+	conflictsMutex.Unlock()
+
+	if input.Resolution == "client" {
+		op := SyncOperation{Op: conflict.Conflict.Op, ID: conflict.Conflict.ID, Student: conflict.Conflict.Client}
+		op.Student.Tenant = conflict.Tenant
+		server, exists := findStudent(op.ID)
+		var err error
+		if exists {
+			_, err = applySyncOperationLocked(op, server)
+		}
+		if !exists || err != nil {
+			conflictsMutex.Lock()
+			conflict.Status = "open"
+			conflict.Resolution = ""
+			conflict.ResolvedAt = nil
+			conflictsMutex.Unlock()
+		}
+		switch {
+		case !exists:
+			http.Error(w, "Student no longer exists", http.StatusConflict)
+			return
+		case err != nil:
+			writeError(w, r, err, "Failed to apply the client version")
+			return
+		}
+	}
+
+	conflictsMutex.Lock()
+	response := *conflict
+	conflictsMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
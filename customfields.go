@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Custom fields let each tenant track what its schools need (house, bus
+// route, allergies) in a student's attributes. A tenant defines its fields,
+// and every write checks the attributes against them; "" holds the fields of
+// untenanted students.
+
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	FieldDate    = "date" // YYYY-MM-DD
+	FieldEnum    = "enum" // one of Options
+
+	maxCustomFields     = 50  // per tenant
+	maxAttributeLength  = 500 // characters in a string value
+	customFieldDateForm = "2006-01-02"
+)
+
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+type CustomField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Options  []string `json:"options,omitempty"` // the allowed values of an enum
+}
+
+func (f CustomField) validate() error {
+	if !customFieldNamePattern.MatchString(f.Name) {
+		return fmt.Errorf("name must be 1-40 lowercase letters, digits or underscores, starting with a letter")
+	}
+	switch f.Type {
+	case FieldString, FieldNumber, FieldBoolean, FieldDate:
+		if len(f.Options) > 0 {
+			return fmt.Errorf("options are only for enum fields")
+		}
+	case FieldEnum:
+		if len(f.Options) == 0 {
+			return fmt.Errorf("an enum field needs options")
+		}
+		seen := map[string]bool{}
+		for _, option := range f.Options {
+			if option == "" || seen[option] {
+				return fmt.Errorf("options must be non-empty and distinct")
+			}
+			seen[option] = true
+		}
+	default:
+		return fmt.Errorf("type must be %s, %s, %s, %s or %s", FieldString, FieldNumber, FieldBoolean, FieldDate, FieldEnum)
+	}
+	return nil
+}
+
+// check validates one attribute value, returning it in its stored form
+func (f CustomField) check(value interface{}) (interface{}, error) {
+	switch f.Type {
+	case FieldString:
+		if s, ok := value.(string); ok && len([]rune(s)) <= maxAttributeLength {
+			return s, nil
+		}
+		return nil, fmt.Errorf("must be a string of at most %d characters", maxAttributeLength)
+	case FieldNumber:
+		switch n := value.(type) {
+		case float64:
+			return n, nil
+		case json.Number:
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a number")
+	case FieldBoolean:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("must be true or false")
+	case FieldDate:
+		if s, ok := value.(string); ok {
+			if _, err := time.Parse(customFieldDateForm, s); err == nil {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a date such as 2026-09-01")
+	case FieldEnum:
+		if s, ok := value.(string); ok {
+			for _, option := range f.Options {
+				if s == option {
+					return s, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(f.Options, ", "))
+	}
+	return nil, fmt.Errorf("has unknown type %q", f.Type)
+}
+
+// customFields are each tenant's field definitions by name. Writes check
+// attributes with mutex held, so customFieldsMutex comes after it.
+var (
+	customFields      = map[string]map[string]CustomField{}
+	customFieldsMutex sync.RWMutex
+)
+
+// AttributeError lists a student's attributes that break the tenant's field
+// definitions
+type AttributeError struct {
+	Problems map[string]string // by attribute name
+}
+
+func (e *AttributeError) Unwrap() error { return ErrInvalid }
+
+func (e *AttributeError) Error() string {
+	names := make([]string, 0, len(e.Problems))
+	for name := range e.Problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = "attributes." + name + " " + e.Problems[name]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// checkAttributes validates attributes against the tenant's fields and
+// returns them in their stored form. Values stored before a field was
+// redefined are checked again on the student's next write.
+func checkAttributes(tenant string, attributes map[string]interface{}) (map[string]interface{}, error) {
+	customFieldsMutex.RLock()
+	defer customFieldsMutex.RUnlock()
+	fields := customFields[tenant]
+	problems := map[string]string{}
+	checked := make(map[string]interface{}, len(attributes))
+	for name, value := range attributes {
+		field, ok := fields[name]
+		if !ok {
+			problems[name] = "is not a defined field"
+			continue
+		}
+		if value == nil {
+			continue // null clears the attribute
+		}
+		stored, err := field.check(value)
+		if err != nil {
+			problems[name] = err.Error()
+			continue
+		}
+		checked[name] = stored
+	}
+	for name, field := range fields {
+		if _, ok := checked[name]; field.Required && !ok && problems[name] == "" {
+			problems[name] = "is required"
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &AttributeError{Problems: problems}
+	}
+	if len(checked) == 0 {
+		return nil, nil
+	}
+	return checked, nil
+}
+
+// formatAttribute renders an attribute value the way filters and search
+// compare it
+func formatAttribute(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(value)
+}
+
+func writeAttributeError(w http.ResponseWriter, r *http.Request, err *AttributeError) {
+	http.Error(w, localize(r, "Invalid attributes")+": "+err.Error(), http.StatusBadRequest)
+}
+
+// handleFieldList lists the caller's custom fields by name
+func handleFieldList(w http.ResponseWriter, r *http.Request) {
+	customFieldsMutex.RLock()
+	result := []CustomField{}
+	for _, field := range customFields[requestTenantName(r)] {
+		result = append(result, field)
+	}
+	customFieldsMutex.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleFieldPut defines or redefines one of the caller's custom fields.
+// Students keep their values; ones that no longer fit are caught on the
+// student's next write.
+func handleFieldPut(w http.ResponseWriter, r *http.Request) {
+	var field CustomField
+	if err := decodeJSON(w, r, &field); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if field.Name == "" {
+		field.Name = r.PathValue("name")
+	}
+	if field.Name != r.PathValue("name") {
+		http.Error(w, "name must match the path", http.StatusBadRequest)
+		return
+	}
+	if err := field.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant := requestTenantName(r)
+	customFieldsMutex.Lock()
+	fields := customFields[tenant]
+	_, exists := fields[field.Name]
+	if !exists && len(fields) >= maxCustomFields {
+		customFieldsMutex.Unlock()
+		http.Error(w, fmt.Sprintf("A tenant can define at most %d custom fields", maxCustomFields), http.StatusConflict)
+		return
+	}
+	if fields == nil {
+		fields = map[string]CustomField{}
+		customFields[tenant] = fields
+	}
+	fields[field.Name] = field
+	customFieldsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(field)
+}
+
+// handleFieldDelete removes one of the caller's custom fields, first taking
+// the attribute off their students as one batch
+func handleFieldDelete(w http.ResponseWriter, r *http.Request) {
+	tenant, name := requestTenantName(r), r.PathValue("name")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	customFieldsMutex.RLock()
+	_, exists := customFields[tenant][name]
+	customFieldsMutex.RUnlock()
+	if !exists {
+		http.Error(w, "Field not found", http.StatusNotFound)
+		return
+	}
+
+	var affected []Student
+	for _, student := range students {
+		if _, ok := student.Attributes[name]; ok && student.Tenant == tenant {
+			affected = append(affected, student)
+		}
+	}
+	if err := removeAttributeLocked(affected, name); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove the attribute from students: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	customFieldsMutex.Lock()
+	delete(customFields[tenant], name)
+	if len(customFields[tenant]) == 0 {
+		delete(customFields, tenant)
+	}
+	customFieldsMutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeAttributeLocked drops an attribute from students. Callers must hold
+// mutex.
+func removeAttributeLocked(affected []Student, name string) error {
+	if len(affected) == 0 {
+		return nil
+	}
+	if err := beginBatchLocked(); err != nil {
+		return err
+	}
+	for _, student := range affected {
+		attributes := make(map[string]interface{}, len(student.Attributes))
+		for key, value := range student.Attributes {
+			if key != name {
+				attributes[key] = value
+			}
+		}
+		if len(attributes) == 0 {
+			attributes = nil
+		}
+		student.Attributes = attributes
+		if err := commitChange(EventStudentUpdated, student); err != nil {
+			endBatchLocked()
+			return err
+		}
+	}
+	return endBatchLocked()
+}
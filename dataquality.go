@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A student's data quality score starts at 100 and loses points for each
+// problem found: fields left empty, a record nobody has written in a long
+// time, and data that fails today's rules, which may have changed since it
+// was stored. It's computed on demand, so it always reflects current rules.
+
+const (
+	staleAfter           = 365 * 24 * time.Hour // records unwritten for longer are stale
+	defaultQualityWorst  = 20                   // students listed by the dashboard
+	maxQualityWorstLimit = 500
+)
+
+// Quality checks and the points each one costs
+const (
+	QualityInvalid         = "invalid"       // fails validation
+	QualityAttributes      = "attributes"    // breaks the tenant's field definitions
+	QualityEmailDomain     = "email_domain"  // rejected by, or questionable under, the email policy
+	QualityPhoneInvalid    = "phone_invalid" // can't be normalized
+	QualityMissingPhone    = "missing_phone"
+	QualityMissingAddress  = "missing_address"
+	QualityNotGeocoded     = "not_geocoded" // has an address but no location
+	QualityMissingBirth    = "missing_birth_date"
+	QualityMissingEnrolled = "missing_enrolled_on"
+	QualityStale           = "stale" // not written for staleAfter
+)
+
+var qualityPenalties = map[string]int{
+	QualityInvalid:         40,
+	QualityAttributes:      20,
+	QualityStale:           20,
+	QualityEmailDomain:     15,
+	QualityPhoneInvalid:    10,
+	QualityMissingPhone:    10,
+	QualityMissingAddress:  10,
+	QualityMissingBirth:    10,
+	QualityNotGeocoded:     5,
+	QualityMissingEnrolled: 5,
+}
+
+type QualityIssue struct {
+	Check   string `json:"check"`
+	Penalty int    `json:"penalty"`
+	Message string `json:"message,omitempty"`
+}
+
+type StudentQuality struct {
+	ID     int64          `json:"id"`
+	Name   string         `json:"name"`
+	Score  int            `json:"score"` // 0 to 100
+	Issues []QualityIssue `json:"issues"`
+}
+
+// writeHorizon is when each student was last written, from the change feed.
+// Students the feed doesn't mention were last written before it starts.
+type writeHorizon struct {
+	lastWritten map[int64]time.Time
+	start       time.Time // of the feed; zero if it's empty
+}
+
+func currentWriteHorizon() writeHorizon {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	horizon := writeHorizon{lastWritten: make(map[int64]time.Time, len(students))}
+	for _, change := range changes {
+		horizon.lastWritten[change.Student.ID] = change.OccurredAt
+	}
+	if len(changes) > 0 {
+		horizon.start = changes[0].OccurredAt
+	}
+	return horizon
+}
+
+// stale reports whether a student hasn't been written since cutoff. One
+// the feed doesn't mention is stale only if the feed starts before cutoff.
+func (h writeHorizon) stale(id int64, cutoff time.Time) bool {
+	if written, ok := h.lastWritten[id]; ok {
+		return written.Before(cutoff)
+	}
+	return !h.start.IsZero() && h.start.Before(cutoff)
+}
+
+// assessQuality scores a student
+func assessQuality(student Student, horizon writeHorizon, now time.Time) StudentQuality {
+	quality := StudentQuality{ID: student.ID, Name: student.Name, Score: 100, Issues: []QualityIssue{}}
+	flag := func(check, message string) {
+		quality.Issues = append(quality.Issues, QualityIssue{Check: check, Penalty: qualityPenalties[check], Message: message})
+		quality.Score -= qualityPenalties[check]
+	}
+
+	if err := validateStudent(student); err != nil {
+		flag(QualityInvalid, err.Error())
+	}
+	if _, err := checkAttributes(student.Tenant, student.Attributes); err != nil {
+		flag(QualityAttributes, err.Error())
+	}
+	if warning, err := checkEmailDomain(student.Tenant, student.Email); err != nil {
+		flag(QualityEmailDomain, err.Error())
+	} else if warning != "" {
+		flag(QualityEmailDomain, warning)
+	}
+	if student.Phone == "" {
+		flag(QualityMissingPhone, "")
+	} else if _, err := normalizePhone(student.Phone); err != nil {
+		flag(QualityPhoneInvalid, err.Error())
+	}
+	if student.Address == "" {
+		flag(QualityMissingAddress, "")
+	} else if student.Location == nil && geocoder != nil {
+		flag(QualityNotGeocoded, "")
+	}
+	if student.BirthDate == "" {
+		flag(QualityMissingBirth, "")
+	}
+	if student.EnrolledOn == "" {
+		flag(QualityMissingEnrolled, "")
+	}
+	if horizon.stale(student.ID, now.Add(-staleAfter)) {
+		flag(QualityStale, "")
+	}
+	quality.Score = max(quality.Score, 0)
+	return quality
+}
+
+// parseQualityBelow reads ?quality_below=, a score from 1 to 101; 0 means
+// no filter
+func parseQualityBelow(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("quality_below")
+	if value == "" {
+		return 0, true
+	}
+	below, err := strconv.Atoi(value)
+	return below, err == nil && below >= 1 && below <= 101
+}
+
+// filterQualityBelow keeps the students scoring under ?quality_below=. On
+// failure it has already written the error response.
+func filterQualityBelow(w http.ResponseWriter, r *http.Request, roster []Student) ([]Student, bool) {
+	below, ok := parseQualityBelow(r)
+	if !ok {
+		http.Error(w, "Invalid quality_below: must be a score from 1 to 101", http.StatusBadRequest)
+		return nil, false
+	}
+	if below == 0 {
+		return roster, true
+	}
+	horizon, now := currentWriteHorizon(), time.Now()
+	matches := []Student{}
+	for _, student := range roster {
+		if assessQuality(student, horizon, now).Score < below {
+			matches = append(matches, student)
+		}
+	}
+	return matches, true
+}
+
+// DataQualityReport is the cleanup dashboard: how good the data is overall,
+// which problems are most common and which students need the most work
+type DataQualityReport struct {
+	Revision   int64            `json:"revision"`
+	Tenant     string           `json:"tenant,omitempty"`
+	Count      int              `json:"count"`
+	MeanScore  float64          `json:"mean_score"`
+	Perfect    int              `json:"perfect"`   // students scoring 100
+	Histogram  []ScoreBucket    `json:"histogram"` // lowest scores first
+	Issues     map[string]int   `json:"issues"`    // students with each issue
+	StaleAfter int              `json:"stale_after_days"`
+	Worst      []StudentQuality `json:"worst"`
+	CheckedAt  time.Time        `json:"checked_at"`
+}
+
+// ScoreBucket counts the students scoring From to To inclusive
+type ScoreBucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+// handleDataQuality answers GET /stats/data-quality for the caller's
+// students, narrowed by the usual filters and ?quality_below=. ?limit= sets
+// how many of the lowest scoring students are listed.
+func handleDataQuality(w http.ResponseWriter, r *http.Request) {
+	limit := defaultQualityWorst
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 || limit > maxQualityWorstLimit {
+			http.Error(w, "Invalid limit: must be 0-"+strconv.Itoa(maxQualityWorstLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	below, ok := parseQualityBelow(r)
+	if !ok {
+		http.Error(w, "Invalid quality_below: must be a score from 1 to 101", http.StatusBadRequest)
+		return
+	}
+	roster, revision := snapshotRoster()
+	roster, ok = filterRequestRoster(w, r, visibleStudents(requestTenantName(r), roster))
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	horizon := currentWriteHorizon()
+	report := DataQualityReport{
+		Revision:   revision,
+		Tenant:     requestTenantName(r),
+		Issues:     map[string]int{},
+		StaleAfter: int(staleAfter / (24 * time.Hour)),
+		CheckedAt:  localTime(r, now),
+	}
+	for from := 0; from <= 90; from += 10 {
+		report.Histogram = append(report.Histogram, ScoreBucket{From: from, To: from + 9})
+	}
+	report.Histogram[len(report.Histogram)-1].To = 100 // the top bucket takes perfect scores too
+	assessed := []StudentQuality{}
+	total := 0
+	for _, student := range roster {
+		quality := assessQuality(student, horizon, now)
+		if below > 0 && quality.Score >= below {
+			continue
+		}
+		assessed = append(assessed, quality)
+		total += quality.Score
+		report.Histogram[min(quality.Score/10, 9)].Count++
+		if quality.Score == 100 {
+			report.Perfect++
+		}
+		for _, issue := range quality.Issues {
+			report.Issues[issue.Check]++
+		}
+	}
+	report.Count = len(assessed)
+	if report.Count > 0 {
+		report.MeanScore = float64(total) / float64(report.Count)
+	}
+	sort.SliceStable(assessed, func(i, j int) bool { return assessed[i].Score < assessed[j].Score })
+	report.Worst = assessed[:min(limit, len(assessed))]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
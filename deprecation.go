@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deprecation marks a route or a request body field as on its way out.
+// Responses to requests that use it carry a Deprecation header (RFC 9745)
+// and, once a date is set, a Sunset header (RFC 8594), and every use is
+// counted against the API key that made it, so /admin/deprecations can tell
+// which clients still need to move before the sunset.
+type Deprecation struct {
+	Version     string // API version that deprecated it, for the changelog
+	Since       time.Time
+	Sunset      time.Time // zero until decided
+	Replacement string    // a path for routes, a field name for fields
+	Note        string
+}
+
+// deprecatedFields are request body fields on their way out, by the name of
+// the type the body decodes into and then the field's JSON name, e.g.
+// {"Student": {"age": {...}}}; JSON names are lowercase. Routes are marked
+// with Route.Deprecated.
+var deprecatedFields = map[string]map[string]Deprecation{}
+
+// DeprecatedUse is one API key's use of a deprecated route or field
+type DeprecatedUse struct {
+	KeyID     int       `json:"key_id,omitempty"` // 0 for requests without a key
+	KeyName   string    `json:"key_name,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Requests  int64     `json:"requests"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+var (
+	deprecatedUses      = map[string]map[int]*DeprecatedUse{} // surface -> key ID -> use
+	deprecatedUsesMutex sync.Mutex
+)
+
+// setHeaders adds the Deprecation and Sunset headers
+func (d Deprecation) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		sunset := d.Sunset.UTC().Format(http.TimeFormat)
+		// of all the deprecated surfaces a request uses, the soonest sunset
+		if current := w.Header().Get("Sunset"); current != "" {
+			if t, err := http.ParseTime(current); err == nil && t.Before(d.Sunset) {
+				sunset = current
+			}
+		}
+		w.Header().Set("Sunset", sunset)
+	}
+}
+
+// recordDeprecatedUse counts a request's use of a deprecated surface
+func recordDeprecatedUse(r *http.Request, surface string) {
+	now := time.Now().UTC()
+	use := DeprecatedUse{FirstSeen: now}
+	if key := keyFromContext(r.Context()); key != nil {
+		use.KeyID, use.KeyName, use.Tenant = key.ID, key.Name, key.Tenant
+	}
+	deprecatedUsesMutex.Lock()
+	defer deprecatedUsesMutex.Unlock()
+	uses := deprecatedUses[surface]
+	if uses == nil {
+		uses = map[int]*DeprecatedUse{}
+		deprecatedUses[surface] = uses
+	}
+	recorded := uses[use.KeyID]
+	if recorded == nil {
+		recorded = &use
+		uses[use.KeyID] = recorded
+	}
+	recorded.Requests++
+	recorded.LastSeen = now
+}
+
+// deprecatedRoute marks the response to a request for a deprecated route
+// and records the use
+func deprecatedRoute(w http.ResponseWriter, r *http.Request, route *boundRoute) {
+	route.Deprecated.setHeaders(w)
+	if route.Deprecated.Replacement != "" {
+		w.Header().Add("Link", "<"+route.Deprecated.Replacement+`>; rel="successor-version"`)
+	}
+	recordDeprecatedUse(r, route.name)
+}
+
+// noteDeprecatedFields marks the response to a request whose JSON body sets
+// deprecated fields of the struct v points to, with a Warning for each, and
+// records the uses
+func noteDeprecatedFields(w http.ResponseWriter, r *http.Request, data []byte, v interface{}) {
+	target := reflect.TypeOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return
+	}
+	fields := deprecatedFields[target.Elem().Name()]
+	if len(fields) == 0 {
+		return
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return
+	}
+	for name := range object {
+		deprecation, ok := fields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		deprecation.setHeaders(w)
+		message := "field " + strings.ToLower(name) + " is deprecated"
+		if deprecation.Replacement != "" {
+			message += "; use " + deprecation.Replacement
+		}
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", message))
+		recordDeprecatedUse(r, target.Elem().Name()+"."+strings.ToLower(name))
+	}
+}
+
+// DeprecatedSurface is a deprecated route or field and the clients still
+// using it, most recent first
+type DeprecatedSurface struct {
+	Surface     string          `json:"surface"` // e.g. "GET /students/{id}/edit" or "Student.age"
+	Kind        string          `json:"kind"`    // route or field
+	Since       time.Time       `json:"since"`
+	Sunset      *time.Time      `json:"sunset,omitempty"`
+	Replacement string          `json:"replacement,omitempty"`
+	Note        string          `json:"note,omitempty"`
+	Requests    int64           `json:"requests"`
+	Clients     []DeprecatedUse `json:"clients"`
+}
+
+func deprecatedSurface(surface, kind string, deprecation Deprecation) DeprecatedSurface {
+	described := DeprecatedSurface{Surface: surface, Kind: kind, Since: deprecation.Since,
+		Replacement: deprecation.Replacement, Note: deprecation.Note, Clients: []DeprecatedUse{}}
+	if !deprecation.Sunset.IsZero() {
+		described.Sunset = &deprecation.Sunset
+	}
+	return described
+}
+
+// handleDeprecations reports every deprecated route and field and which API
+// keys have used each since the server started, soonest sunset first
+func handleDeprecations(w http.ResponseWriter, r *http.Request) {
+	var surfaces []DeprecatedSurface
+	for _, route := range apiRoutes() {
+		if route.Deprecated != nil {
+			surfaces = append(surfaces, deprecatedSurface(route.Method+" "+route.Path, "route", *route.Deprecated))
+		}
+	}
+	for typeName, fields := range deprecatedFields {
+		for name, deprecation := range fields {
+			surfaces = append(surfaces, deprecatedSurface(typeName+"."+name, "field", deprecation))
+		}
+	}
+
+	deprecatedUsesMutex.Lock()
+	for i := range surfaces {
+		surface := &surfaces[i]
+		for _, use := range deprecatedUses[surface.Surface] {
+			surface.Clients = append(surface.Clients, *use)
+			surface.Requests += use.Requests
+		}
+		sort.Slice(surface.Clients, func(i, j int) bool { return surface.Clients[i].LastSeen.After(surface.Clients[j].LastSeen) })
+	}
+	deprecatedUsesMutex.Unlock()
+
+	sort.Slice(surfaces, func(i, j int) bool {
+		a, b := surfaces[i], surfaces[j]
+		if (a.Sunset == nil) != (b.Sunset == nil) {
+			return a.Sunset != nil
+		}
+		if a.Sunset != nil && !a.Sunset.Equal(*b.Sunset) {
+			return a.Sunset.Before(*b.Sunset)
+		}
+		return a.Surface < b.Surface
+	})
+	if surfaces == nil {
+		surfaces = []DeprecatedSurface{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(surfaces)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var startedAt = time.Now()
+
+// isAdminPath reports whether a route needs an admin key. Diagnostics under
+// /debug/ are treated like the admin API.
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/")
+}
+
+func handleRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	mutex.RLock()
+	store := map[string]int{
+		"students": len(students),
+		"changes":  len(changes),
+	}
+	if wal != nil {
+		store["wal_entries"] = wal.entries
+	}
+	mutex.RUnlock()
+
+	hooksMutex.RLock()
+	store["hooks"] = len(hooks)
+	hooksMutex.RUnlock()
+
+	apiKeysMutex.Lock()
+	store["api_keys"] = len(apiKeys)
+	apiKeysMutex.Unlock()
+
+	var cdcStats, shadowStats map[string]interface{}
+	if cdc != nil {
+		cdcStats = cdc.stats()
+	}
+	if shadow != nil {
+		shadowStats = shadow.stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"uptime":     time.Since(startedAt).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"go_version": runtime.Version(),
+		"memory": map[string]uint64{
+			"alloc_bytes":       mem.Alloc,
+			"heap_inuse_bytes":  mem.HeapInuse,
+			"heap_objects":      mem.HeapObjects,
+			"sys_bytes":         mem.Sys,
+			"total_alloc_bytes": mem.TotalAlloc,
+			"num_gc":            uint64(mem.NumGC),
+		},
+		"store":         store,
+		"load_shedding": loadSheddingStats(),
+		"cdc":           cdcStats,
+		"shadow":        shadowStats,
+	})
+}
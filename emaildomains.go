@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// EmailPolicy limits the domains a tenant's students' emails may use. With
+// no allowed domains any domain is accepted. Subdomains of an allowed domain
+// are allowed too.
+type EmailPolicy struct {
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	Mode           string   `json:"mode,omitempty"` // reject (the default) or warn
+}
+
+const (
+	EmailPolicyReject = "reject"
+	EmailPolicyWarn   = "warn"
+)
+
+// commonEmailDomains are the domains typos are most often made of
+var commonEmailDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "yahoo.co.in", "yahoo.co.uk",
+	"hotmail.com", "hotmail.co.uk", "outlook.com", "live.com", "msn.com",
+	"icloud.com", "me.com", "aol.com", "protonmail.com", "proton.me",
+	"rediffmail.com", "zoho.com", "gmx.com", "mail.com",
+}
+
+func (p *EmailPolicy) validate() error {
+	switch p.Mode {
+	case "", EmailPolicyReject, EmailPolicyWarn:
+	default:
+		return fmt.Errorf("email_policy.mode must be %s or %s", EmailPolicyReject, EmailPolicyWarn)
+	}
+	if len(p.AllowedDomains) > 100 {
+		return fmt.Errorf("email_policy.allowed_domains must have at most 100 domains")
+	}
+	for i, domain := range p.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain == "" || !strings.Contains(domain, ".") || strings.ContainsAny(domain, "@ /") {
+			return fmt.Errorf("email_policy.allowed_domains: %q is not a domain", p.AllowedDomains[i])
+		}
+		p.AllowedDomains[i] = domain
+	}
+	return nil
+}
+
+func (p EmailPolicy) allows(domain string) bool {
+	if len(p.AllowedDomains) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedDomains {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailError is an email the tenant's policy rejects, with a likely fix when
+// the domain looks like a typo
+type EmailError struct {
+	Email      string
+	Suggestion string
+}
+
+func (e *EmailError) Unwrap() error { return ErrInvalid }
+
+func (e *EmailError) Error() string {
+	message := fmt.Sprintf("the domain of %s is not allowed", e.Email)
+	if e.Suggestion != "" {
+		message += "; did you mean " + e.Suggestion + "?"
+	}
+	return message
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// suggestEmail returns the email with its domain swapped for the nearest of
+// candidates, or "" if none is within two edits. Domains that are candidates
+// themselves are taken as meant.
+func suggestEmail(email string, candidates []string) string {
+	domain := emailDomain(email)
+	if domain == "" {
+		return ""
+	}
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if candidate == domain {
+			return ""
+		}
+		if distance := editDistance(domain, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return email[:strings.LastIndex(email, "@")+1] + best
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters that turn a into b
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(prev[j]+1, current[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = min(current[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, current = prev, current, prev2
+	}
+	return prev[len(b)]
+}
+
+// checkEmailDomain enforces the tenant's email policy. It returns a warning
+// when the policy only warns, or when the domain looks like a typo of a
+// common one.
+func checkEmailDomain(tenant, email string) (warning string, err error) {
+	record, _ := lookupTenant(tenant)
+	policy := record.EmailPolicy
+	if policy.allows(emailDomain(email)) {
+		if suggestion := suggestEmail(email, commonEmailDomains); suggestion != "" && len(policy.AllowedDomains) == 0 {
+			return fmt.Sprintf("%s looks like a typo; did you mean %s?", email, suggestion), nil
+		}
+		return "", nil
+	}
+	invalid := &EmailError{Email: email, Suggestion: suggestEmail(email, append(append([]string{}, policy.AllowedDomains...), commonEmailDomains...))}
+	if policy.Mode == EmailPolicyWarn {
+		return invalid.Error(), nil
+	}
+	return "", invalid
+}
+
+// checkEmailLocked applies checkEmailDomain to a write, leaving out emails
+// the student already had so that tightening the policy doesn't lock
+// existing students out of updates. Callers must hold mutex.
+func checkEmailLocked(student Student, existing *Student) error {
+	if existing != nil && strings.EqualFold(existing.Email, student.Email) {
+		return nil
+	}
+	_, err := checkEmailDomain(student.Tenant, student.Email)
+	return err
+}
+
+// setEmailWarning reports a warning from the tenant's email policy in a
+// Warning header on an otherwise successful write
+func setEmailWarning(w http.ResponseWriter, student Student) {
+	if warning, _ := checkEmailDomain(student.Tenant, student.Email); warning != "" {
+		w.Header().Add("Warning", fmt.Sprintf("299 - %q", "email: "+warning))
+	}
+}
+
+func writeEmailError(w http.ResponseWriter, r *http.Request, err *EmailError) {
+	http.Error(w, localize(r, "Invalid email")+": "+err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/behalnihal/fealtyx/validation"
+)
+
+// Kinds of failure. Errors the server returns wrap one of them, and its kind
+// alone decides the HTTP status (see errorStatus), so every transport and
+// middleware answers the same failure the same way. Check with errors.Is.
+var (
+	ErrInvalid        = errors.New("invalid")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrLocked         = errors.New("locked")             // e.g. by a legal hold
+	ErrPlanLimit      = errors.New("plan limit reached") // needs a bigger plan
+	ErrQuotaExceeded  = errors.New("quota exceeded")     // try again later
+	ErrLLMUnavailable = errors.New("LLM unavailable")
+	ErrUpstream       = errors.New("upstream service failed") // other than the LLM
+)
+
+// statusClientClosedRequest is nginx's status for requests the client gave
+// up on. No one reads the response; it keeps them apart from failures in
+// logs and metrics.
+const statusClientClosedRequest = 499
+
+// kindStatuses are the statuses for each kind of failure
+var kindStatuses = []struct {
+	kind   error
+	status int
+}{
+	{ErrInvalid, http.StatusBadRequest},
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{ErrLocked, http.StatusLocked},
+	{ErrPlanLimit, http.StatusPaymentRequired},
+	{ErrQuotaExceeded, http.StatusTooManyRequests},
+	{ErrLLMUnavailable, http.StatusServiceUnavailable},
+	{ErrUpstream, http.StatusBadGateway},
+	{context.Canceled, statusClientClosedRequest},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout},
+}
+
+// domainError is a failure of one kind. Its message is the text clients
+// see, which is also looked up in translations.
+type domainError struct {
+	kind    error
+	message string
+	cause   error
+}
+
+func (e *domainError) Error() string { return e.message }
+
+func (e *domainError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.kind}
+	}
+	return []error{e.kind, e.cause}
+}
+
+// newError returns a failure of a kind, for a sentinel
+func newError(kind error, message string) error {
+	return &domainError{kind: kind, message: message}
+}
+
+// wrapError marks err as a failure of a kind, keeping its message
+func wrapError(kind error, err error) error {
+	return &domainError{kind: kind, message: err.Error(), cause: err}
+}
+
+// errorStatus is the status to answer err with, by its kind: 400 for
+// validation failures and 500 for errors of no kind
+func errorStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	for _, kind := range kindStatuses {
+		if errors.Is(err, kind.kind) {
+			return kind.status
+		}
+	}
+	return validation.HTTPStatus(err)
+}
+
+// errorMessage is what clients are told about err. Errors of a kind are
+// told as they are, translated when there is a translation; others are
+// prefixed with what failed, e.g. "Failed to save student".
+func errorMessage(r *http.Request, err error, failed string) string {
+	if status := errorStatus(err); status >= http.StatusInternalServerError {
+		return fmt.Sprintf("%s: %v", localize(r, failed), err)
+	}
+	return localize(r, err.Error())
+}
+
+// writeError answers a request that failed with err. Errors with a body of
+// their own are written by their writers.
+func writeError(w http.ResponseWriter, r *http.Request, err error, failed string) {
+	var invalidAttributes *AttributeError
+	var invalidEmail *EmailError
+	var related *RelatedRecordsError
+	switch {
+	case errors.As(err, &invalidAttributes):
+		writeAttributeError(w, r, invalidAttributes)
+	case errors.As(err, &invalidEmail):
+		writeEmailError(w, r, invalidEmail)
+	case errors.As(err, &related):
+		writeRelatedRecordsError(w, related)
+	default:
+		http.Error(w, errorMessage(r, err, failed), errorStatus(err))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const eventsKeepAlive = 15 * time.Second
+
+// eventsAfter returns the retained changes with an ID above after, and the
+// signal that fires on the next commit. ok is false when the feed no longer
+// reaches back that far.
+func eventsAfter(after int64) (events []Change, signal <-chan struct{}, ok bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if after < oldestRevisionLocked() {
+		return nil, nil, false
+	}
+	for _, change := range changes {
+		if change.ID > after {
+			events = append(events, change)
+		}
+	}
+	return events, changeSignal, true
+}
+
+// handleEvents streams the change log from ?from=<seq> (inclusive) as NDJSON,
+// or as server-sent events when the client accepts text/event-stream. With
+// ?follow=true the stream stays open and new events are pushed as they
+// happen. SSE clients resume with the Last-Event-ID header.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	from := int64(1)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid from: must be a positive sequence number", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	sse := r.Header.Get("Accept") == "text/event-stream"
+	if lastID := r.Header.Get("Last-Event-ID"); sse && lastID != "" {
+		parsed, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		from = parsed + 1
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	events, signal, ok := eventsAfter(from - 1)
+	if !ok {
+		// Rebuild from GET /students/export, then stream from its revision
+		http.Error(w, "Events before this sequence are no longer retained; load GET /students/export and continue from its revision", http.StatusGone)
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+	tenant := requestTenantName(r)
+	write := func(change Change) {
+		if !visibleTo(tenant, change.Student) {
+			from = change.ID + 1
+			return
+		}
+		if sse {
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Event, data)
+		} else {
+			encoder.Encode(change)
+		}
+		from = change.ID + 1
+	}
+
+	for _, change := range events {
+		write(change)
+	}
+	controller.Flush()
+	if !follow {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if sse {
+				fmt.Fprint(w, ": keep-alive\n\n")
+			} else {
+				fmt.Fprint(w, "\n")
+			}
+		case <-signal:
+			events, signal, ok = eventsAfter(from - 1)
+			if !ok {
+				// Retention overtook this reader; it has to rebuild
+				return
+			}
+			for _, change := range events {
+				write(change)
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
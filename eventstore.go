@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// In event-sourced mode the write-ahead log is the system of record: every
+// change is kept there as an event, forever, and the roster is a projection
+// of them, rebuilt by replaying the log at startup. The log is never
+// compacted and the change feed never purged, so history is complete from
+// the first event and versions, diffs and as-of reads are exact.
+//
+// The events are the Change records the feed already carries. A log
+// compacted before the mode was switched on, or restored from a backup,
+// starts from its snapshot instead; history begins there.
+
+var eventSourced bool
+
+// checkEventSourcing validates the mode against the rest of the setup
+func checkEventSourcing(walPath string) error {
+	if eventSourced && walPath == "" {
+		return fmt.Errorf("-event-sourced needs -wal, which holds the events")
+	}
+	return nil
+}
+
+// projectEvents folds events, oldest first, into the roster they describe
+func projectEvents(base []Student, events []Change) map[int64]Student {
+	projection := make(map[int64]Student, len(base))
+	for _, student := range base {
+		projection[student.ID] = student
+	}
+	for _, event := range events {
+		switch event.Event {
+		case EventStudentCreated, EventStudentUpdated:
+			projection[event.Student.ID] = event.Student
+		case EventStudentDeleted:
+			delete(projection, event.Student.ID)
+		}
+	}
+	return projection
+}
+
+// revisionAtLocked is the revision the roster was at at t: the last change
+// committed by then. Callers must hold mutex.
+func revisionAtLocked(t time.Time) (int64, error) {
+	i := sort.Search(len(changes), func(i int) bool { return changes[i].OccurredAt.After(t) })
+	if i > 0 {
+		return changes[i-1].ID, nil
+	}
+	if oldest := oldestRevisionLocked(); oldest > 0 {
+		return 0, errRevisionNotRetained
+	}
+	return 0, nil
+}
+
+// rosterAsOfLocked is the roster as it was at t, ordered by ID. Callers
+// must hold mutex.
+func rosterAsOfLocked(t time.Time) ([]Student, error) {
+	if _, err := revisionAtLocked(t); err != nil {
+		return nil, err
+	}
+	states, _ := rosterAtLocked(t)
+	roster := []Student{}
+	for _, state := range states {
+		if !state.exists {
+			continue
+		}
+		if !state.known {
+			// written since, and its earlier version was purged
+			return nil, errRevisionNotRetained
+		}
+		roster = append(roster, state.student)
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	return roster, nil
+}
+
+// EventStoreStatus describes the event log and checks the roster against it
+type EventStoreStatus struct {
+	Enabled           bool       `json:"enabled"`
+	Events            int        `json:"events"`
+	FirstRevision     int64      `json:"first_revision,omitempty"`
+	LatestRevision    int64      `json:"latest_revision"`
+	Complete          bool       `json:"complete"` // history reaches back to the first event
+	FirstEventAt      *time.Time `json:"first_event_at,omitempty"`
+	ProjectionMatches *bool      `json:"projection_matches,omitempty"` // only checked when complete
+	Mismatched        []int64    `json:"mismatched,omitempty"`         // students whose state differs from the events
+}
+
+// handleEventStoreStatus reports on the event log and, when it is complete,
+// replays it to check that the roster is the projection of its events
+func handleEventStoreStatus(w http.ResponseWriter, r *http.Request) {
+	mutex.RLock()
+	status := EventStoreStatus{
+		Enabled:        eventSourced,
+		Events:         len(changes),
+		LatestRevision: changeSeq,
+		Complete:       oldestRevisionLocked() == 0,
+	}
+	if len(changes) > 0 {
+		status.FirstRevision = changes[0].ID
+		first := localTime(r, changes[0].OccurredAt)
+		status.FirstEventAt = &first
+	}
+	if status.Complete {
+		projection := projectEvents(nil, changes)
+		matches := len(projection) == len(students)
+		for _, student := range students {
+			if projected, ok := projection[student.ID]; !ok || !projected.equal(student) {
+				status.Mismatched = append(status.Mismatched, student.ID)
+			}
+		}
+		matches = matches && len(status.Mismatched) == 0
+		status.ProjectionMatches = &matches
+	}
+	mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnonymizedStudent is a roster row with the PII removed: the name is replaced
+// by a keyed hash, the email is dropped and the age is bucketed
+type AnonymizedStudent struct {
+	NameHash  string `json:"name_hash"`
+	AgeBucket string `json:"age_bucket"`
+}
+
+var ageBuckets = []struct {
+	max   int
+	label string
+}{
+	{17, "under 18"},
+	{24, "18-24"},
+	{34, "25-34"},
+	{44, "35-44"},
+	{54, "45-54"},
+	{64, "55-64"},
+}
+
+func ageBucket(age int) string {
+	for _, bucket := range ageBuckets {
+		if age <= bucket.max {
+			return bucket.label
+		}
+	}
+	return "65+"
+}
+
+var (
+	processAnonymizationKey     []byte
+	processAnonymizationKeyOnce sync.Once
+)
+
+// anonymizationKey keys the name hashes so they can't be reversed by hashing
+// a list of common names. It comes from the ANONYMIZATION_KEY secret, which
+// keeps hashes stable across exports and restarts; without it a random key is
+// used until the process exits.
+func anonymizationKey() []byte {
+	if key := getSecret("ANONYMIZATION_KEY"); key != "" {
+		return []byte(key)
+	}
+	processAnonymizationKeyOnce.Do(func() {
+		processAnonymizationKey = make([]byte, 32)
+		rand.Read(processAnonymizationKey)
+	})
+	return processAnonymizationKey
+}
+
+func anonymize(roster []Student) []AnonymizedStudent {
+	key := anonymizationKey()
+	result := make([]AnonymizedStudent, len(roster))
+	for i, student := range roster {
+		result[i] = AnonymizedStudent{
+			NameHash:  anonymizedNameHash(key, student.Name),
+			AgeBucket: ageBucket(student.Age),
+		}
+	}
+	return result
+}
+
+func anonymizedNameHash(key []byte, name string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(name))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleExport serves a consistent snapshot of the roster with the revision it
+// reflects, ordered by ID, and a manifest with the checksum of the students
+// array as served. With ?anonymized=true the rows are stripped of PII for
+// analysts. ?format=geojson maps the students with a location instead.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "geojson" {
+		http.Error(w, "Invalid format: must be json or geojson", http.StatusBadRequest)
+		return
+	}
+	anonymized := false
+	if value := r.URL.Query().Get("anonymized"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid anonymized: must be true or false", http.StatusBadRequest)
+			return
+		}
+		anonymized = parsed
+	}
+
+	roster, revision := snapshotRoster()
+	roster = visibleStudents(requestTenantName(r), roster)
+	roster, ok := filterRequestRoster(w, r, roster)
+	if !ok {
+		return
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	if format == "geojson" {
+		writeGeoJSONExport(w, r, roster, revision)
+		return
+	}
+	var data []byte
+	if anonymized {
+		var err error
+		if data, err = json.Marshal(anonymize(roster)); err != nil {
+			http.Error(w, "Failed to encode export", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		data = appendStudentsJSON(nil, roster)
+	}
+	manifest := BackupManifest{
+		Format:    backupFormat,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Kind:      "export",
+		Source:    "export",
+		Revision:  revision,
+		Students:  len(roster),
+		Files:     []BackupFile{newBackupFile("students", data)},
+	}
+	if err := manifest.sign(); err != nil {
+		slog.Error("Failed to sign export", "error", err)
+		http.Error(w, "Failed to sign export", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revision":   revision,
+		"anonymized": anonymized,
+		"students":   json.RawMessage(data),
+		"manifest":   manifest,
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxSavedFilters = 100 // per tenant
+
+var (
+	filterNamePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+	errUnknownFilter   = newError(ErrInvalid, "no saved filter with that name")
+	errInvalidAgeRange = newError(ErrInvalid, "min_age and max_age must be whole numbers, min_age no more than max_age")
+)
+
+// StudentFilter picks students from the roster. The list, exports and report
+// subscriptions all take one, either ad hoc from the query string or saved
+// under a name.
+type StudentFilter struct {
+	Query      string            `json:"q,omitempty"`          // searched in names, emails, tags and attributes
+	Tags       []string          `json:"tags,omitempty"`       // students must have all of them
+	Attributes map[string]string `json:"attributes,omitempty"` // attribute values, matched exactly
+	Near       *GeoCircle        `json:"near,omitempty"`       // students located within the circle
+	// Name is searched in names only. It's student_name in saved filters,
+	// whose own name is name.
+	Name   string `json:"student_name,omitempty"`
+	MinAge int    `json:"min_age,omitempty"` // inclusive
+	MaxAge int    `json:"max_age,omitempty"` // inclusive
+}
+
+func (f StudentFilter) empty() bool {
+	return f.Query == "" && len(f.Tags) == 0 && len(f.Attributes) == 0 && f.Near == nil &&
+		f.Name == "" && f.MinAge == 0 && f.MaxAge == 0
+}
+
+func (f StudentFilter) matches(student Student) bool {
+	if f.Query != "" && !studentContains(student, strings.ToLower(f.Query)) {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(student.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if (f.MinAge != 0 && student.Age < f.MinAge) || (f.MaxAge != 0 && student.Age > f.MaxAge) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !hasTag(student, tag) {
+			return false
+		}
+	}
+	for name, want := range f.Attributes {
+		value, ok := student.Attributes[name]
+		if !ok || !strings.EqualFold(formatAttribute(value), want) {
+			return false
+		}
+	}
+	return f.Near == nil || f.Near.contains(student)
+}
+
+// apply keeps the students the filter matches
+func (f StudentFilter) apply(roster []Student) []Student {
+	if f.empty() {
+		return roster
+	}
+	matches := []Student{}
+	for _, student := range roster {
+		if f.matches(student) {
+			matches = append(matches, student)
+		}
+	}
+	return matches
+}
+
+// normalize puts tags in their stored form so they compare equal, and
+// checks the circle and ages
+func (f StudentFilter) normalize() (StudentFilter, error) {
+	f.Query = strings.TrimSpace(f.Query)
+	f.Name = strings.TrimSpace(f.Name)
+	tags, err := normalizeTags(f.Tags)
+	f.Tags = tags
+	if err == nil && f.Near != nil {
+		err = f.Near.validate()
+	}
+	if err == nil && (f.MinAge < 0 || f.MaxAge < 0 || (f.MaxAge != 0 && f.MinAge > f.MaxAge)) {
+		err = errInvalidAgeRange
+	}
+	return f, err
+}
+
+// parseAge reads an age bound from the query string; empty is no bound
+func parseAge(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	age, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errInvalidAgeRange
+	}
+	return age, nil
+}
+
+// adhocFilter reads ?q=, ?name=, ?min_age=, ?max_age=, ?tag= (repeatable),
+// ?attributes.<name>= and ?near=lat,lng with ?radius=
+func adhocFilter(r *http.Request) (StudentFilter, error) {
+	query := r.URL.Query()
+	near, err := parseGeoCircle(query.Get("near"), query.Get("radius"))
+	if err != nil {
+		return StudentFilter{}, err
+	}
+	minAge, err := parseAge(query.Get("min_age"))
+	if err != nil {
+		return StudentFilter{}, err
+	}
+	maxAge, err := parseAge(query.Get("max_age"))
+	if err != nil {
+		return StudentFilter{}, err
+	}
+	filter := StudentFilter{Query: query.Get("q"), Tags: query["tag"], Near: near, Name: query.Get("name"), MinAge: minAge, MaxAge: maxAge}
+	for key, values := range query {
+		if name, ok := strings.CutPrefix(key, "attributes."); ok && len(values) > 0 {
+			if filter.Attributes == nil {
+				filter.Attributes = map[string]string{}
+			}
+			filter.Attributes[name] = values[0]
+		}
+	}
+	return filter.normalize()
+}
+
+// requestFilters returns the filters a request asks for: the saved one named
+// by ?filter=, if any, and the ad hoc one. A student must match both.
+func requestFilters(r *http.Request) ([]StudentFilter, error) {
+	adhoc, err := adhocFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	filters := []StudentFilter{adhoc}
+	if name := r.URL.Query().Get("filter"); name != "" {
+		saved, ok := lookupSavedFilter(requestTenantName(r), name)
+		if !ok {
+			return nil, errUnknownFilter
+		}
+		filters = append(filters, saved.StudentFilter)
+	}
+	return filters, nil
+}
+
+func applyFilters(roster []Student, filters []StudentFilter) []Student {
+	for _, filter := range filters {
+		roster = filter.apply(roster)
+	}
+	return roster
+}
+
+// filterRequestRoster narrows a roster to what the request's filters match.
+// On failure it has already written the error response.
+func filterRequestRoster(w http.ResponseWriter, r *http.Request, roster []Student) ([]Student, bool) {
+	filters, err := requestFilters(r)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return applyFilters(roster, filters), true
+}
+
+// SavedFilter is a StudentFilter kept under a name for reuse
+type SavedFilter struct {
+	Name string `json:"name"`
+	StudentFilter
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// savedFilters are each tenant's saved filters by name
+var (
+	savedFilters      = map[string]map[string]SavedFilter{}
+	savedFiltersMutex sync.RWMutex
+)
+
+func lookupSavedFilter(tenant, name string) (SavedFilter, bool) {
+	savedFiltersMutex.RLock()
+	defer savedFiltersMutex.RUnlock()
+	filter, ok := savedFilters[tenant][name]
+	return filter, ok
+}
+
+// savedFilterNames lists a tenant's saved filters for the admin UI
+func savedFilterNames(tenant string) []string {
+	savedFiltersMutex.RLock()
+	defer savedFiltersMutex.RUnlock()
+	names := make([]string, 0, len(savedFilters[tenant]))
+	for name := range savedFilters[tenant] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func handleFilterList(w http.ResponseWriter, r *http.Request) {
+	savedFiltersMutex.RLock()
+	result := []SavedFilter{}
+	for _, filter := range savedFilters[requestTenantName(r)] {
+		result = append(result, filter)
+	}
+	savedFiltersMutex.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleFilterCreate(w http.ResponseWriter, r *http.Request) {
+	var filter SavedFilter
+	if err := decodeJSON(w, r, &filter); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !filterNamePattern.MatchString(filter.Name) {
+		http.Error(w, "name must be 1-63 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	normalized, err := filter.StudentFilter.normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if normalized.empty() {
+		http.Error(w, "a filter needs q, tags or attributes", http.StatusBadRequest)
+		return
+	}
+	filter.StudentFilter = normalized
+	filter.CreatedAt = time.Now().UTC()
+
+	tenant := requestTenantName(r)
+	savedFiltersMutex.Lock()
+	filters := savedFilters[tenant]
+	if _, exists := filters[filter.Name]; exists {
+		savedFiltersMutex.Unlock()
+		http.Error(w, "Filter already exists", http.StatusConflict)
+		return
+	}
+	if len(filters) >= maxSavedFilters {
+		savedFiltersMutex.Unlock()
+		http.Error(w, fmt.Sprintf("A tenant can save at most %d filters", maxSavedFilters), http.StatusConflict)
+		return
+	}
+	if filters == nil {
+		filters = map[string]SavedFilter{}
+		savedFilters[tenant] = filters
+	}
+	filters[filter.Name] = filter
+	savedFiltersMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(filter)
+}
+
+func handleFilterDelete(w http.ResponseWriter, r *http.Request) {
+	tenant, name := requestTenantName(r), r.PathValue("name")
+	savedFiltersMutex.Lock()
+	_, exists := savedFilters[tenant][name]
+	delete(savedFilters[tenant], name)
+	if len(savedFilters[tenant]) == 0 {
+		delete(savedFilters, tenant)
+	}
+	savedFiltersMutex.Unlock()
+	if !exists {
+		http.Error(w, "Filter not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
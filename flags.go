@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// FeatureFlag gates an experimental feature. Enabled is the default and
+// Tenants overrides it for the tenant of the calling API key, so a feature can
+// ship dark and be rolled out one tenant at a time.
+type FeatureFlag struct {
+	Name    string          `json:"name"`
+	Enabled bool            `json:"enabled"`
+	Tenants map[string]bool `json:"tenants,omitempty"`
+}
+
+// FlagStreamingSummaries gates summaries streamed token by token, over
+// server-sent events and the WebSocket
+const FlagStreamingSummaries = "streaming_summaries"
+
+var (
+	featureFlagsPath string
+	featureFlags     = map[string]FeatureFlag{}
+	flagsMutex       sync.RWMutex
+)
+
+// loadFeatureFlags reads a JSON file mapping flag names to their settings, e.g.
+// {"streaming_summaries": {"enabled": false, "tenants": {"springfield": true}}}
+func loadFeatureFlags(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read feature flags: %v", err)
+	}
+	loaded := map[string]FeatureFlag{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("invalid feature flags file: %v", err)
+	}
+	for name, flag := range loaded {
+		flag.Name = name
+		loaded[name] = flag
+	}
+
+	flagsMutex.Lock()
+	featureFlags = loaded
+	flagsMutex.Unlock()
+	return nil
+}
+
+// featureEnabled reports whether a flag is on for the tenant making the request.
+// Unknown flags are off.
+func featureEnabled(ctx context.Context, name string) bool {
+	flagsMutex.RLock()
+	flag, ok := featureFlags[name]
+	flagsMutex.RUnlock()
+	if !ok {
+		return false
+	}
+	if key := keyFromContext(ctx); key != nil && key.Tenant != "" {
+		if enabled, ok := flag.Tenants[key.Tenant]; ok {
+			return enabled
+		}
+	}
+	return flag.Enabled
+}
+
+func handleFlagList(w http.ResponseWriter, r *http.Request) {
+	flagsMutex.RLock()
+	result := make([]FeatureFlag, 0, len(featureFlags))
+	for _, flag := range featureFlags {
+		result = append(result, flag)
+	}
+	flagsMutex.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleFlagSet toggles a flag at runtime. Changes last until the next restart.
+func handleFlagSet(w http.ResponseWriter, r *http.Request) {
+	var flag FeatureFlag
+	if err := decodeJSON(w, r, &flag); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	flag.Name = r.PathValue("name")
+
+	flagsMutex.Lock()
+	featureFlags[flag.Name] = flag
+	flagsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
+
+func handleFlagDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	flagsMutex.Lock()
+	defer flagsMutex.Unlock()
+	if _, ok := featureFlags[name]; !ok {
+		http.Error(w, "Feature flag not found", http.StatusNotFound)
+		return
+	}
+	delete(featureFlags, name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/behalnihal/fealtyx/validation"
+)
+
+// Addresses are geocoded on write when a geocoder is configured with
+// -geocoder, so students can be found by distance. Without one, clients may
+// set a student's location themselves.
+
+var (
+	errAddressNotFound = newError(ErrInvalid, "Address not found")
+	errGeocoding       = newError(ErrUpstream, "geocoding failed")
+)
+
+// GeoPoint is a position in decimal degrees
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (p GeoPoint) validate() error {
+	return validation.Location("location", p.Lat, p.Lng)
+}
+
+// distanceKm is the great-circle distance between two points
+func distanceKm(a, b GeoPoint) float64 {
+	const earthRadiusKm = 6371
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat, dLng := lat2-lat1, (b.Lng-a.Lng)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// Geocoder finds the position of a postal address. found is false when the
+// provider doesn't know the address.
+type Geocoder interface {
+	Name() string
+	Geocode(ctx context.Context, address string) (point GeoPoint, found bool, err error)
+}
+
+var (
+	geocoder       Geocoder // nil disables geocoding
+	geocoderClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// openGeocoder opens the geocoder named by spec: nominatim, optionally with
+// the URL of a self-hosted instance, or google
+func openGeocoder(spec string) (Geocoder, error) {
+	kind, location, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "":
+		return nil, nil
+	case "nominatim":
+		if location == "" {
+			location = "https://nominatim.openstreetmap.org"
+		}
+		return &nominatimGeocoder{baseURL: strings.TrimSuffix(location, "/")}, nil
+	case "google":
+		if getSecret("GOOGLE_GEOCODING_API_KEY") == "" {
+			return nil, fmt.Errorf("the google geocoder needs GOOGLE_GEOCODING_API_KEY")
+		}
+		return googleGeocoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown geocoder %q (supported: nominatim, google)", kind)
+	}
+}
+
+// nominatimGeocoder asks OpenStreetMap's Nominatim. The public instance
+// allows one request a second from an identified client, so requests are
+// spaced out.
+type nominatimGeocoder struct {
+	baseURL string
+	mu      sync.Mutex
+	last    time.Time
+}
+
+func (g *nominatimGeocoder) Name() string { return "nominatim" }
+
+func (g *nominatimGeocoder) Geocode(ctx context.Context, address string) (GeoPoint, bool, error) {
+	g.mu.Lock()
+	wait := time.Until(g.last.Add(time.Second))
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			g.mu.Unlock()
+			return GeoPoint{}, false, ctx.Err()
+		}
+	}
+	g.last = time.Now()
+	g.mu.Unlock()
+
+	query := url.Values{"q": {address}, "format": {"jsonv2"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return GeoPoint{}, false, err
+	}
+	req.Header.Set("User-Agent", "fealtyx (+mailto:"+mailFrom+")")
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := getGeocoderJSON(req, &results); err != nil {
+		return GeoPoint{}, false, err
+	}
+	if len(results) == 0 {
+		return GeoPoint{}, false, nil
+	}
+	lat, latErr := strconv.ParseFloat(results[0].Lat, 64)
+	lng, lngErr := strconv.ParseFloat(results[0].Lon, 64)
+	if latErr != nil || lngErr != nil {
+		return GeoPoint{}, false, fmt.Errorf("nominatim returned an invalid position")
+	}
+	return GeoPoint{Lat: lat, Lng: lng}, true, nil
+}
+
+// googleGeocoder asks the Google Geocoding API
+type googleGeocoder struct{}
+
+func (googleGeocoder) Name() string { return "google" }
+
+func (googleGeocoder) Geocode(ctx context.Context, address string) (GeoPoint, bool, error) {
+	query := url.Values{"address": {address}, "key": {getSecret("GOOGLE_GEOCODING_API_KEY")}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://maps.googleapis.com/maps/api/geocode/json?"+query.Encode(), nil)
+	if err != nil {
+		return GeoPoint{}, false, err
+	}
+	var response struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Results      []struct {
+			Geometry struct {
+				Location GeoPoint `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := getGeocoderJSON(req, &response); err != nil {
+		return GeoPoint{}, false, err
+	}
+	switch response.Status {
+	case "OK":
+		if len(response.Results) > 0 {
+			return response.Results[0].Geometry.Location, true, nil
+		}
+		return GeoPoint{}, false, nil
+	case "ZERO_RESULTS":
+		return GeoPoint{}, false, nil
+	default:
+		return GeoPoint{}, false, fmt.Errorf("google geocoding: %s %s", response.Status, response.ErrorMessage)
+	}
+}
+
+func getGeocoderJSON(req *http.Request, out interface{}) error {
+	resp, err := geocoderClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoder returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+const maxGeocodeCache = 10000
+
+// geocodeCache remembers positions by normalized address, so students
+// sharing an address and unchanged addresses cost no lookups. It is cleared
+// when full.
+var (
+	geocodeCache      = map[string]GeoPoint{}
+	geocodeCacheMutex sync.Mutex
+)
+
+// geocodeStudent sets a student's location from its address when a
+// geocoder is configured and the client didn't set one
+func geocodeStudent(ctx context.Context, student *Student) error {
+	address := strings.Join(strings.Fields(strings.ToLower(student.Address)), " ")
+	if geocoder == nil || address == "" || student.Location != nil {
+		return nil
+	}
+	geocodeCacheMutex.Lock()
+	point, ok := geocodeCache[address]
+	geocodeCacheMutex.Unlock()
+	if !ok {
+		var found bool
+		var err error
+		point, found, err = geocoder.Geocode(ctx, student.Address)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errGeocoding, err)
+		}
+		if !found {
+			return errAddressNotFound
+		}
+		geocodeCacheMutex.Lock()
+		if len(geocodeCache) >= maxGeocodeCache {
+			geocodeCache = map[string]GeoPoint{}
+		}
+		geocodeCache[address] = point
+		geocodeCacheMutex.Unlock()
+	}
+	student.Location = &point
+	return nil
+}
+
+// GeoCircle picks the students within RadiusKm of a point
+type GeoCircle struct {
+	GeoPoint
+	RadiusKm float64 `json:"radius_km"`
+}
+
+func (c GeoCircle) contains(student Student) bool {
+	return student.Location != nil && distanceKm(c.GeoPoint, *student.Location) <= c.RadiusKm
+}
+
+// parseGeoCircle reads ?near=lat,lng and ?radius= in kilometres, 5 by default
+func parseGeoCircle(near, radius string) (*GeoCircle, error) {
+	if near == "" {
+		if radius != "" {
+			return nil, fmt.Errorf("radius needs near")
+		}
+		return nil, nil
+	}
+	latText, lngText, ok := strings.Cut(near, ",")
+	lat, latErr := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	lng, lngErr := strconv.ParseFloat(strings.TrimSpace(lngText), 64)
+	if !ok || latErr != nil || lngErr != nil {
+		return nil, fmt.Errorf("near must be lat,lng")
+	}
+	circle := &GeoCircle{GeoPoint: GeoPoint{Lat: lat, Lng: lng}, RadiusKm: 5}
+	if radius != "" {
+		var err error
+		if circle.RadiusKm, err = strconv.ParseFloat(radius, 64); err != nil {
+			return nil, fmt.Errorf("radius must be a number of kilometres")
+		}
+	}
+	return circle, circle.validate()
+}
+
+func (c GeoCircle) validate() error {
+	if err := validation.Location("near", c.Lat, c.Lng); err != nil {
+		return err
+	}
+	if !(c.RadiusKm > 0 && c.RadiusKm <= 1000) {
+		return fmt.Errorf("radius must be more than 0 and at most 1000 km")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GeoJSON exports put students with a location on a map. Anonymized
+// exports, the default, round positions to about 100 m and describe points
+// the way anonymized JSON exports do.
+
+const geoJSONAnonymizedDecimals = 3 // about 110 m of latitude
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+	Revision int64            `json:"revision"` // a foreign member; GIS tools ignore it
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"` // longitude first
+}
+
+func geoJSONPoint(point GeoPoint, anonymized bool) GeoJSONPoint {
+	if anonymized {
+		scale := math.Pow10(geoJSONAnonymizedDecimals)
+		point = GeoPoint{Lat: math.Round(point.Lat*scale) / scale, Lng: math.Round(point.Lng*scale) / scale}
+	}
+	return GeoJSONPoint{Type: "Point", Coordinates: []float64{point.Lng, point.Lat}}
+}
+
+// geoJSONGroups reads ?group_by=tag or attributes.<name> into a function
+// listing the groups a student is in
+func geoJSONGroups(groupBy string) (func(Student) []string, error) {
+	if groupBy == "tag" {
+		return func(student Student) []string { return student.Tags }, nil
+	}
+	if name, ok := strings.CutPrefix(groupBy, "attributes."); ok && name != "" {
+		return func(student Student) []string {
+			if value, ok := student.Attributes[name]; ok {
+				return []string{formatAttribute(value)}
+			}
+			return nil
+		}, nil
+	}
+	return nil, fmt.Errorf("group_by must be tag or attributes.<name>")
+}
+
+// studentFeatures is a point for each located student
+func studentFeatures(roster []Student, anonymized bool) []GeoJSONFeature {
+	features := []GeoJSONFeature{}
+	key := anonymizationKey()
+	for _, student := range roster {
+		if student.Location == nil {
+			continue
+		}
+		properties := map[string]interface{}{"age_bucket": ageBucket(student.Age)}
+		if !anonymized {
+			properties = map[string]interface{}{"id": student.ID, "name": student.Name, "age": student.Age}
+			if student.Address != "" {
+				properties["address"] = student.Address
+			}
+			if len(student.Tags) > 0 {
+				properties["tags"] = student.Tags
+			}
+		} else {
+			properties["name_hash"] = anonymizedNameHash(key, student.Name)
+		}
+		features = append(features, GeoJSONFeature{Type: "Feature", Geometry: geoJSONPoint(*student.Location, anonymized), Properties: properties})
+	}
+	return features
+}
+
+// groupFeatures is a point at the centroid of each group's located
+// students, with their count and the distance from the centroid to the
+// farthest of them
+func groupFeatures(roster []Student, groups func(Student) []string, anonymized bool) []GeoJSONFeature {
+	members := map[string][]GeoPoint{}
+	for _, student := range roster {
+		if student.Location == nil {
+			continue
+		}
+		for _, group := range groups(student) {
+			members[group] = append(members[group], *student.Location)
+		}
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	features := []GeoJSONFeature{}
+	for _, name := range names {
+		points := members[name]
+		var centroid GeoPoint
+		for _, point := range points {
+			centroid.Lat += point.Lat / float64(len(points))
+			centroid.Lng += point.Lng / float64(len(points))
+		}
+		radius := 0.0
+		for _, point := range points {
+			radius = max(radius, distanceKm(centroid, point))
+		}
+		features = append(features, GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONPoint(centroid, anonymized),
+			Properties: map[string]interface{}{
+				"group":     name,
+				"count":     len(points),
+				"radius_km": math.Round(radius*100) / 100,
+			},
+		})
+	}
+	return features
+}
+
+// writeGeoJSONExport answers GET /students/export?format=geojson. Only
+// ?anonymized=false shows names and exact positions.
+func writeGeoJSONExport(w http.ResponseWriter, r *http.Request, roster []Student, revision int64) {
+	anonymized := true
+	if value := r.URL.Query().Get("anonymized"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid anonymized: must be true or false", http.StatusBadRequest)
+			return
+		}
+		anonymized = parsed
+	}
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Revision: revision}
+	if groupBy := r.URL.Query().Get("group_by"); groupBy != "" {
+		groups, err := geoJSONGroups(groupBy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		collection.Features = groupFeatures(roster, groups, anonymized)
+	} else {
+		collection.Features = studentFeatures(roster, anonymized)
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="students.geojson"`)
+	json.NewEncoder(w).Encode(collection)
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/behalnihal/fealtyx/validation"
+)
+
+// studentContains reports whether the student's name, email, phone, a tag or
+// an attribute value contains the lowercased query
+func studentContains(student Student, query string) bool {
+	if strings.Contains(strings.ToLower(student.Name), query) || strings.Contains(strings.ToLower(student.Email), query) ||
+		strings.Contains(student.Phone, query) || strings.Contains(student.PhoneE164, query) {
+		return true
+	}
+	for _, tag := range student.Tags {
+		if strings.Contains(tag, query) {
+			return true
+		}
+	}
+	for _, value := range student.Attributes {
+		if strings.Contains(strings.ToLower(formatAttribute(value)), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleStudents(w http.ResponseWriter, r *http.Request) {
+	var asOf time.Time
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		var err error
+		if asOf, err = parseTime(r, value); err != nil {
+			http.Error(w, "Invalid as_of: must be a date or timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stage := timeStage(r.Context(), "store")
+	var roster []Student
+	if asOf.IsZero() {
+		var revision int64
+		roster, revision = snapshotRoster()
+		stage.stop()
+		shadowList(roster, revision)
+	} else {
+		var err error
+		mutex.RLock()
+		roster, err = rosterAsOfLocked(asOf)
+		mutex.RUnlock()
+		stage.stop()
+		if err != nil {
+			http.Error(w, "Invalid as_of: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	roster = visibleStudents(requestTenantName(r), roster)
+	roster, ok := filterRequestRoster(w, r, roster)
+	if !ok {
+		return
+	}
+	if roster, ok = filterQualityBelow(w, r, roster); !ok {
+		return
+	}
+	if roster, ok = sortRequestRoster(w, r, roster); !ok {
+		return
+	}
+	page, paged, ok := paginateRequestRoster(w, r, roster)
+	if !ok {
+		return
+	}
+	if paged {
+		roster = page.Items
+	}
+	if isHTMX(r) {
+		renderFragment(w, "rows", roster)
+		return
+	}
+	if negotiateHTML(w, r) {
+		renderView(w, r, "students", map[string]interface{}{
+			"Students": roster,
+			"Query":    r.URL.Query().Get("q"),
+			"Filter":   r.URL.Query().Get("filter"),
+			"Filters":  savedFilterNames(requestTenantName(r)),
+		})
+		return
+	}
+
+	if paged {
+		writeStudentPageJSON(w, r, page)
+		return
+	}
+	writeStudentsJSON(w, roster)
+}
+
+// decodeStudent reads a student from a JSON or form-encoded body. On failure
+// it has already written the error response.
+func decodeStudent(w http.ResponseWriter, r *http.Request) (Student, bool) {
+	var student Student
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &student); err != nil {
+			http.Error(w, localize(r, "Invalid JSON data")+": "+err.Error(), http.StatusBadRequest)
+			return student, false
+		}
+		return student, true
+	}
+
+	// Handle form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, localize(r, "Invalid form data"), http.StatusBadRequest)
+		return student, false
+	}
+
+	student.Name = r.FormValue("name")
+	ageStr := r.FormValue("age")
+	if ageStr == "" {
+		http.Error(w, localize(r, "Age is required"), http.StatusBadRequest)
+		return student, false
+	}
+	age, err := strconv.Atoi(ageStr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(localize(r, "Invalid age: %s (must be a number)"), ageStr), http.StatusBadRequest)
+		return student, false
+	}
+	student.Age = age
+	student.Email = r.FormValue("email")
+	student.Phone = r.FormValue("phone")
+	student.Address = r.FormValue("address")
+	student.BirthDate = r.FormValue("birth_date")
+	student.EnrolledOn = r.FormValue("enrolled_on")
+	return student, true
+}
+
+func handleCreateStudent(w http.ResponseWriter, r *http.Request) {
+	newStudent, ok := decodeStudent(w, r)
+	if !ok {
+		return
+	}
+
+	// Validate student data
+	if err := validateStudent(newStudent); err != nil {
+		http.Error(w, localize(r, err.Error()), validation.HTTPStatus(err))
+		return
+	}
+
+	newStudent.Tenant = requestTenantName(r)
+	if err := geocodeStudent(r.Context(), &newStudent); err != nil {
+		writeError(w, r, err, "Failed to geocode address")
+		return
+	}
+	stage := timeStage(r.Context(), "store")
+	newStudent, err := createStudent(newStudent)
+	stage.stop()
+	if err != nil {
+		writeError(w, r, err, "Failed to save student")
+		return
+	}
+
+	setEmailWarning(w, newStudent)
+	setQuotaWarnings(w, r)
+	if isHTMX(r) {
+		renderFragment(w, "row", newStudent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newStudent)
+}
+
+func handleGetStudent(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	var asOf time.Time
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		if asOf, err = parseTime(r, value); err != nil {
+			http.Error(w, "Invalid as_of: must be a date or timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stage := timeStage(r.Context(), "store")
+	mutex.RLock()
+	var student Student
+	var ok bool
+	if asOf.IsZero() {
+		student, ok = findStudent(id)
+		shadowGet(id, student, ok)
+	} else {
+		var revision int64
+		if revision, err = revisionAtLocked(asOf); err == nil && revision > 0 {
+			student, ok, err = studentAtRevisionLocked(id, revision)
+		}
+	}
+	mutex.RUnlock()
+	stage.stop()
+	if err != nil {
+		http.Error(w, "Invalid as_of: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !ok || !visibleTo(requestTenantName(r), student) {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+	if isHTMX(r) {
+		renderFragment(w, "row", student)
+		return
+	}
+	if negotiateHTML(w, r) {
+		renderView(w, r, "student", map[string]interface{}{"Student": student})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(student)
+}
+
+func handleUpdateStudent(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	updatedStudent, ok := decodeStudent(w, r)
+	if !ok {
+		return
+	}
+	updatedStudent.ID = id // Ensure ID is set correctly
+	updatedStudent.Tenant = requestTenantName(r)
+
+	// Validate student data
+	if err := validateStudent(updatedStudent); err != nil {
+		http.Error(w, localize(r, err.Error()), validation.HTTPStatus(err))
+		return
+	}
+
+	if err := geocodeStudent(r.Context(), &updatedStudent); err != nil {
+		writeError(w, r, err, "Failed to geocode address")
+		return
+	}
+
+	// Update the student in the slice
+	stage := timeStage(r.Context(), "store")
+	updatedStudent, err = updateStudent(updatedStudent)
+	stage.stop()
+	if err != nil {
+		writeError(w, r, err, "Failed to save student")
+		return
+	}
+	setEmailWarning(w, updatedStudent)
+	if isHTMX(r) {
+		renderFragment(w, "row", updatedStudent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedStudent)
+}
+
+func handleDeleteStudent(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	stage := timeStage(r.Context(), "store")
+	err = deleteStudent(id, requestTenantName(r))
+	stage.stop()
+	if err != nil {
+		writeError(w, r, err, "Failed to delete student")
+		return
+	}
+	if isHTMX(r) {
+		// htmx ignores 204s; an empty 200 swaps the row away
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleStudentEditRow is the inline edit form for one row of the student
+// list page
+func handleStudentEditRow(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	mutex.RLock()
+	student, ok := findStudent(id)
+	mutex.RUnlock()
+
+	if !ok || !visibleTo(requestTenantName(r), student) {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+	renderFragment(w, "edit_row", student)
+}
+
+// handleStudentSummary generates a summary of a student using the LLM provider
+func handleStudentSummary(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	stage := timeStage(r.Context(), "store")
+	mutex.RLock()
+	targetStudent, ok := findStudent(id)
+	mutex.RUnlock()
+	stage.stop()
+
+	if !ok || !visibleTo(requestTenantName(r), targetStudent) {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+
+	// Ask the LLM provider for the summary
+	stage = timeStage(r.Context(), "llm")
+	locale := requestLocale(r)
+	summary, err := generateSummary(r.Context(), targetStudent, locale)
+	stage.stop()
+	if err != nil {
+		writeError(w, r, err, "Failed to generate summary")
+		return
+	}
+
+	w.Header().Set("Content-Language", locale)
+	if negotiateHTML(w, r) {
+		renderView(w, r, "summary", map[string]interface{}{"Student": targetStudent, "Branding": studentBranding(targetStudent),
+			"Locale": locale, "Paragraphs": strings.Split(strings.TrimSpace(summary), "\n\n")})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaryResponse(w, r, targetStudent, summary))
+}
+
+// studentBranding is the branding of the student's tenant, if it has any
+func studentBranding(student Student) *Branding {
+	if tenant, ok := lookupTenant(student.Tenant); ok && tenant.Branding != (Branding{}) {
+		return &tenant.Branding
+	}
+	return nil
+}
+
+// summaryResponse is the JSON response to a summary request
+func summaryResponse(w http.ResponseWriter, r *http.Request, student Student, summary string) map[string]interface{} {
+	response := map[string]interface{}{
+		"student": student,
+		"summary": summary,
+	}
+	if branding := studentBranding(student); branding != nil {
+		response["branding"] = branding
+	}
+	if warnings := setQuotaWarnings(w, r); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	return response
+}
+
+// LegalHoldRequest is the body of PUT /admin/students/{id}/legal-hold
+type LegalHoldRequest struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+// handleLegalHoldSet places or lifts a legal hold, which blocks deletion
+func handleLegalHoldSet(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var input LegalHoldRequest
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &input); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		if input.Enabled, err = strconv.ParseBool(r.FormValue("enabled")); err != nil {
+			http.Error(w, "Invalid enabled: must be true or false", http.StatusBadRequest)
+			return
+		}
+		input.Reason = r.FormValue("reason")
+	}
+
+	student, err := setLegalHold(id, input.Enabled)
+	if err != nil {
+		writeError(w, r, err, "Failed to save student")
+		return
+	}
+	keyName := ""
+	if key := keyFromContext(r.Context()); key != nil {
+		keyName = key.Name
+	}
+	slog.Info("Legal hold changed", "student", id, "enabled", input.Enabled, "reason", input.Reason, "key", keyName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(student)
+}
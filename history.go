@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A student's history is its entries in the change feed. Each entry is a
+// version, named by the revision that wrote it. History goes back as far as
+// the feed does: retention and restores cut it short.
+
+var errRevisionNotRetained = newError(ErrInvalid, "revision is older than the retained history")
+
+// StudentVersion is one write to a student
+type StudentVersion struct {
+	Revision   int64     `json:"revision"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// studentVersionsLocked lists a student's versions, oldest first. Callers
+// must hold mutex.
+func studentVersionsLocked(id int64) []StudentVersion {
+	versions := []StudentVersion{}
+	for _, change := range changes {
+		if change.Student.ID == id {
+			versions = append(versions, StudentVersion{Revision: change.ID, Event: change.Event, OccurredAt: change.OccurredAt})
+		}
+	}
+	return versions
+}
+
+// studentAtRevisionLocked returns a student as it was once revision was
+// committed; ok is false if it didn't exist then. Callers must hold mutex.
+func studentAtRevisionLocked(id, revision int64) (student Student, ok bool, err error) {
+	if revision > changeSeq {
+		return Student{}, false, fmt.Errorf("revision %d hasn't happened yet; the latest is %d", revision, changeSeq)
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		if change.ID > revision || change.Student.ID != id {
+			continue
+		}
+		return change.Student, change.Event != EventStudentDeleted, nil
+	}
+	// No retained change touched the student by then. That settles it only
+	// if the feed reaches back to the start.
+	if oldestRevisionLocked() > 0 {
+		return Student{}, false, errRevisionNotRetained
+	}
+	return Student{}, false, nil
+}
+
+// FieldDiff is one field that differs between two students. Attributes are
+// compared one by one, as attributes.<name>.
+type FieldDiff struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"` // added, removed or changed
+	From  interface{} `json:"from,omitempty"`
+	To    interface{} `json:"to,omitempty"`
+}
+
+// StudentDiff compares two students, or two versions of one, left to right
+type StudentDiff struct {
+	Left    DiffSide    `json:"left"`
+	Right   DiffSide    `json:"right"`
+	Changes []FieldDiff `json:"changes"`
+}
+
+type DiffSide struct {
+	ID       int64    `json:"id"`
+	Revision int64    `json:"revision,omitempty"` // 0 is the current version
+	Exists   bool     `json:"exists"`
+	Student  *Student `json:"student,omitempty"`
+}
+
+// diffFields flattens the fields a diff compares. IDs are left out: they
+// identify the sides rather than describe them.
+func diffFields(student Student) map[string]interface{} {
+	fields := map[string]interface{}{
+		"name":        student.Name,
+		"age":         student.Age,
+		"email":       student.Email,
+		"phone":       student.Phone,
+		"address":     student.Address,
+		"birth_date":  student.BirthDate,
+		"enrolled_on": student.EnrolledOn,
+		"legal_hold":  student.LegalHold,
+		"tenant":      student.Tenant,
+		"tags":        append([]string{}, student.Tags...),
+	}
+	if student.Location != nil {
+		fields["location"] = *student.Location
+	}
+	for name, value := range student.Attributes {
+		fields["attributes."+name] = value
+	}
+	return fields
+}
+
+// diffStudents lists the fields that differ. A side that doesn't exist has
+// no fields, so everything on the other side is added or removed.
+func diffStudents(left DiffSide, right DiffSide) []FieldDiff {
+	leftFields, rightFields := map[string]interface{}{}, map[string]interface{}{}
+	if left.Exists {
+		leftFields = diffFields(*left.Student)
+	}
+	if right.Exists {
+		rightFields = diffFields(*right.Student)
+	}
+	names := []string{}
+	for name := range leftFields {
+		names = append(names, name)
+	}
+	for name := range rightFields {
+		if _, ok := leftFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []FieldDiff{}
+	for _, name := range names {
+		from, inLeft := leftFields[name]
+		to, inRight := rightFields[name]
+		switch {
+		case !inLeft:
+			diffs = append(diffs, FieldDiff{Field: name, Op: "added", To: to})
+		case !inRight:
+			diffs = append(diffs, FieldDiff{Field: name, Op: "removed", From: from})
+		case !reflect.DeepEqual(from, to):
+			diffs = append(diffs, FieldDiff{Field: name, Op: "changed", From: from, To: to})
+		}
+	}
+	return diffs
+}
+
+// diffSideLocked loads a student at a revision, 0 being now. Callers must
+// hold mutex.
+func diffSideLocked(id, revision int64) (DiffSide, error) {
+	side := DiffSide{ID: id, Revision: revision}
+	var student Student
+	if revision == 0 {
+		student, side.Exists = findStudent(id)
+	} else {
+		var err error
+		if student, side.Exists, err = studentAtRevisionLocked(id, revision); err != nil {
+			return side, err
+		}
+	}
+	if side.Exists {
+		side.Student = &student
+	}
+	return side, nil
+}
+
+// lastWriteLocked returns the revision of the last write to a student up to
+// a revision (0 is now), or that revision if none is retained. Callers must
+// hold mutex.
+func lastWriteLocked(id, revision int64) int64 {
+	if revision == 0 {
+		revision = changeSeq
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].ID <= revision && changes[i].Student.ID == id {
+			return changes[i].ID
+		}
+	}
+	return revision
+}
+
+func parseRevision(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision <= 0 {
+		return 0, fmt.Errorf("invalid revision %q", value)
+	}
+	return revision, nil
+}
+
+// visibleSide hides a side the caller's tenant can't see as if it didn't
+// exist
+func visibleSide(tenant string, side DiffSide) DiffSide {
+	if side.Exists && !visibleTo(tenant, *side.Student) {
+		return DiffSide{ID: side.ID, Revision: side.Revision}
+	}
+	return side
+}
+
+func writeDiff(w http.ResponseWriter, r *http.Request, left, right DiffSide) {
+	tenant := requestTenantName(r)
+	left, right = visibleSide(tenant, left), visibleSide(tenant, right)
+	if !left.Exists && !right.Exists {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StudentDiff{Left: left, Right: right, Changes: diffStudents(left, right)})
+}
+
+// handleStudentVersions lists the revisions that wrote a student
+func handleStudentVersions(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	mutex.RLock()
+	versions := studentVersionsLocked(id)
+	var latest Student
+	if len(versions) > 0 {
+		latest, _, _ = studentAtRevisionLocked(id, versions[len(versions)-1].Revision)
+	}
+	complete := oldestRevisionLocked() == 0
+	mutex.RUnlock()
+	if len(versions) == 0 || !visibleTo(requestTenantName(r), latest) {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+	for i := range versions {
+		versions[i].OccurredAt = localTime(r, versions[i].OccurredAt)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"versions": versions, "complete": complete})
+}
+
+// handleStudentDiff compares two versions of a student. ?version= defaults
+// to the current one and ?against= to the version before it.
+func handleStudentDiff(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	version, err := parseRevision(r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	against, err := parseRevision(r.URL.Query().Get("against"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mutex.RLock()
+	right, err := diffSideLocked(id, version)
+	if err == nil && against == 0 {
+		// The revision just before the write that produced the right side
+		against = lastWriteLocked(id, version) - 1
+	}
+	left := DiffSide{ID: id, Revision: against} // nothing before revision 1
+	if err == nil && against > 0 {
+		left, err = diffSideLocked(id, against)
+	}
+	mutex.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeDiff(w, r, left, right)
+}
+
+// handleDiff compares two students as they are now
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("left") == "" || query.Get("right") == "" {
+		http.Error(w, "left and right are required", http.StatusBadRequest)
+		return
+	}
+	leftID, err := parseStudentID(query.Get("left"))
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	rightID, err := parseStudentID(query.Get("right"))
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	mutex.RLock()
+	left, _ := diffSideLocked(leftID, 0)
+	right, _ := diffSideLocked(rightID, 0)
+	mutex.RUnlock()
+	writeDiff(w, r, left, right)
+}
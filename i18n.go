@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLocale = "en"
+
+// languageNames are the supported locales, named the way the summary prompt
+// asks for them
+var languageNames = map[string]string{
+	"en": "English",
+	"hi": "Hindi",
+	"es": "Spanish",
+}
+
+// translations maps English messages (or format strings) to their
+// translations. Messages without a translation are returned in English.
+var translations = map[string]map[string]string{
+	"hi": {
+		"Invalid ID":                                        "अमान्य आईडी",
+		"Student not found":                                 "छात्र नहीं मिला",
+		"Invalid JSON data":                                 "अमान्य JSON डेटा",
+		"Invalid form data":                                 "अमान्य फ़ॉर्म डेटा",
+		"Invalid attributes":                                "अमान्य विशेषताएँ",
+		"Invalid email":                                     "अमान्य ईमेल",
+		"Address not found":                                 "पता नहीं मिला",
+		"Age is required":                                   "आयु आवश्यक है",
+		"Invalid age: %s (must be a number)":                "अमान्य आयु: %s (संख्या होनी चाहिए)",
+		"name is required":                                  "नाम आवश्यक है",
+		"age must be between 1 and 150":                     "आयु 1 से 150 के बीच होनी चाहिए",
+		"email is required":                                 "ईमेल आवश्यक है",
+		"Student limit reached for this plan":               "इस योजना के लिए छात्रों की सीमा पूरी हो गई है",
+		"Daily summary limit reached, try again tomorrow":   "दैनिक सारांश सीमा पूरी हो गई है, कल फिर से प्रयास करें",
+		"Failed to generate summary":                        "सारांश बनाने में विफल",
+		"Student is under legal hold and cannot be deleted": "छात्र कानूनी रोक के अधीन है और हटाया नहीं जा सकता",
+	},
+	"es": {
+		"Invalid ID":                                        "ID no válido",
+		"Student not found":                                 "Estudiante no encontrado",
+		"Invalid JSON data":                                 "Datos JSON no válidos",
+		"Invalid form data":                                 "Datos de formulario no válidos",
+		"Invalid attributes":                                "Atributos no válidos",
+		"Invalid email":                                     "Correo electrónico no válido",
+		"Address not found":                                 "Dirección no encontrada",
+		"Age is required":                                   "La edad es obligatoria",
+		"Invalid age: %s (must be a number)":                "Edad no válida: %s (debe ser un número)",
+		"name is required":                                  "El nombre es obligatorio",
+		"age must be between 1 and 150":                     "La edad debe estar entre 1 y 150",
+		"email is required":                                 "El correo electrónico es obligatorio",
+		"Student limit reached for this plan":               "Se alcanzó el límite de estudiantes de este plan",
+		"Daily summary limit reached, try again tomorrow":   "Se alcanzó el límite diario de resúmenes, inténtalo mañana",
+		"Failed to generate summary":                        "No se pudo generar el resumen",
+		"Student is under legal hold and cannot be deleted": "El estudiante está bajo retención legal y no se puede eliminar",
+	},
+}
+
+// requestLocale picks the supported language the client prefers most from
+// its Accept-Language header, falling back to the tenant's locale and then
+// English
+func requestLocale(r *http.Request) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		locale, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := languageNames[locale]; !ok {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		if locale := requestTenant(r).Locale; locale != "" {
+			return locale
+		}
+		return defaultLocale
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// localize translates a message into the request's language
+func localize(r *http.Request, message string) string {
+	if translated, ok := translations[requestLocale(r)][message]; ok {
+		return translated
+	}
+	return message
+}
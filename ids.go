@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Students have two IDs. ID is a 64-bit number assigned in order by this
+// server. UUID is a UUIDv7, which any node can assign without coordinating,
+// sorts by creation time and survives JavaScript's 53-bit numbers. Paths
+// accept either while clients move to UUIDs; -numeric-id-paths=false ends
+// the transition.
+
+var (
+	errInvalidStudentID  = newError(ErrInvalid, "Invalid ID")
+	errNumericIDsRetired = newError(ErrInvalid, "Numeric IDs are no longer accepted; use the student's uuid")
+)
+
+// numericIDPaths is whether /students/{id} still accepts numeric IDs
+var numericIDPaths = true
+
+// lastStudentID is the highest ID ever assigned, so deleted IDs are never
+// reused. It is guarded by mutex.
+var lastStudentID int64
+
+// nextStudentIDLocked assigns the next ID. Callers must hold mutex for
+// writing.
+func nextStudentIDLocked() int64 {
+	lastStudentID++
+	return lastStudentID
+}
+
+// noteStudentIDLocked raises lastStudentID past an ID seen in the roster or
+// the log. Callers must hold mutex for writing.
+func noteStudentIDLocked(id int64) {
+	lastStudentID = max(lastStudentID, id)
+}
+
+// newUUIDv7 returns a random UUID whose first 48 bits are the Unix time in
+// milliseconds, per RFC 9562
+func newUUIDv7() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	ms := uint64(time.Now().UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// isUUID reports whether s is a UUID in the canonical 8-4-4-4-12 form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'):
+			return false
+		}
+	}
+	return true
+}
+
+// studentIDFromPath reads the {id} path parameter as a numeric ID or a UUID.
+// A UUID no student has resolves to 0, which no student has either, so the
+// caller's lookup reports it as not found.
+func studentIDFromPath(r *http.Request) (int64, error) {
+	return parseStudentID(r.PathValue("id"))
+}
+
+// parseStudentID reads a numeric ID or a UUID, like studentIDFromPath
+func parseStudentID(value string) (int64, error) {
+	if isUUID(value) {
+		mutex.RLock()
+		defer mutex.RUnlock()
+		for _, student := range students {
+			if strings.EqualFold(student.UUID, value) {
+				return student.ID, nil
+			}
+		}
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errInvalidStudentID
+	}
+	if !numericIDPaths {
+		return 0, errNumericIDsRetired
+	}
+	return id, nil
+}
+
+// backfillStudentUUIDs gives students created before UUIDs existed one, as
+// a single batch
+func backfillStudentUUIDs() (int, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var missing []Student
+	for _, student := range students {
+		if student.UUID == "" {
+			missing = append(missing, student)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	if err := beginBatchLocked(); err != nil {
+		return 0, err
+	}
+	for _, student := range missing {
+		student.UUID = newUUIDv7()
+		if err := commitChange(EventStudentUpdated, student); err != nil {
+			endBatchLocked()
+			return 0, err
+		}
+	}
+	return len(missing), endBatchLocked()
+}
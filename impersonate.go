@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultImpersonationTTL = 30 * time.Minute
+	maxImpersonationTTL     = 4 * time.Hour
+)
+
+// ImpersonationRequest asks for a key acting as a user, or as a tenant with
+// the given role. Reason is required and recorded with every request.
+type ImpersonationRequest struct {
+	UserID int      `json:"user_id"`
+	Tenant string   `json:"tenant"`
+	Role   string   `json:"role"` // for tenants; write or read, default read
+	Reason string   `json:"reason"`
+	TTL    Duration `json:"ttl"` // default 30m, at most 4h
+}
+
+// impersonator describes who issued an impersonation key, for the audit log
+func impersonator(key *APIKey) string {
+	if key.UserID == 0 {
+		return "key:" + key.Name
+	}
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	if user := findUserLocked(key.UserID); user != nil {
+		return "user:" + user.Email
+	}
+	return fmt.Sprintf("user:%d", key.UserID)
+}
+
+// auditImpersonation logs every request made with an impersonation key at
+// info level, whatever the log level, and marks the response so clients can
+// show that the session is impersonated
+func auditImpersonation(key *APIKey, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Impersonated-By", key.ImpersonatedBy)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+	slog.Info("Impersonated request", "impersonated_by", key.ImpersonatedBy, "acting_as", key.Name,
+		"tenant", key.Tenant, "reason", key.ImpersonationReason, "method", r.Method, "path", r.URL.Path,
+		"status", recorder.status)
+}
+
+// handleImpersonate issues a time-limited key that acts as another user or as
+// a tenant. It can't act as an admin, and can't reach /auth/ endpoints, so it
+// can't change the target's password, sessions or second factor.
+func handleImpersonate(w http.ResponseWriter, r *http.Request) {
+	var input ImpersonationRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(input.Reason) == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if (input.UserID == 0) == (input.Tenant == "") {
+		http.Error(w, "give exactly one of user_id or tenant", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(input.TTL)
+	if ttl == 0 {
+		ttl = defaultImpersonationTTL
+	}
+	if ttl < 0 || ttl > maxImpersonationTTL {
+		http.Error(w, "ttl must be positive and at most 4h", http.StatusBadRequest)
+		return
+	}
+
+	var name, role, tenant string
+	if input.UserID != 0 {
+		usersMutex.Lock()
+		user := findUserLocked(input.UserID)
+		var target User
+		if user != nil {
+			target = *user
+		}
+		usersMutex.Unlock()
+		if user == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if target.Role == RoleAdmin {
+			http.Error(w, "Admin users can't be impersonated", http.StatusBadRequest)
+			return
+		}
+		name, role, tenant = "user:"+target.Email, target.Role, target.Tenant
+	} else {
+		if _, ok := lookupTenant(input.Tenant); !ok {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		role = input.Role
+		if role == "" {
+			role = RoleRead
+		}
+		if role != RoleWrite && role != RoleRead {
+			http.Error(w, "role must be write or read", http.StatusBadRequest)
+			return
+		}
+		name, tenant = "tenant:"+input.Tenant, input.Tenant
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl).UTC()
+	key := &APIKey{
+		Name:      name,
+		Role:      role,
+		Tenant:    tenant,
+		Prefix:    secret[:8],
+		ExpiresAt: &expiresAt,
+		CreatedAt: now.UTC(),
+		hash:      hashAPIKey(secret),
+
+		ImpersonatedBy:      impersonator(keyFromContext(r.Context())),
+		ImpersonationReason: input.Reason,
+	}
+	apiKeysMutex.Lock()
+	apiKeySeq++
+	key.ID = apiKeySeq
+	apiKeys = append(apiKeys, key)
+	issued := IssuedAPIKey{APIKey: *key, Key: secret}
+	apiKeysMutex.Unlock()
+	saveAuthState()
+	slog.Info("Impersonation started", "impersonated_by", key.ImpersonatedBy, "acting_as", name,
+		"tenant", tenant, "reason", input.Reason, "expires_at", expiresAt, "key", key.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issued)
+}
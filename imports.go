@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxImportBytes   = 32 << 20 // largest CSV accepted
+	maxImportErrors  = 100      // row errors kept per import
+	maxQueuedImports = 8        // imports waiting to run before new ones are refused
+	importsRetained  = 50       // finished imports kept for GET /imports/{id}
+	importBatchSize  = 100      // rows a worker writes to the store at once
+)
+
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportDone      = "done"
+	ImportCancelled = "cancelled"
+)
+
+// importWorkers is how many rows are written to the store at once, across
+// every import
+var importWorkers = 4
+
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportJob is a CSV import running in the background
+type ImportJob struct {
+	ID         int           `json:"id"`
+	Status     string        `json:"status"`
+	Tenant     string        `json:"tenant,omitempty"`
+	Total      int           `json:"total"`
+	Processed  int           `json:"processed"`
+	Created    int           `json:"created"`
+	Failed     int           `json:"failed"`
+	Errors     []ImportError `json:"errors"`
+	CreatedAt  time.Time     `json:"created_at"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+
+	rows    [][]string
+	columns importColumns
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    *sync.WaitGroup // batches handed to the workers and not yet written
+}
+
+// importBatch is a run of rows starting at a line of the CSV
+type importBatch struct {
+	job       *ImportJob
+	firstLine int
+	records   [][]string
+}
+
+// importColumns maps the CSV header to column positions
+type importColumns struct{ name, age, email, phone, address, birthDate, enrolledOn int }
+
+var (
+	imports      []*ImportJob
+	importSeq    int
+	importsMutex sync.Mutex
+
+	importQueue     = make(chan *ImportJob, maxQueuedImports)
+	importBatches   chan importBatch
+	importStartOnce sync.Once
+)
+
+// startImportWorkers starts the pool the first time an import arrives. Rows
+// are fed to it in batches through a small channel, so when the store is
+// slow the feeder blocks rather than piling rows up in memory.
+func startImportWorkers() {
+	importStartOnce.Do(func() {
+		importBatches = make(chan importBatch, importWorkers)
+		for i := 0; i < max(importWorkers, 1); i++ {
+			go importWorker()
+		}
+		go runImports()
+	})
+}
+
+// runImports feeds queued imports to the workers one at a time, in order
+func runImports() {
+	for job := range importQueue {
+		importsMutex.Lock()
+		if job.Status == ImportCancelled {
+			job.rows = nil
+			importsMutex.Unlock()
+			continue
+		}
+		now := time.Now().UTC()
+		job.Status = ImportRunning
+		job.StartedAt = &now
+		importsMutex.Unlock()
+
+		for i := 0; i < len(job.rows) && job.ctx.Err() == nil; i += importBatchSize {
+			batch := importBatch{job: job, firstLine: i + 2, records: job.rows[i:min(i+importBatchSize, len(job.rows))]}
+			job.done.Add(1)
+			select {
+			case importBatches <- batch:
+			case <-job.ctx.Done():
+				job.done.Done()
+			}
+		}
+		job.done.Wait()
+		finishImport(job)
+	}
+}
+
+func importWorker() {
+	for batch := range importBatches {
+		if batch.job.ctx.Err() == nil {
+			importStudents(batch)
+		}
+		batch.job.done.Done()
+	}
+}
+
+// importStudents writes the valid rows of a batch in one store batch and
+// records the rest as errors
+func importStudents(batch importBatch) {
+	job := batch.job
+	var valid []Student
+	var lines []int
+	var rowErrors []ImportError
+	for i, record := range batch.records {
+		student, err := parseImportRow(job, record)
+		if err != nil {
+			rowErrors = append(rowErrors, ImportError{Line: batch.firstLine + i, Error: err.Error()})
+			continue
+		}
+		valid = append(valid, student)
+		lines = append(lines, batch.firstLine+i)
+	}
+	created := 0
+	if len(valid) > 0 {
+		_, errs, err := createStudents(valid)
+		for i := range valid {
+			switch {
+			case err != nil:
+				rowErrors = append(rowErrors, ImportError{Line: lines[i], Error: err.Error()})
+			case errs[i] != nil:
+				rowErrors = append(rowErrors, ImportError{Line: lines[i], Error: errs[i].Error()})
+			default:
+				created++
+			}
+		}
+	}
+
+	importsMutex.Lock()
+	defer importsMutex.Unlock()
+	job.Processed += len(batch.records)
+	job.Created += created
+	job.Failed += len(rowErrors)
+	for _, rowError := range rowErrors {
+		if len(job.Errors) < maxImportErrors {
+			job.Errors = append(job.Errors, rowError)
+		}
+	}
+}
+
+func parseImportRow(job *ImportJob, record []string) (Student, error) {
+	columns := job.columns
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	age, err := strconv.Atoi(field(columns.age))
+	if err != nil {
+		return Student{}, fmt.Errorf("invalid age: %q (must be a number)", field(columns.age))
+	}
+	student := Student{Name: field(columns.name), Age: age, Email: field(columns.email),
+		Phone: field(columns.phone), Address: field(columns.address),
+		BirthDate: field(columns.birthDate), EnrolledOn: field(columns.enrolledOn), Tenant: job.Tenant}
+	if err := validateStudent(student); err != nil {
+		return Student{}, err
+	}
+	return student, geocodeStudent(job.ctx, &student)
+}
+
+func finishImport(job *ImportJob) {
+	importsMutex.Lock()
+	defer importsMutex.Unlock()
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	if job.Status != ImportCancelled {
+		job.Status = ImportDone
+	}
+	job.rows = nil
+	job.cancel()
+	slog.Info("Import finished", "import", job.ID, "status", job.Status, "created", job.Created, "failed", job.Failed)
+
+	// Forget the oldest finished imports beyond the retention limit
+	finished := 0
+	for i := len(imports) - 1; i >= 0; i-- {
+		if imports[i].FinishedAt == nil {
+			continue
+		}
+		finished++
+		if finished > importsRetained {
+			imports = append(imports[:i], imports[i+1:]...)
+		}
+	}
+}
+
+// parseImportHeader finds the name, age, email, phone, address, birth_date
+// and enrolled_on columns, in any order
+func parseImportHeader(header []string) (importColumns, error) {
+	columns := importColumns{name: -1, age: -1, email: -1, phone: -1, address: -1, birthDate: -1, enrolledOn: -1}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "name":
+			columns.name = i
+		case "age":
+			columns.age = i
+		case "email":
+			columns.email = i
+		case "phone":
+			columns.phone = i
+		case "address":
+			columns.address = i
+		case "birth_date":
+			columns.birthDate = i
+		case "enrolled_on":
+			columns.enrolledOn = i
+		}
+	}
+	if columns.name < 0 || columns.age < 0 {
+		return columns, errors.New("the header must name a name and an age column (email is optional)")
+	}
+	return columns, nil
+}
+
+// handleImport accepts a CSV of students and imports it in the background.
+// The response points at GET /imports/{id} for progress.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("CSV must be at most %d bytes", maxImportBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		http.Error(w, "Invalid CSV: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "CSV is empty", http.StatusBadRequest)
+		return
+	}
+	columns, err := parseImportHeader(records[0])
+	if err != nil {
+		http.Error(w, "Invalid CSV: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	startImportWorkers()
+	ctx, cancel := context.WithCancel(context.Background())
+	importsMutex.Lock()
+	importSeq++
+	job := &ImportJob{
+		ID:        importSeq,
+		Status:    ImportQueued,
+		Tenant:    requestTenantName(r),
+		Total:     len(records) - 1,
+		Errors:    []ImportError{},
+		CreatedAt: time.Now().UTC(),
+		rows:      records[1:],
+		columns:   columns,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      &sync.WaitGroup{},
+	}
+	select {
+	case importQueue <- job:
+	default:
+		importSeq--
+		importsMutex.Unlock()
+		cancel()
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many imports in progress; try again later", http.StatusServiceUnavailable)
+		return
+	}
+	imports = append(imports, job)
+	response := *job
+	importsMutex.Unlock()
+	slog.Info("Import queued", "import", job.ID, "tenant", job.Tenant, "rows", job.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/imports/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// findImportLocked returns the import with the ID in the path, if the caller's
+// tenant owns it. Callers must hold importsMutex.
+func findImportLocked(r *http.Request) *ImportJob {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil
+	}
+	tenant := requestTenantName(r)
+	for _, job := range imports {
+		if job.ID == id && (tenant == "" || job.Tenant == tenant) {
+			return job
+		}
+	}
+	return nil
+}
+
+func handleImportGet(w http.ResponseWriter, r *http.Request) {
+	importsMutex.Lock()
+	job := findImportLocked(r)
+	var response ImportJob
+	if job != nil {
+		response = *job
+		response.Errors = append([]ImportError{}, job.Errors...)
+	}
+	importsMutex.Unlock()
+	if job == nil {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&response)
+}
+
+// handleImportCancel stops an import. Rows already written stay.
+func handleImportCancel(w http.ResponseWriter, r *http.Request) {
+	importsMutex.Lock()
+	job := findImportLocked(r)
+	if job == nil {
+		importsMutex.Unlock()
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+	if job.FinishedAt != nil {
+		importsMutex.Unlock()
+		http.Error(w, "Import already finished", http.StatusConflict)
+		return
+	}
+	if job.Status == ImportQueued {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+	}
+	job.Status = ImportCancelled
+	job.cancel()
+	response := *job
+	importsMutex.Unlock()
+	slog.Info("Import cancelled", "import", job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&response)
+}
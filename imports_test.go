@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+)
+
+// FuzzImportCSV runs a CSV upload through the parsing handleImport and the
+// import workers do, without the queue
+func FuzzImportCSV(f *testing.F) {
+	for _, seed := range []string{
+		"name,age,email\nAda,20,ada@example.com\n",
+		"\ufeffAge,NAME , phone,birth_date\n20,Ada,+44 20 7946 0958,2005-12-10\n",
+		"name,age\n\"Ada, Countess\",20\nGrace\n,,,,\n",
+		"name,age,email\nAda,twenty,ada@example.com\nAda,-1,\nAda,99999999999999999999,x\n",
+		"name,age\n\"unterminated,20\n",
+		"email\nada@example.com\n",
+		"",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil || len(records) == 0 {
+			return
+		}
+		columns, err := parseImportHeader(records[0])
+		if err != nil {
+			return
+		}
+		job := &ImportJob{columns: columns, ctx: context.Background()}
+		for _, record := range records[1:] {
+			student, err := parseImportRow(job, record)
+			if err != nil {
+				continue
+			}
+			if err := validateStudent(student); err != nil {
+				t.Fatalf("row %q parsed to an invalid student: %v", record, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IntegrityIssue is one problem found by the integrity check. Repair names
+// the fix when there is a safe one; other issues need a human.
+type IntegrityIssue struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"` // error or warning
+	Entity   string `json:"entity"`
+	ID       int64  `json:"id"`
+	Message  string `json:"message"`
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+type IntegrityReport struct {
+	CheckedAt time.Time        `json:"checked_at"`
+	Scope     string           `json:"scope"` // full, or roster when run offline
+	Checked   map[string]int   `json:"checked"`
+	Issues    []IntegrityIssue `json:"issues"`
+	Errors    int              `json:"errors"`
+	Warnings  int              `json:"warnings"`
+	Repaired  int              `json:"repaired"`
+}
+
+// checkRoster looks for duplicate IDs, duplicate emails within a tenant,
+// students that fail validation and a change log out of order. None of these
+// have a safe automatic fix.
+func checkRoster(report *IntegrityReport, tenantNames map[string]bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	report.Checked["students"] = len(students)
+	report.Checked["changes"] = len(changes)
+
+	seenIDs := map[int64]bool{}
+	seenEmails := map[string]int64{}
+	for _, student := range students {
+		if seenIDs[student.ID] {
+			report.add(IntegrityIssue{Check: "duplicate_student_id", Severity: "error", Entity: "student", ID: student.ID,
+				Message: fmt.Sprintf("more than one student has ID %d", student.ID)})
+		}
+		seenIDs[student.ID] = true
+
+		email := student.Tenant + "/" + strings.ToLower(strings.TrimSpace(student.Email))
+		if other, ok := seenEmails[email]; ok && student.Email != "" {
+			report.add(IntegrityIssue{Check: "duplicate_email", Severity: "warning", Entity: "student", ID: student.ID,
+				Message: fmt.Sprintf("email %s is also used by student %d", student.Email, other)})
+		} else {
+			seenEmails[email] = student.ID
+		}
+		if err := validateStudent(student); err != nil {
+			report.add(IntegrityIssue{Check: "invalid_student", Severity: "warning", Entity: "student", ID: student.ID,
+				Message: err.Error()})
+		}
+		if tenantNames != nil && student.Tenant != "" && !tenantNames[student.Tenant] {
+			report.add(IntegrityIssue{Check: "unknown_tenant", Severity: "error", Entity: "student", ID: student.ID,
+				Message: fmt.Sprintf("tenant %q does not exist", student.Tenant)})
+		}
+	}
+
+	for i, change := range changes {
+		if (i > 0 && change.ID <= changes[i-1].ID) || change.ID > changeSeq {
+			report.add(IntegrityIssue{Check: "change_log_order", Severity: "error", Entity: "change", ID: change.ID,
+				Message: fmt.Sprintf("change %d is out of order (revision %d)", change.ID, changeSeq)})
+		}
+	}
+}
+
+// checkAccounts looks for users, sessions and API keys that point at
+// something that no longer exists. Orphaned sessions and keys can't be used
+// sensibly, so repairing revokes them.
+func checkAccounts(report *IntegrityReport, tenantNames map[string]bool, repair bool) {
+	usersMutex.Lock()
+	report.Checked["users"] = len(users)
+	userIDs := map[int]bool{}
+	for _, user := range users {
+		userIDs[user.ID] = true
+		if user.Tenant != "" && !tenantNames[user.Tenant] {
+			report.add(IntegrityIssue{Check: "unknown_tenant", Severity: "error", Entity: "user", ID: int64(user.ID),
+				Message: fmt.Sprintf("tenant %q does not exist", user.Tenant)})
+		}
+	}
+	usersMutex.Unlock()
+
+	sessionsMutex.Lock()
+	report.Checked["sessions"] = len(sessions)
+	sessionIDs := map[int]bool{}
+	var orphanedSessions []IntegrityIssue
+	for _, session := range sessions {
+		sessionIDs[session.ID] = true
+		if !userIDs[session.UserID] {
+			orphanedSessions = append(orphanedSessions, IntegrityIssue{Check: "orphaned_session", Severity: "error", Entity: "session",
+				ID: int64(session.ID), Message: fmt.Sprintf("user %d does not exist", session.UserID), Repair: "revoke the session"})
+		}
+	}
+	sessionsMutex.Unlock()
+	for _, issue := range orphanedSessions {
+		if repair {
+			id := int(issue.ID)
+			revokeSessions(func(s *Session) bool { return s.ID == id })
+			issue.Repaired = true
+		}
+		report.add(issue)
+	}
+
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	report.Checked["api_keys"] = len(apiKeys)
+	kept := apiKeys[:0]
+	for _, key := range apiKeys {
+		var problem string
+		switch {
+		case key.Tenant != "" && !tenantNames[key.Tenant]:
+			problem = fmt.Sprintf("tenant %q does not exist", key.Tenant)
+		case key.UserID != 0 && !userIDs[key.UserID]:
+			problem = fmt.Sprintf("user %d does not exist", key.UserID)
+		case key.SessionID != 0 && !sessionIDs[key.SessionID]:
+			problem = fmt.Sprintf("session %d was revoked", key.SessionID)
+		}
+		if problem == "" {
+			kept = append(kept, key)
+			continue
+		}
+		issue := IntegrityIssue{Check: "orphaned_api_key", Severity: "error", Entity: "api_key", ID: int64(key.ID),
+			Message: problem, Repair: "revoke the key", Repaired: repair}
+		report.add(issue)
+		if !repair {
+			kept = append(kept, key)
+		}
+	}
+	apiKeys = kept
+}
+
+// sortIssues puts errors before warnings
+func (report *IntegrityReport) sortIssues() {
+	sort.SliceStable(report.Issues, func(i, j int) bool { return report.Issues[i].Severity < report.Issues[j].Severity })
+}
+
+func (report *IntegrityReport) add(issue IntegrityIssue) {
+	report.Issues = append(report.Issues, issue)
+	if issue.Severity == "error" {
+		report.Errors++
+	} else {
+		report.Warnings++
+	}
+	if issue.Repaired {
+		report.Repaired++
+	}
+}
+
+// checkIntegrity validates everything the server holds, fixing what is safe
+// to fix when repair is set
+func checkIntegrity(repair bool) IntegrityReport {
+	report := IntegrityReport{CheckedAt: time.Now().UTC(), Scope: "full", Checked: map[string]int{}, Issues: []IntegrityIssue{}}
+	tenantsMutex.RLock()
+	tenantNames := make(map[string]bool, len(tenants))
+	for name := range tenants {
+		tenantNames[name] = true
+	}
+	tenantsMutex.RUnlock()
+	report.Checked["tenants"] = len(tenantNames)
+
+	checkRoster(&report, tenantNames)
+	checkAccounts(&report, tenantNames, repair)
+	report.sortIssues()
+	return report
+}
+
+// handleIntegrityCheck runs the integrity check; ?repair=true also applies
+// the safe fixes
+func handleIntegrityCheck(w http.ResponseWriter, r *http.Request) {
+	repair := false
+	if value := r.URL.Query().Get("repair"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid repair: must be true or false", http.StatusBadRequest)
+			return
+		}
+		repair = parsed
+	}
+	report := checkIntegrity(repair)
+	slog.Info("Integrity check finished", "errors", report.Errors, "warnings", report.Warnings, "repaired", report.Repaired)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// runFsck implements the fsck subcommand, which checks the roster in a
+// write-ahead log with the server stopped. Users, keys and tenants live only
+// in a running server, so they are checked by POST /admin/integrity-check.
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	walPath := fs.String("wal", os.Getenv("STUDENTS_WAL"), "write-ahead log to check")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+	if *walPath == "" {
+		return fmt.Errorf("usage: fsck -wal path")
+	}
+
+	offlineStorage = true
+	backend, err := openBackend("wal:" + *walPath)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	report := IntegrityReport{CheckedAt: time.Now().UTC(), Scope: "roster", Checked: map[string]int{}, Issues: []IntegrityIssue{}}
+	checkRoster(&report, nil)
+	report.sortIssues()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, issue := range report.Issues {
+			fmt.Printf("%-7s %-20s %s %d: %s\n", issue.Severity, issue.Check, issue.Entity, issue.ID, issue.Message)
+		}
+		fmt.Printf("Checked %d students and %d changes: %d errors, %d warnings\n",
+			report.Checked["students"], report.Checked["changes"], report.Errors, report.Warnings)
+	}
+	if report.Errors > 0 {
+		return fmt.Errorf("integrity check failed")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IPRule restricts a route group by client address. Deny wins over allow; an
+// empty allow list lets every address not denied through.
+type IPRule struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+
+	allow, deny []netip.Prefix
+}
+
+func (rule IPRule) permits(addr netip.Addr) bool {
+	if prefixesContain(rule.deny, addr) {
+		return false
+	}
+	return len(rule.allow) == 0 || prefixesContain(rule.allow, addr)
+}
+
+// ipRuleGroups are the route groups rules apply to. "all" is checked for
+// every request, before the request's own group.
+var ipRuleGroups = map[string]func(path string) bool{
+	"all":   func(string) bool { return true },
+	"admin": isAdminPath,
+	"auth": func(path string) bool {
+		return strings.HasPrefix(path, "/auth/") || strings.HasPrefix(path, "/signup")
+	},
+	"api": func(path string) bool {
+		return !isAdminPath(path) && !strings.HasPrefix(path, "/auth/") && !strings.HasPrefix(path, "/signup")
+	},
+}
+
+var (
+	ipRules      = map[string]IPRule{}
+	ipRulesMutex sync.RWMutex
+)
+
+// ipAllowed checks the client against the rules for the path's groups
+func ipAllowed(rules map[string]IPRule, addr netip.Addr, path string) bool {
+	for group, rule := range rules {
+		if ipRuleGroups[group](path) && !rule.permits(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterIPs refuses clients the IP rules don't allow, before anything else
+// looks at the request
+func filterIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipRulesMutex.RLock()
+		allowed := ipAllowed(ipRules, clientIP(r), r.URL.Path)
+		ipRulesMutex.RUnlock()
+		if !allowed {
+			enableCORS(w)
+			http.Error(w, "Access from your network is not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleIPRuleList(w http.ResponseWriter, r *http.Request) {
+	ipRulesMutex.RLock()
+	result := map[string]IPRule{}
+	for group, rule := range ipRules {
+		result[group] = rule
+	}
+	ipRulesMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleIPRuleSet replaces a group's rule. A rule that would lock the caller
+// out of the admin API is refused.
+func handleIPRuleSet(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+	if ipRuleGroups[group] == nil {
+		groups := make([]string, 0, len(ipRuleGroups))
+		for name := range ipRuleGroups {
+			groups = append(groups, name)
+		}
+		sort.Strings(groups)
+		http.Error(w, "Unknown route group; must be one of "+strings.Join(groups, ", "), http.StatusNotFound)
+		return
+	}
+	var rule IPRule
+	if err := decodeJSON(w, r, &rule); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if rule.allow, err = parsePrefixes(rule.Allow); err != nil {
+		http.Error(w, "allow: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.deny, err = parsePrefixes(rule.Deny); err != nil {
+		http.Error(w, "deny: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.Allow == nil {
+		rule.Allow = []string{}
+	}
+	if rule.Deny == nil {
+		rule.Deny = []string{}
+	}
+
+	ipRulesMutex.Lock()
+	updated := map[string]IPRule{group: rule}
+	for name, existing := range ipRules {
+		if name != group {
+			updated[name] = existing
+		}
+	}
+	if !ipAllowed(updated, clientIP(r), r.URL.Path) {
+		ipRulesMutex.Unlock()
+		http.Error(w, "Rule would lock you out of the admin API", http.StatusConflict)
+		return
+	}
+	ipRules = updated
+	ipRulesMutex.Unlock()
+	slog.Info("IP rule set", "group", group, "allow", rule.Allow, "deny", rule.Deny)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func handleIPRuleDelete(w http.ResponseWriter, r *http.Request) {
+	group := r.PathValue("group")
+	ipRulesMutex.Lock()
+	_, ok := ipRules[group]
+	delete(ipRules, group)
+	ipRulesMutex.Unlock()
+	if !ok {
+		http.Error(w, "No rule for that route group", http.StatusNotFound)
+		return
+	}
+	slog.Info("IP rule removed", "group", group)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	maxJSONBodyBytes = 1 << 20 // request bodies larger than this are rejected
+	maxJSONDepth     = 32      // maximum nesting of objects and arrays
+	maxJSONNumberLen = 32      // longer number literals are rejected as absurd
+)
+
+// Compatibility modes decide what happens to unknown JSON fields: strict
+// rejects the request, lenient ignores them. Either way they are listed in the
+// X-Unknown-Fields response header.
+const (
+	CompatStrict  = "strict"
+	CompatLenient = "lenient"
+)
+
+// compatibilityMode is the default for keys without their own setting
+var compatibilityMode = CompatStrict
+
+func validCompatibility(mode string) bool {
+	return mode == CompatStrict || mode == CompatLenient
+}
+
+// compatibilityFor returns the mode for a request: the key's own setting, if
+// any, else the global one
+func compatibilityFor(r *http.Request) string {
+	if key := keyFromContext(r.Context()); key != nil && key.Compatibility != "" {
+		return key.Compatibility
+	}
+	return compatibilityMode
+}
+
+// decodeJSON reads exactly one JSON value from the request body into v. It
+// rejects oversized or deeply nested bodies, absurdly long numbers and
+// trailing data, so a hostile payload can't panic or stall a handler. Unknown
+// fields are handled according to the request's compatibility mode.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("body exceeds %d bytes", maxJSONBodyBytes)
+		}
+		return err
+	}
+	if err := checkJSONShape(data); err != nil {
+		return err
+	}
+	if err := validateJSON(data, v); err != nil {
+		return err
+	}
+
+	if unknown := unknownFields(data, v); len(unknown) > 0 {
+		w.Header().Set("X-Unknown-Fields", strings.Join(unknown, ", "))
+		if compatibilityFor(r) == CompatStrict {
+			return fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	noteDeprecatedFields(w, r, data, v)
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// checkJSONShape scans the raw body for nesting deeper than maxJSONDepth and
+// number literals longer than maxJSONNumberLen, before any decoding happens.
+// NaN and Infinity are not valid JSON, so the decoder itself rejects them.
+func checkJSONShape(data []byte) error {
+	depth, numberLen := 0, 0
+	inString, escaped := false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		if (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E' {
+			numberLen++
+			if numberLen > maxJSONNumberLen {
+				return fmt.Errorf("number longer than %d characters", maxJSONNumberLen)
+			}
+			continue
+		}
+		numberLen = 0
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxJSONDepth {
+				return fmt.Errorf("nesting deeper than %d levels", maxJSONDepth)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// unknownFields lists the top-level keys of a JSON object that don't match a
+// field of the struct v points to, matching names case-insensitively like
+// encoding/json does
+func unknownFields(data []byte, v interface{}) []string {
+	target := reflect.TypeOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	var object map[string]json.RawMessage
+	if json.Unmarshal(data, &object) != nil {
+		return nil
+	}
+
+	known := map[string]bool{}
+	collectJSONFields(target.Elem(), known)
+	var unknown []string
+	for name := range object {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func collectJSONFields(t reflect.Type, known map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			collectJSONFields(field.Type, known)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		known[strings.ToLower(name)] = true
+	}
+}
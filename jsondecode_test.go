@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func FuzzDecodeJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{"name":"Ada","age":20,"email":"ada@example.com"}`,
+		`{"name":"Ada","age":20,"email":"ada@example.com","tags":["a"],"attributes":{"house":{"colour":"red"}}}`,
+		`{"name":"Ada","nickname":"ada"}`,
+		`{"name":"Ada"} {"name":"Grace"}`,
+		`{"age":1e999999999999999999999999999999999}`,
+		`{"age":NaN}`,
+		`{"name":"\"}{[","age":-0.5e-3}`,
+		`{"attributes":` + strings.Repeat("[", 40) + strings.Repeat("]", 40) + `}`,
+		`"\ud800"`,
+		`[]`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := httptest.NewRequest("POST", "/students", strings.NewReader(string(data)))
+		var student Student
+		if err := decodeJSON(httptest.NewRecorder(), r, &student); err != nil {
+			return
+		}
+		if !json.Valid(data) {
+			t.Fatalf("accepted invalid JSON %q", data)
+		}
+		if err := checkJSONShape(data); err != nil {
+			t.Fatalf("accepted %q despite %v", data, err)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// The roster list is the hottest endpoint and encoding/json's reflection
+// dominated its CPU profile. Students are encoded by hand instead, into
+// pooled buffers, producing exactly the bytes json.Marshal would. appendJSON
+// must be kept in step with the Student struct.
+
+const maxPooledJSONBuffer = 1 << 20 // bigger buffers are left to the GC
+
+var jsonBuffers = sync.Pool{New: func() interface{} {
+	buffer := make([]byte, 0, 16<<10)
+	return &buffer
+}}
+
+// appendJSON appends the student as json.Marshal encodes it
+func (s Student) appendJSON(dst []byte) []byte {
+	dst = append(dst, `{"id":`...)
+	dst = strconv.AppendInt(dst, s.ID, 10)
+	if s.UUID != "" {
+		dst = append(dst, `,"uuid":`...)
+		dst = appendJSONString(dst, s.UUID)
+	}
+	dst = append(dst, `,"name":`...)
+	dst = appendJSONString(dst, s.Name)
+	dst = append(dst, `,"age":`...)
+	dst = strconv.AppendInt(dst, int64(s.Age), 10)
+	dst = append(dst, `,"email":`...)
+	dst = appendJSONString(dst, s.Email)
+	if s.Phone != "" {
+		dst = append(dst, `,"phone":`...)
+		dst = appendJSONString(dst, s.Phone)
+	}
+	if s.PhoneE164 != "" {
+		dst = append(dst, `,"phone_e164":`...)
+		dst = appendJSONString(dst, s.PhoneE164)
+	}
+	if s.Address != "" {
+		dst = append(dst, `,"address":`...)
+		dst = appendJSONString(dst, s.Address)
+	}
+	if s.Location != nil {
+		dst = append(dst, `,"location":{"lat":`...)
+		dst = appendJSONFloat(dst, s.Location.Lat)
+		dst = append(dst, `,"lng":`...)
+		dst = appendJSONFloat(dst, s.Location.Lng)
+		dst = append(dst, '}')
+	}
+	if s.BirthDate != "" {
+		dst = append(dst, `,"birth_date":`...)
+		dst = appendJSONString(dst, s.BirthDate)
+	}
+	if s.EnrolledOn != "" {
+		dst = append(dst, `,"enrolled_on":`...)
+		dst = appendJSONString(dst, s.EnrolledOn)
+	}
+	if s.LegalHold {
+		dst = append(dst, `,"legal_hold":true`...)
+	}
+	if s.Tenant != "" {
+		dst = append(dst, `,"tenant":`...)
+		dst = appendJSONString(dst, s.Tenant)
+	}
+	if len(s.Tags) > 0 {
+		dst = append(dst, `,"tags":[`...)
+		for i, tag := range s.Tags {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, tag)
+		}
+		dst = append(dst, ']')
+	}
+	if len(s.Attributes) > 0 {
+		// rare and free-form, so left to encoding/json
+		if attributes, err := json.Marshal(s.Attributes); err == nil {
+			dst = append(dst, `,"attributes":`...)
+			dst = append(dst, attributes...)
+		}
+	}
+	return append(dst, '}')
+}
+
+// appendStudentsJSON appends a roster as json.Marshal encodes it, null
+// included
+func appendStudentsJSON(dst []byte, roster []Student) []byte {
+	if roster == nil {
+		return append(dst, "null"...)
+	}
+	dst = append(dst, '[')
+	for i, student := range roster {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = student.appendJSON(dst)
+	}
+	return append(dst, ']')
+}
+
+// writeStudentsJSON writes a roster as the JSON response
+func writeStudentsJSON(w http.ResponseWriter, roster []Student) {
+	if len(roster) > streamStudentsThreshold {
+		streamStudentsJSON(w, roster)
+		return
+	}
+	buffer := jsonBuffers.Get().(*[]byte)
+	data := appendStudentsJSON((*buffer)[:0], roster)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+	if cap(data) <= maxPooledJSONBuffer {
+		*buffer = data
+		jsonBuffers.Put(buffer)
+	}
+}
+
+const (
+	streamStudentsThreshold = 1000     // longer lists are streamed
+	streamChunkBytes        = 64 << 10 // written and flushed at a time
+)
+
+// streamStudentsJSON writes a long list in chunks, flushing each one, so
+// clients start receiving it before it is all encoded and the whole body is
+// never held in memory. The first chunk goes out after the first student.
+func streamStudentsJSON(w http.ResponseWriter, roster []Student) {
+	w.Header().Set("Content-Type", "application/json")
+	controller := http.NewResponseController(w)
+	buffer := jsonBuffers.Get().(*[]byte)
+	chunk := append((*buffer)[:0], '[')
+	for i, student := range roster {
+		if i > 0 {
+			chunk = append(chunk, ',')
+		}
+		chunk = student.appendJSON(chunk)
+		if i == 0 || len(chunk) >= streamChunkBytes {
+			if _, err := w.Write(chunk); err != nil {
+				return // the client went away
+			}
+			controller.Flush()
+			chunk = chunk[:0]
+		}
+	}
+	chunk = append(chunk, ']')
+	w.Write(chunk)
+	*buffer = chunk
+	jsonBuffers.Put(buffer)
+}
+
+// appendJSONFloat formats f as encoding/json does: exponent notation for
+// very small and very large magnitudes, with the exponent's leading zero
+// dropped (1e-07 becomes 1e-7)
+func appendJSONFloat(dst []byte, f float64) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		if n := len(dst); n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+// appendJSONString quotes s the way encoding/json does by default: HTML
+// characters, U+2028 and U+2029 are escaped and invalid UTF-8 becomes U+FFFD
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+var jsonTestStudents = []Student{
+	{},
+	{ID: 1, Name: "Ada Lovelace", Age: 36, Email: "ada@example.com"},
+	{ID: 2, UUID: "0190c4f6-7a1e-7c3b-9a4d-1f2e3d4c5b6a", Name: "Grace", Age: 85, Email: "grace@example.com",
+		Phone: "+1 (555) 010-0000", PhoneE164: "+15550100000", Address: "1 Main St", Location: &GeoPoint{Lat: 51.5072, Lng: -0.1276},
+		BirthDate: "1906-12-09", EnrolledOn: "2024-09-01", LegalHold: true, Tenant: "acme",
+		Tags: []string{"navy", "cobol"}, Attributes: map[string]interface{}{"house": "Hopper", "year": 3.0}},
+	{ID: 3, Name: "phone only", Phone: "555"},
+	{ID: 4, Name: "e164 only", PhoneE164: "+15550100000"},
+	{ID: -5, Name: `quote " backslash \ slash /`, Email: "tab\tnewline\ncr\rbs\bff\f"},
+	{ID: 6, Name: "<script>alert('x')</script> & co", Email: "\x00\x01\x1f\x7f"},
+	{ID: 7, Name: "line\u2028para\u2029sep", Email: "héllo wörld 日本語 🎓"},
+	{ID: 8, Name: "bad \xff utf-8 \xc3", Email: "\xed\xa0\x80"},
+	{ID: 9, Name: "tiny", Location: &GeoPoint{Lat: 1e-7, Lng: -0.000001}},
+	{ID: 10, Name: "huge", Location: &GeoPoint{Lat: 1e21, Lng: -1.5e300}},
+	{ID: 11, Name: "zero", Location: &GeoPoint{}},
+	{ID: 12, Name: "subnormal", Location: &GeoPoint{Lat: 5e-324, Lng: 123456789012345678}},
+	{ID: 13, Name: "empty tags", Tags: []string{}, Attributes: map[string]interface{}{}},
+	{ID: 9007199254740993, Name: "big id", Age: -1, Tags: []string{""}},
+}
+
+func TestAppendJSONMatchesMarshal(t *testing.T) {
+	for i, student := range jsonTestStudents {
+		want, err := json.Marshal(student)
+		if err != nil {
+			t.Fatalf("student %d: %v", i, err)
+		}
+		if got := student.appendJSON(nil); string(got) != string(want) {
+			t.Errorf("student %d:\n got %s\nwant %s", i, got, want)
+		}
+	}
+}
+
+func TestAppendStudentsJSONMatchesMarshal(t *testing.T) {
+	for _, roster := range [][]Student{nil, {}, jsonTestStudents} {
+		want, _ := json.Marshal(roster)
+		if got := appendStudentsJSON(nil, roster); string(got) != string(want) {
+			t.Errorf("roster of %d:\n got %s\nwant %s", len(roster), got, want)
+		}
+	}
+}
+
+func TestAppendJSONFloatMatchesMarshal(t *testing.T) {
+	for _, f := range []float64{0, 1, -1, 0.1, 1e-6, 9.99e-7, 1e-7, 1e-10, 1e-100, 1e20, 1e21, 1.5e21, 1e100, 5e-324, 1.7976931348623157e308} {
+		for _, f := range []float64{f, -f} {
+			want, _ := json.Marshal(f)
+			if got := appendJSONFloat(nil, f); string(got) != string(want) {
+				t.Errorf("%v: got %s, want %s", f, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkStudentsJSON(b *testing.B) {
+	roster := make([]Student, 100)
+	for i := range roster {
+		roster[i] = Student{ID: int64(i + 1), Name: fmt.Sprintf("Student %d", i), Age: 20, Email: fmt.Sprintf("s%d@example.com", i),
+			EnrolledOn: "2024-09-01", Tags: []string{"first-year"}}
+	}
+	b.Run("appendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		var buffer []byte
+		for i := 0; i < b.N; i++ {
+			buffer = appendStudentsJSON(buffer[:0], roster)
+		}
+	})
+	b.Run("json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(roster)
+		}
+	})
+}
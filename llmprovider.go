@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultOllamaURL = "http://localhost:11434"
+
+// LLMRequest is a prompt for a SummaryProvider
+type LLMRequest struct {
+	Prompt string
+	// Canned is what the mock provider answers instead: a deterministic
+	// stand-in for what the model would write
+	Canned string
+}
+
+// LLMResult is what a provider wrote and the tokens it used, prompt and
+// completion together, for metering
+type LLMResult struct {
+	Text   string
+	Tokens int
+}
+
+// SummaryProvider is the model behind summaries, reports and anomaly
+// explanations. Providers are called with the LLM call already reserved, and
+// stop generating when ctx ends.
+type SummaryProvider interface {
+	Name() string
+	Generate(ctx context.Context, request LLMRequest) (LLMResult, error)
+	// Stream is Generate handing each piece of the text to onToken as the
+	// model writes it
+	Stream(ctx context.Context, request LLMRequest, onToken func(string)) (LLMResult, error)
+	// Models lists the models the provider serves, which costs no quota
+	Models(ctx context.Context, client *http.Client) ([]string, error)
+}
+
+var summaryProvider SummaryProvider = ollamaProvider{baseURL: defaultOllamaURL}
+
+// openSummaryProvider opens the provider named by spec: ollama, optionally
+// with its URL, openai with the base URL of an OpenAI-compatible API such as
+// vLLM or LM Studio, or mock
+func openSummaryProvider(spec string) (SummaryProvider, error) {
+	kind, location, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "ollama":
+		if location == "" {
+			location = defaultOllamaURL
+		}
+		return ollamaProvider{baseURL: strings.TrimSuffix(location, "/")}, nil
+	case "openai":
+		if location == "" {
+			return nil, fmt.Errorf("the openai provider needs the API's base URL, e.g. openai:http://localhost:1234/v1")
+		}
+		return openAIProvider{baseURL: strings.TrimSuffix(location, "/")}, nil
+	case "mock":
+		return mockProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (supported: ollama, openai, mock)", kind)
+	}
+}
+
+// configureSummaryProvider opens the -llm-provider spec. -mock-llm is
+// shorthand for mock.
+func configureSummaryProvider(spec string, mock bool) error {
+	if mock {
+		if spec != "" && spec != "mock" {
+			return fmt.Errorf("-mock-llm and -llm-provider %s cannot be used together", spec)
+		}
+		spec = "mock"
+	}
+	provider, err := openSummaryProvider(spec)
+	if err != nil {
+		return err
+	}
+	summaryProvider = provider
+	return nil
+}
+
+// llmGenerate runs a request through the provider, metering the tokens to the
+// tenant. Callers reserve the LLM call first. When ctx ends the provider's
+// request is cancelled, which stops the generation, and ctx's error returned.
+func llmGenerate(ctx context.Context, request LLMRequest, tenant string) (string, error) {
+	result, err := summaryProvider.Generate(ctx, request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	meterLLMTokens(tenant, result.Tokens)
+	return result.Text, nil
+}
+
+// llmStream is llmGenerate with each token handed to onToken. The tokens are
+// metered when the generation finishes, so a cancelled stream meters none.
+func llmStream(ctx context.Context, request LLMRequest, tenant string, onToken func(string)) (string, error) {
+	result, err := summaryProvider.Stream(ctx, request, onToken)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	meterLLMTokens(tenant, result.Tokens)
+	return result.Text, nil
+}
+
+// postLLM sends a JSON request to a provider's API with llmClient, so it can
+// be recorded and replayed, returning the response if its status is 200
+func postLLM(ctx context.Context, url, apiName string, body interface{}, header http.Header) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := llmClient.Do(request)
+	if err != nil {
+		return nil, wrapError(ErrLLMUnavailable, fmt.Errorf("failed to call %s: %v", apiName, err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, wrapError(ErrLLMUnavailable, fmt.Errorf("%s returned status: %d", apiName, resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// getLLMModels fetches a provider's model list into list
+func getLLMModels(ctx context.Context, client *http.Client, url, apiName string, header http.Header, list interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status: %d", apiName, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
+
+type OllamaRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Stream bool   `json:"stream"`
+}
+
+type OllamaResponse struct {
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`              // last chunk of a stream
+	PromptEvalCount int    `json:"prompt_eval_count"` // prompt tokens
+	EvalCount       int    `json:"eval_count"`        // completion tokens
+}
+
+// ollamaProvider calls Ollama's generate API
+type ollamaProvider struct {
+	baseURL string
+}
+
+func (p ollamaProvider) Name() string { return "ollama" }
+
+func (p ollamaProvider) Generate(ctx context.Context, request LLMRequest) (LLMResult, error) {
+	resp, err := postLLM(ctx, p.baseURL+"/api/generate", "Ollama API",
+		OllamaRequest{Model: llmModel(), Prompt: request.Prompt, Stream: false}, nil)
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var ollamaResp OllamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return LLMResult{}, wrapError(ErrLLMUnavailable, err)
+	}
+	return LLMResult{Text: ollamaResp.Response, Tokens: ollamaResp.PromptEvalCount + ollamaResp.EvalCount}, nil
+}
+
+// Stream reads Ollama's streamed response, one JSON object per chunk
+func (p ollamaProvider) Stream(ctx context.Context, request LLMRequest, onToken func(string)) (LLMResult, error) {
+	resp, err := postLLM(ctx, p.baseURL+"/api/generate", "Ollama API",
+		OllamaRequest{Model: llmModel(), Prompt: request.Prompt, Stream: true}, nil)
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			return LLMResult{}, wrapError(ErrLLMUnavailable, fmt.Errorf("Ollama stream ended early: %v", err))
+		}
+		if chunk.Response != "" {
+			text.WriteString(chunk.Response)
+			onToken(chunk.Response)
+		}
+		if chunk.Done {
+			return LLMResult{Text: text.String(), Tokens: chunk.PromptEvalCount + chunk.EvalCount}, nil
+		}
+	}
+}
+
+// Models lists the models Ollama has pulled, tagged like llama3.2:latest
+func (p ollamaProvider) Models(ctx context.Context, client *http.Client) ([]string, error) {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getLLMModels(ctx, client, p.baseURL+"/api/tags", "Ollama", nil, &tags); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(tags.Models))
+	for i, model := range tags.Models {
+		names[i] = model.Name
+	}
+	return names, nil
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	TotalTokens int `json:"total_tokens"`
+}
+
+// openAIChatResponse is a chat completion, or with Delta, a chunk of a
+// streamed one
+type openAIChatResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+// openAIProvider calls the chat completions API that vLLM, LM Studio and
+// other servers offer in OpenAI's shape. The API key, if the server wants
+// one, is the LLM_API_KEY secret.
+type openAIProvider struct {
+	baseURL string
+}
+
+func (p openAIProvider) Name() string { return "openai" }
+
+func (p openAIProvider) header() http.Header {
+	if key := getSecret("LLM_API_KEY"); key != "" {
+		return http.Header{"Authorization": {"Bearer " + key}}
+	}
+	return nil
+}
+
+func (p openAIProvider) chatRequest(request LLMRequest, stream bool) openAIChatRequest {
+	chat := openAIChatRequest{
+		Model:    llmModel(),
+		Messages: []openAIMessage{{Role: "user", Content: request.Prompt}},
+		Stream:   stream,
+	}
+	if stream {
+		// ask for the token counts in a last chunk
+		chat.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	return chat
+}
+
+func (p openAIProvider) Generate(ctx context.Context, request LLMRequest) (LLMResult, error) {
+	resp, err := postLLM(ctx, p.baseURL+"/chat/completions", "OpenAI-compatible API", p.chatRequest(request, false), p.header())
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var chat openAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+		return LLMResult{}, wrapError(ErrLLMUnavailable, err)
+	}
+	if len(chat.Choices) == 0 {
+		return LLMResult{}, newError(ErrLLMUnavailable, "OpenAI-compatible API returned no choices")
+	}
+	result := LLMResult{Text: chat.Choices[0].Message.Content}
+	if chat.Usage != nil {
+		result.Tokens = chat.Usage.TotalTokens
+	}
+	return result, nil
+}
+
+// Stream reads the server-sent events of a streamed completion, which end
+// with a [DONE] event. Servers that don't report usage meter no tokens.
+func (p openAIProvider) Stream(ctx context.Context, request LLMRequest, onToken func(string)) (LLMResult, error) {
+	resp, err := postLLM(ctx, p.baseURL+"/chat/completions", "OpenAI-compatible API", p.chatRequest(request, true), p.header())
+	if err != nil {
+		return LLMResult{}, err
+	}
+	defer resp.Body.Close()
+
+	var result LLMResult
+	var text strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			result.Text = text.String()
+			return result, nil
+		}
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return LLMResult{}, wrapError(ErrLLMUnavailable, fmt.Errorf("invalid OpenAI-compatible stream chunk: %v", err))
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			text.WriteString(chunk.Choices[0].Delta.Content)
+			onToken(chunk.Choices[0].Delta.Content)
+		}
+		if chunk.Usage != nil {
+			result.Tokens = chunk.Usage.TotalTokens
+		}
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return LLMResult{}, wrapError(ErrLLMUnavailable, fmt.Errorf("OpenAI-compatible stream ended early: %v", err))
+}
+
+func (p openAIProvider) Models(ctx context.Context, client *http.Client) ([]string, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getLLMModels(ctx, client, p.baseURL+"/models", "OpenAI-compatible API", p.header(), &list); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(list.Data))
+	for i, model := range list.Data {
+		ids[i] = model.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// llmClient is used for every call to the LLM provider. With -llm-record or
+// -llm-replay its transport captures or serves request/response pairs from
+// disk.
+var llmClient = &http.Client{}
+
+// Interaction is one recorded LLM request and its response, stored as
+// <dir>/<sha256 of method, URL and request body>.json
+type Interaction struct {
+	Request struct {
+		Method string          `json:"method"`
+		URL    string          `json:"url"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"request"`
+	Response struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+func interactionKey(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.String() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func rawJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
+
+// recordingTransport forwards requests upstream and writes each successful
+// exchange to dir
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	var interaction Interaction
+	interaction.Request.Method = req.Method
+	interaction.Request.URL = req.URL.String()
+	interaction.Request.Body = rawJSON(body)
+	interaction.Response.Status = resp.StatusCode
+	interaction.Response.Body = rawJSON(respBody)
+	interaction.RecordedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.dir, interactionKey(req, body)+".json"), data, 0o644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record LLM interaction: %v", err)
+	}
+	return resp, nil
+}
+
+// replayTransport answers requests from recordings in dir and never contacts
+// the upstream. A request with no recording is an error, so tests fail loudly
+// when a prompt changes.
+type replayTransport struct {
+	dir string
+}
+
+func (t replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := interactionKey(req, body)
+	data, err := os.ReadFile(filepath.Join(t.dir, key+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no recorded LLM interaction %s for this request", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var interaction Interaction
+	if err := json.Unmarshal(data, &interaction); err != nil {
+		return nil, fmt.Errorf("corrupt LLM recording %s: %v", key, err)
+	}
+
+	respBody := []byte(interaction.Response.Body)
+	var text string
+	if json.Unmarshal(respBody, &text) == nil {
+		respBody = []byte(text)
+	}
+	return &http.Response{
+		StatusCode:    interaction.Response.Status,
+		Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// configureLLMRecording sets up record or replay mode. At most one of the
+// directories may be given.
+func configureLLMRecording(recordDir, replayDir string) error {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return errors.New("-llm-record and -llm-replay cannot be used together")
+	case recordDir != "":
+		if err := os.MkdirAll(recordDir, 0o755); err != nil {
+			return err
+		}
+		llmClient.Transport = recordingTransport{dir: recordDir, next: http.DefaultTransport}
+		slog.Info("Recording LLM interactions", "dir", recordDir)
+	case replayDir != "":
+		if _, err := os.Stat(replayDir); err != nil {
+			return err
+		}
+		llmClient.Transport = replayTransport{dir: replayDir}
+		slog.Info("Replaying recorded LLM interactions", "dir", replayDir)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// loadShedder rejects low-priority requests while the heap or goroutine count
+// is above its threshold. Zero thresholds disable that check.
+var loadShedder = struct {
+	maxHeapMB     int
+	maxGoroutines int
+	active        atomic.Bool
+	activations   atomic.Int64
+	shedRequests  atomic.Int64
+}{}
+
+// monitorLoad samples the runtime every interval and switches shedding on and
+// off, logging each transition
+func monitorLoad(interval time.Duration) {
+	for range time.Tick(interval) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		heapMB := int(mem.HeapAlloc >> 20)
+		goroutines := runtime.NumGoroutine()
+
+		overloaded := (loadShedder.maxHeapMB > 0 && heapMB >= loadShedder.maxHeapMB) ||
+			(loadShedder.maxGoroutines > 0 && goroutines >= loadShedder.maxGoroutines)
+		if overloaded == loadShedder.active.Load() {
+			continue
+		}
+		loadShedder.active.Store(overloaded)
+		if overloaded {
+			loadShedder.activations.Add(1)
+			slog.Warn("Load shedding activated", "heap_mb", heapMB, "goroutines", goroutines,
+				"max_heap_mb", loadShedder.maxHeapMB, "max_goroutines", loadShedder.maxGoroutines)
+		} else {
+			slog.Info("Load shedding deactivated", "heap_mb", heapMB, "goroutines", goroutines,
+				"shed_requests", loadShedder.shedRequests.Load())
+		}
+	}
+}
+
+// shedRequest answers a request to a Shed route with 503, and reports true,
+// while the process is under pressure
+func shedRequest(w http.ResponseWriter) bool {
+	if !loadShedder.active.Load() {
+		return false
+	}
+	loadShedder.shedRequests.Add(1)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "30")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   "overloaded",
+		"message": "The server is under heavy load; exports, imports, reports and summaries are temporarily unavailable.",
+	})
+	return true
+}
+
+func loadSheddingStats() map[string]interface{} {
+	return map[string]interface{}{
+		"active":         loadShedder.active.Load(),
+		"activations":    loadShedder.activations.Load(),
+		"shed_requests":  loadShedder.shedRequests.Load(),
+		"max_heap_mb":    loadShedder.maxHeapMB,
+		"max_goroutines": loadShedder.maxGoroutines,
+	}
+}
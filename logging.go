@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevel can be changed at runtime via PUT /admin/loglevel or a config reload
+var logLevel = new(slog.LevelVar)
+
+func initLogging() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+}
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(value))); err != nil {
+		return 0, fmt.Errorf("unknown log level: %s (must be debug, info, warn or error)", value)
+	}
+	return level, nil
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests logs every request at debug level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		slog.Debug("request", "method", r.Method, "path", r.URL.Path, "status", recorder.status,
+			"duration", time.Since(start), "remote", r.RemoteAddr)
+	})
+}
+
+func handleLogLevelGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"level": strings.ToLower(logLevel.Level().String())})
+}
+
+// LogLevelRequest is the body of PUT /admin/loglevel
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func handleLogLevelSet(w http.ResponseWriter, r *http.Request) {
+	var input LogLevelRequest
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &input); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		input.Level = r.FormValue("level")
+	}
+
+	level, err := parseLogLevel(input.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logLevel.Set(level)
+	slog.Info("Log level changed", "level", level)
+
+	handleLogLevelGet(w, r)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+)
+
+var (
+	smtpAddr string // host:port of the SMTP relay; empty logs messages instead
+	mailFrom string
+)
+
+// sendMail delivers a plain-text email through the SMTP relay, authenticating
+// with the SMTP_USERNAME and SMTP_PASSWORD secrets when set. Without a relay
+// the message is logged, which is enough for local development.
+func sendMail(to, subject, body string) error {
+	if smtpAddr == "" {
+		slog.Info("No SMTP server configured; email not sent", "to", to, "subject", subject, "body", body)
+	}
+	return deliverMail(to, subject, "Content-Type: text/plain; charset=utf-8\r\n\r\n"+body)
+}
+
+// mailAttachment is a file sent along with an email
+type mailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// sendMailWithAttachment is sendMail with one file attached
+func sendMailWithAttachment(to, subject, body string, attachment mailAttachment) error {
+	if strings.ContainsAny(attachment.Name+attachment.ContentType, "\r\n\"") {
+		return fmt.Errorf("invalid attachment header")
+	}
+	if smtpAddr == "" {
+		slog.Info("No SMTP server configured; email not sent", "to", to, "subject", subject, "body", body,
+			"attachment", attachment.Name, "size", len(attachment.Data))
+	}
+
+	var message bytes.Buffer
+	parts := multipart.NewWriter(&message)
+	text, _ := parts.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	io.WriteString(text, body)
+	file, _ := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {attachment.ContentType},
+		"Content-Disposition":       {`attachment; filename="` + attachment.Name + `"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		io.WriteString(file, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(file, encoded)
+	parts.Close()
+	return deliverMail(to, subject, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary="+parts.Boundary()+"\r\n\r\n"+message.String())
+}
+
+// deliverMail sends a message whose content headers and body are already
+// formatted
+func deliverMail(to, subject, content string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+	if smtpAddr == "" {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if username := getSecret("SMTP_USERNAME"); username != "" {
+		host, _, _ := net.SplitHostPort(smtpAddr)
+		auth = smtp.PlainAuth("", username, getSecret("SMTP_PASSWORD"), host)
+	}
+	message := "From: " + mailFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		content
+	if err := smtp.SendMail(smtpAddr, auth, mailFrom, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
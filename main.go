@@ -1,29 +1,48 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/behalnihal/fealtyx/validation"
 )
 
+// Student is encoded by hand on hot paths; see appendJSON in jsonfast.go
 type Student struct {
-	ID    int    `json:"id"`
+	ID    int64  `json:"id"`
+	UUID  string `json:"uuid,omitempty"` // a UUIDv7; paths accept it as well as ID
 	Name  string `json:"name"`
 	Age   int    `json:"age"`
 	Email string `json:"email"`
-}
-
-type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
-}
+	// Phone is as entered; PhoneE164 is set from it on every write
+	Phone     string `json:"phone,omitempty"`
+	PhoneE164 string `json:"phone_e164,omitempty"`
+	// Location is geocoded from Address when a geocoder is configured
+	Address  string    `json:"address,omitempty"`
+	Location *GeoPoint `json:"location,omitempty"`
+	// BirthDate and EnrolledOn are dates (YYYY-MM-DD). Updates that leave
+	// them out keep the stored ones.
+	BirthDate  string `json:"birth_date,omitempty"`
+	EnrolledOn string `json:"enrolled_on,omitempty"` // defaults to the day the student is created
 
-type OllamaResponse struct {
-	Response string `json:"response"`
+	// LegalHold blocks deletion. Only admins can change it; values sent to
+	// the regular create and update endpoints are ignored.
+	LegalHold bool `json:"legal_hold,omitempty"`
+	// Tenant is taken from the creating API key and never changes
+	Tenant string `json:"tenant,omitempty"`
+	// Tags are free-form labels, normalized by normalizeTags; see tags.go
+	Tags []string `json:"tags,omitempty"`
+	// Attributes hold the tenant's custom fields; see customfields.go
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
 var (
@@ -31,293 +50,390 @@ var (
 	mutex    sync.RWMutex
 )
 
-func handleStudents(w http.ResponseWriter, r *http.Request) {
-	mutex.RLock()
-	defer mutex.RUnlock()
-	
-	// Convert students to JSON
-	jsonData, err := json.Marshal(students)
-	if err != nil {
-		http.Error(w, "Error marshaling data", http.StatusInternalServerError)
-		return
+// validateStudent applies the validation package's rules, which every API
+// that accepts students shares
+func validateStudent(student Student) error {
+	fields := validation.Student{Name: student.Name, Age: student.Age, Email: student.Email,
+		BirthDate: student.BirthDate, EnrolledOn: student.EnrolledOn}
+	if student.Location != nil {
+		fields.HasLocation, fields.Lat, fields.Lng = true, student.Location.Lat, student.Location.Lng
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	return validation.ValidateStudent(fields, time.Now())
 }
 
-func validateStudent(student Student) error {
-	if student.Name == "" {
-		return fmt.Errorf("name is required")
+// beginSummary reserves the LLM quotas for summarizing a student
+func beginSummary(student Student) error {
+	if err := reserveLLMCall(); err != nil {
+		return err
 	}
-	if student.Age <= 0 || student.Age > 150 {
-		return fmt.Errorf("age must be between 1 and 150")
+	if err := reserveTenantLLMCall(student.Tenant); err != nil {
+		return err
 	}
-	if student.Email == "" {
-		return fmt.Errorf("email is required")
+	if simulateOllamaFailure() {
+		return errSimulatedOllamaFailure
 	}
 	return nil
 }
 
-func callOllamaAPI(student Student) (string, error) {
-	prompt := fmt.Sprintf("Generate a brief, friendly summary of this student: Name: %s, Age: %d, Email: %s. Keep it under 100 words. Don't include any other text like 'Here is the summary' or 'Here is the student' or 'Here is the student summary'. Just the summary.", 
-		student.Name, student.Age, student.Email)
-	
-	requestBody := OllamaRequest{
-		Model:  "llama3.2",
-		Prompt: prompt,
-		Stream: false,
-	}
-	
-	jsonData, err := json.Marshal(requestBody)
+// summaryPrompt asks for a summary of a student in the given language (a
+// key of languageNames)
+func summaryPrompt(student Student, locale string) (string, error) {
+	prompt, err := renderPrompt(student)
 	if err != nil {
 		return "", err
 	}
-	
-	resp, err := http.Post("http://localhost:11434/api/generate", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to call Ollama API: %v", err)
+	if tenant, ok := lookupTenant(student.Tenant); ok {
+		prompt += tenant.Branding.promptStyle()
 	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Ollama API returned status: %d", resp.StatusCode)
+	if locale != defaultLocale {
+		prompt += " Write the summary in " + languageNames[locale] + "."
 	}
-	
-	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+	return prompt, nil
+}
+
+// summarizeStudent summarizes a student in the given language (a key of
+// languageNames). The call is abandoned when ctx ends.
+func summarizeStudent(ctx context.Context, student Student, locale string) (string, error) {
+	if err := beginSummary(student); err != nil {
 		return "", err
 	}
-	
-	return ollamaResp.Response, nil
+	prompt, err := summaryPrompt(student, locale)
+	if err != nil {
+		return "", err
+	}
+	slog.Debug("Calling the LLM", "provider", summaryProvider.Name(), "student", student.ID, "prompt", prompt)
+	return llmGenerate(ctx, LLMRequest{Prompt: prompt, Canned: mockSummary(student)}, student.Tenant)
 }
 
-func enableCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// envInt reads an integer environment variable, used as a flag default
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envString reads a string environment variable, used as a flag default
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// corsHeaders are shared by every response rather than built for each
+var corsHeaders = http.Header{
+	"Access-Control-Allow-Origin":   {"*"},
+	"Access-Control-Allow-Methods":  {"GET, POST, PUT, PATCH, DELETE, OPTIONS"},
+	"Access-Control-Allow-Headers":  {"Content-Type, Authorization, X-API-Key, X-Response-Envelope, X-Response-Naming"},
+	"Access-Control-Expose-Headers": {"X-Unknown-Fields, X-Impersonated-By, X-Quota-Warning, Deprecation, Sunset"},
 }
 
+func enableCORS(w http.ResponseWriter) {
+	header := w.Header()
+	for name, values := range corsHeaders {
+		header[name] = values
+	}
+}
 
 func main() {
-	students = []Student{}
-	api := http.NewServeMux()
+	if len(os.Args) > 1 && os.Args[1] == "prompttest" {
+		initLogging()
+		if err := runPromptTest(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		initLogging()
+		if err := runFsck(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		initLogging()
+		if err := runBackup(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		initLogging()
+		if err := runRestore(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate-data" {
+		initLogging()
+		if err := runMigrateData(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Handle both GET and POST for /students
-	api.HandleFunc("/students", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w)
-		if r.Method == http.MethodGet {
-			handleStudents(w, r)
-		} else if r.Method == http.MethodPost {
-			var newStudent Student
-			
-			// Check if it's JSON request
-			if r.Header.Get("Content-Type") == "application/json" {
-				if err := json.NewDecoder(r.Body).Decode(&newStudent); err != nil {
-					http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-					return
-				}
-			} else {
-				// Handle form data
-				if err := r.ParseForm(); err != nil {
-					http.Error(w, "Invalid form data", http.StatusBadRequest)
-					return
-				}
-				
-				newStudent.Name = r.FormValue("name")
-				ageStr := r.FormValue("age")
-				if ageStr == "" {
-					http.Error(w, "Age is required", http.StatusBadRequest)
-					return
-				}
-				age, err := strconv.Atoi(ageStr)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Invalid age: %s (must be a number)", ageStr), http.StatusBadRequest)
-					return
-				}
-				newStudent.Age = age
-				newStudent.Email = r.FormValue("email")
-			}
-			
-			// Validate student data
-			if err := validateStudent(newStudent); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			
-			mutex.Lock()
-			newStudent.ID = len(students) + 1
-			students = append(students, newStudent)
-			mutex.Unlock()
-			
-			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(newStudent)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	walPath := flag.String("wal", os.Getenv("STUDENTS_WAL"), "path to the write-ahead log (empty keeps students in memory only)")
+	storeSpec := flag.String("store", envString("STUDENT_STORE", "memory"), "where students are kept: memory, wal:PATH, sqlite:PATH or a postgres:// URL")
+	walCompact := flag.Int("wal-compact", 1000, "snapshot and truncate the write-ahead log after this many entries")
+	flag.BoolVar(&eventSourced, "event-sourced", os.Getenv("EVENT_SOURCED") == "true", "keep every change in the write-ahead log as an event, never compacting it, and project the roster from them (needs -wal)")
+	flag.IntVar(&quotas.maxStudents, "max-students", envInt("MAX_STUDENTS", 0), "maximum number of students (0 is unlimited)")
+	flag.BoolVar(&numericIDPaths, "numeric-id-paths", os.Getenv("NUMERIC_ID_PATHS") != "false", "accept numeric IDs as well as UUIDs in /students/{id} paths")
+	flag.IntVar(&importWorkers, "import-workers", envInt("IMPORT_WORKERS", 4), "rows of CSV imports written to the store at once")
+	flag.IntVar(&quotas.maxLLMCallsPerDay, "max-llm-calls", envInt("MAX_LLM_CALLS_PER_DAY", 0), "maximum LLM calls per UTC day (0 is unlimited)")
+	flag.IntVar(&quotaWarnPercent, "quota-warn-percent", envInt("QUOTA_WARN_PERCENT", 80), "warn in responses once a quota is this full (0 disables)")
+	flag.BoolVar(&requireAPIKey, "require-api-key", os.Getenv("REQUIRE_API_KEY") == "true", "reject requests without an API key")
+	flag.BoolVar(&maintenance.Enabled, "maintenance", os.Getenv("MAINTENANCE_MODE") == "true", "start in maintenance mode")
+	flag.BoolVar(&maintenance.AllowReads, "maintenance-allow-reads", os.Getenv("MAINTENANCE_ALLOW_READS") == "true", "keep serving GET requests during maintenance")
+	flag.StringVar(&featureFlagsPath, "flags", os.Getenv("FEATURE_FLAGS_FILE"), "path to the feature flags JSON file")
+	flag.StringVar(&configPath, "config", os.Getenv("CONFIG_FILE"), "path to the runtime config JSON file")
+	debugAddr := flag.String("debug-addr", os.Getenv("DEBUG_ADDR"), "internal address serving pprof and diagnostics without auth, e.g. localhost:6060")
+	flag.BoolVar(&chaosEnabled, "chaos", false, "enable fault injection (development only)")
+	chaosLatency := flag.Duration("chaos-latency", 0, "latency added to every request when -chaos is set")
+	chaosJitter := flag.Duration("chaos-jitter", 0, "random extra latency up to this much when -chaos is set")
+	flag.Float64Var(&chaos.ErrorRate, "chaos-error-rate", 0, "fraction of requests failed with 503 when -chaos is set")
+	flag.Float64Var(&chaos.OllamaFailureRate, "chaos-ollama-failure-rate", 0, "fraction of Ollama calls failed when -chaos is set")
+	llmProvider := flag.String("llm-provider", os.Getenv("LLM_PROVIDER"), "model behind summaries: ollama[:URL], openai:URL for an OpenAI-compatible API such as vLLM or LM Studio, or mock (default ollama)")
+	mockLLM := flag.Bool("mock-llm", os.Getenv("MOCK_LLM") == "true", "return canned summaries instead of calling a model; shorthand for -llm-provider mock")
+	llmRecordDir := flag.String("llm-record", os.Getenv("LLM_RECORD_DIR"), "record every LLM request and response into this directory")
+	llmReplayDir := flag.String("llm-replay", os.Getenv("LLM_REPLAY_DIR"), "answer LLM requests from recordings in this directory")
+	flag.IntVar(&loadShedder.maxHeapMB, "shed-heap-mb", envInt("SHED_HEAP_MB", 0), "shed low-priority requests while the heap is at least this many MiB (0 disables)")
+	flag.IntVar(&loadShedder.maxGoroutines, "shed-goroutines", envInt("SHED_GOROUTINES", 0), "shed low-priority requests while at least this many goroutines run (0 disables)")
+	flag.DurationVar(&slowThreshold, "slow-threshold", time.Second, "log requests slower than this and keep them in /admin/slowlog (0 disables)")
+	logBodyRoutes := flag.String("log-bodies", os.Getenv("LOG_BODIES"), "comma-separated path prefixes whose redacted request and response bodies are logged, e.g. /students")
+	flag.StringVar(&compatibilityMode, "compat", envString("API_COMPAT_MODE", CompatStrict), "JSON compatibility mode: strict rejects unknown fields, lenient ignores them")
+	flag.StringVar(&responseEnvelope, "response-envelope", envString("RESPONSE_ENVELOPE", EnvelopeNone), "JSON response envelope: none sends the value itself, data wraps it as {\"data\": ..., \"meta\": {...}}")
+	flag.StringVar(&responseNaming, "response-naming", envString("RESPONSE_NAMING", NamingSnake), "JSON response member names: snake or camel")
+	flag.StringVar(&deletePolicy, "delete-policy", envString("DELETE_POLICY", DeleteBlock), "deleting a student with related records: block refuses with 409, cascade cleans them up")
+	flag.StringVar(&syncConflictPolicy, "sync-conflict-policy", envString("SYNC_CONFLICT_POLICY", PolicyServerWins), "how sync conflicts are resolved: last-write-wins, server-wins or manual")
+	shadowSpec := flag.String("shadow", os.Getenv("SHADOW_BACKEND"), "mirror writes to this storage backend and compare reads against it, e.g. jsonfile:/tmp/shadow.json (empty disables)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "on SIGTERM, how long to let requests finish and the store catch up before exiting")
+	backendCacheTTL := flag.Duration("backend-cache-ttl", 0, "cache student lookups from the roster for this long (0 disables)")
+	backendCacheSize := flag.Int("backend-cache-size", envInt("BACKEND_CACHE_SIZE", 10000), "most students kept in the student lookup cache")
+	cdcProxy := flag.String("cdc-rest-proxy", os.Getenv("CDC_REST_PROXY_URL"), "Kafka REST proxy URL to publish every change to (empty disables CDC)")
+	cdcTopicPrefix := flag.String("cdc-topic-prefix", envString("CDC_TOPIC_PREFIX", "fealtyx."), "prefix for the per-entity CDC topics")
+	flag.StringVar(&smtpAddr, "smtp-addr", os.Getenv("SMTP_ADDR"), "SMTP relay host:port for outgoing email (empty logs emails instead)")
+	flag.StringVar(&defaultPhoneRegion, "phone-region", os.Getenv("PHONE_REGION"), "region phone numbers without a country code are read in, e.g. US or IN (empty requires +)")
+	geocoderSpec := flag.String("geocoder", os.Getenv("GEOCODER"), "geocode student addresses with nominatim[:URL] or google (empty disables)")
+	flag.StringVar(&anomalySchedule, "anomaly-schedule", envString("ANOMALY_SCHEDULE", "0 2 * * *"), "cron schedule, in UTC, of the nightly roster anomaly check (empty disables it)")
+	anomalyEmail := flag.String("anomaly-email", os.Getenv("ANOMALY_EMAIL"), "comma-separated admin addresses emailed about roster anomalies")
+	flag.BoolVar(&anomalyExplain, "anomaly-explain", os.Getenv("ANOMALY_EXPLAIN") == "true", "ask the LLM to explain roster anomalies in the notification")
+	flag.StringVar(&mailFrom, "mail-from", envString("MAIL_FROM", "no-reply@localhost"), "sender address of outgoing email")
+	level := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	flag.Parse()
+
+	initLogging()
+	parsedLevel, err := parseLogLevel(*level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logLevel.Set(parsedLevel)
+	logBuildInfo()
+
+	if !validCompatibility(compatibilityMode) {
+		log.Fatalf("Invalid -compat %q: must be strict or lenient", compatibilityMode)
+	}
+	if !validEnvelope(responseEnvelope) {
+		log.Fatalf("Invalid -response-envelope %q: must be none or data", responseEnvelope)
+	}
+	if !validNaming(responseNaming) {
+		log.Fatalf("Invalid -response-naming %q: must be snake or camel", responseNaming)
+	}
+
+	if err := checkAPIChangelog(apiRoutes()); err != nil {
+		log.Fatalf("Invalid API changelog annotation: %v", err)
+	}
+	if quotaWarnPercent < 0 || quotaWarnPercent > 100 {
+		log.Fatalf("Invalid -quota-warn-percent %d: must be 0-100", quotaWarnPercent)
+	}
+	if location, ok := strings.CutPrefix(*storeSpec, "wal:"); ok {
+		if *walPath != "" && *walPath != location {
+			log.Fatalf("-store %s and -wal %s name different write-ahead logs", *storeSpec, *walPath)
+		}
+		*walPath, *storeSpec = location, "memory"
+	}
+	if err := checkEventSourcing(*walPath); err != nil {
+		log.Fatal(err)
+	}
+	if !validPhoneRegion(defaultPhoneRegion) {
+		log.Fatalf("Invalid -phone-region %q: must be a supported region such as US or IN", defaultPhoneRegion)
+	}
+	var anomalyCron cronSchedule
+	if anomalySchedule != "" {
+		if anomalyCron, err = parseCron(anomalySchedule); err != nil {
+			log.Fatalf("Invalid -anomaly-schedule %q: %v", anomalySchedule, err)
+		}
+	}
+	for _, address := range strings.Split(*anomalyEmail, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			anomalyEmails = append(anomalyEmails, address)
 		}
-	})
+	}
+	if !validDeletePolicy(deletePolicy) {
+		log.Fatalf("Invalid -delete-policy %q: must be block or cascade", deletePolicy)
+	}
 
+	if !validConflictPolicy(syncConflictPolicy) {
+		log.Fatalf("Invalid -sync-conflict-policy %q: must be last-write-wins, server-wins or manual", syncConflictPolicy)
+	}
 
-	// GET a specific student by ID
-	api.HandleFunc("/students/{id}", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w)
-		if r.Method == http.MethodGet {
-			id, err := strconv.Atoi(r.PathValue("id"))
-			if err != nil {
-				http.Error(w, "Invalid ID", http.StatusBadRequest)
-				return
-			}
-			
-			mutex.RLock()
-			defer mutex.RUnlock()
-			
-			for _, student := range students {
-				if student.ID == id {
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(student)
-					return
-				}
-			}
-			http.Error(w, "Student not found", http.StatusNotFound)
-		} else if r.Method == http.MethodPut {
-			// Update a specific student by ID
-			id, err := strconv.Atoi(r.PathValue("id"))
-			if err != nil {
-				http.Error(w, "Invalid ID", http.StatusBadRequest)
-				return
-			}
-			
-			var updatedStudent Student
-			updatedStudent.ID = id
-			
-			// Check if it's JSON request
-			if r.Header.Get("Content-Type") == "application/json" {
-				if err := json.NewDecoder(r.Body).Decode(&updatedStudent); err != nil {
-					http.Error(w, "Invalid JSON data", http.StatusBadRequest)
-					return
-				}
-				updatedStudent.ID = id // Ensure ID is set correctly
-			} else {
-				// Handle form data
-				if err := r.ParseForm(); err != nil {
-					http.Error(w, "Invalid form data", http.StatusBadRequest)
-					return
-				}
-				
-				updatedStudent.Name = r.FormValue("name")
-				ageStr := r.FormValue("age")
-				if ageStr == "" {
-					http.Error(w, "Age is required", http.StatusBadRequest)
-					return
-				}
-				age, err := strconv.Atoi(ageStr)
-				if err != nil {
-					http.Error(w, fmt.Sprintf("Invalid age: %s (must be a number)", ageStr), http.StatusBadRequest)
-					return
-				}
-				updatedStudent.Age = age
-				updatedStudent.Email = r.FormValue("email")
-			}
-			
-			// Validate student data
-			if err := validateStudent(updatedStudent); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+	chaos.Latency = Duration(*chaosLatency)
+	chaos.Jitter = Duration(*chaosJitter)
+	if err := chaos.validate(); err != nil {
+		log.Fatalf("Invalid fault injection settings: %v", err)
+	}
+	if err := configureLLMRecording(*llmRecordDir, *llmReplayDir); err != nil {
+		log.Fatalf("Failed to set up LLM recording: %v", err)
+	}
+	for _, prefix := range strings.Split(*logBodyRoutes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			bodyLogPrefixes = append(bodyLogPrefixes, prefix)
+		}
+	}
+	if len(bodyLogPrefixes) > 0 {
+		slog.Warn("Logging request and response bodies", "prefixes", bodyLogPrefixes)
+	}
+	if chaosEnabled {
+		slog.Warn("Fault injection is enabled; do not use in production", "config", chaos)
+	}
 
-			mutex.Lock()
-			defer mutex.Unlock()
-			
-			// Update the student in the slice
-			for i, student := range students {
-				if student.ID == updatedStudent.ID {
-					students[i] = updatedStudent
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(updatedStudent)
-					return
-				}
-			}
-			http.Error(w, "Student not found", http.StatusNotFound)
-		} else if r.Method == http.MethodDelete {
-			// DELETE a specific student by ID
-			id, err := strconv.Atoi(r.PathValue("id"))
-			if err != nil {
-				http.Error(w, "Invalid ID", http.StatusBadRequest)
-				return
-			}
-			
-			mutex.Lock()
-			defer mutex.Unlock()
-			
-			for i, student := range students {
-				if student.ID == id {
-					students = append(students[:i], students[i+1:]...)
-					w.WriteHeader(http.StatusNoContent)
-					return
-				}
-			}
-			http.Error(w, "Student not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if configPath != "" {
+		if err := loadConfig(configPath); err != nil {
+			log.Fatalf("Failed to load config: %v", err)
 		}
-	})
+	}
+
+	if err := initSecrets(); err != nil {
+		log.Fatalf("Failed to load secrets: %v", err)
+	}
 
-	// Generate summary of a student using Ollama
-	api.HandleFunc("/students/{id}/summary", func(w http.ResponseWriter, r *http.Request) {
-		enableCORS(w)
-		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	if geocoder, err = openGeocoder(*geocoderSpec); err != nil {
+		log.Fatalf("Invalid -geocoder: %v", err)
+	}
+	if err := configureSummaryProvider(*llmProvider, *mockLLM); err != nil {
+		log.Fatalf("Invalid -llm-provider: %v", err)
+	}
+	slog.Info("Using LLM provider", "provider", summaryProvider.Name(), "model", llmModel())
+
+	if featureFlagsPath != "" {
+		if err := loadFeatureFlags(featureFlagsPath); err != nil {
+			log.Fatalf("Failed to load feature flags: %v", err)
 		}
-		
-		id, err := strconv.Atoi(r.PathValue("id"))
+	}
+
+	students = []Student{}
+	if *walPath != "" {
+		wal, err = openWAL(*walPath, *walCompact)
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		slog.Info("Restored students from write-ahead log", "count", len(students), "path", *walPath)
+		if backfilled, err := backfillStudentUUIDs(); err != nil {
+			log.Fatalf("Failed to assign student UUIDs: %v", err)
+		} else if backfilled > 0 {
+			slog.Info("Assigned UUIDs to existing students", "count", backfilled)
+		}
+	}
+	if *storeSpec != "memory" {
+		if studentStore, err = openStore(*storeSpec); err != nil {
+			log.Fatalf("Failed to open store: %v", err)
+		}
+		roster, revision, err := studentStore.List(context.Background())
 		if err != nil {
-			http.Error(w, "Invalid ID", http.StatusBadRequest)
-			return
+			log.Fatalf("Failed to load students from %s: %v", studentStore.Name(), err)
 		}
-		
-		mutex.RLock()
-		var targetStudent *Student
-		for _, student := range students {
-			if student.ID == id {
-				targetStudent = &student
-				break
+		var lastID int64
+		if keeper, ok := studentStore.(studentIDKeeper); ok {
+			if lastID, err = keeper.LastStudentID(context.Background()); err != nil {
+				log.Fatalf("Failed to load the last student ID from %s: %v", studentStore.Name(), err)
 			}
 		}
-		mutex.RUnlock()
-		
-		if targetStudent == nil {
-			http.Error(w, "Student not found", http.StatusNotFound)
-			return
+		// With -wal as well, whichever is further ahead wins. The store
+		// catches up with a log that's ahead; a log that's behind restarts
+		// from the store's roster.
+		mutex.Lock()
+		noteStudentIDLocked(lastID)
+		if wal != nil && changeSeq >= revision {
+			if changeSeq > revision {
+				slog.Info("The write-ahead log is ahead of the store; the store will catch up",
+					"store", studentStore.Name(), "store_revision", revision, "revision", changeSeq)
+			}
+		} else {
+			students, changeSeq, changes = roster, revision, nil
+			rebuildStatsLocked()
+			if wal != nil {
+				err = wal.compact()
+			}
+			slog.Info("Restored students from store", "count", len(students), "store", studentStore.Name())
 		}
-		
-		// Call Ollama API to generate summary
-		summary, err := callOllamaAPI(*targetStudent)
+		mutex.Unlock()
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to generate summary: %v", err), http.StatusInternalServerError)
-			return
+			log.Fatalf("Failed to restart the write-ahead log from %s: %v", studentStore.Name(), err)
+		}
+		startStoreMirror(revision)
+		if backfilled, err := backfillStudentUUIDs(); err != nil {
+			log.Fatalf("Failed to assign student UUIDs: %v", err)
+		} else if backfilled > 0 {
+			slog.Info("Assigned UUIDs to existing students", "count", backfilled)
+		}
+	}
+	if err := restoreAuthState(context.Background()); err != nil {
+		log.Fatalf("Failed to restore users, sessions, API keys and tenants: %v", err)
+	}
+	if *cdcProxy != "" {
+		startCDC(*cdcProxy, *cdcTopicPrefix)
+	}
+	if *backendCacheTTL > 0 {
+		studentCache = newTTLCache[int64, cachedStudent](*backendCacheTTL, *backendCacheSize)
+	}
+	if *shadowSpec != "" {
+		backend, err := openBackend(*shadowSpec)
+		if err == nil {
+			err = startShadow(backend)
 		}
-		
-		response := map[string]interface{}{
-			"student": targetStudent,
-			"summary": summary,
+		if err != nil {
+			log.Fatalf("Failed to start shadow backend: %v", err)
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	})
+	}
+
+	api := http.NewServeMux()
+	routes := append(apiRoutes(), debugRoutes()...)
+	registerRoutes(api, routes)
+
+	// CSS and scripts for the HTML pages
+	api.HandleFunc("/static/", handleStatic)
 
 	// Introduction page
-	api.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Welcome to the Student Management API\n"))
-		w.Write([]byte("You can use the following endpoints to manage students\n"))
-		w.Write([]byte("GET /students - Get all students\n"))
-		w.Write([]byte("POST /students - Create a new student\n"))
-		w.Write([]byte("PUT /students/{id} - Update a student\n"))
-		w.Write([]byte("DELETE /students/{id} - Delete a student\n"))
-		w.Write([]byte("GET /students/{id}/summary - Get a summary of a student\n"))
-	})
+	api.HandleFunc("/", introductionPage(routes))
 
-	fmt.Println("Server starting on port 8000...")
-	http.ListenAndServe(":8000", api)
-}
\ No newline at end of file
+	if *debugAddr != "" {
+		debug := http.NewServeMux()
+		registerRoutes(debug, debugRoutes())
+		go func() {
+			slog.Info("Diagnostics listening", "addr", *debugAddr)
+			if err := http.ListenAndServe(*debugAddr, debug); err != nil {
+				slog.Error("Diagnostics listener stopped", "error", err)
+			}
+		}()
+	}
+
+	go runRetention()
+	go runReportScheduler()
+	go runUsageMeter(time.Hour)
+	if anomalySchedule != "" {
+		go runAnomalyScheduler(anomalyCron)
+	}
+	if loadShedder.maxHeapMB > 0 || loadShedder.maxGoroutines > 0 {
+		go monitorLoad(time.Second)
+	}
+
+	slog.Info("Server starting on port 8000...")
+	server := &http.Server{Addr: ":8000", Handler: logRequests(observeRequests(logBodies(filterIPs(detectAbuse(maintenanceMode(authenticate(warnQuotas(injectFaults(shapeResponses(api))))))))))}
+	serveUntilSignal(server, *shutdownTimeout)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const defaultMaintenanceMessage = "The Student Management API is down for scheduled maintenance. Please try again shortly."
+
+// MaintenanceState is switched via POST /admin/maintenance or the -maintenance flag
+type MaintenanceState struct {
+	Enabled    bool   `json:"enabled"`
+	AllowReads bool   `json:"allow_reads"`
+	Message    string `json:"message"`
+}
+
+var (
+	maintenance      = MaintenanceState{Message: defaultMaintenanceMessage}
+	maintenanceMutex sync.RWMutex
+)
+
+func currentMaintenance() MaintenanceState {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+	return maintenance
+}
+
+// maintenanceMode answers every non-admin request with 503 while maintenance
+// is on, optionally letting reads through
+func maintenanceMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := currentMaintenance()
+		if !state.Enabled || isAdminPath(r.URL.Path) ||
+			(state.AllowReads && (r.Method == http.MethodGet || r.Method == http.MethodHead)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		enableCORS(w)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "300")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":       "maintenance",
+			"message":     state.Message,
+			"allow_reads": state.AllowReads,
+		})
+	})
+}
+
+func handleMaintenanceGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentMaintenance())
+}
+
+func handleMaintenanceSet(w http.ResponseWriter, r *http.Request) {
+	state := currentMaintenance()
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &state); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		for field, target := range map[string]*bool{"enabled": &state.Enabled, "allow_reads": &state.AllowReads} {
+			value := r.FormValue(field)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "Invalid "+field+": must be true or false", http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+		if message := r.FormValue("message"); message != "" {
+			state.Message = message
+		}
+	}
+	if state.Message == "" {
+		state.Message = defaultMaintenanceMessage
+	}
+
+	maintenanceMutex.Lock()
+	maintenance = state
+	maintenanceMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
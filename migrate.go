@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// migrationCheckpoint records how far an interrupted migrate-data run got
+type migrationCheckpoint struct {
+	From           string    `json:"from"`
+	To             string    `json:"to"`
+	SourceRevision int64     `json:"source_revision"`
+	SourceChecksum string    `json:"source_checksum"`
+	CopiedThrough  int64     `json:"copied_through"` // highest student ID copied
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// rosterChecksum is the SHA-256 of a roster ordered by ID, for comparing
+// copies of it in different backends
+func rosterChecksum(roster []Student) (string, error) {
+	data, err := json.Marshal(roster)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func readCheckpoint(path string) (*migrationCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint migrationCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint %s: %v", path, err)
+	}
+	return &checkpoint, nil
+}
+
+func writeCheckpoint(path string, checkpoint migrationCheckpoint) error {
+	checkpoint.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runMigrateData implements the migrate-data subcommand. It copies every
+// student from one storage backend to another in batches, checkpointing after
+// each batch so an interrupted run resumes where it stopped, and then verifies
+// that the count and checksum of both sides match. The server must not be
+// writing to either backend while it runs.
+func runMigrateData(args []string) error {
+	fs := flag.NewFlagSet("migrate-data", flag.ExitOnError)
+	from := fs.String("from", "", "source backend, e.g. wal:/var/lib/fealtyx/students.wal")
+	to := fs.String("to", "", "target backend, e.g. jsonfile:/var/lib/fealtyx/roster.json")
+	batchSize := fs.Int("batch", 500, "students copied per batch")
+	checkpointPath := fs.String("checkpoint", "migrate-data.checkpoint", "file recording progress, for resuming")
+	overwrite := fs.Bool("overwrite", false, "replace a target that already holds students")
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		return fmt.Errorf("usage: migrate-data -from kind:location -to kind:location")
+	}
+	if *from == *to {
+		return fmt.Errorf("-from and -to are the same backend")
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+
+	offlineStorage = true
+	source, err := openBackend(*from)
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	defer source.Close()
+	target, err := openBackend(*to)
+	if err != nil {
+		return fmt.Errorf("target: %v", err)
+	}
+	defer target.Close()
+
+	roster, revision, err := source.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read source: %v", err)
+	}
+	checksum, err := rosterChecksum(roster)
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := readCheckpoint(*checkpointPath)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && (checkpoint.From != *from || checkpoint.To != *to) {
+		return fmt.Errorf("%s belongs to a migration from %s to %s; remove it to start over", *checkpointPath, checkpoint.From, checkpoint.To)
+	}
+	if checkpoint != nil && (checkpoint.SourceRevision != revision || checkpoint.SourceChecksum != checksum) {
+		return fmt.Errorf("the source changed since the interrupted run; remove %s and migrate again with -overwrite", *checkpointPath)
+	}
+	if checkpoint == nil {
+		existing, _, err := target.List(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to read target: %v", err)
+		}
+		if len(existing) > 0 && !*overwrite {
+			return fmt.Errorf("target already holds %d students; pass -overwrite to replace them", len(existing))
+		}
+		if err := target.Replace(nil, 0); err != nil {
+			return fmt.Errorf("failed to clear target: %v", err)
+		}
+		checkpoint = &migrationCheckpoint{From: *from, To: *to, SourceRevision: revision, SourceChecksum: checksum}
+		if err := writeCheckpoint(*checkpointPath, *checkpoint); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Resuming after student %d\n", checkpoint.CopiedThrough)
+	}
+
+	var pending []Student
+	for _, student := range roster {
+		if student.ID > checkpoint.CopiedThrough {
+			pending = append(pending, student)
+		}
+	}
+	copied := len(roster) - len(pending)
+	for len(pending) > 0 {
+		batch := pending[:min(len(pending), *batchSize)]
+		pending = pending[len(batch):]
+		// Every copied student is stamped with the source revision, so the
+		// target ends up at the same revision as the source
+		batchChanges := make([]Change, len(batch))
+		for i, student := range batch {
+			batchChanges[i] = Change{ID: revision, Event: EventStudentCreated, Student: student, OccurredAt: time.Now().UTC()}
+		}
+		if batcher, ok := target.(batchApplier); ok {
+			err = batcher.ApplyBatch(batchChanges)
+		} else {
+			for _, change := range batchChanges {
+				if err = target.Apply(change); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write to target after student %d: %v (rerun to resume)", checkpoint.CopiedThrough, err)
+		}
+		checkpoint.CopiedThrough = batch[len(batch)-1].ID
+		if err := writeCheckpoint(*checkpointPath, *checkpoint); err != nil {
+			return err
+		}
+		copied += len(batch)
+		fmt.Printf("Copied %d/%d students\n", copied, len(roster))
+	}
+
+	copiedRoster, copiedRevision, err := target.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to read target for verification: %v", err)
+	}
+	copiedChecksum, err := rosterChecksum(copiedRoster)
+	if err != nil {
+		return err
+	}
+	if len(copiedRoster) != len(roster) || copiedChecksum != checksum || (len(roster) > 0 && copiedRevision != revision) {
+		return fmt.Errorf("verification failed: source has %d students (sha256 %s), target has %d (sha256 %s)",
+			len(roster), checksum, len(copiedRoster), copiedChecksum)
+	}
+	if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fmt.Printf("Migrated %d students at revision %d from %s to %s\nsha256 %s verified\n", len(roster), revision, source.Name(), target.Name(), checksum)
+	return nil
+}
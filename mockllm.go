@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mockProvider answers every request with its canned text, so frontend
+// development and CI can exercise the summary endpoints offline and get the
+// same text every time
+type mockProvider struct{}
+
+func (mockProvider) Name() string { return "mock" }
+
+func (mockProvider) Generate(ctx context.Context, request LLMRequest) (LLMResult, error) {
+	return LLMResult{Text: request.Canned}, nil
+}
+
+// Stream hands the canned text over a word at a time
+func (mockProvider) Stream(ctx context.Context, request LLMRequest, onToken func(string)) (LLMResult, error) {
+	for _, word := range strings.SplitAfter(request.Canned, " ") {
+		if ctx.Err() != nil {
+			return LLMResult{}, ctx.Err()
+		}
+		onToken(word)
+	}
+	return LLMResult{Text: request.Canned}, nil
+}
+
+func (mockProvider) Models(ctx context.Context, client *http.Client) ([]string, error) {
+	return nil, nil
+}
+
+// Canned summaries take the name, age and email as arguments 1, 2 and 3
+var mockSummaries = []string{
+	"%[1]s is a %[2]d-year-old student who brings curiosity and steady effort to every class. Reach them at %[3]s.",
+	"At %[2]d, %[1]s is an engaged learner known for thoughtful questions and helping classmates. Contact: %[3]s.",
+	"%[1]s, aged %[2]d, is a dependable student with a positive attitude and a growing love of learning. Email: %[3]s.",
+	"A %[2]d-year-old with a creative streak, %[1]s approaches new topics with enthusiasm. They can be reached at %[3]s.",
+}
+
+// mockSummary picks a canned summary seeded by the student ID, so the same
+// student always gets the same text
+func mockSummary(student Student) string {
+	index := int(student.ID % int64(len(mockSummaries)))
+	if index < 0 {
+		index = -index
+	}
+	return fmt.Sprintf(mockSummaries[index], student.Name, student.Age, student.Email)
+}
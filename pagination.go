@@ -0,0 +1,118 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The student list is paginated with ?page= (from 1) and ?limit=, and
+// ordered with ?sort=. Asking for a page turns the response into a
+// StudentPage; without one the list is every student, as it always was.
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// StudentPage is one page of a list
+type StudentPage struct {
+	Total int       `json:"total"` // students on all pages
+	Page  int       `json:"page"`
+	Limit int       `json:"limit"`
+	Items []Student `json:"items"`
+}
+
+// studentSorts are the orders ?sort= accepts. Ties are broken by ID, so
+// pages don't shift between requests.
+var studentSorts = map[string]func(a, b Student) int{
+	"id": func(a, b Student) int { return cmp.Compare(a.ID, b.ID) },
+	"name": func(a, b Student) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), cmp.Compare(a.ID, b.ID))
+	},
+	"age": func(a, b Student) int { return cmp.Or(cmp.Compare(a.Age, b.Age), cmp.Compare(a.ID, b.ID)) },
+}
+
+// sortRequestRoster returns a roster ordered by ?sort=, a field with a
+// leading "-" for descending order. It sorts a copy, since the roster may be
+// queued for a shadow read. On failure it has already written the error
+// response.
+func sortRequestRoster(w http.ResponseWriter, r *http.Request, roster []Student) ([]Student, bool) {
+	value := r.URL.Query().Get("sort")
+	if value == "" {
+		return roster, true
+	}
+	field, descending := strings.CutPrefix(value, "-")
+	compare, ok := studentSorts[field]
+	if !ok {
+		http.Error(w, "Invalid sort: must be id, name or age, with a leading - for descending order", http.StatusBadRequest)
+		return nil, false
+	}
+	roster = slices.Clone(roster)
+	slices.SortFunc(roster, func(a, b Student) int {
+		if descending {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	return roster, true
+}
+
+// paginateRequestRoster cuts the page asked for by ?page= and ?limit= out of
+// a roster. paged is false when the request asks for neither. On failure it
+// has already written the error response.
+func paginateRequestRoster(w http.ResponseWriter, r *http.Request, roster []Student) (page StudentPage, paged, ok bool) {
+	query := r.URL.Query()
+	if query.Get("page") == "" && query.Get("limit") == "" {
+		return StudentPage{}, false, true
+	}
+	page = StudentPage{Total: len(roster), Page: 1, Limit: defaultPageLimit}
+	if value := query.Get("page"); value != "" {
+		var err error
+		if page.Page, err = strconv.Atoi(value); err != nil || page.Page < 1 {
+			http.Error(w, "Invalid page: must be a whole number from 1", http.StatusBadRequest)
+			return StudentPage{}, true, false
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if page.Limit, err = strconv.Atoi(value); err != nil || page.Limit < 1 || page.Limit > maxPageLimit {
+			http.Error(w, "Invalid limit: must be 1-"+strconv.Itoa(maxPageLimit), http.StatusBadRequest)
+			return StudentPage{}, true, false
+		}
+	}
+	// past the last page is an empty page, not an error
+	start := len(roster)
+	if pages := (len(roster) + page.Limit - 1) / page.Limit; page.Page <= pages {
+		start = (page.Page - 1) * page.Limit
+	}
+	end := min(start+page.Limit, len(roster))
+	page.Items = append([]Student{}, roster[start:end]...)
+	return page, true, true
+}
+
+// appendJSON encodes a page like encoding/json, with the students encoded as
+// in the list
+func (p StudentPage) appendJSON(dst []byte) []byte {
+	dst = append(dst, `{"total":`...)
+	dst = strconv.AppendInt(dst, int64(p.Total), 10)
+	dst = append(dst, `,"page":`...)
+	dst = strconv.AppendInt(dst, int64(p.Page), 10)
+	dst = append(dst, `,"limit":`...)
+	dst = strconv.AppendInt(dst, int64(p.Limit), 10)
+	dst = append(dst, `,"items":`...)
+	dst = appendStudentsJSON(dst, p.Items)
+	return append(dst, '}')
+}
+
+// writeStudentPageJSON writes a page as the JSON response. Enveloped, the
+// items are the data and the rest is meta.
+func writeStudentPageJSON(w http.ResponseWriter, r *http.Request, page StudentPage) {
+	setResponseData(r, "items")
+	data := page.appendJSON(nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phone numbers are stored as entered and in E.164 (+14155550123). Numbers
+// without a country code are read in the default region. The rules here
+// cover what E.164 needs: country codes, trunk prefixes and the lengths of
+// national numbers in the regions below. Numbers in other countries are
+// accepted as long as they're written internationally.
+
+var errInvalidPhone = newError(ErrInvalid, "invalid phone")
+
+// phoneRegion is how a region writes its national numbers
+type phoneRegion struct {
+	countryCode string
+	trunkPrefix string // dialled before national numbers within the region
+	minLength   int    // of the national number, without the trunk prefix
+	maxLength   int
+}
+
+var phoneRegions = map[string]phoneRegion{
+	"US": {"1", "1", 10, 10},
+	"CA": {"1", "1", 10, 10},
+	"GB": {"44", "0", 9, 10},
+	"IE": {"353", "0", 7, 9},
+	"IN": {"91", "0", 10, 10},
+	"AU": {"61", "0", 9, 9},
+	"NZ": {"64", "0", 8, 10},
+	"DE": {"49", "0", 6, 13},
+	"FR": {"33", "0", 9, 9},
+	"NL": {"31", "0", 9, 9},
+	"ES": {"34", "", 9, 9},
+	"MX": {"52", "", 10, 10},
+	"BR": {"55", "0", 10, 11},
+	"JP": {"81", "0", 9, 10},
+	"CN": {"86", "0", 10, 11},
+	"SG": {"65", "", 8, 8},
+	"AE": {"971", "0", 8, 9},
+	"ZA": {"27", "0", 9, 9},
+}
+
+// defaultPhoneRegion reads numbers written without a country code. Empty
+// requires every number to start with +.
+var defaultPhoneRegion string
+
+func validPhoneRegion(region string) bool {
+	_, ok := phoneRegions[region]
+	return region == "" || ok
+}
+
+// normalizePhone returns a number in E.164, or "" for an empty one
+func normalizePhone(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	international := strings.HasPrefix(raw, "+")
+	var digits strings.Builder
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '.', r == '(', r == ')':
+		default:
+			return "", fmt.Errorf("%w: phone may only contain digits, spaces, dashes, dots, brackets and a leading +", errInvalidPhone)
+		}
+	}
+	number := digits.String()
+
+	region, hasRegion := phoneRegions[defaultPhoneRegion]
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00") && region.countryCode != "1":
+		number, international = number[2:], true // the international prefix outside North America
+	case strings.HasPrefix(number, "011") && region.countryCode == "1":
+		number, international = number[3:], true
+	case !hasRegion:
+		return "", fmt.Errorf("%w: phone must start with + and a country code", errInvalidPhone)
+	default:
+		national := number
+		// A leading 0 is always a trunk prefix. North America's 1 is one
+		// only when it makes the number a digit too long.
+		if region.trunkPrefix == "0" || region.trunkPrefix != "" && len(national) > region.maxLength {
+			national = strings.TrimPrefix(national, region.trunkPrefix)
+		}
+		if len(national) < region.minLength || len(national) > region.maxLength {
+			return "", fmt.Errorf("%w: phone is not a valid %s number", errInvalidPhone, defaultPhoneRegion)
+		}
+		return "+" + region.countryCode + national, nil
+	}
+
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", fmt.Errorf("%w: phone must be a country code and number of 8 to 15 digits", errInvalidPhone)
+	}
+	for _, known := range phoneRegions {
+		if !strings.HasPrefix(number, known.countryCode) {
+			continue
+		}
+		national := number[len(known.countryCode):]
+		if len(national) >= known.minLength && len(national) <= known.maxLength {
+			return "+" + number, nil
+		}
+		if known.trunkPrefix == "0" && strings.HasPrefix(national, "0") && len(national)-1 >= known.minLength && len(national)-1 <= known.maxLength {
+			// A trunk prefix written after the country code, as in +44 (0)20 ...
+			return "+" + known.countryCode + national[1:], nil
+		}
+		return "", fmt.Errorf("%w: phone has the wrong number of digits for country code +%s", errInvalidPhone, known.countryCode)
+	}
+	return "+" + number, nil
+}
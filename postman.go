@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanVariable struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host"`
+	Path     []string          `json:"path"`
+	Query    []postmanVariable `json:"query,omitempty"`
+	Variable []postmanVariable `json:"variable,omitempty"`
+}
+
+type postmanBody struct {
+	Mode    string                 `json:"mode"`
+	Raw     string                 `json:"raw"`
+	Options map[string]interface{} `json:"options"`
+}
+
+type postmanRequest struct {
+	Method      string            `json:"method"`
+	Header      []postmanVariable `json:"header"`
+	URL         postmanURL        `json:"url"`
+	Body        *postmanBody      `json:"body,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+}
+
+// postmanItemFor converts a registry route into a Postman request, turning
+// {id} path parameters into :id variables
+func postmanItemFor(route Route) postmanItem {
+	var path []string
+	var variables []postmanVariable
+	for _, segment := range strings.Split(strings.Trim(route.Path, "/"), "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := strings.Trim(segment, "{}")
+			segment = ":" + name
+			variables = append(variables, postmanVariable{Key: name, Value: "1"})
+		}
+		path = append(path, segment)
+	}
+
+	raw := "{{baseUrl}}/" + strings.Join(path, "/")
+	var query []postmanVariable
+	if route.Query != "" {
+		raw += "?" + route.Query
+		for _, pair := range strings.Split(route.Query, "&") {
+			key, value, _ := strings.Cut(pair, "=")
+			query = append(query, postmanVariable{Key: key, Value: value})
+		}
+	}
+
+	request := &postmanRequest{
+		Method:      route.Method,
+		Header:      []postmanVariable{},
+		URL:         postmanURL{Raw: raw, Host: []string{"{{baseUrl}}"}, Path: path, Query: query, Variable: variables},
+		Description: route.Description,
+	}
+	if route.Deprecated != nil {
+		request.Description = "Deprecated. " + request.Description
+	}
+	if route.Example != nil {
+		example, _ := json.MarshalIndent(route.Example, "", "  ")
+		request.Header = append(request.Header, postmanVariable{Key: "Content-Type", Value: "application/json"})
+		request.Body = &postmanBody{
+			Mode:    "raw",
+			Raw:     string(example),
+			Options: map[string]interface{}{"raw": map[string]string{"language": "json"}},
+		}
+	}
+
+	name := route.Method + " " + route.Path
+	if route.Description != "" {
+		name = route.Description
+	}
+	return postmanItem{Name: name, Request: request}
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handlePostmanCollection builds a collection from the route registry, with one
+// folder per top-level path segment
+func handlePostmanCollection(w http.ResponseWriter, r *http.Request) {
+	var folders []postmanItem
+	index := map[string]int{}
+	for _, route := range append(apiRoutes(), debugRoutes()...) {
+		if route.Description == "" {
+			continue
+		}
+		folder := strings.SplitN(strings.Trim(route.Path, "/"), "/", 2)[0]
+		i, ok := index[folder]
+		if !ok {
+			i = len(folders)
+			index[folder] = i
+			folders = append(folders, postmanItem{Name: folder})
+		}
+		folders[i].Item = append(folders[i].Item, postmanItemFor(route))
+	}
+
+	collection := map[string]interface{}{
+		"info": map[string]string{
+			"name":   "Student Management API",
+			"schema": postmanSchema,
+		},
+		"auth": map[string]interface{}{
+			"type": "apikey",
+			"apikey": []postmanVariable{
+				{Key: "key", Value: "X-API-Key", Type: "string"},
+				{Key: "value", Value: "{{apiKey}}", Type: "string"},
+				{Key: "in", Value: "header", Type: "string"},
+			},
+		},
+		"variable": []postmanVariable{
+			{Key: "baseUrl", Value: requestBaseURL(r), Type: "string"},
+			{Key: "apiKey", Value: "", Type: "string"},
+		},
+		"item": folders,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="postman.json"`)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// handlePostmanEnvironment serves a matching environment so the base URL and
+// API key can be switched per deployment without editing the collection
+func handlePostmanEnvironment(w http.ResponseWriter, r *http.Request) {
+	enabled := true
+	environment := map[string]interface{}{
+		"name": "Student Management API",
+		"values": []postmanVariable{
+			{Key: "baseUrl", Value: requestBaseURL(r), Type: "default", Enabled: &enabled},
+			{Key: "apiKey", Value: "", Type: "secret", Enabled: &enabled},
+		},
+		"_postman_variable_scope": "environment",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="postman-environment.json"`)
+	json.NewEncoder(w).Encode(environment)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// runPromptTest implements the prompttest subcommand. It summarizes a fixture
+// set of students with the current prompt template and model and compares each
+// summary with a golden file, failing when the similarity drops below the
+// threshold. With -update the golden files are rewritten instead.
+func runPromptTest(args []string) error {
+	fs := flag.NewFlagSet("prompttest", flag.ExitOnError)
+	fixtures := fs.String("fixtures", "testdata/prompttest/students.json", "JSON file with the fixture students")
+	golden := fs.String("golden", "testdata/prompttest/golden", "directory holding one golden summary per student ID")
+	threshold := fs.Float64("threshold", 0.5, "minimum similarity (0-1) between a summary and its golden file")
+	update := fs.Bool("update", false, "write the current summaries as the new golden files")
+	fs.StringVar(&configPath, "config", os.Getenv("CONFIG_FILE"), "runtime config JSON file with the prompt template and model")
+	provider := fs.String("llm-provider", os.Getenv("LLM_PROVIDER"), "model behind summaries: ollama[:URL], openai:URL or mock")
+	mockLLM := fs.Bool("mock-llm", false, "use canned summaries instead of calling a model")
+	recordDir := fs.String("llm-record", "", "record every LLM request and response into this directory")
+	replayDir := fs.String("llm-replay", "", "answer LLM requests from recordings in this directory")
+	fs.Parse(args)
+
+	if configPath != "" {
+		if err := loadConfig(configPath); err != nil {
+			return err
+		}
+	}
+	if err := configureSummaryProvider(*provider, *mockLLM); err != nil {
+		return err
+	}
+	if err := configureLLMRecording(*recordDir, *replayDir); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*fixtures)
+	if err != nil {
+		return fmt.Errorf("failed to read fixtures: %v", err)
+	}
+	var fixtureStudents []Student
+	if err := json.Unmarshal(data, &fixtureStudents); err != nil {
+		return fmt.Errorf("invalid fixtures file: %v", err)
+	}
+	if *update {
+		if err := os.MkdirAll(*golden, 0o755); err != nil {
+			return err
+		}
+	}
+
+	failures := 0
+	for _, student := range fixtureStudents {
+		summary, err := summarizeStudent(context.Background(), student, defaultLocale)
+		if err != nil {
+			return fmt.Errorf("student %d: %v", student.ID, err)
+		}
+		path := filepath.Join(*golden, strconv.FormatInt(student.ID, 10)+".txt")
+
+		if *update {
+			if err := os.WriteFile(path, []byte(strings.TrimSpace(summary)+"\n"), 0o644); err != nil {
+				return err
+			}
+			fmt.Printf("UPDATED %s\n", path)
+			continue
+		}
+
+		expected, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("MISSING %s (run with -update to create it)\n", path)
+			failures++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		score := similarity(string(expected), summary)
+		status := "PASS"
+		if score < *threshold {
+			status = "FAIL"
+			failures++
+		}
+		fmt.Printf("%s student %d similarity %.2f\n", status, student.ID, score)
+		if status == "FAIL" {
+			fmt.Printf("  golden: %s\n  actual: %s\n", strings.TrimSpace(string(expected)), strings.TrimSpace(summary))
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d summaries drifted below similarity %.2f", failures, len(fixtureStudents), *threshold)
+	}
+	return nil
+}
+
+// similarity is the cosine similarity of the word counts of two texts
+func similarity(a, b string) float64 {
+	countsA, countsB := wordCounts(a), wordCounts(b)
+	var dot, normA, normB float64
+	for word, count := range countsA {
+		dot += count * countsB[word]
+		normA += count * count
+	}
+	for _, count := range countsB {
+		normB += count * count
+	}
+	if normA == 0 || normB == 0 {
+		if normA == normB {
+			return 1
+		}
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func wordCounts(text string) map[string]float64 {
+	counts := map[string]float64{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		counts[word]++
+	}
+	return counts
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PublicRoster is a tenant's opt-in class list for posting publicly. Only the
+// listed fields are shown; email is never publishable.
+type PublicRoster struct {
+	Enabled bool     `json:"enabled"`
+	Fields  []string `json:"fields,omitempty"` // default first_name
+}
+
+// publicRosterFields are the student fields a tenant may publish
+var publicRosterFields = map[string]func(Student) interface{}{
+	"first_name": func(s Student) interface{} { return firstName(s.Name) },
+	"name":       func(s Student) interface{} { return s.Name },
+	"age":        func(s Student) interface{} { return s.Age },
+}
+
+func firstName(name string) string {
+	first, _, _ := strings.Cut(strings.TrimSpace(name), " ")
+	return first
+}
+
+func (p PublicRoster) validate() error {
+	for _, field := range p.Fields {
+		if publicRosterFields[field] == nil {
+			return fmt.Errorf("public_roster.fields: %q can't be published; use first_name, name or age", field)
+		}
+	}
+	return nil
+}
+
+func (p PublicRoster) fields() []string {
+	if len(p.Fields) == 0 {
+		return []string{"first_name"}
+	}
+	return p.Fields
+}
+
+// handlePublicRoster shows a tenant's published class list as JSON, or as a
+// simple HTML page with ?format=html or Accept: text/html. Tenants that
+// haven't opted in look the same as tenants that don't exist.
+func handlePublicRoster(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := lookupTenant(r.URL.Query().Get("tenant"))
+	if !ok || tenant.Status != TenantActive || !tenant.PublicRoster.Enabled {
+		http.Error(w, "Roster not found", http.StatusNotFound)
+		return
+	}
+	fields := tenant.PublicRoster.fields()
+
+	roster, _ := snapshotRoster()
+	roster = visibleStudents(tenant.Name, roster)
+	sort.Slice(roster, func(i, j int) bool { return roster[i].Name < roster[j].Name })
+	entries := make([]map[string]interface{}, len(roster))
+	rows := make([][]interface{}, len(roster))
+	for i, student := range roster {
+		entries[i] = map[string]interface{}{}
+		for _, field := range fields {
+			value := publicRosterFields[field](student)
+			entries[i][field] = value
+			rows[i] = append(rows[i], value)
+		}
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if negotiateHTML(w, r) {
+		title := tenant.Branding.SchoolName
+		if title == "" {
+			title = tenant.Name
+		}
+		renderView(w, r, "public_roster", map[string]interface{}{"Title": title + " class list", "Fields": fields, "Rows": rows})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenant":   tenant.Name,
+		"fields":   fields,
+		"students": entries,
+	})
+}
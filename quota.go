@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errStudentQuotaExceeded = newError(ErrPlanLimit, "Student limit reached for this plan")
+	errLLMQuotaExceeded     = newError(ErrQuotaExceeded, "Daily summary limit reached, try again tomorrow")
+)
+
+// Limit reports usage against a quota. A nil Limit means unlimited.
+type Limit struct {
+	Limit    *int       `json:"limit"`
+	Used     int        `json:"used"`
+	ResetsAt *time.Time `json:"resets_at,omitempty"`
+}
+
+// quotas holds the configured limits and the daily LLM call counter. Zero
+// limits are unlimited.
+var quotas = struct {
+	mu                sync.Mutex
+	maxStudents       int
+	maxLLMCallsPerDay int
+	llmCalls          int
+	llmDay            string
+}{}
+
+// checkStudentQuota rejects creating another student once the roster is full.
+// Callers must hold mutex.
+func checkStudentQuota() error {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+	if quotas.maxStudents > 0 && len(students) >= quotas.maxStudents {
+		return errStudentQuotaExceeded
+	}
+	return nil
+}
+
+// reserveLLMCall counts an upstream LLM call against today's quota (UTC days)
+func reserveLLMCall() error {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	if quotas.llmDay != today {
+		quotas.llmDay = today
+		quotas.llmCalls = 0
+	}
+	if quotas.maxLLMCallsPerDay > 0 && quotas.llmCalls >= quotas.maxLLMCallsPerDay {
+		return errLLMQuotaExceeded
+	}
+	quotas.llmCalls++
+	return nil
+}
+
+func limitOf(limit int) *int {
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
+
+// quotaWarnPercent is how full a quota must be before responses warn about
+// it; 0 disables the warnings
+var quotaWarnPercent = 80
+
+// QuotaWarning is a quota nearing its limit. Scope is global for the
+// server's quotas and tenant for the caller's tenant's.
+type QuotaWarning struct {
+	Quota    string     `json:"quota"` // students or llm_calls_per_day
+	Scope    string     `json:"scope"`
+	Used     int        `json:"used"`
+	Limit    int        `json:"limit"`
+	ResetsAt *time.Time `json:"resets_at,omitempty"`
+}
+
+// header renders the warning as an X-Quota-Warning value, e.g.
+// students; scope=tenant; used=82; limit=100
+func (q QuotaWarning) header() string {
+	value := fmt.Sprintf("%s; scope=%s; used=%d; limit=%d", q.Quota, q.Scope, q.Used, q.Limit)
+	if q.ResetsAt != nil {
+		value += "; resets=" + q.ResetsAt.Format(time.RFC3339)
+	}
+	return value
+}
+
+// quotaWarnings lists the quotas at least quotaWarnPercent full that apply
+// to the tenant's requests: the global ones and, for a tenant, its own
+func quotaWarnings(tenant string) []QuotaWarning {
+	if quotaWarnPercent == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	today := now.Format(time.DateOnly)
+	resetsAt := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	var warnings []QuotaWarning
+	add := func(quota, scope string, used, limit int) {
+		if limit > 0 && used*100 >= limit*quotaWarnPercent {
+			warning := QuotaWarning{Quota: quota, Scope: scope, Used: used, Limit: limit}
+			if quota == "llm_calls_per_day" {
+				warning.ResetsAt = &resetsAt
+			}
+			warnings = append(warnings, warning)
+		}
+	}
+
+	mutex.RLock()
+	studentCount, tenantCount := len(students), 0
+	if stats := tenantStats[tenant]; stats != nil && tenant != "" {
+		tenantCount = stats.count
+	}
+	mutex.RUnlock()
+
+	quotas.mu.Lock()
+	llmCalls := quotas.llmCalls
+	if quotas.llmDay != today {
+		llmCalls = 0
+	}
+	add("students", "global", studentCount, quotas.maxStudents)
+	add("llm_calls_per_day", "global", llmCalls, quotas.maxLLMCallsPerDay)
+	quotas.mu.Unlock()
+
+	if tenant == "" {
+		return warnings
+	}
+	tenantsMutex.RLock()
+	if record, ok := tenants[tenant]; ok {
+		tenantLLMCalls := record.llmCalls
+		if record.llmDay != today {
+			tenantLLMCalls = 0
+		}
+		add("students", "tenant", tenantCount, record.MaxStudents)
+		add("llm_calls_per_day", "tenant", tenantLLMCalls, record.MaxLLMCallsPerDay)
+	}
+	tenantsMutex.RUnlock()
+	return warnings
+}
+
+// setQuotaWarnings replaces the response's X-Quota-Warning headers with the
+// quotas nearing their limits now, and returns them for the body. Handlers
+// that use up quota call it after doing so, so the response reflects it.
+func setQuotaWarnings(w http.ResponseWriter, r *http.Request) []QuotaWarning {
+	warnings := quotaWarnings(requestTenantName(r))
+	w.Header().Del("X-Quota-Warning")
+	for _, warning := range warnings {
+		w.Header().Add("X-Quota-Warning", warning.header())
+	}
+	return warnings
+}
+
+// warnQuotas adds an X-Quota-Warning header to every response for each
+// quota nearing its limit, as of when the request arrived, so clients can
+// tell users before the limit is hit
+func warnQuotas(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/static/") {
+			setQuotaWarnings(w, r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleLimits(w http.ResponseWriter, r *http.Request) {
+	mutex.RLock()
+	studentCount := len(students)
+	mutex.RUnlock()
+
+	now := time.Now().UTC()
+	resetsAt := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+
+	quotas.mu.Lock()
+	llmCalls := quotas.llmCalls
+	if quotas.llmDay != now.Format(time.DateOnly) {
+		llmCalls = 0
+	}
+	response := map[string]interface{}{
+		"students": Limit{
+			Limit: limitOf(quotas.maxStudents),
+			Used:  studentCount,
+		},
+		"llm_calls_per_day": Limit{
+			Limit:    limitOf(quotas.maxLLMCallsPerDay),
+			Used:     llmCalls,
+			ResetsAt: &resetsAt,
+		},
+	}
+	quotas.mu.Unlock()
+	if warnings := quotaWarnings(requestTenantName(r)); len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DeleteBlock   = "block"
+	DeleteCascade = "cascade"
+)
+
+// deletePolicy decides what deleting a student with related records does:
+// block refuses with 409, cascade removes or closes the records too
+var deletePolicy = DeleteBlock
+
+func validDeletePolicy(policy string) bool {
+	return policy == DeleteBlock || policy == DeleteCascade
+}
+
+// studentRelation is a kind of record that refers to students. New entities
+// that do (courses, grades, enrollments) register here, and every way of
+// deleting a student honors them.
+type studentRelation struct {
+	name string
+	// countLocked returns how many records refer to the student
+	countLocked func(id int64) int
+	// cascadeLocked removes or closes them once the student is deleted
+	cascadeLocked func(id int64)
+}
+
+// studentRelations are checked with mutex held, so their functions may take
+// only locks that come after it
+var studentRelations = []studentRelation{
+	{name: "conflicts", countLocked: countOpenConflicts, cascadeLocked: closeOpenConflicts},
+}
+
+// RelatedRecordsError blocks deleting a student that records still refer to
+type RelatedRecordsError struct {
+	StudentID int64          `json:"student_id"`
+	Relations map[string]int `json:"relations"` // records per relation
+}
+
+func (e *RelatedRecordsError) Unwrap() error { return ErrConflict }
+
+func (e *RelatedRecordsError) Error() string {
+	names := make([]string, 0, len(e.Relations))
+	for name := range e.Relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", e.Relations[name], name)
+	}
+	return fmt.Sprintf("student %d has related records: %s", e.StudentID, strings.Join(parts, ", "))
+}
+
+// checkRelationsLocked returns a RelatedRecordsError if the policy blocks
+// deleting the student. Callers must hold mutex.
+func checkRelationsLocked(id int64) error {
+	if deletePolicy != DeleteBlock {
+		return nil
+	}
+	blocking := map[string]int{}
+	for _, relation := range studentRelations {
+		if count := relation.countLocked(id); count > 0 {
+			blocking[relation.name] = count
+		}
+	}
+	if len(blocking) > 0 {
+		return &RelatedRecordsError{StudentID: id, Relations: blocking}
+	}
+	return nil
+}
+
+// cascadeRelationsLocked cleans up after a deleted student. Callers must
+// hold mutex.
+func cascadeRelationsLocked(id int64) {
+	for _, relation := range studentRelations {
+		relation.cascadeLocked(id)
+	}
+}
+
+// writeRelatedRecordsError responds 409 with the relations that block a
+// delete
+func writeRelatedRecordsError(w http.ResponseWriter, err *RelatedRecordsError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      err.Error(),
+		"student_id": err.StudentID,
+		"relations":  err.Relations,
+		"hint":       "resolve the related records first, or run the server with -delete-policy cascade",
+	})
+}
+
+func countOpenConflicts(id int64) int {
+	conflictsMutex.Lock()
+	defer conflictsMutex.Unlock()
+	count := 0
+	for _, conflict := range conflicts {
+		if conflict.Status == "open" && conflict.Conflict.ID == id {
+			count++
+		}
+	}
+	return count
+}
+
+// closeOpenConflicts resolves the student's open conflicts in favor of the
+// server, which no longer has the student
+func closeOpenConflicts(id int64) {
+	conflictsMutex.Lock()
+	defer conflictsMutex.Unlock()
+	now := time.Now().UTC()
+	for _, conflict := range conflicts {
+		if conflict.Status == "open" && conflict.Conflict.ID == id {
+			conflict.Status = "resolved"
+			conflict.Resolution = "server"
+			conflict.ResolvedAt = &now
+		}
+	}
+}
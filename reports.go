@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report subscriptions deliver a saved filter's students as CSV, PDF or an
+// AI summary of the cohort, by email or webhook, on a cron schedule in the
+// tenant's timezone. The scheduler checks for due subscriptions every minute.
+
+const (
+	ReportCSV     = "csv"
+	ReportPDF     = "pdf"
+	ReportSummary = "summary"
+
+	maxReportSubscriptions = 20 // per tenant
+	reportWebhookTimeout   = 30 * time.Second
+)
+
+var (
+	errReportNotFound = newError(ErrNotFound, "Report subscription not found")
+	errReportRunning  = newError(ErrConflict, "Report is already being delivered")
+)
+
+// ReportSubscriptionRequest is what callers set on a subscription. Exactly
+// one of Email and WebhookURL says where reports go.
+type ReportSubscriptionRequest struct {
+	Name       string `json:"name,omitempty"`
+	Filter     string `json:"filter,omitempty"` // a saved filter; the whole roster if empty
+	Format     string `json:"format"`           // csv, pdf or summary
+	Schedule   string `json:"schedule"`         // cron, e.g. "0 7 * * 1" for Mondays at 07:00
+	Email      string `json:"email,omitempty"`
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+type ReportSubscription struct {
+	ID int `json:"id"`
+	ReportSubscriptionRequest
+	Tenant    string     `json:"tenant,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	NextRun   time.Time  `json:"next_run"`
+	LastRun   *ReportRun `json:"last_run,omitempty"`
+
+	schedule cronSchedule
+	running  bool
+}
+
+// ReportRun records one delivery
+type ReportRun struct {
+	At       time.Time `json:"at"`
+	Students int       `json:"students"`
+	Bytes    int       `json:"bytes"`
+	Error    string    `json:"error,omitempty"`
+}
+
+var (
+	reportSubscriptions = map[int]*ReportSubscription{}
+	reportSeq           int
+	reportsMutex        sync.Mutex
+
+	reportWebhookClient = &http.Client{Timeout: reportWebhookTimeout}
+)
+
+func (s ReportSubscriptionRequest) validate() error {
+	switch s.Format {
+	case ReportCSV, ReportPDF, ReportSummary:
+	default:
+		return fmt.Errorf("format must be %s, %s or %s", ReportCSV, ReportPDF, ReportSummary)
+	}
+	if _, err := parseCron(s.Schedule); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+	if (s.Email == "") == (s.WebhookURL == "") {
+		return fmt.Errorf("set exactly one of email and webhook_url")
+	}
+	if s.Email != "" && (!strings.Contains(s.Email, "@") || strings.ContainsAny(s.Email, "\r\n")) {
+		return fmt.Errorf("invalid email")
+	}
+	if s.WebhookURL != "" {
+		target, err := url.Parse(s.WebhookURL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("webhook_url must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// reportRoster picks the subscription's students, ordered by ID
+func reportRoster(subscription ReportSubscription) ([]Student, error) {
+	roster, _ := snapshotRoster()
+	roster = visibleStudents(subscription.Tenant, roster)
+	if subscription.Filter != "" {
+		filter, ok := lookupSavedFilter(subscription.Tenant, subscription.Filter)
+		if !ok {
+			return nil, fmt.Errorf("saved filter %q: %w", subscription.Filter, errUnknownFilter)
+		}
+		roster = filter.apply(roster)
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	return roster, nil
+}
+
+// reportFieldNames are the tenant's custom fields, which reports add as
+// columns
+func reportFieldNames(tenant string) []string {
+	customFieldsMutex.RLock()
+	defer customFieldsMutex.RUnlock()
+	names := make([]string, 0, len(customFields[tenant]))
+	for name := range customFields[tenant] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func reportRows(roster []Student, fields []string) [][]string {
+	rows := [][]string{append([]string{"id", "name", "age", "email", "tags"}, fields...)}
+	for _, student := range roster {
+		row := []string{strconv.FormatInt(student.ID, 10), student.Name, strconv.Itoa(student.Age), student.Email, strings.Join(student.Tags, ";")}
+		for _, field := range fields {
+			value := ""
+			if attribute, ok := student.Attributes[field]; ok {
+				value = formatAttribute(attribute)
+			}
+			row = append(row, value)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func reportCSV(rows [][]string) []byte {
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.WriteAll(rows)
+	return out.Bytes()
+}
+
+// reportPDF lays the rows out as a fixed-width table under a title
+func reportPDF(title string, rows [][]string) []byte {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = min(max(widths[i], len([]rune(cell))), 30)
+		}
+	}
+	lines := []string{title, ""}
+	for r, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			if runes := []rune(cell); len(runes) > widths[i] {
+				cell = string(runes[:widths[i]-1]) + "~"
+			}
+			cells[i] = cell + strings.Repeat(" ", widths[i]-len([]rune(cell)))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, "  "), " "))
+		if r == 0 {
+			lines = append(lines, strings.Repeat("-", len([]rune(lines[len(lines)-1]))))
+		}
+	}
+	return renderPDF(lines)
+}
+
+// cohortSummary asks the model to describe a group of students from
+// aggregate figures only, so no names or emails leave the server. Like the
+// rest of a delivery, it runs to the end even if the caller goes away.
+func cohortSummary(tenant string, roster []Student) (string, error) {
+	if err := reserveLLMCall(); err != nil {
+		return "", err
+	}
+	if err := reserveTenantLLMCall(tenant); err != nil {
+		return "", err
+	}
+	if simulateOllamaFailure() {
+		return "", errSimulatedOllamaFailure
+	}
+	facts := cohortFacts(roster)
+	prompt := "Write a short summary, for school staff, of a group of students described by these figures. " +
+		"Point out anything notable and do not invent individual students.\n- " + strings.Join(facts, "\n- ")
+	if record, ok := lookupTenant(tenant); ok {
+		prompt += record.Branding.promptStyle()
+	}
+	canned := "This cohort in brief: " + strings.Join(facts, "; ") + "."
+	return llmGenerate(context.Background(), LLMRequest{Prompt: prompt, Canned: canned}, tenant)
+}
+
+// cohortFacts describes a roster by its counts and distributions
+func cohortFacts(roster []Student) []string {
+	var totals rosterStats
+	tags := map[string]int{}
+	attributes := map[string]map[string]int{}
+	for _, student := range roster {
+		totals.add(student, 1)
+		for _, tag := range student.Tags {
+			tags[tag]++
+		}
+		for name, value := range student.Attributes {
+			if attributes[name] == nil {
+				attributes[name] = map[string]int{}
+			}
+			attributes[name][formatAttribute(value)]++
+		}
+	}
+	stats := totals.report()
+	facts := []string{fmt.Sprintf("%d students", stats.Count)}
+	if stats.Count == 0 {
+		return facts
+	}
+	facts = append(facts, fmt.Sprintf("ages %d to %d, median %d, mean %.1f", stats.MinAge, stats.MaxAge, stats.MedianAge, stats.MeanAge))
+	if len(tags) > 0 {
+		facts = append(facts, "tags: "+topCounts(tags, 10))
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		facts = append(facts, name+": "+topCounts(attributes[name], 5))
+	}
+	return facts
+}
+
+// topCounts renders the most frequent values, e.g. "red 12, blue 9"
+func topCounts(counts map[string]int, limit int) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	parts := make([]string, 0, limit)
+	for _, value := range values[:min(limit, len(values))] {
+		parts = append(parts, fmt.Sprintf("%s %d", value, counts[value]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// deliverReport builds a subscription's report and sends it
+func deliverReport(subscription ReportSubscription) ReportRun {
+	run := ReportRun{At: time.Now().UTC()}
+	err := func() error {
+		roster, err := reportRoster(subscription)
+		if err != nil {
+			return err
+		}
+		run.Students = len(roster)
+
+		generated := run.At.In(tenantLocation(subscription.Tenant))
+		title := "Student report"
+		if subscription.Name != "" {
+			title = subscription.Name
+		}
+		subject := fmt.Sprintf("%s, %s", title, generated.Format("2 Jan 2006"))
+		body := fmt.Sprintf("%d students, generated %s.", len(roster), generated.Format("2 Jan 2006 15:04 MST"))
+		if subscription.Filter != "" {
+			body += " Filter: " + subscription.Filter + "."
+		}
+
+		var attachment mailAttachment
+		base := "students-" + generated.Format("2006-01-02")
+		switch subscription.Format {
+		case ReportCSV:
+			attachment = mailAttachment{Name: base + ".csv", ContentType: "text/csv; charset=utf-8", Data: reportCSV(reportRows(roster, reportFieldNames(subscription.Tenant)))}
+		case ReportPDF:
+			attachment = mailAttachment{Name: base + ".pdf", ContentType: "application/pdf",
+				Data: reportPDF(subject+" - "+body, reportRows(roster, reportFieldNames(subscription.Tenant)))}
+		case ReportSummary:
+			summary, err := cohortSummary(subscription.Tenant, roster)
+			if err != nil {
+				return fmt.Errorf("failed to summarize: %v", err)
+			}
+			body += "\n\n" + summary
+			attachment = mailAttachment{ContentType: "text/plain; charset=utf-8", Data: []byte(summary)}
+		}
+		run.Bytes = len(attachment.Data)
+
+		if subscription.Email != "" {
+			if attachment.Name == "" {
+				return sendMail(subscription.Email, subject, body)
+			}
+			return sendMailWithAttachment(subscription.Email, subject, body, attachment)
+		}
+		request, err := http.NewRequest(http.MethodPost, subscription.WebhookURL, bytes.NewReader(attachment.Data))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", attachment.ContentType)
+		request.Header.Set("X-Report-Subscription", strconv.Itoa(subscription.ID))
+		request.Header.Set("X-Report-Format", subscription.Format)
+		request.Header.Set("X-Report-Students", strconv.Itoa(run.Students))
+		resp, err := reportWebhookClient.Do(request)
+		if err != nil {
+			return fmt.Errorf("failed to deliver webhook: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("webhook returned status: %d", resp.StatusCode)
+		}
+		return nil
+	}()
+	if err != nil {
+		run.Error = err.Error()
+	}
+	return run
+}
+
+// runReport delivers a subscription now and records the run
+func runReport(id int) (ReportRun, error) {
+	reportsMutex.Lock()
+	subscription, ok := reportSubscriptions[id]
+	if !ok {
+		reportsMutex.Unlock()
+		return ReportRun{}, errReportNotFound
+	}
+	if subscription.running {
+		reportsMutex.Unlock()
+		return ReportRun{}, errReportRunning
+	}
+	subscription.running = true
+	snapshot := *subscription
+	reportsMutex.Unlock()
+
+	run := deliverReport(snapshot)
+	if run.Error != "" {
+		slog.Warn("Failed to deliver report", "subscription", id, "error", run.Error)
+	}
+
+	reportsMutex.Lock()
+	subscription.running = false
+	subscription.LastRun = &run
+	reportsMutex.Unlock()
+	return run, nil
+}
+
+// runDueReports starts every subscription whose next run has come. A run
+// still going when the next is due is not started twice.
+func runDueReports(now time.Time) {
+	reportsMutex.Lock()
+	var due []int
+	for id, subscription := range reportSubscriptions {
+		if subscription.running || subscription.NextRun.IsZero() || subscription.NextRun.After(now) {
+			continue
+		}
+		subscription.NextRun = subscription.schedule.next(now.In(tenantLocation(subscription.Tenant))).UTC()
+		due = append(due, id)
+	}
+	reportsMutex.Unlock()
+	for _, id := range due {
+		go runReport(id)
+	}
+	// runReport checks running again, so a manual run racing this one
+	// only delivers once
+}
+
+// runReportScheduler checks for due reports every minute
+func runReportScheduler() {
+	for now := range time.Tick(time.Minute) {
+		runDueReports(now)
+	}
+}
+
+// findReportSubscription returns a subscription the caller's tenant can see.
+// Callers must hold reportsMutex.
+func findReportSubscription(r *http.Request) (*ReportSubscription, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil, false
+	}
+	subscription, ok := reportSubscriptions[id]
+	if !ok {
+		return nil, false
+	}
+	tenant := requestTenantName(r)
+	return subscription, tenant == "" || subscription.Tenant == tenant
+}
+
+// decodeReportSubscription reads and checks a subscription body. On failure
+// it has already written the error response.
+func decodeReportSubscription(w http.ResponseWriter, r *http.Request) (ReportSubscriptionRequest, cronSchedule, bool) {
+	var request ReportSubscriptionRequest
+	if err := decodeJSON(w, r, &request); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return request, cronSchedule{}, false
+	}
+	request.Format = strings.ToLower(request.Format)
+	if err := request.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return request, cronSchedule{}, false
+	}
+	if request.Filter != "" {
+		if _, ok := lookupSavedFilter(requestTenantName(r), request.Filter); !ok {
+			http.Error(w, "Invalid filter: "+errUnknownFilter.Error(), http.StatusBadRequest)
+			return request, cronSchedule{}, false
+		}
+	}
+	schedule, _ := parseCron(request.Schedule)
+	return request, schedule, true
+}
+
+func handleReportSubscriptionList(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenantName(r)
+	reportsMutex.Lock()
+	result := []ReportSubscription{}
+	for _, subscription := range reportSubscriptions {
+		if tenant == "" || subscription.Tenant == tenant {
+			result = append(result, *subscription)
+		}
+	}
+	reportsMutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleReportSubscriptionCreate(w http.ResponseWriter, r *http.Request) {
+	request, schedule, ok := decodeReportSubscription(w, r)
+	if !ok {
+		return
+	}
+	tenant := requestTenantName(r)
+	now := time.Now()
+
+	reportsMutex.Lock()
+	count := 0
+	for _, subscription := range reportSubscriptions {
+		if subscription.Tenant == tenant {
+			count++
+		}
+	}
+	if count >= maxReportSubscriptions {
+		reportsMutex.Unlock()
+		http.Error(w, fmt.Sprintf("A tenant can have at most %d report subscriptions", maxReportSubscriptions), http.StatusConflict)
+		return
+	}
+	reportSeq++
+	subscription := &ReportSubscription{
+		ID:                        reportSeq,
+		ReportSubscriptionRequest: request,
+		Tenant:                    tenant,
+		CreatedAt:                 now.UTC(),
+		NextRun:                   schedule.next(now.In(tenantLocation(tenant))).UTC(),
+		schedule:                  schedule,
+	}
+	reportSubscriptions[subscription.ID] = subscription
+	created := *subscription
+	reportsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func handleReportSubscriptionGet(w http.ResponseWriter, r *http.Request) {
+	reportsMutex.Lock()
+	subscription, ok := findReportSubscription(r)
+	var result ReportSubscription
+	if ok {
+		result = *subscription
+	}
+	reportsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Report subscription not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleReportSubscriptionUpdate replaces what a subscription delivers, where
+// and when, keeping its history
+func handleReportSubscriptionUpdate(w http.ResponseWriter, r *http.Request) {
+	request, schedule, ok := decodeReportSubscription(w, r)
+	if !ok {
+		return
+	}
+	reportsMutex.Lock()
+	subscription, ok := findReportSubscription(r)
+	if !ok {
+		reportsMutex.Unlock()
+		http.Error(w, "Report subscription not found", http.StatusNotFound)
+		return
+	}
+	subscription.ReportSubscriptionRequest = request
+	subscription.schedule = schedule
+	subscription.NextRun = schedule.next(time.Now().In(tenantLocation(subscription.Tenant))).UTC()
+	updated := *subscription
+	reportsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func handleReportSubscriptionDelete(w http.ResponseWriter, r *http.Request) {
+	reportsMutex.Lock()
+	subscription, ok := findReportSubscription(r)
+	if ok {
+		delete(reportSubscriptions, subscription.ID)
+	}
+	reportsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Report subscription not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReportSubscriptionRun delivers a report now, outside its schedule
+func handleReportSubscriptionRun(w http.ResponseWriter, r *http.Request) {
+	reportsMutex.Lock()
+	subscription, ok := findReportSubscription(r)
+	id := 0
+	if ok {
+		id = subscription.ID
+	}
+	reportsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Report subscription not found", http.StatusNotFound)
+		return
+	}
+	run, err := runReport(id)
+	if err != nil {
+		writeError(w, r, err, "Failed to deliver report")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if run.Error != "" {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(run)
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Some clients predate the API's shape: they expect every response wrapped
+// as {"data": ..., "meta": {...}}, or camelCase member names. Handlers encode
+// responses one way, and shapeResponses reshapes the JSON they write for
+// clients that want another, so no handler has to know.
+
+const (
+	EnvelopeNone = "none" // the value itself
+	EnvelopeData = "data" // {"data": value, "meta": {...}}
+
+	NamingSnake = "snake" // as the handlers encode it
+	NamingCamel = "camel"
+)
+
+// responseEnvelope and responseNaming are the defaults for requests and keys
+// that don't choose
+var (
+	responseEnvelope = EnvelopeNone
+	responseNaming   = NamingSnake
+)
+
+func validEnvelope(mode string) bool {
+	return mode == EnvelopeNone || mode == EnvelopeData
+}
+
+func validNaming(mode string) bool {
+	return mode == NamingSnake || mode == NamingCamel
+}
+
+// verbatimMembers hold objects keyed by data, such as attribute and tenant
+// names, whose keys are kept as they are when renaming
+var verbatimMembers = map[string]bool{
+	"attributes": true,
+	"tenants":    true,
+	"relations":  true,
+	"issues":     true,
+	"checked":    true,
+	"stages":     true,
+}
+
+// responseShape is how a request's JSON responses are shaped
+type responseShape struct {
+	envelope string
+	naming   string
+	// dataMember is the member of the response holding its data, set by
+	// handlers whose responses are a page; the rest of it goes in meta
+	dataMember string
+}
+
+type responseShapeContextKey struct{}
+
+// responseShapeFor returns the shape for a request: the X-Response-Envelope
+// and X-Response-Naming headers, else the key's own settings, else the
+// global ones
+func responseShapeFor(r *http.Request) (*responseShape, error) {
+	shape := &responseShape{envelope: responseEnvelope, naming: responseNaming}
+	if key := keyFromContext(r.Context()); key != nil {
+		if key.Envelope != "" {
+			shape.envelope = key.Envelope
+		}
+		if key.Naming != "" {
+			shape.naming = key.Naming
+		}
+	}
+	if value := r.Header.Get("X-Response-Envelope"); value != "" {
+		if !validEnvelope(value) {
+			return nil, fmt.Errorf("X-Response-Envelope must be none or data")
+		}
+		shape.envelope = value
+	}
+	if value := r.Header.Get("X-Response-Naming"); value != "" {
+		if !validNaming(value) {
+			return nil, fmt.Errorf("X-Response-Naming must be snake or camel")
+		}
+		shape.naming = value
+	}
+	return shape, nil
+}
+
+// setResponseData names the member of the response that holds its data, for
+// enveloped responses
+func setResponseData(r *http.Request, member string) {
+	if shape, ok := r.Context().Value(responseShapeContextKey{}).(*responseShape); ok {
+		shape.dataMember = member
+	}
+}
+
+// renameEventData applies a request's naming to the JSON of an event sent
+// on a stream, which shapeResponses passes through. Events aren't enveloped.
+func renameEventData(r *http.Request, data []byte) []byte {
+	shape, ok := r.Context().Value(responseShapeContextKey{}).(*responseShape)
+	if !ok || shape.naming != NamingCamel {
+		return data
+	}
+	renamed, err := renameJSONKeys(data, camelCase)
+	if err != nil {
+		slog.Warn("Failed to rename event", "path", r.URL.Path, "error", err)
+		return data
+	}
+	return renamed
+}
+
+// plain reports whether responses go out as the handlers write them
+func (s *responseShape) plain() bool {
+	return s.envelope == EnvelopeNone && s.naming == NamingSnake
+}
+
+// reshape applies the shape to a JSON response body
+func (s *responseShape) reshape(body []byte) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if s.naming == NamingCamel {
+		var err error
+		if body, err = renameJSONKeys(body, camelCase); err != nil {
+			return nil, err
+		}
+	}
+	if s.envelope == EnvelopeData {
+		return envelopeJSON(body, s.dataMember)
+	}
+	return body, nil
+}
+
+// camelCase turns a snake_case name into camelCase; other names are kept
+func camelCase(name string) string {
+	if !strings.Contains(name, "_") || strings.IndexFunc(name, func(c rune) bool {
+		return c != '_' && !unicode.IsLower(c) && !unicode.IsDigit(c)
+	}) >= 0 {
+		return name
+	}
+	parts := strings.Split(strings.Trim(name, "_"), "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// jsonFrame is an object or array being renamed
+type jsonFrame struct {
+	object   bool
+	tokens   int  // keys and values so far
+	verbatim bool // keys are data, kept as they are
+}
+
+// renameJSONKeys renames the members of every object in a JSON value,
+// keeping their order, except in verbatimMembers
+func renameJSONKeys(data []byte, rename func(string) string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	out := make([]byte, 0, len(data))
+	var stack []jsonFrame
+	verbatimNext := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			out = append(out, byte(delim))
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].tokens++
+			}
+			continue
+		}
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		isKey := top != nil && top.object && top.tokens%2 == 0
+		if top != nil && top.tokens > 0 {
+			if top.object && !isKey {
+				out = append(out, ':')
+			} else {
+				out = append(out, ',')
+			}
+		}
+		switch value := token.(type) {
+		case json.Delim:
+			out = append(out, byte(value))
+			stack = append(stack, jsonFrame{object: value == '{', verbatim: verbatimNext})
+			verbatimNext = false
+			continue
+		case string:
+			if isKey {
+				verbatimNext = verbatimMembers[value]
+				if !top.verbatim {
+					value = rename(value)
+				}
+			}
+			out = appendJSONString(out, value)
+		case json.Number:
+			out = append(out, value...)
+		case bool:
+			out = strconv.AppendBool(out, value)
+		case nil:
+			out = append(out, "null"...)
+		}
+		if !isKey {
+			verbatimNext = false
+		}
+		if top != nil {
+			top.tokens++
+		}
+	}
+}
+
+// envelopeJSON wraps a JSON value as {"data": ..., "meta": {...}}. With a
+// dataMember, the value must be an object: that member is the data and the
+// others, in order, the meta.
+func envelopeJSON(body []byte, dataMember string) ([]byte, error) {
+	if dataMember == "" {
+		out := append([]byte(`{"data":`), body...)
+		return append(out, `,"meta":{}}`...), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("response with a data member isn't an object")
+	}
+	data := json.RawMessage("null")
+	meta := []byte{'{'}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		if key == dataMember {
+			data = value
+			continue
+		}
+		if len(meta) > 1 {
+			meta = append(meta, ',')
+		}
+		meta = appendJSONString(meta, key)
+		meta = append(meta, ':')
+		meta = append(meta, value...)
+	}
+	out := append([]byte(`{"data":`), data...)
+	out = append(out, `,"meta":`...)
+	out = append(out, meta...)
+	return append(out, `}}`...), nil
+}
+
+// shapedWriter holds back successful JSON responses so they can be reshaped
+// once the handler is done. Anything else, streams included, passes through.
+type shapedWriter struct {
+	http.ResponseWriter
+	shape       *responseShape
+	status      int
+	wroteHeader bool
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (w *shapedWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	w.buffering = status/100 == 2 && status != http.StatusNoContent && mediaType == "application/json"
+	if !w.buffering {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *shapedWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush passes through for responses that aren't held back
+func (w *shapedWriter) Flush() {
+	if !w.buffering {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+func (w *shapedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes a held back response, reshaped
+func (w *shapedWriter) finish(r *http.Request) {
+	if !w.buffering {
+		return
+	}
+	body := w.body.Bytes()
+	if len(body) > 0 {
+		reshaped, err := w.shape.reshape(body)
+		if err != nil {
+			slog.Warn("Failed to reshape response", "path", r.URL.Path, "error", err)
+		} else {
+			body = reshaped
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+// shapeResponses reshapes JSON responses for requests and keys that ask for
+// an envelope or camelCase names
+func shapeResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-Response-Envelope, X-Response-Naming")
+		shape, err := responseShapeFor(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if shape.plain() || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), responseShapeContextKey{}, shape))
+		shaped := &shapedWriter{ResponseWriter: w, shape: shape}
+		next.ServeHTTP(shaped, r)
+		shaped.finish(r)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy says how long data is kept. Zero keeps it forever.
+type RetentionPolicy struct {
+	ChangeFeedDays int `json:"change_feed_days"` // entries of /students/changes
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.ChangeFeedDays < 0 {
+		return fmt.Errorf("retention: change_feed_days must not be negative")
+	}
+	if p.ChangeFeedDays > 0 && eventSourced {
+		return fmt.Errorf("retention: change_feed_days can't be set in event-sourced mode, where the feed is the record")
+	}
+	return nil
+}
+
+// RetentionResult is what one rule purged, or would purge in a dry run
+type RetentionResult struct {
+	Rule   string    `json:"rule"`
+	Cutoff time.Time `json:"cutoff"`
+	Purged int       `json:"purged"`
+}
+
+type RetentionReport struct {
+	DryRun  bool              `json:"dry_run"`
+	RanAt   time.Time         `json:"ran_at"`
+	Results []RetentionResult `json:"results"`
+}
+
+var (
+	retention       RetentionPolicy
+	lastRetention   *RetentionReport
+	retentionMutex  sync.Mutex
+	retentionPeriod = time.Hour
+)
+
+func setRetentionPolicy(policy RetentionPolicy) {
+	retentionMutex.Lock()
+	retention = policy
+	retentionMutex.Unlock()
+}
+
+// applyRetention runs every rule once. Each purged record is logged as an
+// audit entry; a dry run only counts.
+func applyRetention(dryRun bool) RetentionReport {
+	retentionMutex.Lock()
+	defer retentionMutex.Unlock()
+
+	report := RetentionReport{DryRun: dryRun, RanAt: time.Now().UTC(), Results: []RetentionResult{}}
+	if retention.ChangeFeedDays > 0 {
+		cutoff := report.RanAt.AddDate(0, 0, -retention.ChangeFeedDays)
+		result := RetentionResult{Rule: "change_feed", Cutoff: cutoff}
+
+		mutex.Lock()
+		// The feed is in commit order, so expired entries form a prefix
+		for result.Purged < len(changes) && changes[result.Purged].OccurredAt.Before(cutoff) {
+			if !dryRun {
+				change := changes[result.Purged]
+				slog.Info("Retention purged change", "rule", result.Rule, "change", change.ID,
+					"event", change.Event, "student", change.Student.ID, "occurred_at", change.OccurredAt)
+			}
+			result.Purged++
+		}
+		if !dryRun {
+			changes = append([]Change{}, changes[result.Purged:]...)
+		}
+		mutex.Unlock()
+		report.Results = append(report.Results, result)
+	}
+
+	if !dryRun {
+		lastRetention = &report
+	}
+	return report
+}
+
+// runRetention applies the policy every retentionPeriod
+func runRetention() {
+	for range time.Tick(retentionPeriod) {
+		applyRetention(false)
+	}
+}
+
+func handleRetentionGet(w http.ResponseWriter, r *http.Request) {
+	retentionMutex.Lock()
+	response := map[string]interface{}{"policy": retention, "last_run": lastRetention}
+	retentionMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleRetentionRun applies the policy now. With ?dry_run=true it only
+// reports what would be purged.
+func handleRetentionRun(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid dry_run: must be true or false", http.StatusBadRequest)
+			return
+		}
+		dryRun = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applyRetention(dryRun))
+}
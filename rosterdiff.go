@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// studentState is a student as of some time. known is false when the change
+// feed no longer holds what the student looked like then, only that it
+// existed.
+type studentState struct {
+	student Student
+	exists  bool
+	known   bool
+}
+
+// rosterAtLocked rebuilds the roster as of t by walking the change feed back
+// from the current roster. It also returns the revision the roster was at.
+// Callers must hold mutex.
+func rosterAtLocked(t time.Time) (map[int64]studentState, int64) {
+	states := make(map[int64]studentState, len(students))
+	for _, student := range students {
+		states[student.ID] = studentState{student: student, exists: true, known: true}
+	}
+	k := len(changes)
+	for ; k > 0 && changes[k-1].OccurredAt.After(t); k-- {
+		change := changes[k-1]
+		switch change.Event {
+		case EventStudentCreated:
+			states[change.Student.ID] = studentState{known: true}
+		case EventStudentDeleted:
+			states[change.Student.ID] = studentState{student: change.Student, exists: true, known: true}
+		default:
+			states[change.Student.ID] = studentState{student: change.Student, exists: true}
+		}
+	}
+	// An update's previous state is whatever the write before it left
+	for i := k - 1; i >= 0; i-- {
+		change := changes[i]
+		if state, ok := states[change.Student.ID]; ok && !state.known && change.Event != EventStudentDeleted {
+			states[change.Student.ID] = studentState{student: change.Student, exists: true, known: true}
+		}
+	}
+
+	revision := oldestRevisionLocked()
+	if k > 0 {
+		revision = changes[k-1].ID
+	}
+	return states, revision
+}
+
+// changedBetweenLocked reports whether a student was written after from and
+// up to to. Callers must hold mutex.
+func changedBetweenLocked(id int64, from, to time.Time) bool {
+	for i := len(changes) - 1; i >= 0 && changes[i].OccurredAt.After(from); i-- {
+		if changes[i].Student.ID == id && !changes[i].OccurredAt.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// RosterChange is a student in both snapshots whose fields differ. Fields is
+// omitted when the feed no longer shows what the student was like at from.
+type RosterChange struct {
+	Student Student     `json:"student"` // as of to
+	Fields  []FieldDiff `json:"fields,omitempty"`
+}
+
+type RosterDiffSummary struct {
+	FromCount int `json:"from_count"`
+	ToCount   int `json:"to_count"`
+	Joined    int `json:"joined"`
+	Left      int `json:"left"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+}
+
+type RosterDiff struct {
+	From         time.Time         `json:"from"`
+	To           time.Time         `json:"to"`
+	FromRevision int64             `json:"from_revision"`
+	ToRevision   int64             `json:"to_revision"`
+	Complete     bool              `json:"complete"` // false when some changes are missing their fields
+	Summary      RosterDiffSummary `json:"summary"`
+	Joined       []Student         `json:"joined"`
+	Left         []Student         `json:"left"` // as of from
+	Changed      []RosterChange    `json:"changed"`
+}
+
+// handleRosterDiff compares the roster at two times: who joined, who left
+// and who changed in between, and how
+func handleRosterDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("from") == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTime(r, query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from: must be a date or timestamp", http.StatusBadRequest)
+		return
+	}
+	to := time.Now().UTC()
+	if query.Get("to") != "" {
+		if to, err = parseTime(r, query.Get("to")); err != nil {
+			http.Error(w, "Invalid to: must be a date or timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	tenant := requestTenantName(r)
+
+	mutex.RLock()
+	before, fromRevision := rosterAtLocked(from)
+	after, toRevision := rosterAtLocked(to)
+	diff := RosterDiff{
+		From: localTime(r, from), To: localTime(r, to),
+		FromRevision: fromRevision, ToRevision: toRevision,
+		Complete: true,
+		Joined:   []Student{}, Left: []Student{}, Changed: []RosterChange{},
+	}
+	ids := make([]int64, 0, len(after))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		was, is := before[id], after[id]
+		if was.exists && !visibleTo(tenant, was.student) || is.exists && !visibleTo(tenant, is.student) {
+			continue
+		}
+		if was.exists {
+			diff.Summary.FromCount++
+		}
+		if is.exists {
+			diff.Summary.ToCount++
+		}
+		switch {
+		case !was.exists && is.exists:
+			diff.Joined = append(diff.Joined, is.student)
+		case was.exists && !is.exists:
+			diff.Left = append(diff.Left, was.student)
+		case !was.exists:
+		case was.known && is.known:
+			fields := diffStudents(DiffSide{Exists: true, Student: &was.student}, DiffSide{Exists: true, Student: &is.student})
+			if len(fields) > 0 {
+				diff.Changed = append(diff.Changed, RosterChange{Student: is.student, Fields: fields})
+			} else {
+				diff.Summary.Unchanged++
+			}
+		case changedBetweenLocked(id, from, to):
+			diff.Complete = false
+			diff.Changed = append(diff.Changed, RosterChange{Student: is.student})
+		default:
+			diff.Summary.Unchanged++
+		}
+	}
+	mutex.RUnlock()
+	diff.Summary.Joined, diff.Summary.Left, diff.Summary.Changed = len(diff.Joined), len(diff.Left), len(diff.Changed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+)
+
+// Route describes one endpoint. The registry drives the mux, the introduction
+// page and the generated API docs, so every endpoint is declared exactly once.
+type Route struct {
+	Method      string
+	Path        string
+	Description string
+	Handler     http.HandlerFunc
+	Example     interface{}  // example JSON request body, if the route takes one
+	Body        interface{}  // a value of the type the body decodes into, published at /schemas/
+	Query       string       // example query string, if the route takes one
+	Scope       string       // scope a key needs; admin routes default to admin:<area>
+	Since       string       // API version that added the route; empty is 1.0.0. See changelog.go
+	Deprecated  *Deprecation // set when the route is on its way out; see deprecation.go
+	Shed        bool         // heavy and not core CRUD: refused while the server sheds load; see loadshed.go
+}
+
+func (rt Route) Admin() bool {
+	return isAdminPath(rt.Path)
+}
+
+var exampleStudent = map[string]interface{}{"name": "John Doe", "age": 20, "email": "john.doe@example.com"}
+
+func apiRoutes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: "/students", Scope: ScopeStudentsRead, Description: "Get all students, or a page of them, now or as they were at a time", Handler: handleStudents,
+			Query: "q=doe&name=ann&min_age=10&max_age=14&tag=choir&attributes.house=red&filter=choir-reds&near=39.78,-89.65&radius=3&quality_below=60&as_of=2024-12-01T00:00:00Z&sort=-age&page=2&limit=25"},
+		{Method: http.MethodPost, Path: "/students", Scope: ScopeStudentsWrite, Description: "Create a new student", Handler: handleCreateStudent, Body: Student{}, Example: exampleStudent},
+		{Method: http.MethodGet, Path: "/students/{id}", Scope: ScopeStudentsRead, Description: "Get a student, now or as it was at a time", Handler: handleGetStudent, Query: "as_of=2024-12-01T00:00:00Z"},
+		{Method: http.MethodPut, Path: "/students/{id}", Scope: ScopeStudentsWrite, Description: "Update a student", Handler: handleUpdateStudent, Body: Student{}, Example: exampleStudent},
+		{Method: http.MethodDelete, Path: "/students/{id}", Scope: ScopeStudentsWrite, Description: "Delete a student", Handler: handleDeleteStudent},
+		{Method: http.MethodPost, Path: "/students/{id}/tags", Scope: ScopeStudentsWrite, Description: "Add tags to a student", Handler: handleStudentTagsAdd, Body: TagRequest{},
+			Example: map[string]interface{}{"tags": []string{"choir", "needs-bus"}}},
+		{Method: http.MethodDelete, Path: "/students/{id}/tags/{tag}", Scope: ScopeStudentsWrite, Description: "Remove a tag from a student", Handler: handleStudentTagRemove},
+		{Method: http.MethodGet, Path: "/students/birthdays", Scope: ScopeStudentsRead, Description: "List the birthdays in a month", Handler: handleBirthdays, Query: "month=4&tag=choir"},
+		{Method: http.MethodGet, Path: "/students/birthdays/upcoming", Scope: ScopeStudentsRead, Description: "List the birthdays in the next few days", Handler: handleUpcomingBirthdays, Query: "days=14"},
+		{Method: http.MethodGet, Path: "/students/cohorts", Scope: ScopeStudentsRead, Description: "Group students by age or enrollment year", Handler: handleCohorts, Query: "by=enrollment_year"},
+		{Method: http.MethodGet, Path: "/students/{id}/versions", Scope: ScopeStudentsRead, Description: "List the revisions that wrote a student", Handler: handleStudentVersions},
+		{Method: http.MethodGet, Path: "/students/{id}/diff", Scope: ScopeStudentsRead, Description: "Compare two versions of a student field by field", Handler: handleStudentDiff, Query: "version=12&against=7"},
+		{Method: http.MethodGet, Path: "/diff", Scope: ScopeStudentsRead, Description: "Compare two students field by field", Handler: handleDiff, Query: "left=1&right=2"},
+		{Method: http.MethodGet, Path: "/students/{id}/edit", Scope: ScopeStudentsWrite, Description: "Get the inline edit form for a student (HTML fragment)", Handler: handleStudentEditRow},
+		{Method: http.MethodGet, Path: "/students/{id}/summary", Shed: true, Scope: ScopeSummariesGenerate, Description: "Get a summary of a student", Handler: handleStudentSummary},
+		{Method: http.MethodGet, Path: "/students/{id}/summary/stream", Shed: true, Scope: ScopeSummariesGenerate, Description: "Stream a summary of a student as server-sent events", Handler: handleStudentSummaryStream,
+			Since: "1.4.0"},
+		{Method: http.MethodGet, Path: "/students/export", Shed: true, Scope: ScopeStudentsRead, Description: "Export the roster as JSON or GeoJSON, optionally anonymized and filtered", Handler: handleExport, Query: "format=geojson&group_by=attributes.section&filter=choir-reds"},
+		{Method: http.MethodPost, Path: "/links", Description: "Create a time-limited signed link to a student, summary or export", Handler: handleSignURL, Body: SignURLRequest{},
+			Example: map[string]interface{}{"path": "/students/1/summary", "ttl": "48h"}},
+		{Method: http.MethodPost, Path: "/students/export/google-sheet", Shed: true, Scope: ScopeStudentsRead, Description: "Export students to a Google Sheet", Handler: handleGoogleSheetExport, Body: SheetExportRequest{},
+			Example: map[string]interface{}{"spreadsheet_id": "1AbC...xyz", "sheet": "Roster", "mode": "replace"}},
+		{Method: http.MethodGet, Path: "/filters", Scope: ScopeStudentsRead, Description: "List saved filters", Handler: handleFilterList},
+		{Method: http.MethodPost, Path: "/filters", Scope: ScopeStudentsWrite, Description: "Save a named filter for the list, exports and reports", Handler: handleFilterCreate, Body: SavedFilter{},
+			Example: map[string]interface{}{"name": "choir-reds", "tags": []string{"choir"}, "attributes": map[string]string{"house": "red"}}},
+		{Method: http.MethodDelete, Path: "/filters/{name}", Scope: ScopeStudentsWrite, Description: "Delete a saved filter", Handler: handleFilterDelete},
+		{Method: http.MethodGet, Path: "/report-subscriptions", Scope: ScopeReportsRead, Description: "List scheduled report subscriptions", Handler: handleReportSubscriptionList},
+		{Method: http.MethodPost, Path: "/report-subscriptions", Scope: ScopeReportsWrite, Description: "Subscribe to a report of a saved filter, by email or webhook on a cron schedule", Handler: handleReportSubscriptionCreate, Body: ReportSubscriptionRequest{},
+			Example: map[string]interface{}{"name": "Weekly choir list", "filter": "choir-reds", "format": ReportPDF, "schedule": "0 7 * * 1", "email": "office@springfield.edu"}},
+		{Method: http.MethodGet, Path: "/report-subscriptions/{id}", Scope: ScopeReportsRead, Description: "Get a report subscription and its last run", Handler: handleReportSubscriptionGet},
+		{Method: http.MethodPut, Path: "/report-subscriptions/{id}", Scope: ScopeReportsWrite, Description: "Change a report subscription", Handler: handleReportSubscriptionUpdate, Body: ReportSubscriptionRequest{},
+			Example: map[string]interface{}{"format": ReportSummary, "schedule": "@monthly", "webhook_url": "https://example.com/reports"}},
+		{Method: http.MethodDelete, Path: "/report-subscriptions/{id}", Scope: ScopeReportsWrite, Description: "Cancel a report subscription", Handler: handleReportSubscriptionDelete},
+		{Method: http.MethodPost, Path: "/report-subscriptions/{id}/run", Shed: true, Scope: ScopeReportsWrite, Description: "Deliver a subscribed report now", Handler: handleReportSubscriptionRun},
+		{Method: http.MethodGet, Path: "/fields", Scope: ScopeStudentsRead, Description: "List the custom fields students' attributes are checked against", Handler: handleFieldList},
+		{Method: http.MethodPut, Path: "/fields/{name}", Scope: ScopeStudentsWrite, Description: "Define or redefine a custom field", Handler: handleFieldPut, Body: CustomField{},
+			Example: map[string]interface{}{"type": FieldEnum, "required": true, "options": []string{"red", "green", "blue", "yellow"}}},
+		{Method: http.MethodDelete, Path: "/fields/{name}", Scope: ScopeStudentsWrite, Description: "Delete a custom field and remove it from students", Handler: handleFieldDelete},
+		{Method: http.MethodGet, Path: "/reports/roster-diff", Shed: true, Scope: ScopeStudentsRead, Description: "Compare the roster at two dates: who joined, left or changed", Handler: handleRosterDiff, Query: "from=2024-09-01&to=2025-01-01"},
+		{Method: http.MethodGet, Path: "/stats/students", Scope: ScopeStudentsRead, Description: "Get student counts and the age distribution", Handler: handleStudentStats},
+		{Method: http.MethodGet, Path: "/stats/data-quality", Shed: true, Scope: ScopeStudentsRead, Description: "Score how complete and valid student records are, worst first", Handler: handleDataQuality, Query: "quality_below=60&limit=20&tag=choir"},
+		{Method: http.MethodPost, Path: "/students/import", Shed: true, Scope: ScopeStudentsWrite, Description: "Import students from a CSV with name, age and email columns, in the background", Handler: handleImport},
+		{Method: http.MethodGet, Path: "/imports/{id}", Scope: ScopeStudentsWrite, Description: "Show the progress and row errors of a CSV import", Handler: handleImportGet},
+		{Method: http.MethodDelete, Path: "/imports/{id}", Scope: ScopeStudentsWrite, Description: "Cancel a CSV import; rows already imported stay", Handler: handleImportCancel},
+		{Method: http.MethodGet, Path: "/students/changes", Scope: ScopeStudentsRead, Description: "Poll for roster changes", Handler: handleChanges, Query: "since=0"},
+		{Method: http.MethodGet, Path: "/events", Scope: ScopeStudentsRead, Description: "Stream the event log as NDJSON or server-sent events", Handler: handleEvents, Query: "from=1"},
+		{Method: http.MethodGet, Path: "/ws", Scope: ScopeStudentsRead, Description: "Push roster events and stream summaries over a WebSocket", Handler: handleWebSocket, Query: "from=1", Since: "1.1.0"},
+		{Method: http.MethodGet, Path: "/sync", Scope: ScopeStudentsRead, Description: "Get changes since a revision for offline clients", Handler: handleSyncGet, Query: "since=0"},
+		{Method: http.MethodPost, Path: "/sync", Scope: ScopeStudentsWrite, Description: "Apply offline edits", Handler: handleSyncPost, Body: SyncRequest{},
+			Example: map[string]interface{}{"base_revision": 0, "operations": []map[string]interface{}{{"op": SyncCreate, "student": exampleStudent}}}},
+		{Method: http.MethodGet, Path: "/conflicts", Scope: ScopeStudentsRead, Description: "List sync conflicts awaiting review", Handler: handleConflictList},
+		{Method: http.MethodPost, Path: "/conflicts/{id}/resolve", Scope: ScopeStudentsWrite, Description: "Resolve a sync conflict", Handler: handleConflictResolve, Body: ConflictResolveRequest{},
+			Example: map[string]interface{}{"resolution": "client"}},
+		{Method: http.MethodGet, Path: "/hooks", Scope: ScopeHooksRead, Description: "List REST hooks", Handler: handleHookList},
+		{Method: http.MethodPost, Path: "/hooks", Scope: ScopeHooksWrite, Description: "Subscribe a REST hook", Handler: handleHookSubscribe, Body: Hook{},
+			Example: map[string]interface{}{"target_url": "https://hooks.zapier.com/...", "event": EventStudentCreated}},
+		{Method: http.MethodDelete, Path: "/hooks/{id}", Scope: ScopeHooksWrite, Description: "Unsubscribe a REST hook", Handler: handleHookUnsubscribe},
+		{Method: http.MethodGet, Path: "/public/roster", Description: "Show a tenant's published class list, as JSON or HTML", Handler: handlePublicRoster, Query: "tenant=springfield&format=html"},
+		{Method: http.MethodPost, Path: "/signup", Description: "Sign up a new tenant when self-service signup is enabled", Handler: handleSignup, Body: SignupRequest{},
+			Example: map[string]interface{}{"tenant": "shelbyville", "email": "principal@shelbyville.edu"}},
+		{Method: http.MethodGet, Path: "/signup/verify", Description: "Confirm a signup email and receive the tenant's first API key", Handler: handleSignupVerify, Query: "token=..."},
+		{Method: http.MethodPost, Path: "/auth/register", Scope: "admin:users", Description: "Register a user (admin keys only)", Handler: handleRegister, Body: RegisterRequest{},
+			Example: map[string]interface{}{"email": "teacher@springfield.edu", "password": "correct horse battery", "role": RoleWrite, "tenant": "springfield"}},
+		{Method: http.MethodPost, Path: "/auth/login", Description: "Sign in with email and password and receive an access key and refresh token", Handler: handleLogin, Body: LoginRequest{},
+			Example: map[string]interface{}{"email": "teacher@springfield.edu", "password": "correct horse battery"}},
+		{Method: http.MethodPost, Path: "/auth/refresh", Description: "Exchange a refresh token for a new access key and refresh token", Handler: handleRefresh, Body: RefreshRequest{},
+			Example: map[string]interface{}{"refresh_token": "fxr_..."}},
+		{Method: http.MethodPost, Path: "/auth/logout", Description: "End the current session", Handler: handleLogout},
+		{Method: http.MethodGet, Path: "/auth/sessions", Description: "List the signed-in user's sessions", Handler: handleSessionList},
+		{Method: http.MethodDelete, Path: "/auth/sessions/{id}", Description: "Revoke one of the signed-in user's sessions", Handler: handleSessionRevoke},
+		{Method: http.MethodPost, Path: "/auth/password-reset", Description: "Email a password reset token", Handler: handlePasswordResetRequest, Body: PasswordResetRequest{},
+			Example: map[string]interface{}{"email": "teacher@springfield.edu"}},
+		{Method: http.MethodPost, Path: "/auth/password-reset/confirm", Description: "Set a new password with a reset token", Handler: handlePasswordResetConfirm, Body: PasswordResetConfirmRequest{},
+			Example: map[string]interface{}{"token": "...", "password": "a new long passphrase"}},
+		{Method: http.MethodPost, Path: "/auth/totp/enroll", Description: "Start enrolling a TOTP authenticator for the signed-in user", Handler: handleTOTPEnroll},
+		{Method: http.MethodPost, Path: "/auth/totp/confirm", Description: "Confirm TOTP enrollment with a first code and receive backup codes", Handler: handleTOTPConfirm, Body: TOTPConfirmRequest{},
+			Example: map[string]interface{}{"code": "123456"}},
+		{Method: http.MethodGet, Path: "/limits", Description: "Show quota usage", Handler: handleLimits},
+		{Method: http.MethodGet, Path: "/version", Description: "Show the server's version, build and enabled feature flags", Handler: handleVersion, Since: "1.1.0"},
+		{Method: http.MethodGet, Path: "/sdk/typescript.zip", Shed: true, Description: "Download the TypeScript client", Handler: handleTypeScriptSDK},
+		{Method: http.MethodGet, Path: "/schemas/", Description: "List the JSON Schemas of request bodies", Handler: handleSchemaIndex},
+		{Method: http.MethodGet, Path: "/schemas/{name}", Description: "Get the JSON Schema of a request body", Handler: handleSchema},
+		{Method: http.MethodGet, Path: "/docs/postman.json", Shed: true, Description: "Download a Postman collection", Handler: handlePostmanCollection},
+		{Method: http.MethodGet, Path: "/meta/changes", Description: "List the API's changes by version, for automated compatibility checks", Handler: handleAPIChanges, Query: "since=1.0.0"},
+		{Method: http.MethodGet, Path: "/docs/postman-environment.json", Shed: true, Description: "Download a Postman environment", Handler: handlePostmanEnvironment},
+
+		{Method: http.MethodPut, Path: "/admin/students/{id}/legal-hold", Description: "Place or lift a legal hold on a student", Handler: handleLegalHoldSet, Body: LegalHoldRequest{},
+			Example: map[string]interface{}{"enabled": true, "reason": "Case 2026-114"}},
+		{Method: http.MethodGet, Path: "/admin/tenants", Description: "List tenants", Handler: handleTenantList},
+		{Method: http.MethodPost, Path: "/admin/tenants", Description: "Create a tenant", Handler: handleTenantCreate, Body: Tenant{},
+			Example: map[string]interface{}{"name": "springfield", "plan": "school", "max_students": 500, "max_llm_calls_per_day": 200,
+				"prompt_template": "Summarize {{.Name}}, age {{.Age}}, for a report card."}},
+		{Method: http.MethodGet, Path: "/admin/tenants/{name}", Description: "Get a tenant", Handler: handleTenantGet},
+		{Method: http.MethodPatch, Path: "/admin/tenants/{name}", Description: "Update a tenant's plan, quotas, prompt, locale or branding", Handler: handleTenantUpdate, Body: TenantUpdate{},
+			Example: map[string]interface{}{"locale": "hi", "branding": map[string]string{"school_name": "Springfield Elementary", "logo_url": "https://example.com/logo.png", "summary_tone": "warm"}}},
+		{Method: http.MethodDelete, Path: "/admin/tenants/{name}", Description: "Delete a tenant with its students, users, hooks and API keys", Handler: handleTenantDelete},
+		{Method: http.MethodPost, Path: "/admin/tenants/{name}/suspend", Description: "Suspend a tenant's API keys", Handler: setTenantStatus(TenantSuspended)},
+		{Method: http.MethodPost, Path: "/admin/tenants/{name}/resume", Description: "Reactivate a suspended tenant", Handler: setTenantStatus(TenantActive)},
+		{Method: http.MethodGet, Path: "/admin/usage", Description: "Show metered usage per tenant for a month", Handler: handleUsage, Query: "month=2026-10"},
+		{Method: http.MethodGet, Path: "/admin/usage.csv", Description: "Download monthly usage per tenant as CSV for invoicing", Handler: handleUsageCSV, Query: "month=2026-10"},
+		{Method: http.MethodGet, Path: "/admin/users", Description: "List users", Handler: handleUserList},
+		{Method: http.MethodPost, Path: "/admin/users/{id}/disable", Description: "Disable a user and revoke their sign-ins", Handler: setUserDisabled(true)},
+		{Method: http.MethodPost, Path: "/admin/users/{id}/enable", Description: "Re-enable a disabled user", Handler: setUserDisabled(false)},
+		{Method: http.MethodPost, Path: "/admin/users/{id}/totp/reset", Description: "Turn off two-factor authentication for a user who lost their device", Handler: handleTOTPReset},
+		{Method: http.MethodPost, Path: "/admin/impersonate", Description: "Get a time-limited key acting as a user or tenant, for support", Handler: handleImpersonate, Body: ImpersonationRequest{},
+			Example: map[string]interface{}{"tenant": "springfield", "role": RoleRead, "reason": "Ticket 4521: roster looks empty", "ttl": "30m"}},
+		{Method: http.MethodGet, Path: "/admin/bans", Description: "List clients temporarily banned for failed requests", Handler: handleBanList},
+		{Method: http.MethodDelete, Path: "/admin/bans/{ip}", Description: "Lift a ban early", Handler: handleUnban},
+		{Method: http.MethodGet, Path: "/admin/ip-rules", Description: "List IP allow/deny rules per route group", Handler: handleIPRuleList},
+		{Method: http.MethodPut, Path: "/admin/ip-rules/{group}", Description: "Set the IP rule for a route group (all, admin, auth or api)", Handler: handleIPRuleSet, Body: IPRule{},
+			Example: map[string]interface{}{"allow": []string{"10.20.0.0/16"}, "deny": []string{}}},
+		{Method: http.MethodDelete, Path: "/admin/ip-rules/{group}", Description: "Remove a route group's IP rule", Handler: handleIPRuleDelete},
+		{Method: http.MethodGet, Path: "/admin/api-keys", Description: "List API keys", Handler: handleAPIKeyList},
+		{Method: http.MethodPost, Path: "/admin/api-keys", Description: "Create an API key", Handler: handleAPIKeyCreate, Body: APIKeyRequest{},
+			Example: map[string]interface{}{"name": "frontend", "role": RoleWrite, "scopes": []string{ScopeStudentsRead, ScopeSummariesGenerate}, "rate_limit": 120}},
+		{Method: http.MethodPost, Path: "/admin/api-keys/{id}/rotate", Description: "Rotate an API key", Handler: handleAPIKeyRotate},
+		{Method: http.MethodDelete, Path: "/admin/api-keys/{id}", Description: "Revoke an API key", Handler: handleAPIKeyRevoke},
+		{Method: http.MethodGet, Path: "/admin/maintenance", Description: "Show maintenance mode", Handler: handleMaintenanceGet},
+		{Method: http.MethodPost, Path: "/admin/maintenance", Description: "Turn maintenance mode on or off", Handler: handleMaintenanceSet, Body: MaintenanceState{},
+			Example: map[string]interface{}{"enabled": true, "allow_reads": true, "message": "Back at 18:00"}},
+		{Method: http.MethodGet, Path: "/admin/flags", Description: "List feature flags", Handler: handleFlagList},
+		{Method: http.MethodPut, Path: "/admin/flags/{name}", Description: "Set a feature flag", Handler: handleFlagSet, Body: FeatureFlag{},
+			Example: map[string]interface{}{"enabled": true, "tenants": map[string]bool{"springfield": true}}},
+		{Method: http.MethodDelete, Path: "/admin/flags/{name}", Description: "Delete a feature flag", Handler: handleFlagDelete},
+		{Method: http.MethodGet, Path: "/admin/loglevel", Description: "Show the log level", Handler: handleLogLevelGet},
+		{Method: http.MethodPut, Path: "/admin/loglevel", Description: "Change the log level", Handler: handleLogLevelSet, Body: LogLevelRequest{},
+			Example: map[string]interface{}{"level": "debug"}},
+		{Method: http.MethodPost, Path: "/admin/config/reload", Description: "Reload the config file", Handler: handleConfigReload},
+		{Method: http.MethodPost, Path: "/admin/integrity-check", Description: "Check the data for inconsistencies, optionally repairing the safe ones", Handler: handleIntegrityCheck, Query: "repair=true"},
+		{Method: http.MethodGet, Path: "/admin/storage", Description: "Show record counts, bytes used, the largest tenants and students, and growth", Handler: handleStorage},
+		{Method: http.MethodGet, Path: "/admin/shadow", Description: "Show how the shadow storage backend keeps up and where its reads diverge", Handler: handleShadow},
+		{Method: http.MethodGet, Path: "/admin/slowlog", Description: "Show the slowest recent requests", Handler: handleSlowLog},
+		{Method: http.MethodGet, Path: "/admin/slo", Description: "Show SLO compliance and burn rates per route", Handler: handleSLO},
+		{Method: http.MethodGet, Path: "/admin/deprecations", Description: "List deprecated routes and fields and the API keys still using them", Handler: handleDeprecations},
+		{Method: http.MethodGet, Path: "/admin/event-store", Description: "Describe the event log and check the roster is its projection", Handler: handleEventStoreStatus},
+		{Method: http.MethodGet, Path: "/admin/retention", Description: "Show the retention policy and last run", Handler: handleRetentionGet},
+		{Method: http.MethodPost, Path: "/admin/retention/run", Description: "Apply the retention policy now", Handler: handleRetentionRun, Query: "dry_run=true"},
+		{Method: http.MethodGet, Path: "/admin/anomalies", Description: "Show the roster anomaly check and the runs that found anomalies", Handler: handleAnomalyList},
+		{Method: http.MethodPost, Path: "/admin/anomalies/run", Description: "Check the last day of roster changes for anomalies now", Handler: handleAnomalyRun, Query: "dry_run=true"},
+		{Method: http.MethodPost, Path: "/admin/selftest", Description: "Check every subsystem end to end, e.g. after a deployment", Handler: handleSelfTest, Since: "1.1.0",
+			Body: SelfTestRequest{}, Example: map[string]interface{}{"webhook_url": "https://example.com/hooks/fealtyx"}},
+		{Method: http.MethodGet, Path: "/admin/chaos", Description: "Show fault injection settings", Handler: handleChaosGet},
+		{Method: http.MethodPut, Path: "/admin/chaos", Description: "Adjust fault injection when started with -chaos", Handler: handleChaosSet, Body: ChaosConfig{},
+			Example: map[string]interface{}{"latency": "1s", "jitter": "0s", "error_rate": 0.25, "ollama_failure_rate": 1}},
+	}
+}
+
+// debugRoutes are served behind admin auth on the main port, and without auth
+// on the -debug-addr listener
+func debugRoutes() []Route {
+	return []Route{
+		{Method: http.MethodGet, Path: "/debug/runtime", Description: "Goroutine, memory and store statistics", Handler: handleRuntimeStats},
+		{Method: http.MethodGet, Path: "/debug/pprof/", Description: "Go profiling endpoints", Handler: pprof.Index},
+		{Method: http.MethodGet, Path: "/debug/pprof/cmdline", Handler: pprof.Cmdline},
+		{Method: http.MethodGet, Path: "/debug/pprof/profile", Handler: pprof.Profile},
+		{Method: http.MethodGet, Path: "/debug/pprof/symbol", Handler: pprof.Symbol},
+		{Method: http.MethodPost, Path: "/debug/pprof/symbol", Handler: pprof.Symbol},
+		{Method: http.MethodGet, Path: "/debug/pprof/trace", Handler: pprof.Trace},
+	}
+}
+
+// registerRoutes mounts the routes on mux, one handler per path that
+// dispatches on method and answers anything else with 405
+func registerRoutes(mux *http.ServeMux, routes []Route) {
+	var paths []string
+	entries := map[string]*routeEntry{}
+	for _, route := range routes {
+		entry := entries[route.Path]
+		if entry == nil {
+			entry = &routeEntry{path: route.Path, methods: map[string]*boundRoute{}}
+			entries[route.Path] = entry
+			paths = append(paths, route.Path)
+		}
+		entry.methods[route.Method] = &boundRoute{Route: route, name: route.Method + " " + route.Path, scope: route.requiredScope()}
+	}
+
+	for _, path := range paths {
+		entry := entries[path]
+		var allowed []string
+		for method := range entry.methods {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		entry.allow = []string{strings.Join(allowed, ", ")}
+		mux.Handle(path, entry)
+	}
+}
+
+// boundRoute is a route with what each request needs from it worked out
+// once, at registration
+type boundRoute struct {
+	Route
+	name  string // e.g. "GET /students/{id}", for traces and SLOs
+	scope string
+}
+
+// routeEntry serves every method of one path. Nothing here allocates per
+// request on the way to the handler.
+type routeEntry struct {
+	path    string
+	methods map[string]*boundRoute
+	allow   []string // the Allow header
+}
+
+func (e *routeEntry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enableCORS(w)
+	route, ok := e.methods[r.Method]
+	if !ok {
+		setTraceRoute(r.Context(), r.Method+" "+e.path)
+		w.Header()["Allow"] = e.allow
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	setTraceRoute(r.Context(), route.name)
+	if route.Shed && shedRequest(w) {
+		return
+	}
+	if !authorize(w, r, route.scope) {
+		return
+	}
+	if route.Deprecated != nil {
+		deprecatedRoute(w, r, route)
+	}
+	route.Handler(w, r)
+}
+
+// introductionPage lists every documented route
+func introductionPage(routes []Route) http.HandlerFunc {
+	var page strings.Builder
+	page.WriteString("Welcome to the Student Management API\n")
+	page.WriteString("You can use the following endpoints to manage students\n")
+	for _, route := range routes {
+		if route.Description == "" {
+			continue
+		}
+		page.WriteString(route.Method + " " + route.Path + " - " + route.Description)
+		if route.Admin() {
+			page.WriteString(" (admin)")
+		}
+		if route.Deprecated != nil {
+			page.WriteString(" (deprecated)")
+		}
+		page.WriteString("\n")
+	}
+	text := []byte(page.String())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write(text)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarkRequest serves method and path through observeRequests and the
+// registered routes, reporting the allocations routing and tracing add
+func benchmarkRequest(b *testing.B, method, path string) {
+	mutex.Lock()
+	students = []Student{{ID: 1, Name: "Ada Lovelace", Age: 36, Email: "ada@example.com"}}
+	mutex.Unlock()
+	mux := http.NewServeMux()
+	registerRoutes(mux, apiRoutes())
+	handler := observeRequests(mux)
+	request := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	if w.Code != http.StatusOK {
+		b.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Body.Reset()
+		handler.ServeHTTP(w, request)
+	}
+}
+
+func BenchmarkRouteGetStudent(b *testing.B) {
+	benchmarkRequest(b, http.MethodGet, "/students/1")
+}
+
+func BenchmarkRouteAdminMaintenance(b *testing.B) {
+	benchmarkRequest(b, http.MethodGet, "/admin/maintenance")
+}
+
+func BenchmarkTimeStage(b *testing.B) {
+	trace := tracePool.Get().(*requestTrace)
+	defer releaseTrace(trace)
+	ctx := context.WithValue(context.Background(), traceKey{}, trace)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		timeStage(ctx, "store").stop()
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request bodies are described by JSON Schema generated from the Go types
+// the handlers decode into, so the published schemas, the route registry and
+// the decoder can't disagree. decodeJSON validates every body against its
+// schema first, reporting each problem at a JSON Pointer.
+
+const (
+	jsonSchemaDialect   = "https://json-schema.org/draft/2020-12/schema"
+	maxSchemaViolations = 10 // problems reported per request
+)
+
+// JSONSchema is the part of JSON Schema that Go types need: a type, whether
+// null is allowed, and what objects and arrays hold. A schema without a type
+// accepts anything.
+type JSONSchema struct {
+	ID          string
+	Title       string
+	Description string
+	Type        string // object, array, string, integer, number or boolean
+	Nullable    bool
+	Format      string
+	Properties  map[string]*JSONSchema
+	Items       *JSONSchema // for arrays
+	Values      *JSONSchema // for objects used as maps
+}
+
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	if s.ID != "" {
+		out["$schema"] = jsonSchemaDialect
+		out["$id"] = s.ID
+	}
+	if s.Title != "" {
+		out["title"] = s.Title
+	}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	switch {
+	case s.Type != "" && s.Nullable:
+		out["type"] = []string{s.Type, "null"}
+	case s.Type != "":
+		out["type"] = s.Type
+	}
+	if s.Format != "" {
+		out["format"] = s.Format
+	}
+	if s.Properties != nil {
+		out["properties"] = s.Properties
+	}
+	if s.Items != nil {
+		out["items"] = s.Items
+	}
+	if s.Values != nil {
+		out["additionalProperties"] = s.Values
+	}
+	return json.Marshal(out)
+}
+
+var (
+	schemaCache      = map[reflect.Type]*JSONSchema{}
+	schemaCacheMutex sync.Mutex
+
+	timeType        = reflect.TypeOf(time.Time{})
+	durationType    = reflect.TypeOf(Duration(0))
+	rawMessageType  = reflect.TypeOf(json.RawMessage{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// schemaFor returns the schema of a Go type, generating it once
+func schemaFor(t reflect.Type) *JSONSchema {
+	schemaCacheMutex.Lock()
+	defer schemaCacheMutex.Unlock()
+	schema, ok := schemaCache[t]
+	if !ok {
+		schema = generateSchema(t)
+		schemaCache[t] = schema
+	}
+	return schema
+}
+
+func generateSchema(t reflect.Type) *JSONSchema {
+	switch t {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &JSONSchema{Type: "string", Description: "a duration such as 90s, 30m or 48h"}
+	case rawMessageType:
+		return &JSONSchema{}
+	}
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(unmarshalerType) {
+		return &JSONSchema{} // decodes itself; nothing to say about its shape
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *generateSchema(t.Elem())
+		schema.Nullable = schema.Type != ""
+		return &schema
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"} // base64
+		}
+		// encoding/json decodes null into a slice as an empty one
+		return &JSONSchema{Type: "array", Nullable: t.Kind() == reflect.Slice, Items: generateSchema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", Nullable: true, Values: generateSchema(t.Elem())}
+	case reflect.Struct:
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		addStructProperties(schema, t)
+		return schema
+	default:
+		return &JSONSchema{}
+	}
+}
+
+// addStructProperties adds a struct's JSON fields to schema, flattening
+// embedded structs the way encoding/json does
+func addStructProperties(schema *JSONSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addStructProperties(schema, field.Type)
+			continue
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if options == "string" {
+			schema.Properties[name] = &JSONSchema{Type: "string"}
+			continue
+		}
+		schema.Properties[name] = generateSchema(field.Type)
+	}
+}
+
+// schemaViolation is one place a body breaks its schema
+type schemaViolation struct {
+	Pointer string // RFC 6901; "" is the whole body
+	Message string
+}
+
+// SchemaError lists where a request body breaks its schema
+type SchemaError struct {
+	Violations []schemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		pointer := violation.Pointer
+		if pointer == "" {
+			pointer = "body"
+		}
+		parts[i] = pointer + ": " + violation.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// validateJSON checks a JSON body against the schema of the type v points
+// to. Unknown object members are left to the compatibility mode.
+func validateJSON(data []byte, v interface{}) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Pointer {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil // the decoder reports syntax errors itself
+	}
+	report := &SchemaError{}
+	schemaFor(t.Elem()).validate(value, "", report)
+	if len(report.Violations) > 0 {
+		return report
+	}
+	return nil
+}
+
+func (s *JSONSchema) validate(value interface{}, pointer string, report *SchemaError) {
+	if s.Type == "" || len(report.Violations) >= maxSchemaViolations {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		report.Violations = append(report.Violations, schemaViolation{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+	if value == nil {
+		if !s.Nullable {
+			fail("must be %s %s, not null", article(s.Type), s.Type)
+		}
+		return
+	}
+	if got := jsonTypeOf(value); got != s.Type && !(s.Type == "number" && got == "integer") {
+		fail("must be %s %s, not %s", article(s.Type), s.Type, got)
+		return
+	}
+
+	switch value := value.(type) {
+	case json.Number:
+		if s.Type == "integer" {
+			if _, err := strconv.ParseInt(value.String(), 10, 64); err != nil {
+				if _, err := strconv.ParseUint(value.String(), 10, 64); err != nil {
+					fail("%s is out of range", value)
+				}
+			}
+		}
+	case string:
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				fail("must be an RFC 3339 date-time, e.g. 2026-01-02T15:04:05Z")
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			s.Items.validate(item, pointer+"/"+strconv.Itoa(i), report)
+		}
+	case map[string]interface{}:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property := s.Values
+			if s.Properties != nil {
+				property = s.property(name)
+			}
+			if property != nil {
+				property.validate(value[name], pointer+"/"+escapeJSONPointer(name), report)
+			}
+		}
+	}
+}
+
+// property finds an object member's schema, matching names
+// case-insensitively like encoding/json does
+func (s *JSONSchema) property(name string) *JSONSchema {
+	if property, ok := s.Properties[name]; ok {
+		return property
+	}
+	for candidate, property := range s.Properties {
+		if strings.EqualFold(candidate, name) {
+			return property
+		}
+	}
+	return nil
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value := value.(type) {
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(value.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+func escapeJSONPointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// schemaName names a body type's schema after the Go type
+func schemaName(body interface{}) string {
+	return reflect.TypeOf(body).Name()
+}
+
+// requestSchemas returns each route's body schema by name, with the routes
+// that take it
+func requestSchemas() (map[string]*JSONSchema, map[string][]string) {
+	schemas := map[string]*JSONSchema{}
+	routes := map[string][]string{}
+	for _, route := range append(apiRoutes(), debugRoutes()...) {
+		if route.Body == nil {
+			continue
+		}
+		name := schemaName(route.Body)
+		if _, ok := schemas[name]; !ok {
+			schema := *schemaFor(reflect.TypeOf(route.Body))
+			schema.ID = "/schemas/" + name + ".json"
+			schema.Title = name
+			schemas[name] = &schema
+		}
+		routes[name] = append(routes[name], route.Method+" "+route.Path)
+	}
+	return schemas, routes
+}
+
+// handleSchemaIndex lists the published request body schemas
+func handleSchemaIndex(w http.ResponseWriter, r *http.Request) {
+	schemas, routes := requestSchemas()
+	type entry struct {
+		Name   string   `json:"name"`
+		URL    string   `json:"url"`
+		Routes []string `json:"routes"`
+	}
+	index := []entry{}
+	for name, schema := range schemas {
+		index = append(index, entry{Name: name, URL: schema.ID, Routes: routes[name]})
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].Name < index[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(index)
+}
+
+// handleSchema serves one request body schema, e.g. /schemas/Student.json
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	schemas, _ := requestSchemas()
+	schema, ok := schemas[strings.TrimSuffix(r.PathValue("name"), ".json")]
+	if !ok {
+		http.Error(w, "Schema not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(schema)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Scopes name what a key may do. Routes declare the scope they need; admin
+// and debug routes need admin:<area>, e.g. admin:tenants for /admin/tenants.
+// A trailing * matches any scope with that prefix.
+const (
+	ScopeStudentsRead      = "students:read"
+	ScopeStudentsWrite     = "students:write"
+	ScopeSummariesGenerate = "summaries:generate"
+	ScopeHooksRead         = "hooks:read"
+	ScopeHooksWrite        = "hooks:write"
+	ScopeReportsRead       = "reports:read"
+	ScopeReportsWrite      = "reports:write"
+	ScopeAdmin             = "admin:*"
+)
+
+var scopePattern = regexp.MustCompile(`^(\*|[a-z]+:(\*|[a-z-]+))$`)
+
+// roleScopes are what each role may be granted, and what a key without
+// explicit scopes gets
+var roleScopes = map[string][]string{
+	RoleAdmin: {"*"},
+	RoleWrite: {ScopeStudentsRead, ScopeStudentsWrite, ScopeSummariesGenerate, ScopeHooksRead, ScopeHooksWrite, ScopeReportsRead, ScopeReportsWrite},
+	RoleRead:  {ScopeStudentsRead, ScopeSummariesGenerate, ScopeHooksRead, ScopeReportsRead},
+}
+
+func scopeMatches(granted, required string) bool {
+	if prefix, ok := strings.CutSuffix(granted, "*"); ok {
+		return strings.HasPrefix(required, prefix)
+	}
+	return granted == required
+}
+
+func hasScope(granted []string, required string) bool {
+	for _, scope := range granted {
+		if scopeMatches(scope, required) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScopes checks that requested scopes are well-formed and within the
+// key's role
+func validateScopes(role string, scopes []string) error {
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+		if role == RoleAdmin {
+			continue
+		}
+		if !hasScope(roleScopes[role], scope) {
+			return fmt.Errorf("scope %q is not available to the %s role", scope, role)
+		}
+	}
+	return nil
+}
+
+// scopes returns what the key is allowed to do
+func (k *APIKey) scopes() []string {
+	if len(k.Scopes) > 0 {
+		return k.Scopes
+	}
+	return roleScopes[k.Role]
+}
+
+// requiredScope is the scope a route needs, "" if any key will do
+func (rt Route) requiredScope() string {
+	if rt.Scope != "" || !rt.Admin() {
+		return rt.Scope
+	}
+	area, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(rt.Path, "/admin/"), "/"), "/")
+	return "admin:" + area
+}
+
+// tenantAdminArea is an admin area a tenant's own admin keys may use.
+// tenantOf finds the tenant of the record a request names, if it names one.
+type tenantAdminArea struct {
+	tenantOf func(r *http.Request) (tenant string, found bool)
+	notFound string
+}
+
+// tenantAdminAreas are the admin areas that stay within a tenant. Every
+// other one, tenants and server settings among them, spans all tenants and
+// needs a tenantless admin key.
+var tenantAdminAreas = map[string]tenantAdminArea{
+	"admin:api-keys": {tenantOf: apiKeyTenant, notFound: "API key not found"},
+	"admin:users":    {tenantOf: userTenant, notFound: "User not found"},
+}
+
+// authorize is the central authorization check, run for every routed request.
+// Requests without a key were already let through or refused by authenticate.
+// A tenant's keys are kept to their tenant: they may only use the admin areas
+// in tenantAdminAreas, and only on their own tenant's records, which to them
+// other tenants' records look missing.
+func authorize(w http.ResponseWriter, r *http.Request, scope string) bool {
+	key := keyFromContext(r.Context())
+	if key == nil || scope == "" {
+		return true
+	}
+	if !hasScope(key.scopes(), scope) {
+		http.Error(w, "API key is missing the "+scope+" scope", http.StatusForbidden)
+		return false
+	}
+	if key.Tenant == "" || !strings.HasPrefix(scope, "admin:") {
+		return true
+	}
+	area, ok := tenantAdminAreas[scope]
+	if !ok {
+		http.Error(w, "Tenant API keys can't use "+scope+", which spans every tenant", http.StatusForbidden)
+		return false
+	}
+	if tenant, found := area.tenantOf(r); found && tenant != key.Tenant {
+		http.Error(w, area.notFound, http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// checkGrant reports whether caller may hand out a key or account with the
+// given role, scopes and tenant: never more than it holds itself, and only
+// in its own tenant if it belongs to one
+func checkGrant(caller *APIKey, role string, scopes []string, tenant string) error {
+	if caller == nil {
+		return nil
+	}
+	if caller.Tenant != "" && tenant != caller.Tenant {
+		return fmt.Errorf("tenant admins can only grant access to their own tenant")
+	}
+	if len(scopes) == 0 {
+		scopes = roleScopes[role]
+	}
+	for _, scope := range scopes {
+		if !hasScope(caller.scopes(), scope) {
+			return fmt.Errorf("can't grant the %s scope, which this API key doesn't have", scope)
+		}
+	}
+	return nil
+}
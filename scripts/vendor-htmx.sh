@@ -0,0 +1,16 @@
+#!/bin/sh
+# Vendors the pinned htmx release and its license into static/, where they are
+# embedded and served in place of the hx-lite.js fallback. Run with
+# `go generate` from the repository root, and commit both files. To upgrade,
+# change HTMX_VERSION and check the pages still work.
+set -eu
+
+HTMX_VERSION=2.0.3
+base="https://unpkg.com/htmx.org@${HTMX_VERSION}"
+
+cd "$(dirname "$0")/../static"
+curl -fsSL -o htmx.min.js.tmp "${base}/dist/htmx.min.js"
+curl -fsSL -o htmx.LICENSE.txt.tmp "${base}/LICENSE"
+mv htmx.min.js.tmp htmx.min.js
+mv htmx.LICENSE.txt.tmp htmx.LICENSE.txt
+echo "Vendored htmx ${HTMX_VERSION}"
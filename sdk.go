@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"embed"
+	"io/fs"
+	"net/http"
+	"sync"
+)
+
+//go:embed sdk/typescript
+var typescriptSDK embed.FS
+
+var (
+	typescriptZip     []byte
+	typescriptZipErr  error
+	typescriptZipOnce sync.Once
+)
+
+// buildSDKZip packs an embedded SDK directory into a zip archive rooted at its
+// base name
+func buildSDKZip(fsys fs.FS, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err := fs.WalkDir(fsys, root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		file, err := archive.Create(path[len("sdk/"):])
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func handleTypeScriptSDK(w http.ResponseWriter, r *http.Request) {
+	typescriptZipOnce.Do(func() {
+		typescriptZip, typescriptZipErr = buildSDKZip(typescriptSDK, "sdk/typescript")
+	})
+	if typescriptZipErr != nil {
+		http.Error(w, "Failed to build SDK archive", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="typescript.zip"`)
+	w.Write(typescriptZip)
+}
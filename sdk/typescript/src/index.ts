@@ -0,0 +1,213 @@
+export interface Student {
+  id: number;
+  /** UUIDv7. Prefer it over `id`, which may outgrow JavaScript numbers. */
+  uuid?: string;
+  name: string;
+  age: number;
+  email: string;
+  /** As entered. Omit to keep it on update. */
+  phone?: string;
+  /** `phone` in E.164, set by the server. */
+  phone_e164?: string;
+  /** Omit to keep it, and its location, on update. */
+  address?: string;
+  /** Geocoded from `address` when the server has a geocoder. */
+  location?: { lat: number; lng: number };
+  /** YYYY-MM-DD. Omit to keep it on update. */
+  birth_date?: string;
+  /** YYYY-MM-DD, defaulting to the day the student is created. Omit to keep it on update. */
+  enrolled_on?: string;
+  /** Set by admins; blocks deletion. Ignored on create and update. */
+  legal_hold?: boolean;
+  tenant?: string;
+  /** Free-form labels, stored lowercased. Omit to keep them on update. */
+  tags?: string[];
+  /** Values of the tenant's custom fields, see `GET /fields`. Omit to keep them on update. */
+  attributes?: Record<string, string | number | boolean>;
+}
+
+export interface StudentPage {
+  /** Students on all pages. */
+  total: number;
+  page: number;
+  limit: number;
+  items: Student[];
+}
+
+export interface ListOptions {
+  /** From 1. */
+  page?: number;
+  /** 1-500, 50 by default. */
+  limit?: number;
+  /** A leading `-` sorts in descending order. */
+  sort?: "id" | "name" | "age" | "-id" | "-name" | "-age";
+  /** Part of the name, in any case. */
+  name?: string;
+  min_age?: number;
+  max_age?: number;
+}
+
+/** A student's `id` or, preferably, its `uuid`. */
+export type StudentID = number | string;
+
+export type StudentInput = Omit<Student, "id" | "uuid" | "phone_e164" | "legal_hold" | "tenant">;
+
+export interface StudentSummary {
+  student: Student;
+  summary: string;
+  warnings?: QuotaWarning[];
+}
+
+export interface Birthday {
+  /** YYYY-MM-DD. A 29 February birthday is on the 28th in other years. */
+  date: string;
+  turning: number;
+  student: Student;
+}
+
+export type ChangeEvent = "student.created" | "student.updated" | "student.deleted";
+
+export interface Change {
+  id: number;
+  event: ChangeEvent;
+  student: Student;
+  occurred_at: string;
+}
+
+export interface Hook {
+  id: number;
+  target_url: string;
+  event?: ChangeEvent;
+}
+
+export interface Limit {
+  limit: number | null;
+  used: number;
+  resets_at?: string;
+}
+
+export interface QuotaWarning {
+  quota: "students" | "llm_calls_per_day";
+  scope: "global" | "tenant";
+  used: number;
+  limit: number;
+  resets_at?: string;
+}
+
+export interface Limits {
+  students: Limit;
+  llm_calls_per_day: Limit;
+  warnings?: QuotaWarning[];
+}
+
+export class ApiError extends Error {
+  constructor(
+    public readonly status: number,
+    message: string,
+  ) {
+    super(`${status}: ${message}`);
+    this.name = "ApiError";
+  }
+}
+
+export interface ClientOptions {
+  apiKey?: string;
+  fetch?: typeof fetch;
+}
+
+export class FealtyxClient {
+  private readonly baseUrl: string;
+  private readonly apiKey?: string;
+  private readonly fetchImpl: typeof fetch;
+
+  constructor(baseUrl: string, options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/+$/, "");
+    this.apiKey = options.apiKey;
+    this.fetchImpl = options.fetch ?? fetch.bind(globalThis);
+  }
+
+  listStudents(signal?: AbortSignal): Promise<Student[]> {
+    return this.request("GET", "/students", undefined, signal);
+  }
+
+  listStudentsPage(options: ListOptions = {}, signal?: AbortSignal): Promise<StudentPage> {
+    const query = new URLSearchParams({ page: String(options.page ?? 1) });
+    for (const key of ["limit", "sort", "name", "min_age", "max_age"] as const) {
+      if (options[key] !== undefined) {
+        query.set(key, String(options[key]));
+      }
+    }
+    return this.request("GET", `/students?${query}`, undefined, signal);
+  }
+
+  getStudent(id: StudentID, signal?: AbortSignal): Promise<Student> {
+    return this.request("GET", `/students/${id}`, undefined, signal);
+  }
+
+  createStudent(student: StudentInput, signal?: AbortSignal): Promise<Student> {
+    return this.request("POST", "/students", student, signal);
+  }
+
+  updateStudent(id: StudentID, student: StudentInput, signal?: AbortSignal): Promise<Student> {
+    return this.request("PUT", `/students/${id}`, student, signal);
+  }
+
+  deleteStudent(id: StudentID, signal?: AbortSignal): Promise<void> {
+    return this.request("DELETE", `/students/${id}`, undefined, signal);
+  }
+
+  addTags(id: StudentID, tags: string[], signal?: AbortSignal): Promise<Student> {
+    return this.request("POST", `/students/${id}/tags`, { tags }, signal);
+  }
+
+  /** Birthdays from today through the next `days` days, soonest first. */
+  async upcomingBirthdays(days = 30, signal?: AbortSignal): Promise<Birthday[]> {
+    const page = await this.request<{ birthdays: Birthday[] }>("GET", `/students/birthdays/upcoming?days=${days}`, undefined, signal);
+    return page.birthdays;
+  }
+
+  summary(id: StudentID, signal?: AbortSignal): Promise<StudentSummary> {
+    return this.request("GET", `/students/${id}/summary`, undefined, signal);
+  }
+
+  /** Changes newer than `since`, newest first. */
+  changes(since = 0, signal?: AbortSignal): Promise<Change[]> {
+    return this.request("GET", `/students/changes?since=${since}`, undefined, signal);
+  }
+
+  subscribeHook(targetUrl: string, event?: ChangeEvent): Promise<Hook> {
+    return this.request("POST", "/hooks", { target_url: targetUrl, event });
+  }
+
+  unsubscribeHook(id: number): Promise<void> {
+    return this.request("DELETE", `/hooks/${id}`);
+  }
+
+  limits(signal?: AbortSignal): Promise<Limits> {
+    return this.request("GET", "/limits", undefined, signal);
+  }
+
+  private async request<T>(method: string, path: string, body?: unknown, signal?: AbortSignal): Promise<T> {
+    const headers: Record<string, string> = { Accept: "application/json" };
+    if (body !== undefined) {
+      headers["Content-Type"] = "application/json";
+    }
+    if (this.apiKey) {
+      headers["Authorization"] = `Bearer ${this.apiKey}`;
+    }
+
+    const response = await this.fetchImpl(this.baseUrl + path, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+      signal,
+    });
+    if (!response.ok) {
+      throw new ApiError(response.status, (await response.text()).trim());
+    }
+    if (response.status === 204) {
+      return undefined as T;
+    }
+    return (await response.json()) as T;
+  }
+}
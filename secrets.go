@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider loads the full set of secrets from a backing source. Values
+// are cached by secretCache and refreshed periodically.
+type SecretProvider interface {
+	Name() string
+	Load() (map[string]string, error)
+}
+
+// envSecretProvider serves secrets straight from the environment
+type envSecretProvider struct{}
+
+func (envSecretProvider) Name() string { return "env" }
+
+func (envSecretProvider) Load() (map[string]string, error) { return nil, nil }
+
+// fileSecretProvider reads one secret per file from a directory, the layout
+// used by Docker and Kubernetes secret mounts
+type fileSecretProvider struct {
+	dir string
+}
+
+func (p fileSecretProvider) Name() string { return "file" }
+
+func (p fileSecretProvider) Load() (map[string]string, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets directory: %v", err)
+	}
+	values := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(p.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s: %v", entry.Name(), err)
+		}
+		values[entry.Name()] = strings.TrimSpace(string(data))
+	}
+	return values, nil
+}
+
+// vaultSecretProvider reads a HashiCorp Vault KV version 2 secret
+type vaultSecretProvider struct {
+	addr  string
+	token string
+	mount string
+	path  string
+}
+
+func (p vaultSecretProvider) Name() string { return "vault" }
+
+func (p vaultSecretProvider) Load() (map[string]string, error) {
+	endpoint := strings.TrimRight(p.addr, "/") + "/v1/" + p.mount + "/data/" + strings.TrimLeft(p.path, "/")
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned status: %d", resp.StatusCode)
+	}
+
+	var vaultResp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vaultResp); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for key, value := range vaultResp.Data.Data {
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+type secretCache struct {
+	mu       sync.RWMutex
+	provider SecretProvider
+	values   map[string]string
+}
+
+var secrets = &secretCache{provider: envSecretProvider{}}
+
+// getSecret returns a secret from the configured provider, falling back to the
+// environment variable of the same name
+func getSecret(name string) string {
+	secrets.mu.RLock()
+	value, ok := secrets.values[name]
+	secrets.mu.RUnlock()
+	if ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func (c *secretCache) refresh() error {
+	values, err := c.provider.Load()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.values = values
+	c.mu.Unlock()
+	return nil
+}
+
+// newSecretProvider picks the provider named by SECRETS_PROVIDER (env, file or vault)
+func newSecretProvider() (SecretProvider, error) {
+	switch os.Getenv("SECRETS_PROVIDER") {
+	case "", "env":
+		return envSecretProvider{}, nil
+	case "file":
+		dir := os.Getenv("SECRETS_DIR")
+		if dir == "" {
+			dir = "/run/secrets"
+		}
+		return fileSecretProvider{dir: dir}, nil
+	case "vault":
+		provider := vaultSecretProvider{
+			addr:  os.Getenv("VAULT_ADDR"),
+			token: os.Getenv("VAULT_TOKEN"),
+			mount: os.Getenv("VAULT_MOUNT"),
+			path:  os.Getenv("VAULT_SECRET_PATH"),
+		}
+		if provider.addr == "" || provider.token == "" || provider.path == "" {
+			return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH are required")
+		}
+		if provider.mount == "" {
+			provider.mount = "secret"
+		}
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown secrets provider: %s", os.Getenv("SECRETS_PROVIDER"))
+	}
+}
+
+// initSecrets loads secrets once and keeps them fresh in the background. A
+// failed refresh keeps serving the last good values.
+func initSecrets() error {
+	provider, err := newSecretProvider()
+	if err != nil {
+		return err
+	}
+	secrets.provider = provider
+	if err := secrets.refresh(); err != nil {
+		return err
+	}
+
+	interval := 5 * time.Minute
+	if value := os.Getenv("SECRETS_REFRESH_INTERVAL"); value != "" {
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid SECRETS_REFRESH_INTERVAL: %s", value)
+		}
+	}
+	if provider.Name() != "env" {
+		go func() {
+			for range time.Tick(interval) {
+				if err := secrets.refresh(); err != nil {
+					slog.Error("Failed to refresh secrets", "provider", provider.Name(), "error", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
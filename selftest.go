@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The self-test is a quick end-to-end check run after deployments: it
+// exercises each subsystem the way real requests do, without changing the
+// roster, and reports what passed. Checks run in parallel and each gets
+// selftestTimeout.
+
+const selftestTimeout = 5 * time.Second
+
+const (
+	SelfTestPass = "pass"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip" // not configured
+)
+
+type SelfTestCheck struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Detail   string   `json:"detail,omitempty"`
+	Duration Duration `json:"duration"`
+}
+
+type SelfTestReport struct {
+	Passed  bool            `json:"passed"` // no check failed
+	Version string          `json:"version"`
+	At      time.Time       `json:"at"`
+	Checks  []SelfTestCheck `json:"checks"`
+}
+
+// SelfTestRequest optionally names a webhook receiver to deliver the test
+// payload to. Without one it goes to a receiver the test starts locally.
+type SelfTestRequest struct {
+	WebhookURL string `json:"webhook_url"`
+}
+
+type selfTest struct {
+	name string
+	run  func(ctx context.Context) (status, detail string)
+}
+
+var selftestClient = &http.Client{Timeout: selftestTimeout}
+
+// checkStorage writes, syncs, reads back and removes a file beside the
+// write-ahead log, proving the disk it's on takes writes, or checks the
+// -store database
+func checkStorage(ctx context.Context) (string, string) {
+	if _, inMemory := studentStore.(memoryStore); !inMemory {
+		return checkStore(ctx)
+	}
+	if wal == nil {
+		return SelfTestSkip, "students are kept in memory only"
+	}
+	probe := filepath.Join(filepath.Dir(wal.path), fmt.Sprintf(".selftest-%d", time.Now().UnixNano()))
+	data := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	err := func() error {
+		file, err := os.OpenFile(probe, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(probe)
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		read, err := os.ReadFile(probe)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(read, data) {
+			return fmt.Errorf("read back different data")
+		}
+		return nil
+	}()
+	if err != nil {
+		return SelfTestFail, err.Error()
+	}
+	mutex.RLock()
+	detail := fmt.Sprintf("%d students at revision %d", len(students), changeSeq)
+	mutex.RUnlock()
+	return SelfTestPass, detail
+}
+
+// checkStore reads a student back from the -store database and compares it
+// with the roster, once the store has caught up with it. Holding the
+// mirror's lock keeps the store from moving on between the two; writes to
+// the roster carry on meanwhile.
+func checkStore(ctx context.Context) (string, string) {
+	for {
+		storeMirror.mu.Lock()
+		mutex.RLock()
+		var student Student
+		if len(students) > 0 {
+			student = students[0]
+		}
+		count, revision := len(students), changeSeq
+		mutex.RUnlock()
+		if storeMirror.applied != revision {
+			storeMirror.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return SelfTestFail, fmt.Sprintf("%s is still catching up with revision %d", studentStore.Name(), revision)
+			case <-time.After(10 * time.Millisecond):
+			}
+			continue
+		}
+		stored, found, err := studentStore.Get(ctx, student.ID)
+		storeMirror.mu.Unlock()
+		if err != nil {
+			return SelfTestFail, studentStore.Name() + ": " + err.Error()
+		}
+		if student.ID != 0 && (!found || !bytes.Equal(stored.appendJSON(nil), student.appendJSON(nil))) {
+			return SelfTestFail, fmt.Sprintf("student %d in %s differs from the roster", student.ID, studentStore.Name())
+		}
+		return SelfTestPass, fmt.Sprintf("%s, %d students at revision %d", studentStore.Name(), count, revision)
+	}
+}
+
+// checkShadow reads a student back from the shadow backend
+func checkShadow(ctx context.Context) (string, string) {
+	if shadow == nil {
+		return SelfTestSkip, "no shadow backend"
+	}
+	mutex.RLock()
+	var id int64
+	if len(students) > 0 {
+		id = students[0].ID
+	}
+	revision := changeSeq
+	mutex.RUnlock()
+	if _, _, err := shadow.backend.Get(ctx, id); err != nil {
+		return SelfTestFail, shadow.backend.Name() + ": " + err.Error()
+	}
+	shadow.mu.Lock()
+	behind := revision - shadow.applied
+	shadow.mu.Unlock()
+	return SelfTestPass, fmt.Sprintf("%s, %d revisions behind", shadow.backend.Name(), max(behind, 0))
+}
+
+// checkLLM asks the LLM provider which models it serves, which costs no
+// quota, and checks the configured one is among them
+func checkLLM(ctx context.Context) (string, string) {
+	if _, mock := summaryProvider.(mockProvider); mock {
+		return SelfTestSkip, "canned summaries (mock provider)"
+	}
+	if _, replaying := llmClient.Transport.(replayTransport); replaying {
+		return SelfTestSkip, "replaying recorded interactions (-llm-replay)"
+	}
+	models, err := summaryProvider.Models(ctx, selftestClient)
+	if err != nil {
+		return SelfTestFail, err.Error()
+	}
+	model := llmModel()
+	for _, available := range models {
+		// Ollama tags its models, as in llama3.2:latest
+		if available == model || strings.HasPrefix(available, model+":") {
+			return SelfTestPass, summaryProvider.Name() + " model " + available + " is available"
+		}
+	}
+	return SelfTestFail, summaryProvider.Name() + " model " + model + " is not available"
+}
+
+// checkCache exercises the TTL cache: lookups, eviction of the least
+// recently used entry and removal
+func checkCache(ctx context.Context) (string, string) {
+	cache := newTTLCache[int, string](time.Minute, 2)
+	cache.put(1, "one")
+	cache.put(2, "two")
+	cache.get(1)
+	cache.put(3, "three") // evicts 2
+	_, kept := cache.get(1)
+	_, evicted := cache.get(2)
+	cache.remove(3)
+	_, removed := cache.get(3)
+	if !kept || evicted || removed {
+		return SelfTestFail, "unexpected cache contents"
+	}
+	return SelfTestPass, ""
+}
+
+// checkWebhook delivers a test payload, the way hooks are delivered, to the
+// given URL or to a receiver started for the test
+func checkWebhook(target string) func(ctx context.Context) (string, string) {
+	return func(ctx context.Context) (string, string) {
+		payload, _ := json.Marshal(map[string]interface{}{"event": "selftest.ping", "occurred_at": time.Now().UTC()})
+		received := make(chan []byte, 1)
+		if target == "" {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return SelfTestFail, err.Error()
+			}
+			receiver := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received <- body
+			})}
+			go receiver.Serve(listener)
+			defer receiver.Close()
+			target = "http://" + listener.Addr().String() + "/hook"
+		}
+
+		request, _ := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+		request.Header.Set("Content-Type", "application/json")
+		resp, err := selftestClient.Do(request)
+		if err != nil {
+			return SelfTestFail, err.Error()
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return SelfTestFail, fmt.Sprintf("%s returned status: %d", target, resp.StatusCode)
+		}
+		select {
+		case body := <-received:
+			if !bytes.Equal(body, payload) {
+				return SelfTestFail, "the receiver got a different payload"
+			}
+			return SelfTestPass, "delivered to a local receiver"
+		default:
+			return SelfTestPass, "delivered to " + target
+		}
+	}
+}
+
+// checkMail connects to the SMTP relay and waits for its greeting, without
+// sending anything
+func checkMail(ctx context.Context) (string, string) {
+	if smtpAddr == "" {
+		return SelfTestSkip, "no SMTP relay; emails are logged"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", smtpAddr)
+	if err != nil {
+		return SelfTestFail, err.Error()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(smtpAddr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return SelfTestFail, err.Error()
+	}
+	client.Quit()
+	return SelfTestPass, smtpAddr
+}
+
+// runSelfTest runs every check in parallel
+func runSelfTest(ctx context.Context, webhookURL string) SelfTestReport {
+	tests := []selfTest{
+		{"storage", checkStorage},
+		{"shadow", checkShadow},
+		{"llm", checkLLM},
+		{"cache", checkCache},
+		{"webhook", checkWebhook(webhookURL)},
+		{"mail", checkMail},
+	}
+	report := SelfTestReport{Passed: true, Version: buildInfo.Version, At: time.Now().UTC(), Checks: make([]SelfTestCheck, len(tests))}
+	var wg sync.WaitGroup
+	for i, test := range tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, selftestTimeout)
+			defer cancel()
+			start := time.Now()
+			status, detail := test.run(ctx)
+			report.Checks[i] = SelfTestCheck{Name: test.name, Status: status, Detail: detail, Duration: Duration(time.Since(start))}
+		}()
+	}
+	wg.Wait()
+	for _, check := range report.Checks {
+		if check.Status == SelfTestFail {
+			report.Passed = false
+		}
+	}
+	return report
+}
+
+// handleSelfTest runs the self-test. It answers 503 if any check failed, so
+// deployment scripts can go by the status alone.
+func handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	var request SelfTestRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &request); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.WebhookURL != "" {
+		u, err := url.Parse(request.WebhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			http.Error(w, "webhook_url must be an http or https URL", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report := runSelfTest(r.Context(), request.WebhookURL)
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
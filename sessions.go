@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session is one sign-in. It holds a refresh token that is exchanged for
+// short-lived access keys; revoking the session deletes both, so a stolen
+// token stops working immediately.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // when the refresh token stops working
+
+	refreshHash     string
+	prevRefreshHash string // the rotated-out refresh token, to detect reuse
+	csrfToken       string // for cookie sign-ins, see csrf.go
+}
+
+// SessionTokens is the response to login and refresh. Key is the access key.
+type SessionTokens struct {
+	IssuedAPIKey
+	SessionID        int       `json:"session_id"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	CSRFToken        string    `json:"csrf_token,omitempty"` // cookie sign-ins only
+
+	TOTPEnrollmentRequired bool `json:"totp_enrollment_required,omitempty"`
+}
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	sessions      []*Session
+	sessionSeq    int
+	sessionsMutex sync.Mutex
+)
+
+func generateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "fxr_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// issueAccessKey creates an access key for a session, replacing the
+// session's previous one, and drops expired sign-in keys while it is at it
+func issueAccessKey(user User, sessionID int) (IssuedAPIKey, error) {
+	secret, err := generateAPIKey()
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL).UTC()
+	key := &APIKey{
+		Name:      "login: " + user.Email,
+		Role:      user.Role,
+		Tenant:    user.Tenant,
+		Prefix:    secret[:8],
+		ExpiresAt: &expiresAt,
+		CreatedAt: now.UTC(),
+		UserID:    user.ID,
+		SessionID: sessionID,
+		hash:      hashAPIKey(secret),
+
+		enrollOnly: user.Role == RoleAdmin && !user.TOTPEnabled,
+	}
+
+	apiKeysMutex.Lock()
+	kept := apiKeys[:0]
+	for _, existing := range apiKeys {
+		replaced := existing.SessionID == sessionID
+		expired := existing.SessionID != 0 && now.After(*existing.ExpiresAt)
+		if !replaced && !expired {
+			kept = append(kept, existing)
+		}
+	}
+	apiKeys = kept
+	apiKeySeq++
+	key.ID = apiKeySeq
+	apiKeys = append(apiKeys, key)
+	issued := IssuedAPIKey{APIKey: *key, Key: secret}
+	apiKeysMutex.Unlock()
+	saveAuthState()
+	return issued, nil
+}
+
+// startSession records a sign-in and issues its first tokens
+func startSession(user User, r *http.Request) (SessionTokens, error) {
+	refresh, err := generateRefreshToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	csrf, err := generateCSRFToken()
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	now := time.Now().UTC()
+	session := &Session{
+		UserID:      user.ID,
+		UserAgent:   r.UserAgent(),
+		RemoteAddr:  r.RemoteAddr,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(refreshTokenTTL),
+		refreshHash: hashAPIKey(refresh),
+		csrfToken:   csrf,
+	}
+	sessionsMutex.Lock()
+	kept := sessions[:0]
+	for _, existing := range sessions {
+		if now.Before(existing.ExpiresAt) {
+			kept = append(kept, existing)
+		}
+	}
+	sessions = kept
+	sessionSeq++
+	session.ID = sessionSeq
+	sessions = append(sessions, session)
+	sessionsMutex.Unlock()
+
+	issued, err := issueAccessKey(user, session.ID)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+	return SessionTokens{IssuedAPIKey: issued, SessionID: session.ID, RefreshToken: refresh, RefreshExpiresAt: session.ExpiresAt,
+		CSRFToken: csrf, TOTPEnrollmentRequired: issued.enrollOnly}, nil
+}
+
+// revokeSessions ends the sessions matching a filter and deletes their
+// access keys
+func revokeSessions(match func(*Session) bool) int {
+	sessionsMutex.Lock()
+	revoked := map[int]bool{}
+	kept := sessions[:0]
+	for _, session := range sessions {
+		if match(session) {
+			revoked[session.ID] = true
+		} else {
+			kept = append(kept, session)
+		}
+	}
+	sessions = kept
+	sessionsMutex.Unlock()
+
+	apiKeysMutex.Lock()
+	keys := apiKeys[:0]
+	for _, key := range apiKeys {
+		if !revoked[key.SessionID] {
+			keys = append(keys, key)
+		}
+	}
+	apiKeys = keys
+	apiKeysMutex.Unlock()
+	if len(revoked) > 0 {
+		saveAuthState()
+	}
+	return len(revoked)
+}
+
+// RefreshRequest is the body of POST /auth/refresh
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// handleRefresh exchanges a refresh token for a new access key and a new
+// refresh token. Presenting an already rotated refresh token means it was
+// copied, so the whole session is revoked. Browsers that signed in with
+// cookies send no body; the refresh cookie is used and the cookies replaced.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var input RefreshRequest
+	fromCookie := false
+	if cookie, err := r.Cookie(refreshCookie); err == nil && r.Header.Get("Content-Type") != "application/json" {
+		input.RefreshToken, fromCookie = cookie.Value, true
+	} else if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash := hashAPIKey(input.RefreshToken)
+	refresh, err := generateRefreshToken()
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+
+	sessionsMutex.Lock()
+	var session *Session
+	reused := false
+	for _, candidate := range sessions {
+		if subtle.ConstantTimeCompare([]byte(candidate.refreshHash), []byte(hash)) == 1 {
+			session = candidate
+		} else if candidate.prevRefreshHash != "" && subtle.ConstantTimeCompare([]byte(candidate.prevRefreshHash), []byte(hash)) == 1 {
+			session, reused = candidate, true
+		}
+	}
+	if session == nil || reused || now.After(session.ExpiresAt) {
+		sessionsMutex.Unlock()
+		if reused {
+			slog.Warn("Refresh token reused; revoking session", "session", session.ID, "user", session.UserID)
+			revokeSessions(func(s *Session) bool { return s.ID == session.ID })
+		}
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	csrf := session.csrfToken
+	if fromCookie && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(csrf)) != 1 {
+		sessionsMutex.Unlock()
+		http.Error(w, errCSRFToken.Error(), http.StatusForbidden)
+		return
+	}
+	session.prevRefreshHash, session.refreshHash = session.refreshHash, hashAPIKey(refresh)
+	session.LastUsedAt = now.UTC()
+	sessionID, userID, refreshExpires := session.ID, session.UserID, session.ExpiresAt
+	sessionsMutex.Unlock()
+
+	usersMutex.Lock()
+	var user User
+	found := findUserLocked(userID)
+	if found != nil {
+		user = *found
+	}
+	usersMutex.Unlock()
+	if found == nil || user.Disabled {
+		revokeSessions(func(s *Session) bool { return s.ID == sessionID })
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	issued, err := issueAccessKey(user, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	tokens := SessionTokens{IssuedAPIKey: issued, SessionID: sessionID, RefreshToken: refresh, RefreshExpiresAt: refreshExpires,
+		TOTPEnrollmentRequired: issued.enrollOnly}
+	if fromCookie {
+		tokens.CSRFToken = csrf
+		setSessionCookies(w, r, tokens, refreshExpires)
+		tokens.Key, tokens.RefreshToken = "", ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// handleSessionList shows the signed-in user's sessions
+func handleSessionList(w http.ResponseWriter, r *http.Request) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	sessionsMutex.Lock()
+	result := []Session{}
+	for _, session := range sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			result = append(result, *session)
+		}
+	}
+	sessionsMutex.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].LastUsedAt.After(result[j].LastUsedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleSessionRevoke signs out one of the user's sessions
+func handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	if revokeSessions(func(s *Session) bool { return s.ID == id && s.UserID == userID }) == 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLogout ends the session the request was made with
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	key := keyFromContext(r.Context())
+	if key == nil || key.SessionID == 0 {
+		http.Error(w, "Sign in as a user first", http.StatusForbidden)
+		return
+	}
+	revokeSessions(func(s *Session) bool { return s.ID == key.SessionID })
+	clearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	shadowCompareQueue = 256
+	shadowDivergences  = 50 // most recent divergences kept for /admin/shadow
+)
+
+// Divergence is a read where the shadow backend disagreed with the primary
+// store at the same revision
+type Divergence struct {
+	At        time.Time `json:"at"`
+	Read      string    `json:"read"` // get or list
+	Revision  int64     `json:"revision"`
+	StudentID int64     `json:"student_id"`
+	Primary   *Student  `json:"primary"` // nil if the student is missing
+	Shadow    *Student  `json:"shadow"`
+}
+
+type shadowCompare struct {
+	read     string
+	revision int64
+	roster   []Student // for get, the one student read, if found
+	id       int64     // for get
+}
+
+// shadowMirror dark-launches a storage backend: it follows the change log
+// like CDC does, applying every write to the backend, and compares reads in
+// the background, dropping them when it can't keep up. Nothing the backend
+// does affects responses.
+type shadowMirror struct {
+	backend  StudentStore
+	compares chan shadowCompare
+
+	mu          sync.Mutex
+	applied     int64 // revision the backend has caught up to
+	caughtUp    *sync.Cond
+	writeErrors int
+	lastError   string
+	compared    int
+	stale       int // reads the backend had moved past before comparing
+	dropped     int
+	divergences []Divergence
+	diverged    int
+}
+
+var shadow *shadowMirror
+
+// startShadow seeds the backend with the current roster and starts mirroring
+func startShadow(backend StudentStore) error {
+	s := &shadowMirror{backend: backend, compares: make(chan shadowCompare, shadowCompareQueue)}
+	s.caughtUp = sync.NewCond(&s.mu)
+	if err := s.resync(); err != nil {
+		return err
+	}
+	shadow = s
+	slog.Info("Shadowing writes", "backend", backend.Name(), "revision", s.applied)
+	go s.followChanges()
+	go s.compareReads()
+	return nil
+}
+
+// resync copies the whole roster to the backend
+func (s *shadowMirror) resync() error {
+	roster, revision := snapshotRoster()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.backend.Replace(roster, revision); err != nil {
+		return err
+	}
+	s.applied = revision
+	s.caughtUp.Broadcast()
+	return nil
+}
+
+// followChanges applies every change after the backend's revision, backing
+// off while the backend fails
+func (s *shadowMirror) followChanges() {
+	backoff := time.Second
+	for {
+		s.mu.Lock()
+		cursor := s.applied
+		s.mu.Unlock()
+
+		events, signal, ok := eventsAfter(cursor)
+		if !ok {
+			slog.Warn("Shadow backend fell behind the retained change log; copying the roster again")
+			if err := s.resync(); err != nil {
+				s.recordWriteError(err)
+				time.Sleep(backoff)
+			}
+			continue
+		}
+		if len(events) == 0 {
+			<-signal
+			continue
+		}
+
+		for _, change := range events {
+			s.mu.Lock()
+			err := s.backend.Apply(change)
+			if err == nil {
+				s.applied = change.ID
+				s.caughtUp.Broadcast()
+			}
+			s.mu.Unlock()
+			if err != nil {
+				s.recordWriteError(err)
+				time.Sleep(backoff)
+				backoff = min(backoff*2, time.Minute)
+				break
+			}
+			backoff = time.Second
+		}
+	}
+}
+
+func (s *shadowMirror) recordWriteError(err error) {
+	slog.Warn("Shadow write failed", "backend", s.backend.Name(), "error", err)
+	s.mu.Lock()
+	s.writeErrors++
+	s.lastError = err.Error()
+	s.mu.Unlock()
+}
+
+// compareReads checks queued reads once the backend reaches their revision.
+// The backend is read with s.mu held, so no write can land in between.
+func (s *shadowMirror) compareReads() {
+	for compare := range s.compares {
+		s.mu.Lock()
+		for s.applied < compare.revision {
+			s.caughtUp.Wait()
+		}
+		if s.applied != compare.revision {
+			s.stale++
+			s.mu.Unlock()
+			continue
+		}
+		var divergences []Divergence
+		if compare.read == "get" {
+			divergences = s.compareGetLocked(compare)
+		} else {
+			divergences = s.compareListLocked(compare)
+		}
+		s.compared++
+		s.diverged += len(divergences)
+		for _, divergence := range divergences {
+			slog.Warn("Shadow read diverged", "read", divergence.Read, "revision", divergence.Revision,
+				"student", divergence.StudentID, "primary", divergence.Primary, "shadow", divergence.Shadow)
+		}
+		s.divergences = append(s.divergences, divergences...)
+		if excess := len(s.divergences) - shadowDivergences; excess > 0 {
+			s.divergences = s.divergences[excess:]
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *shadowMirror) compareGetLocked(compare shadowCompare) []Divergence {
+	id := compare.id
+	var primary *Student
+	if len(compare.roster) == 1 {
+		primary = &compare.roster[0]
+	}
+	student, ok, err := s.backend.Get(context.Background(), id)
+	if err != nil {
+		s.lastError = err.Error()
+		return nil
+	}
+	var secondary *Student
+	if ok {
+		secondary = &student
+	}
+	if (primary == nil) == (secondary == nil) && (primary == nil || primary.equal(*secondary)) {
+		return nil
+	}
+	return []Divergence{{At: time.Now().UTC(), Read: "get", Revision: compare.revision, StudentID: id, Primary: primary, Shadow: secondary}}
+}
+
+func (s *shadowMirror) compareListLocked(compare shadowCompare) []Divergence {
+	roster, _, err := s.backend.List(context.Background())
+	if err != nil {
+		s.lastError = err.Error()
+		return nil
+	}
+	secondary := make(map[int64]Student, len(roster))
+	for _, student := range roster {
+		secondary[student.ID] = student
+	}
+	now := time.Now().UTC()
+	var divergences []Divergence
+	for i := range compare.roster {
+		primary := compare.roster[i]
+		student, ok := secondary[primary.ID]
+		delete(secondary, primary.ID)
+		if ok && student.equal(primary) {
+			continue
+		}
+		divergence := Divergence{At: now, Read: "list", Revision: compare.revision, StudentID: primary.ID, Primary: &compare.roster[i]}
+		if ok {
+			divergence.Shadow = &student
+		}
+		divergences = append(divergences, divergence)
+	}
+	for id, student := range secondary {
+		divergences = append(divergences, Divergence{At: now, Read: "list", Revision: compare.revision, StudentID: id, Shadow: &student})
+	}
+	return divergences
+}
+
+// queue hands a read to the comparer without ever blocking the request
+func (s *shadowMirror) queue(compare shadowCompare) {
+	select {
+	case s.compares <- compare:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		s.mu.Unlock()
+	}
+}
+
+// shadowGet queues a single-student read for comparison. Callers must hold
+// mutex, so changeSeq matches what they read.
+func shadowGet(id int64, student Student, found bool) {
+	if shadow == nil {
+		return
+	}
+	compare := shadowCompare{read: "get", revision: changeSeq, id: id}
+	if found {
+		compare.roster = []Student{student}
+	}
+	shadow.queue(compare)
+}
+
+// shadowList queues a full-roster read, as returned by snapshotRoster
+func shadowList(roster []Student, revision int64) {
+	if shadow == nil {
+		return
+	}
+	shadow.queue(shadowCompare{read: "list", revision: revision, roster: roster})
+}
+
+func (s *shadowMirror) stats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := map[string]interface{}{
+		"backend":      s.backend.Name(),
+		"applied":      s.applied,
+		"write_errors": s.writeErrors,
+		"last_error":   s.lastError,
+		"compared":     s.compared,
+		"stale":        s.stale,
+		"dropped":      s.dropped,
+		"diverged":     s.diverged,
+	}
+	return stats
+}
+
+// handleShadow reports how the shadow backend is keeping up and its most
+// recent divergences
+func handleShadow(w http.ResponseWriter, r *http.Request) {
+	if shadow == nil {
+		http.Error(w, "Shadow mode is off; start the server with -shadow", http.StatusNotFound)
+		return
+	}
+	response := shadow.stats()
+	shadow.mu.Lock()
+	response["divergences"] = append([]Divergence{}, shadow.divergences...)
+	shadow.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
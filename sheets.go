@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+type SheetExportRequest struct {
+	SpreadsheetID string `json:"spreadsheet_id"`
+	Sheet         string `json:"sheet"`
+	Mode          string `json:"mode"`             // "replace" or "append"
+	Filter        string `json:"filter,omitempty"` // a saved filter; the whole roster if empty
+}
+
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// loadServiceAccount reads the key from the GOOGLE_SERVICE_ACCOUNT_JSON secret,
+// or from the file pointed to by GOOGLE_APPLICATION_CREDENTIALS
+func loadServiceAccount() (*serviceAccount, error) {
+	data := []byte(getSecret("GOOGLE_SERVICE_ACCOUNT_JSON"))
+	if len(data) == 0 {
+		path := getSecret("GOOGLE_APPLICATION_CREDENTIALS")
+		if path == "" {
+			return nil, fmt.Errorf("neither GOOGLE_SERVICE_ACCOUNT_JSON nor GOOGLE_APPLICATION_CREDENTIALS is set")
+		}
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account credentials: %v", err)
+		}
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("invalid service account credentials: %v", err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, fmt.Errorf("service account credentials are missing client_email or private_key")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &sa, nil
+}
+
+// accessToken exchanges a signed JWT assertion for an OAuth2 access token
+func (sa *serviceAccount) accessToken() (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key in service account credentials")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key in service account credentials: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account private key is not an RSA key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": sheetsScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %v", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	resp, err := http.PostForm(sa.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain access token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status: %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", err
+	}
+	return tokenResp.AccessToken, nil
+}
+
+func sheetsCall(token, method, endpoint string, body interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint, &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Google Sheets API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Google Sheets API returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// exportToGoogleSheet writes the roster into the given tab, either replacing its
+// contents (with a header row) or appending the rows below existing data
+func exportToGoogleSheet(export SheetExportRequest, roster []Student) error {
+	sa, err := loadServiceAccount()
+	if err != nil {
+		return err
+	}
+	token, err := sa.accessToken()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]interface{}{}
+	if export.Mode == "replace" {
+		rows = append(rows, []interface{}{"ID", "Name", "Age", "Email"})
+	}
+	for _, student := range roster {
+		rows = append(rows, []interface{}{student.ID, student.Name, student.Age, student.Email})
+	}
+
+	base := "https://sheets.googleapis.com/v4/spreadsheets/" + url.PathEscape(export.SpreadsheetID) +
+		"/values/" + url.PathEscape(export.Sheet)
+	body := map[string]interface{}{
+		"majorDimension": "ROWS",
+		"values":         rows,
+	}
+
+	if export.Mode == "append" {
+		return sheetsCall(token, http.MethodPost, base+":append?valueInputOption=RAW&insertDataOption=INSERT_ROWS", body)
+	}
+
+	if err := sheetsCall(token, http.MethodPost, base+":clear", nil); err != nil {
+		return err
+	}
+	return sheetsCall(token, http.MethodPut, base+"?valueInputOption=RAW", body)
+}
+
+func handleGoogleSheetExport(w http.ResponseWriter, r *http.Request) {
+	var export SheetExportRequest
+
+	// Check if it's JSON request
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := decodeJSON(w, r, &export); err != nil {
+			http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		export.SpreadsheetID = r.FormValue("spreadsheet_id")
+		export.Sheet = r.FormValue("sheet")
+		export.Mode = r.FormValue("mode")
+		export.Filter = r.FormValue("filter")
+	}
+
+	if export.SpreadsheetID == "" {
+		http.Error(w, "spreadsheet_id is required", http.StatusBadRequest)
+		return
+	}
+	if export.Sheet == "" {
+		export.Sheet = "Sheet1"
+	}
+	export.Mode = strings.ToLower(export.Mode)
+	if export.Mode == "" {
+		export.Mode = "replace"
+	}
+	if export.Mode != "replace" && export.Mode != "append" {
+		http.Error(w, "mode must be either replace or append", http.StatusBadRequest)
+		return
+	}
+
+	roster, revision := snapshotRoster()
+	roster = visibleStudents(requestTenantName(r), roster)
+	if export.Filter != "" {
+		filter, ok := lookupSavedFilter(requestTenantName(r), export.Filter)
+		if !ok {
+			http.Error(w, "Invalid filter: "+errUnknownFilter.Error(), http.StatusBadRequest)
+			return
+		}
+		roster = filter.apply(roster)
+	}
+
+	if err := exportToGoogleSheet(export, roster); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export to Google Sheets: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"spreadsheet_id": export.SpreadsheetID,
+		"sheet":          export.Sheet,
+		"mode":           export.Mode,
+		"rows":           len(roster),
+		"revision":       revision,
+	})
+}
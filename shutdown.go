@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serveUntilSignal serves until SIGINT or SIGTERM, then shuts down within
+// timeout: it stops accepting connections, lets requests in flight finish,
+// waits for the store to catch up with the roster and closes it. Streams
+// still open when the time is up are cut. A second signal exits at once.
+func serveUntilSignal(server *http.Server, timeout time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	slog.Info("Shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Closing connections still open", "error", err)
+		server.Close()
+	}
+	if err := drainStoreMirror(ctx); err != nil {
+		slog.Error("The store didn't catch up before shutting down", "store", studentStore.Name(), "error", err)
+	}
+	slog.Info("Shut down")
+}
+
+// drainStoreMirror waits for followStore to write everything committed so
+// far, then closes the store. Changes committed later aren't written.
+func drainStoreMirror(ctx context.Context) error {
+	if _, inMemory := studentStore.(memoryStore); inMemory {
+		return nil
+	}
+	for {
+		mutex.RLock()
+		revision := changeSeq
+		mutex.RUnlock()
+		storeMirror.mu.Lock()
+		if storeMirror.applied >= revision {
+			err := studentStore.Close()
+			storeMirror.mu.Unlock()
+			return err
+		}
+		behind := revision - storeMirror.applied
+		storeMirror.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d revisions behind: %v", behind, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
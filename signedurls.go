@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSignedURLTTL = 24 * time.Hour
+	maxSignedURLTTL     = 7 * 24 * time.Hour
+)
+
+// signableStudentID matches what studentIDFromPath accepts: a numeric ID or
+// a UUID in the canonical form
+const signableStudentID = `([0-9]+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})`
+
+// signableResources are the GET endpoints a link can be signed for, with the
+// scope the signer needs and the link grants
+var signableResources = []struct {
+	path  *regexp.Regexp
+	scope string
+}{
+	{regexp.MustCompile(`^/students/` + signableStudentID + `$`), ScopeStudentsRead},
+	{regexp.MustCompile(`^/students/` + signableStudentID + `/summary(/stream)?$`), ScopeSummariesGenerate},
+	{regexp.MustCompile(`^/students/export$`), ScopeStudentsRead},
+}
+
+var (
+	errSignedURL = errors.New("invalid or expired link")
+
+	processSigningKey     []byte
+	processSigningKeyOnce sync.Once
+)
+
+// urlSigningKey comes from the URL_SIGNING_KEY secret; rotating it revokes
+// every outstanding link. Without it a random key is used, so links stop
+// working when the process restarts.
+func urlSigningKey() []byte {
+	if key := getSecret("URL_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+	processSigningKeyOnce.Do(func() {
+		processSigningKey = make([]byte, 32)
+		rand.Read(processSigningKey)
+	})
+	return processSigningKey
+}
+
+func signableScope(path string) (string, bool) {
+	for _, resource := range signableResources {
+		if resource.path.MatchString(path) {
+			return resource.scope, true
+		}
+	}
+	return "", false
+}
+
+// urlSignature signs the path and every query parameter but sig. Encode
+// sorts the parameters, so their order in the link doesn't matter.
+func urlSignature(path string, query url.Values) string {
+	query.Del("sig")
+	mac := hmac.New(sha256.New, urlSigningKey())
+	mac.Write([]byte(http.MethodGet + "\n" + path + "\n" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLKey checks a signed link and returns a key limited to what it
+// grants: read access to that one resource, within the signer's tenant
+func signedURLKey(r *http.Request, now time.Time) (*APIKey, error) {
+	query := r.URL.Query()
+	scope, ok := signableScope(r.URL.Path)
+	if r.Method != http.MethodGet || !ok {
+		return nil, errSignedURL
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return nil, errSignedURL
+	}
+	sig, err := hex.DecodeString(query.Get("sig"))
+	expected, _ := hex.DecodeString(urlSignature(r.URL.Path, query))
+	if err != nil || !hmac.Equal(sig, expected) {
+		return nil, errSignedURL
+	}
+	if name := query.Get("tenant"); name != "" {
+		if tenant, ok := lookupTenant(name); !ok || tenant.Status != TenantActive {
+			return nil, errSignedURL
+		}
+	}
+	expiresAt := time.Unix(expires, 0).UTC()
+	return &APIKey{
+		Name:      "signed-url",
+		Role:      RoleRead,
+		Tenant:    query.Get("tenant"),
+		Scopes:    []string{scope},
+		ExpiresAt: &expiresAt,
+	}, nil
+}
+
+// SignURLRequest is the body of POST /links
+type SignURLRequest struct {
+	Path string   `json:"path"` // may include a query, e.g. /students/export?anonymized=true
+	TTL  Duration `json:"ttl"`  // default 24h, at most 7 days
+}
+
+// handleSignURL creates a time-limited link to a resource, for sharing with
+// people who have no API key. The signer must be allowed to read the
+// resource, and the link only shows what the signer's tenant can see.
+func handleSignURL(w http.ResponseWriter, r *http.Request) {
+	key := keyFromContext(r.Context())
+	if key == nil {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+	var input SignURLRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(input.Path)
+	if err != nil || target.IsAbs() || target.Host != "" {
+		http.Error(w, "path must be a path on this server", http.StatusBadRequest)
+		return
+	}
+	scope, ok := signableScope(target.Path)
+	if !ok {
+		http.Error(w, "Links can only be signed for a student, a student summary or the export", http.StatusBadRequest)
+		return
+	}
+	if !hasScope(key.scopes(), scope) {
+		http.Error(w, "API key is missing the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+	ttl := time.Duration(input.TTL)
+	if ttl == 0 {
+		ttl = defaultSignedURLTTL
+	}
+	if ttl < 0 || ttl > maxSignedURLTTL {
+		http.Error(w, "ttl must be positive and at most 168h", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	query := target.Query()
+	for _, reserved := range []string{"expires", "tenant", "sig"} {
+		query.Del(reserved)
+	}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	if key.Tenant != "" {
+		query.Set("tenant", key.Tenant)
+	}
+	query.Set("sig", urlSignature(target.Path, query))
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	link := url.URL{Scheme: scheme, Host: r.Host, Path: target.Path, RawQuery: query.Encode()}
+	slog.Info("Signed URL created", "key", key.Name, "tenant", key.Tenant, "path", target.Path, "expires_at", expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        link.String(),
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SignupSettings turn on self-service signup, for hosted deployments. New
+// tenants get the plan and quotas given here.
+type SignupSettings struct {
+	Enabled           bool   `json:"enabled"`
+	VerifyURL         string `json:"verify_url"` // public URL of GET /signup/verify
+	Plan              string `json:"plan"`
+	MaxStudents       int    `json:"max_students"`
+	MaxLLMCallsPerDay int    `json:"max_llm_calls_per_day"`
+}
+
+func (s SignupSettings) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	u, err := url.Parse(s.VerifyURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("signup: verify_url must be an http or https URL")
+	}
+	if s.MaxStudents < 0 || s.MaxLLMCallsPerDay < 0 {
+		return fmt.Errorf("signup: quotas must not be negative")
+	}
+	return nil
+}
+
+// pendingSignup is a tenant waiting for its owner to confirm their email
+type pendingSignup struct {
+	tenant    string
+	email     string
+	expiresAt time.Time
+}
+
+const (
+	signupTokenTTL    = 24 * time.Hour
+	maxPendingSignups = 1000
+)
+
+var (
+	signup         SignupSettings
+	pendingSignups = map[string]pendingSignup{} // keyed by the token's hash
+	signupMutex    sync.Mutex
+)
+
+func setSignupSettings(settings SignupSettings) {
+	signupMutex.Lock()
+	signup = settings
+	signupMutex.Unlock()
+}
+
+// expireSignupsLocked drops unconfirmed signups past their deadline and frees
+// their tenant names. Callers must hold signupMutex.
+func expireSignupsLocked(now time.Time) {
+	for hash, pending := range pendingSignups {
+		if now.Before(pending.expiresAt) {
+			continue
+		}
+		delete(pendingSignups, hash)
+		tenantsMutex.Lock()
+		if tenant, ok := tenants[pending.tenant]; ok && tenant.Status == TenantPending {
+			delete(tenants, pending.tenant)
+		}
+		tenantsMutex.Unlock()
+	}
+}
+
+// SignupRequest is the body of POST /signup
+type SignupRequest struct {
+	Tenant string `json:"tenant"`
+	Email  string `json:"email"`
+}
+
+// handleSignup creates a pending tenant and emails its owner a verification
+// link. The tenant becomes active, and gets its first API key, when the link
+// is opened.
+func handleSignup(w http.ResponseWriter, r *http.Request) {
+	signupMutex.Lock()
+	settings := signup
+	signupMutex.Unlock()
+	if !settings.Enabled {
+		http.Error(w, "Signup is disabled", http.StatusNotFound)
+		return
+	}
+
+	var input SignupRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !tenantNamePattern.MatchString(input.Tenant) {
+		http.Error(w, "tenant must be 1-63 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	address, err := mail.ParseAddress(input.Email)
+	if err != nil || address.Name != "" {
+		http.Error(w, "email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to generate verification token", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	now := time.Now()
+
+	signupMutex.Lock()
+	expireSignupsLocked(now)
+	if len(pendingSignups) >= maxPendingSignups {
+		signupMutex.Unlock()
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "Too many pending signups, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	tenant := Tenant{
+		Name:              input.Tenant,
+		Plan:              settings.Plan,
+		MaxStudents:       settings.MaxStudents,
+		MaxLLMCallsPerDay: settings.MaxLLMCallsPerDay,
+		Status:            TenantPending,
+		CreatedAt:         now.UTC(),
+	}
+	tenant.compile()
+	tenantsMutex.Lock()
+	_, exists := tenants[tenant.Name]
+	if !exists {
+		tenants[tenant.Name] = &tenant
+	}
+	tenantsMutex.Unlock()
+	if exists {
+		signupMutex.Unlock()
+		http.Error(w, "Tenant already exists", http.StatusConflict)
+		return
+	}
+	pendingSignups[hashAPIKey(token)] = pendingSignup{tenant: tenant.Name, email: address.Address, expiresAt: now.Add(signupTokenTTL)}
+	signupMutex.Unlock()
+
+	link := settings.VerifyURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Confirm your email to activate %s on the Student Management API:\n\n%s\n\nThe link expires in 24 hours.\n", tenant.Name, link)
+	if err := sendMail(address.Address, "Confirm your email", body); err != nil {
+		slog.Error("Failed to send signup verification", "tenant", tenant.Name, "error", err)
+		http.Error(w, "Failed to send verification email", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"tenant": tenant.Name, "status": TenantPending})
+}
+
+// handleSignupVerify activates a pending tenant and issues its first API key,
+// which is only shown here
+func handleSignupVerify(w http.ResponseWriter, r *http.Request) {
+	hash := hashAPIKey(r.URL.Query().Get("token"))
+	signupMutex.Lock()
+	expireSignupsLocked(time.Now())
+	pending, ok := pendingSignups[hash]
+	delete(pendingSignups, hash)
+	signupMutex.Unlock()
+	if !ok {
+		http.Error(w, "Invalid or expired verification link", http.StatusNotFound)
+		return
+	}
+
+	tenantsMutex.Lock()
+	tenant, ok := tenants[pending.tenant]
+	if ok {
+		tenant.Status = TenantActive
+	}
+	tenantsMutex.Unlock()
+	if !ok {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+	key := &APIKey{
+		Name:      pending.email,
+		Role:      RoleWrite,
+		Tenant:    pending.tenant,
+		Prefix:    secret[:8],
+		CreatedAt: time.Now().UTC(),
+		hash:      hashAPIKey(secret),
+	}
+	apiKeysMutex.Lock()
+	apiKeySeq++
+	key.ID = apiKeySeq
+	apiKeys = append(apiKeys, key)
+	issued := IssuedAPIKey{APIKey: *key, Key: secret}
+	apiKeysMutex.Unlock()
+	saveAuthState()
+	slog.Info("Tenant signup verified", "tenant", pending.tenant, "email", pending.email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issued)
+}
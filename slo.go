@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	sloBuckets     = 60   // one-minute buckets, so burn rates cover up to an hour
+	sloLatencyRing = 1024 // recent latencies kept per route for percentiles
+)
+
+// SLO is the objective for one route, e.g. "GET /students/{id}/summary".
+// Availability is the fraction of requests that must not fail with a 5xx;
+// LatencyTarget is the fraction that must finish within Latency.
+type SLO struct {
+	Route         string   `json:"route"`
+	Availability  float64  `json:"availability"`
+	Latency       Duration `json:"latency"`
+	LatencyTarget float64  `json:"latency_target"`
+}
+
+func (s SLO) validate() error {
+	if s.Availability <= 0 || s.Availability >= 1 || s.LatencyTarget <= 0 || s.LatencyTarget >= 1 {
+		return fmt.Errorf("slo %q: availability and latency_target must be between 0 and 1", s.Route)
+	}
+	if s.Latency <= 0 {
+		return fmt.Errorf("slo %q: latency must be positive", s.Route)
+	}
+	return nil
+}
+
+// defaultSLO applies to every route without its own objective
+var defaultSLO = SLO{Availability: 0.999, Latency: Duration(500 * time.Millisecond), LatencyTarget: 0.99}
+
+type sloBucket struct {
+	minute int64
+	total  int
+	errors int
+	slow   int
+}
+
+type routeStats struct {
+	buckets   [sloBuckets]sloBucket
+	latencies [sloLatencyRing]time.Duration
+	recorded  int
+}
+
+var (
+	sloObjectives = map[string]SLO{
+		"GET /students/{id}/summary": {Route: "GET /students/{id}/summary", Availability: 0.99, Latency: Duration(5 * time.Second), LatencyTarget: 0.95},
+	}
+	sloStats = map[string]*routeStats{}
+	sloMutex sync.Mutex
+)
+
+func objectiveFor(route string) SLO {
+	if objective, ok := sloObjectives[route]; ok {
+		return objective
+	}
+	objective := defaultSLO
+	objective.Route = route
+	return objective
+}
+
+// setSLOs replaces the configured objectives
+func setSLOs(objectives []SLO) {
+	byRoute := map[string]SLO{}
+	for _, objective := range objectives {
+		byRoute[objective.Route] = objective
+	}
+	sloMutex.Lock()
+	sloObjectives = byRoute
+	sloMutex.Unlock()
+}
+
+// recordSLO counts a finished request against its route's objective
+func recordSLO(route string, status int, duration time.Duration) {
+	if route == "" {
+		return
+	}
+	minute := time.Now().Unix() / 60
+
+	sloMutex.Lock()
+	defer sloMutex.Unlock()
+	stats := sloStats[route]
+	if stats == nil {
+		stats = &routeStats{}
+		sloStats[route] = stats
+	}
+	bucket := &stats.buckets[minute%sloBuckets]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if status >= 500 {
+		bucket.errors++
+	}
+	if duration > time.Duration(objectiveFor(route).Latency) {
+		bucket.slow++
+	}
+	stats.latencies[stats.recorded%sloLatencyRing] = duration
+	stats.recorded++
+}
+
+// window sums the buckets of the last minutes
+func (s *routeStats) window(minutes int64) sloBucket {
+	now := time.Now().Unix() / 60
+	var sum sloBucket
+	for _, bucket := range s.buckets {
+		if bucket.minute > now-minutes {
+			sum.total += bucket.total
+			sum.errors += bucket.errors
+			sum.slow += bucket.slow
+		}
+	}
+	return sum
+}
+
+func (s *routeStats) percentiles() map[string]Duration {
+	n := min(s.recorded, sloLatencyRing)
+	sorted := append([]time.Duration{}, s.latencies[:n]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	result := map[string]Duration{}
+	for name, p := range map[string]float64{"p50": 0.50, "p90": 0.90, "p99": 0.99} {
+		result[name] = Duration(sorted[int(p*float64(n-1))])
+	}
+	return result
+}
+
+// burnRate is how fast the error budget is being spent: 1 means exactly on
+// budget, above 1 means the objective will be missed if it continues
+func burnRate(bad, total int, objective float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - objective)
+}
+
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+	sloMutex.Lock()
+	var routes []string
+	for route := range sloStats {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	report := []map[string]interface{}{}
+	for _, route := range routes {
+		stats := sloStats[route]
+		objective := objectiveFor(route)
+		hour, fiveMinutes := stats.window(60), stats.window(5)
+
+		successRate := 1.0
+		withinLatency := 1.0
+		if hour.total > 0 {
+			successRate = 1 - float64(hour.errors)/float64(hour.total)
+			withinLatency = 1 - float64(hour.slow)/float64(hour.total)
+		}
+		report = append(report, map[string]interface{}{
+			"route":          route,
+			"objective":      objective,
+			"requests_1h":    hour.total,
+			"success_rate":   successRate,
+			"within_latency": withinLatency,
+			"latency":        stats.percentiles(),
+			"availability_burn_rate": map[string]float64{
+				"5m": burnRate(fiveMinutes.errors, fiveMinutes.total, objective.Availability),
+				"1h": burnRate(hour.errors, hour.total, objective.Availability),
+			},
+			"latency_burn_rate": map[string]float64{
+				"5m": burnRate(fiveMinutes.slow, fiveMinutes.total, objective.LatencyTarget),
+				"1h": burnRate(hour.slow, hour.total, objective.LatencyTarget),
+			},
+			"meeting_objective": successRate >= objective.Availability && withinLatency >= objective.LatencyTarget,
+		})
+	}
+	sloMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"routes": report})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	slowLogSize   = 50             // worst requests kept
+	slowLogWindow = 24 * time.Hour // older entries drop out
+)
+
+// SlowRequest is one entry of GET /admin/slowlog
+type SlowRequest struct {
+	Method   string              `json:"method"`
+	Route    string              `json:"route"`
+	Path     string              `json:"path"`
+	Status   int                 `json:"status"`
+	Duration Duration            `json:"duration"`
+	Stages   map[string]Duration `json:"stages,omitempty"`
+	At       time.Time           `json:"at"`
+}
+
+var (
+	slowThreshold time.Duration
+	slowLog       []SlowRequest
+	slowLogMutex  sync.Mutex
+)
+
+type traceKey struct{}
+
+// requestTrace collects the matched route and the time spent in each stage of
+// a request, such as the store or the LLM
+type requestTrace struct {
+	mu     sync.Mutex
+	route  string
+	stages map[string]time.Duration
+}
+
+// Traces are reused, since every request needs one
+var tracePool = sync.Pool{New: func() interface{} {
+	return &requestTrace{stages: map[string]time.Duration{}}
+}}
+
+func releaseTrace(trace *requestTrace) {
+	trace.mu.Lock()
+	trace.route = ""
+	clear(trace.stages)
+	trace.mu.Unlock()
+	tracePool.Put(trace)
+}
+
+func traceFromContext(ctx context.Context) *requestTrace {
+	trace, _ := ctx.Value(traceKey{}).(*requestTrace)
+	return trace
+}
+
+func setTraceRoute(ctx context.Context, route string) {
+	if trace := traceFromContext(ctx); trace != nil {
+		trace.mu.Lock()
+		trace.route = route
+		trace.mu.Unlock()
+	}
+}
+
+// stageTimer times one stage of a request. It is a value, so timing a
+// stage doesn't allocate.
+type stageTimer struct {
+	trace *requestTrace
+	stage string
+	start time.Time
+}
+
+// timeStage starts timing a stage of the request; stop ends it. It is a
+// no-op when the request is not traced.
+func timeStage(ctx context.Context, stage string) stageTimer {
+	trace := traceFromContext(ctx)
+	if trace == nil {
+		return stageTimer{}
+	}
+	return stageTimer{trace: trace, stage: stage, start: time.Now()}
+}
+
+func (t stageTimer) stop() {
+	if t.trace == nil {
+		return
+	}
+	t.trace.mu.Lock()
+	t.trace.stages[t.stage] += time.Since(t.start)
+	t.trace.mu.Unlock()
+}
+
+// observeRequests traces every request for the SLO tracker, logs those slower
+// than slowThreshold and keeps the worst of them for GET /admin/slowlog
+func observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace := tracePool.Get().(*requestTrace)
+		defer releaseTrace(trace)
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), traceKey{}, trace)))
+		duration := time.Since(start)
+
+		trace.mu.Lock()
+		route := trace.route
+		trace.mu.Unlock()
+		recordSLO(route, recorder.status, duration)
+		if slowThreshold <= 0 || duration < slowThreshold {
+			return
+		}
+
+		trace.mu.Lock()
+		entry := SlowRequest{
+			Method:   r.Method,
+			Route:    trace.route,
+			Path:     r.URL.Path,
+			Status:   recorder.status,
+			Duration: Duration(duration),
+			Stages:   map[string]Duration{},
+			At:       start.UTC(),
+		}
+		attrs := []any{"method", r.Method, "route", trace.route, "path", r.URL.Path,
+			"status", recorder.status, "duration", duration}
+		for stage, spent := range trace.stages {
+			entry.Stages[stage] = Duration(spent)
+			attrs = append(attrs, stage, spent)
+		}
+		trace.mu.Unlock()
+
+		slog.Warn("Slow request", attrs...)
+		recordSlowRequest(entry)
+	})
+}
+
+// recordSlowRequest adds entry to the slow log, evicting expired entries and
+// then the fastest one when the log is full
+func recordSlowRequest(entry SlowRequest) {
+	slowLogMutex.Lock()
+	defer slowLogMutex.Unlock()
+
+	cutoff := time.Now().Add(-slowLogWindow)
+	kept := slowLog[:0]
+	for _, existing := range slowLog {
+		if existing.At.After(cutoff) {
+			kept = append(kept, existing)
+		}
+	}
+	slowLog = append(kept, entry)
+	sort.SliceStable(slowLog, func(i, j int) bool { return slowLog[i].Duration > slowLog[j].Duration })
+	if len(slowLog) > slowLogSize {
+		slowLog = slowLog[:slowLogSize]
+	}
+}
+
+func handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	slowLogMutex.Lock()
+	entries := append([]SlowRequest{}, slowLog...)
+	slowLogMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"threshold": Duration(slowThreshold),
+		"requests":  entries,
+	})
+}
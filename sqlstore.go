@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sqlDialect is what differs between the SQL databases the store runs on
+type sqlDialect struct {
+	name   string // as in the store spec
+	driver string // as registered with database/sql
+	tag    string // build tag that links the driver in
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+}
+
+var (
+	sqliteDialect   = sqlDialect{name: "sqlite", driver: "sqlite", tag: "sqlite"}
+	postgresDialect = sqlDialect{name: "postgres", driver: "pgx", tag: "postgres", numbered: true}
+)
+
+// sqlSchema works on SQLite and Postgres alike. Students are kept as their
+// JSON, the format of the write-ahead log, so new fields need no migration.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS students (id BIGINT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS roster_revision (id INTEGER PRIMARY KEY CHECK (id = 1), revision BIGINT NOT NULL)`,
+	`INSERT INTO roster_revision (id, revision) VALUES (1, 0) ON CONFLICT (id) DO NOTHING`,
+	`CREATE TABLE IF NOT EXISTS auth_state (id INTEGER PRIMARY KEY CHECK (id = 1), data TEXT NOT NULL)`,
+	// The highest student ID ever stored, so deleted IDs are never reused
+	`CREATE TABLE IF NOT EXISTS student_ids (id INTEGER PRIMARY KEY CHECK (id = 1), last_id BIGINT NOT NULL)`,
+	`INSERT INTO student_ids (id, last_id) VALUES (1, 0) ON CONFLICT (id) DO NOTHING`,
+}
+
+// sqlStore keeps the roster in a SQL database. Besides serving as -store, a
+// roster can be moved into one with migrate-data and a new database tried
+// out with -shadow first.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	name    string
+}
+
+func openSQLStore(dialect sqlDialect, dsn string) (*sqlStore, error) {
+	if !slices.Contains(sql.Drivers(), dialect.driver) {
+		return nil, fmt.Errorf("this build has no %s driver; build with -tags %s", dialect.name, dialect.tag)
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %v", dialect.name, err)
+	}
+	if dialect == sqliteDialect {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s store: %v", dialect.name, err)
+	}
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create %s schema: %v", dialect.name, err)
+		}
+	}
+	name := dialect.name + ":" + dsn
+	if dialect == postgresDialect {
+		name = dialect.name + ":" + redactDSN(dsn)
+	}
+	return &sqlStore{db: db, dialect: dialect, name: name}, nil
+}
+
+// redactDSN hides the password in a Postgres connection string, for logs
+func redactDSN(dsn string) string {
+	if at := strings.LastIndex(dsn, "@"); strings.Contains(dsn, "://") && at >= 0 {
+		scheme, rest, _ := strings.Cut(dsn[:at], "://")
+		if user, _, hasPassword := strings.Cut(rest, ":"); hasPassword {
+			return scheme + "://" + user + ":xxxxx" + dsn[at:]
+		}
+		return dsn
+	}
+	fields := strings.Fields(dsn)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "password=") {
+			fields[i] = "password=xxxxx"
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// query rewrites ? placeholders for the dialect
+func (s *sqlStore) query(statement string) string {
+	if !s.dialect.numbered {
+		return statement
+	}
+	var rewritten strings.Builder
+	n := 0
+	for _, c := range statement {
+		if c == '?' {
+			n++
+			rewritten.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rewritten.WriteRune(c)
+	}
+	return rewritten.String()
+}
+
+func (s *sqlStore) Name() string { return s.name }
+
+// write runs fn in a transaction that also records the revision
+func (s *sqlStore) write(revision int64, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.query(`UPDATE roster_revision SET revision = ? WHERE id = 1`), revision); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// apply writes one change within a transaction. A change that doesn't fit
+// what's stored means the store has drifted from the roster.
+func (s *sqlStore) apply(tx *sql.Tx, change Change) error {
+	if change.Event == EventStudentDeleted {
+		result, err := tx.Exec(s.query(`DELETE FROM students WHERE id = ?`), change.Student.ID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("student %d is not in the %s store", change.Student.ID, s.dialect.name)
+		}
+		return nil
+	}
+	data, err := json.Marshal(change.Student)
+	if err != nil {
+		return err
+	}
+	if change.Event == EventStudentCreated {
+		if _, err := tx.Exec(s.query(`INSERT INTO students (id, data) VALUES (?, ?)`), change.Student.ID, string(data)); err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.query(`UPDATE student_ids SET last_id = ? WHERE id = 1 AND last_id < ?`), change.Student.ID, change.Student.ID)
+		return err
+	}
+	result, err := tx.Exec(s.query(`UPDATE students SET data = ? WHERE id = ?`), string(data), change.Student.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("student %d is not in the %s store", change.Student.ID, s.dialect.name)
+	}
+	return nil
+}
+
+func (s *sqlStore) Apply(change Change) error {
+	return s.ApplyBatch([]Change{change})
+}
+
+// ApplyBatch writes the changes in one transaction
+func (s *sqlStore) ApplyBatch(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return s.write(changes[len(changes)-1].ID, func(tx *sql.Tx) error {
+		for _, change := range changes {
+			if err := s.apply(tx, change); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) Replace(roster []Student, seq int64) error {
+	return s.write(seq, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM students`); err != nil {
+			return err
+		}
+		for _, student := range roster {
+			if err := s.apply(tx, Change{Event: EventStudentCreated, Student: student}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) Get(ctx context.Context, id int64) (Student, bool, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT data FROM students WHERE id = ?`), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Student{}, false, nil
+	}
+	if err != nil {
+		return Student{}, false, err
+	}
+	var student Student
+	if err := json.Unmarshal([]byte(data), &student); err != nil {
+		return Student{}, false, fmt.Errorf("corrupt student %d in the %s store: %v", id, s.dialect.name, err)
+	}
+	return student, true, nil
+}
+
+// List reads the roster and its revision in one transaction, so they agree
+func (s *sqlStore) List(ctx context.Context) ([]Student, int64, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	var revision int64
+	if err := tx.QueryRowContext(ctx, `SELECT revision FROM roster_revision WHERE id = 1`).Scan(&revision); err != nil {
+		return nil, 0, err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, data FROM students ORDER BY id`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	roster := []Student{}
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, 0, err
+		}
+		var student Student
+		if err := json.Unmarshal([]byte(data), &student); err != nil {
+			return nil, 0, fmt.Errorf("corrupt student %d in the %s store: %v", id, s.dialect.name, err)
+		}
+		roster = append(roster, student)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return roster, revision, nil
+}
+
+// LastStudentID returns the highest student ID the store has held. Deleting
+// students and replacing the roster never lower it.
+func (s *sqlStore) LastStudentID(ctx context.Context) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `SELECT last_id FROM student_ids WHERE id = 1`).Scan(&id)
+	return id, err
+}
+
+func (s *sqlStore) SaveAuthState(state []byte) error {
+	_, err := s.db.Exec(s.query(`INSERT INTO auth_state (id, data) VALUES (1, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data`), string(state))
+	return err
+}
+
+func (s *sqlStore) LoadAuthState(ctx context.Context) ([]byte, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM auth_state WHERE id = 1`).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return []byte(data), err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
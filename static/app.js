@@ -0,0 +1,17 @@
+// Shows failed requests in the page's flash area and resets forms marked
+// data-reset after they submit successfully
+(function () {
+  "use strict";
+
+  document.addEventListener("htmx:responseError", function (event) {
+    var flash = document.getElementById("flash");
+    if (flash) flash.textContent = event.detail.xhr.responseText || "Request failed (" + event.detail.xhr.status + ")";
+  });
+
+  document.addEventListener("htmx:afterRequest", function (event) {
+    var flash = document.getElementById("flash");
+    if (event.detail.successful && flash) flash.textContent = "";
+    var form = event.detail.elt;
+    if (event.detail.successful && form.matches("form[data-reset]")) form.reset();
+  });
+})();
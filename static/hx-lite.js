@@ -0,0 +1,143 @@
+// A minimal runtime for the hx-* attributes the pages in templates/ use:
+// hx-get/post/put/delete, hx-target, hx-swap, hx-trigger (with "changed" and
+// "delay:"), hx-confirm, hx-include and hx-headers. It is not htmx, only a
+// fallback for builds that haven't vendored it; `go generate` fetches the
+// pinned htmx release, which the pages then load instead.
+(function () {
+  "use strict";
+
+  var verbs = ["get", "post", "put", "delete"];
+  var selector = verbs.map(function (v) { return "[hx-" + v + "]"; }).join(",");
+  var timers = new WeakMap();
+  var lastValues = new WeakMap();
+
+  function defaultTrigger(el) {
+    if (el.matches("form")) return "submit";
+    if (el.matches("input, select, textarea")) return "change";
+    return "click";
+  }
+
+  function triggers(el) {
+    return (el.getAttribute("hx-trigger") || defaultTrigger(el)).split(",").map(function (spec) {
+      var parts = spec.trim().split(/\s+/);
+      var trigger = { event: parts[0], changed: false, delay: 0 };
+      parts.slice(1).forEach(function (modifier) {
+        if (modifier === "changed") trigger.changed = true;
+        var delay = /^delay:(\d+)(ms|s)$/.exec(modifier);
+        if (delay) trigger.delay = Number(delay[1]) * (delay[2] === "s" ? 1000 : 1);
+      });
+      return trigger;
+    });
+  }
+
+  function resolve(el, spec) {
+    if (!spec || spec === "this") return el;
+    if (spec.indexOf("closest ") === 0) return el.closest(spec.slice(8));
+    if (spec.indexOf("find ") === 0) return el.querySelector(spec.slice(5));
+    return document.querySelector(spec);
+  }
+
+  function inherited(el, name) {
+    var owner = el.closest("[" + name + "]");
+    return owner ? owner.getAttribute(name) : null;
+  }
+
+  function headers(el) {
+    var result = { "HX-Request": "true", "Accept": "text/html" };
+    var chain = [];
+    for (var node = el; node && node.getAttribute; node = node.parentElement) {
+      if (node.hasAttribute("hx-headers")) chain.unshift(JSON.parse(node.getAttribute("hx-headers")));
+    }
+    chain.forEach(function (values) { Object.assign(result, values); });
+    return result;
+  }
+
+  function values(el) {
+    var params = new URLSearchParams();
+    var add = function (container) {
+      if (container.matches("form")) {
+        new FormData(container).forEach(function (value, name) { params.append(name, value); });
+      } else if (container.name) {
+        params.append(container.name, container.value);
+      } else {
+        container.querySelectorAll("input[name], select[name], textarea[name]").forEach(add);
+      }
+    };
+    add(el);
+    var include = el.getAttribute("hx-include");
+    if (include) add(resolve(el, include));
+    return params;
+  }
+
+  function swap(target, html, style) {
+    switch (style) {
+      case "outerHTML": target.outerHTML = html; break;
+      case "beforeend": target.insertAdjacentHTML("beforeend", html); break;
+      case "afterbegin": target.insertAdjacentHTML("afterbegin", html); break;
+      case "none": break;
+      default: target.innerHTML = html;
+    }
+  }
+
+  function fire(el, name, detail) {
+    el.dispatchEvent(new CustomEvent(name, { bubbles: true, detail: detail }));
+  }
+
+  function issue(el) {
+    var confirmation = el.getAttribute("hx-confirm");
+    if (confirmation && !window.confirm(confirmation)) return;
+
+    var verb = verbs.filter(function (v) { return el.hasAttribute("hx-" + v); })[0];
+    var url = el.getAttribute("hx-" + verb);
+    var params = values(el);
+    var init = { method: verb.toUpperCase(), headers: headers(el), credentials: "same-origin" };
+    if (verb === "get") {
+      var query = params.toString();
+      if (query) url += (url.indexOf("?") < 0 ? "?" : "&") + query;
+    } else {
+      init.headers["Content-Type"] = "application/x-www-form-urlencoded";
+      init.body = params.toString();
+    }
+    var target = resolve(el, inherited(el, "hx-target"));
+    var style = inherited(el, "hx-swap") || "innerHTML";
+
+    fetch(url, init).then(function (response) {
+      return response.text().then(function (text) {
+        var xhr = { status: response.status, responseText: text };
+        if (!response.ok) {
+          fire(el, "htmx:responseError", { elt: el, xhr: xhr });
+        } else if (response.status !== 204 && target) {
+          swap(target, text, style);
+        }
+        fire(el, "htmx:afterRequest", { elt: el, xhr: xhr, successful: response.ok });
+      });
+    }, function (error) {
+      fire(el, "htmx:sendError", { elt: el, error: error });
+    });
+  }
+
+  function handle(event) {
+    var el = event.target.closest && event.target.closest(selector);
+    if (!el) return;
+    triggers(el).forEach(function (trigger) {
+      if (trigger.event !== event.type) return;
+      if (event.type === "submit" || (event.type === "click" && el.matches("a, button[type=submit], form button"))) {
+        event.preventDefault();
+      }
+      if (trigger.changed) {
+        if (lastValues.get(el) === el.value) return;
+        lastValues.set(el, el.value);
+      }
+      clearTimeout(timers.get(el));
+      if (trigger.delay) {
+        timers.set(el, setTimeout(function () { issue(el); }, trigger.delay));
+      } else {
+        issue(el);
+      }
+    });
+  }
+
+  ["click", "submit", "change", "input", "keyup", "search"].forEach(function (type) {
+    document.addEventListener(type, handle);
+  });
+})();
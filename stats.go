@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/behalnihal/fealtyx/validation"
+)
+
+const (
+	maxStudentAge  = validation.MaxAge // validateStudent's upper bound
+	ageBucketYears = 10                // width of the histogram's buckets
+)
+
+// rosterStats are running totals for a set of students, kept up to date on
+// every write so reading them never scans the roster
+type rosterStats struct {
+	count     int
+	withEmail int
+	ageSum    int64
+	ages      [maxStudentAge + 1]int // students of each age; out of range ages count as 0
+}
+
+func (s *rosterStats) add(student Student, delta int) {
+	s.count += delta
+	if student.Email != "" {
+		s.withEmail += delta
+	}
+	age := student.Age
+	if age < 0 || age > maxStudentAge {
+		age = 0
+	}
+	s.ageSum += int64(student.Age * delta)
+	s.ages[age] += delta
+}
+
+// AgeBucket is one bar of the age histogram, ages From to To inclusive
+type AgeBucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+type StudentStats struct {
+	Revision  int64       `json:"revision"`
+	Tenant    string      `json:"tenant,omitempty"`
+	Count     int         `json:"count"`
+	WithEmail int         `json:"with_email"`
+	MinAge    int         `json:"min_age"`
+	MaxAge    int         `json:"max_age"`
+	MeanAge   float64     `json:"mean_age"`
+	MedianAge int         `json:"median_age"`
+	Histogram []AgeBucket `json:"histogram"`
+}
+
+// report summarizes the totals. Its cost depends on the range of ages, not
+// the number of students.
+func (s *rosterStats) report() StudentStats {
+	stats := StudentStats{Count: s.count, WithEmail: s.withEmail, Histogram: []AgeBucket{}}
+	if s.count == 0 {
+		return stats
+	}
+	stats.MeanAge = float64(s.ageSum) / float64(s.count)
+	seen := 0
+	for age, count := range s.ages {
+		if count == 0 {
+			continue
+		}
+		if seen == 0 {
+			stats.MinAge = age
+		}
+		if seen < (s.count+1)/2 && seen+count >= (s.count+1)/2 {
+			stats.MedianAge = age
+		}
+		seen += count
+		stats.MaxAge = age
+	}
+	for from := stats.MinAge / ageBucketYears * ageBucketYears; from <= stats.MaxAge; from += ageBucketYears {
+		bucket := AgeBucket{From: from, To: min(from+ageBucketYears-1, maxStudentAge)}
+		for age := from; age <= bucket.To; age++ {
+			bucket.Count += s.ages[age]
+		}
+		stats.Histogram = append(stats.Histogram, bucket)
+	}
+	return stats
+}
+
+// Stats for the whole roster and for each tenant. They are guarded by mutex
+// and changed only alongside students.
+var (
+	allStats    rosterStats
+	tenantStats = map[string]*rosterStats{}
+)
+
+// countStudentLocked adds a student to the totals, or removes it when delta
+// is -1. Callers must hold mutex for writing.
+func countStudentLocked(student Student, delta int) {
+	allStats.add(student, delta)
+	stats := tenantStats[student.Tenant]
+	if stats == nil {
+		stats = &rosterStats{}
+		tenantStats[student.Tenant] = stats
+	}
+	stats.add(student, delta)
+	if stats.count == 0 {
+		delete(tenantStats, student.Tenant)
+	}
+}
+
+// rebuildStatsLocked recounts the roster after it was replaced wholesale,
+// and catches lastStudentID up with it. Callers must hold mutex for writing.
+func rebuildStatsLocked() {
+	allStats = rosterStats{}
+	tenantStats = map[string]*rosterStats{}
+	for _, student := range students {
+		countStudentLocked(student, 1)
+		noteStudentIDLocked(student.ID)
+	}
+}
+
+// handleStudentStats reports counts and the age distribution of the caller's
+// students in constant time, from totals kept up to date on every write
+func handleStudentStats(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenantName(r)
+	mutex.RLock()
+	var stats StudentStats
+	if tenant == "" {
+		stats = allStats.report()
+	} else if totals := tenantStats[tenant]; totals != nil {
+		stats = totals.report()
+	} else {
+		stats = (&rosterStats{}).report()
+	}
+	stats.Revision = changeSeq
+	mutex.RUnlock()
+	stats.Tenant = tenant
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// offlineStorage is set by subcommands that run without the server. Only then
+// may the wal backend be opened, since it loads into the global roster.
+var offlineStorage bool
+
+// openBackend opens a store for shadow writes or an offline tool. Backends
+// are named by a spec of the form kind:location, e.g.
+// jsonfile:/var/lib/fealtyx/roster.json, or by a Postgres URL.
+func openBackend(spec string) (StudentStore, error) {
+	kind, location, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "jsonfile":
+		if location == "" {
+			return nil, fmt.Errorf("jsonfile backend needs a path, e.g. jsonfile:/var/lib/fealtyx/roster.json")
+		}
+		return openJSONFileBackend(location)
+	case "wal":
+		if location == "" {
+			return nil, fmt.Errorf("wal backend needs a path, e.g. wal:/var/lib/fealtyx/students.wal")
+		}
+		if !offlineStorage || wal != nil {
+			return nil, fmt.Errorf("the wal backend is the server's own store; stop the server and use migrate-data")
+		}
+		return openWALBackend(location)
+	case "sqlite", "postgres", "postgresql":
+		return openStore(spec)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (supported: jsonfile, wal, sqlite, postgres)", kind)
+	}
+}
+
+// jsonFileBackend keeps the roster in a single JSON file in the snapshot
+// format of the write-ahead log, rewritten atomically on every change
+type jsonFileBackend struct {
+	mu       sync.Mutex
+	path     string
+	seq      int64
+	students map[int64]Student
+}
+
+func openJSONFileBackend(path string) (*jsonFileBackend, error) {
+	b := &jsonFileBackend{path: path, students: map[int64]Student{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	var snapshot walSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("corrupt roster file %s: %v", path, err)
+	}
+	b.seq = snapshot.Seq
+	for _, student := range snapshot.Students {
+		b.students[student.ID] = student
+	}
+	return b, nil
+}
+
+func (b *jsonFileBackend) Name() string { return "jsonfile:" + b.path }
+
+func (b *jsonFileBackend) Replace(roster []Student, seq int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.students = make(map[int64]Student, len(roster))
+	for _, student := range roster {
+		b.students[student.ID] = student
+	}
+	b.seq = seq
+	return b.save()
+}
+
+func (b *jsonFileBackend) Apply(change Change) error {
+	return b.ApplyBatch([]Change{change})
+}
+
+func (b *jsonFileBackend) ApplyBatch(changes []Change) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, change := range changes {
+		if change.Event == EventStudentDeleted {
+			delete(b.students, change.Student.ID)
+		} else {
+			b.students[change.Student.ID] = change.Student
+		}
+		b.seq = change.ID
+	}
+	return b.save()
+}
+
+func (b *jsonFileBackend) Get(_ context.Context, id int64) (Student, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	student, ok := b.students[id]
+	return student, ok, nil
+}
+
+func (b *jsonFileBackend) List(context.Context) ([]Student, int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sortedLocked(), b.seq, nil
+}
+
+func (b *jsonFileBackend) Close() error { return nil }
+
+func (b *jsonFileBackend) sortedLocked() []Student {
+	roster := make([]Student, 0, len(b.students))
+	for _, student := range b.students {
+		roster = append(roster, student)
+	}
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	return roster
+}
+
+// save rewrites the file atomically
+func (b *jsonFileBackend) save() error {
+	data, err := json.Marshal(walSnapshot{Seq: b.seq, Students: b.sortedLocked()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.path, data)
+}
+
+// walBackend is the server's own write-ahead log and snapshot, for offline
+// tools. It loads into and writes through the global roster.
+type walBackend struct {
+	log *writeAheadLog
+}
+
+func openWALBackend(path string) (*walBackend, error) {
+	log, err := openWAL(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	wal = log
+	return &walBackend{log: log}, nil
+}
+
+func (b *walBackend) Name() string { return "wal:" + b.log.path }
+
+func (b *walBackend) Replace(roster []Student, seq int64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	students = append([]Student{}, roster...)
+	changeSeq = seq
+	rebuildStatsLocked()
+	clearStudentCache()
+	changes = nil
+	return b.log.compact()
+}
+
+func (b *walBackend) Apply(change Change) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := b.log.append(change); err != nil {
+		return err
+	}
+	applyChange(change)
+	changeSeq = change.ID
+	return nil
+}
+
+// ApplyBatch applies the changes and writes a snapshot instead of logging
+// each one
+func (b *walBackend) ApplyBatch(batch []Change) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, change := range batch {
+		applyChange(change)
+		changeSeq = change.ID
+	}
+	return b.log.compact()
+}
+
+func (b *walBackend) Get(_ context.Context, id int64) (Student, bool, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	student, ok := findStudent(id)
+	return student, ok, nil
+}
+
+func (b *walBackend) List(context.Context) ([]Student, int64, error) {
+	roster, seq := snapshotRoster()
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	return roster, seq, nil
+}
+
+func (b *walBackend) Close() error {
+	wal = nil
+	return b.log.file.Close()
+}
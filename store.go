@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StudentStore is somewhere the roster is kept besides memory. The server
+// keeps it for good in the store chosen with -store:
+//
+//	memory                                  nothing outlives the process (default)
+//	wal:/var/lib/fealtyx/students.wal       the write-ahead log, same as -wal
+//	sqlite:/var/lib/fealtyx/students.db     needs a build with -tags sqlite
+//	postgres://fealtyx@db/fealtyx           needs a build with -tags postgres
+//
+// That store is a durable mirror of the in-memory roster, which serves every
+// read: the roster is loaded from it at startup, and committed changes are
+// written to it in the background, in order, by followStore. Stores opened
+// with openBackend are the targets of shadow writes and offline tools such
+// as migrate-data.
+//
+// Writes carry the revision of the change they come from, so the change feed
+// carries on from where it was after a restart.
+type StudentStore interface {
+	Name() string
+	// Apply writes one change, at the revision in its ID
+	Apply(change Change) error
+	// Replace overwrites the store with a roster at a revision
+	Replace(roster []Student, revision int64) error
+	// Get and List are reads, which give up when ctx ends. Writes always run
+	// to the end: a change is committed whether or not its caller waits.
+	Get(ctx context.Context, id int64) (Student, bool, error)
+	// List returns every student, ordered by ID, and the revision they reflect
+	List(ctx context.Context) ([]Student, int64, error)
+	Close() error
+}
+
+// batchApplier is implemented by stores that write a batch of changes
+// faster than one at a time
+type batchApplier interface {
+	ApplyBatch(changes []Change) error
+}
+
+// studentIDKeeper is implemented by stores that remember the highest student
+// ID they have held, so IDs of deleted students aren't assigned again after
+// the roster is loaded from them
+type studentIDKeeper interface {
+	LastStudentID(ctx context.Context) (int64, error)
+}
+
+// authStateStore is implemented by stores that also keep the users,
+// sessions, API keys and tenants; see authstate.go. LoadAuthState returns nil
+// if none was saved.
+type authStateStore interface {
+	SaveAuthState(state []byte) error
+	LoadAuthState(ctx context.Context) ([]byte, error)
+}
+
+// studentStore is the server's store. Stores that implement batchApplier
+// write a batch of changes atomically.
+var studentStore StudentStore = memoryStore{}
+
+// openStore opens the store named by spec. The write-ahead log isn't opened
+// here, since it also restores the change feed: main opens it as for -wal.
+func openStore(spec string) (StudentStore, error) {
+	if strings.HasPrefix(spec, "postgres://") || strings.HasPrefix(spec, "postgresql://") {
+		return openSQLStore(postgresDialect, spec)
+	}
+	kind, location, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "memory":
+		return memoryStore{}, nil
+	case "sqlite":
+		if location == "" {
+			return nil, fmt.Errorf("sqlite store needs a path, e.g. sqlite:/var/lib/fealtyx/students.db")
+		}
+		return openSQLStore(sqliteDialect, location)
+	case "postgres":
+		if location == "" {
+			return nil, fmt.Errorf("postgres store needs a connection string, e.g. postgres://fealtyx@db/fealtyx")
+		}
+		return openSQLStore(postgresDialect, location)
+	default:
+		return nil, fmt.Errorf("unknown store %q (supported: memory, wal, sqlite, postgres)", kind)
+	}
+}
+
+// writeBatchToStore writes a batch of changes through to the store, at once
+// if it can
+func writeBatchToStore(batch []Change) error {
+	if batcher, ok := studentStore.(batchApplier); ok {
+		return batcher.ApplyBatch(batch)
+	}
+	for _, change := range batch {
+		if err := studentStore.Apply(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeMirror tracks how far followStore has written the change log to the
+// store. mu is held while the store is written, so a read of the store made
+// with it held sees exactly revision applied.
+var storeMirror = struct {
+	mu          sync.Mutex
+	applied     int64
+	writeErrors int64
+	lastError   string
+}{}
+
+// startStoreMirror follows the change log from revision, the store's own,
+// writing each change to the store
+func startStoreMirror(revision int64) {
+	storeMirror.mu.Lock()
+	storeMirror.applied = revision
+	storeMirror.mu.Unlock()
+	go followStore()
+}
+
+// followStore writes committed changes to the store in revision order,
+// outside mutex, so a slow database never holds up the roster. Whatever has
+// piled up since the last write goes in one batch. After a failure the store
+// may or may not hold the batch, so it is copied the whole roster again.
+func followStore() {
+	backoff := time.Second
+	resync := false
+	for {
+		storeMirror.mu.Lock()
+		cursor := storeMirror.applied
+		storeMirror.mu.Unlock()
+
+		var err error
+		if !resync {
+			events, signal, ok := eventsAfter(cursor)
+			switch {
+			case !ok:
+				slog.Warn("The store fell behind the retained change log; copying the roster again", "store", studentStore.Name())
+				resync = true
+				continue
+			case len(events) == 0:
+				<-signal
+				continue
+			}
+			storeMirror.mu.Lock()
+			if err = writeBatchToStore(events); err == nil {
+				storeMirror.applied = events[len(events)-1].ID
+			}
+			storeMirror.mu.Unlock()
+		} else {
+			roster, revision := snapshotRoster()
+			storeMirror.mu.Lock()
+			if err = studentStore.Replace(roster, revision); err == nil {
+				storeMirror.applied = revision
+				resync = false
+			}
+			storeMirror.mu.Unlock()
+		}
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+		slog.Error("Failed to write to the store", "store", studentStore.Name(), "error", err)
+		storeMirror.mu.Lock()
+		storeMirror.writeErrors++
+		storeMirror.lastError = err.Error()
+		storeMirror.mu.Unlock()
+		resync = true
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// storeMirrorStats reports the store's progress for GET /admin/storage
+func storeMirrorStats() map[string]interface{} {
+	mutex.RLock()
+	revision := changeSeq
+	mutex.RUnlock()
+	storeMirror.mu.Lock()
+	defer storeMirror.mu.Unlock()
+	return map[string]interface{}{
+		"name":         studentStore.Name(),
+		"revision":     storeMirror.applied,
+		"behind":       revision - storeMirror.applied,
+		"write_errors": storeMirror.writeErrors,
+		"last_error":   storeMirror.lastError,
+	}
+}
+
+// memoryStore is the in-memory roster itself: writes have nothing more to
+// do, and nothing survives a restart
+type memoryStore struct{}
+
+func (memoryStore) Name() string                   { return "memory" }
+func (memoryStore) Apply(Change) error             { return nil }
+func (memoryStore) Replace([]Student, int64) error { return nil }
+func (memoryStore) Close() error                   { return nil }
+
+func (memoryStore) Get(_ context.Context, id int64) (Student, bool, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	student, ok := findStudent(id)
+	return student, ok, nil
+}
+
+func (memoryStore) List(context.Context) ([]Student, int64, error) {
+	roster, revision := snapshotRoster()
+	sort.Slice(roster, func(i, j int) bool { return roster[i].ID < roster[j].ID })
+	return roster, revision, nil
+}
@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+// Links in a Postgres driver for -store postgres://... Only builds with the
+// tag include it:
+//
+//	go build -tags postgres
+import _ "github.com/jackc/pgx/v5/stdlib"
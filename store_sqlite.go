@@ -0,0 +1,9 @@
+//go:build sqlite
+
+package main
+
+// Links in a SQLite driver, pure Go so the build needs no cgo, for
+// -store sqlite:PATH. Only builds with the tag include it:
+//
+//	go build -tags sqlite
+import _ "modernc.org/sqlite"
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore keeps students in a map and fails its first writes
+type flakyStore struct {
+	mu       sync.Mutex
+	failures int
+	roster   map[int64]Student
+	replaced int
+	closed   bool
+}
+
+func (s *flakyStore) fail() error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (s *flakyStore) Name() string { return "flaky" }
+
+func (s *flakyStore) Apply(change Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail(); err != nil {
+		return err
+	}
+	if change.Event == EventStudentDeleted {
+		delete(s.roster, change.Student.ID)
+	} else {
+		s.roster[change.Student.ID] = change.Student
+	}
+	return nil
+}
+
+func (s *flakyStore) Replace(roster []Student, _ int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.fail(); err != nil {
+		return err
+	}
+	s.replaced++
+	s.roster = map[int64]Student{}
+	for _, student := range roster {
+		s.roster[student.ID] = student
+	}
+	return nil
+}
+
+func (s *flakyStore) Get(_ context.Context, id int64) (Student, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	student, ok := s.roster[id]
+	return student, ok, nil
+}
+
+func (s *flakyStore) List(context.Context) ([]Student, int64, error) { return nil, 0, nil }
+
+func (s *flakyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestStoreMirrorRecoversFromFailedWrites(t *testing.T) {
+	mutex.Lock()
+	students, changes, changeSeq, lastStudentID = nil, nil, 0, 0
+	rebuildStatsLocked()
+	mutex.Unlock()
+	store := &flakyStore{failures: 1, roster: map[int64]Student{}}
+	studentStore = store
+	t.Cleanup(func() { studentStore = memoryStore{} })
+	startStoreMirror(0)
+
+	ada, err := createStudent(Student{Name: "Ada", Age: 20, Email: "ada@example.com"})
+	if err != nil {
+		t.Fatalf("a failing store refused the write: %v", err)
+	}
+	grace, _ := createStudent(Student{Name: "Grace", Age: 21, Email: "grace@example.com"})
+	if err := deleteStudent(ada.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if status, detail := checkStore(ctx); status != SelfTestPass {
+		t.Fatalf("store didn't catch up: %s", detail)
+	}
+	store.mu.Lock()
+	if _, ok := store.roster[ada.ID]; ok || len(store.roster) != 1 || store.roster[grace.ID].Name != "Grace" {
+		t.Fatalf("store holds %v", store.roster)
+	}
+	if store.replaced != 1 {
+		t.Fatalf("store was copied the roster %d times after one failure, want 1", store.replaced)
+	}
+	store.mu.Unlock()
+	storeMirror.mu.Lock()
+	if storeMirror.writeErrors != 1 || storeMirror.lastError != "connection reset" {
+		t.Fatalf("recorded %d errors, the last %q", storeMirror.writeErrors, storeMirror.lastError)
+	}
+	storeMirror.mu.Unlock()
+
+	// Shutting down writes what was just committed before closing the store
+	alan, _ := createStudent(Student{Name: "Alan", Age: 22, Email: "alan@example.com"})
+	if err := drainStoreMirror(ctx); err != nil {
+		t.Fatalf("draining the store: %v", err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.roster[alan.ID]; !ok || !store.closed {
+		t.Fatalf("after draining, the store holds %v and closed is %v", store.roster, store.closed)
+	}
+}
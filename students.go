@@ -0,0 +1,238 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+var (
+	errStudentNotFound    = newError(ErrNotFound, "Student not found")
+	errStudentOnLegalHold = newError(ErrLocked, "Student is under legal hold and cannot be deleted")
+)
+
+// createStudent assigns the next ID and stores the student
+func createStudent(student Student) (Student, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return createStudentLocked(student)
+}
+
+// createStudentLocked, updateStudentLocked and deleteStudentLocked expect the
+// caller to hold mutex, so a batch of writes can be checked and applied
+// atomically
+func createStudentLocked(student Student) (Student, error) {
+	if err := checkStudentQuota(); err != nil {
+		return Student{}, err
+	}
+	if err := checkTenantStudentQuota(student.Tenant); err != nil {
+		return Student{}, err
+	}
+	if err := checkEmailLocked(student, nil); err != nil {
+		return Student{}, err
+	}
+	attributes, err := checkAttributes(student.Tenant, student.Attributes)
+	if err != nil {
+		return Student{}, err
+	}
+	student.Attributes = attributes
+	if student.Tags, err = normalizeTags(student.Tags); err != nil {
+		return Student{}, err
+	}
+	if student.PhoneE164, err = normalizePhone(student.Phone); err != nil {
+		return Student{}, err
+	}
+	if student.EnrolledOn == "" {
+		student.EnrolledOn = time.Now().In(tenantLocation(student.Tenant)).Format(time.DateOnly)
+	}
+	student.ID = nextStudentIDLocked()
+	student.UUID = newUUIDv7()
+	student.LegalHold = false
+	if err := commitChange(EventStudentCreated, student); err != nil {
+		return Student{}, err
+	}
+	return student, nil
+}
+
+// createStudents creates a batch of students under one lock and one sync of
+// the write-ahead log. Each student succeeds or fails on its own, like
+// createStudent; err is set only when the batch as a whole couldn't be
+// saved, in which case none of it was.
+func createStudents(batch []Student) (created []Student, errs []error, err error) {
+	return writeStudents(batch, createStudentLocked)
+}
+
+// updateStudents is the batch form of updateStudent
+func updateStudents(batch []Student) (updated []Student, errs []error, err error) {
+	return writeStudents(batch, updateStudentLocked)
+}
+
+func writeStudents(batch []Student, write func(Student) (Student, error)) ([]Student, []error, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if err := beginBatchLocked(); err != nil {
+		return nil, nil, err
+	}
+	results := make([]Student, len(batch))
+	errs := make([]error, len(batch))
+	for i, student := range batch {
+		results[i], errs[i] = write(student)
+	}
+	if err := endBatchLocked(); err != nil {
+		return nil, nil, err
+	}
+	return results, errs, nil
+}
+
+// updateStudent replaces the stored student with the same ID and returns the
+// stored version. A student.Tenant other than "" must match the stored one.
+// Nil attributes or tags keep the stored ones; empty ones clear them.
+func updateStudent(student Student) (Student, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return updateStudentLocked(student)
+}
+
+func updateStudentLocked(student Student) (Student, error) {
+	existing, ok := findStudent(student.ID)
+	if !ok || !visibleTo(student.Tenant, existing) {
+		return Student{}, errStudentNotFound
+	}
+	student.UUID = existing.UUID
+	student.LegalHold = existing.LegalHold
+	student.Tenant = existing.Tenant
+	if student.Attributes == nil {
+		student.Attributes = existing.Attributes // forms and older clients don't send them
+	}
+	if student.Tags == nil {
+		student.Tags = existing.Tags
+	}
+	if student.Phone == "" {
+		student.Phone = existing.Phone
+	}
+	if student.Address == "" {
+		student.Address, student.Location = existing.Address, existing.Location
+	} else if student.Location == nil && student.Address == existing.Address {
+		student.Location = existing.Location
+	}
+	if student.BirthDate == "" {
+		student.BirthDate = existing.BirthDate
+	}
+	if student.EnrolledOn == "" {
+		student.EnrolledOn = existing.EnrolledOn
+	}
+	if err := checkEmailLocked(student, &existing); err != nil {
+		return Student{}, err
+	}
+	attributes, err := checkAttributes(student.Tenant, student.Attributes)
+	if err != nil {
+		return Student{}, err
+	}
+	student.Attributes = attributes
+	if student.Tags, err = normalizeTags(student.Tags); err != nil {
+		return Student{}, err
+	}
+	if student.PhoneE164, err = normalizePhone(student.Phone); err != nil {
+		return Student{}, err
+	}
+	if err := commitChange(EventStudentUpdated, student); err != nil {
+		return Student{}, err
+	}
+	return student, nil
+}
+
+// deleteStudent removes a student within a tenant scope ("" is any tenant)
+func deleteStudent(id int64, tenant string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return deleteStudentLocked(id, tenant)
+}
+
+func deleteStudentLocked(id int64, tenant string) error {
+	student, ok := findStudent(id)
+	if !ok || !visibleTo(tenant, student) {
+		return errStudentNotFound
+	}
+	if student.LegalHold {
+		return errStudentOnLegalHold
+	}
+	if err := checkRelationsLocked(id); err != nil {
+		return err
+	}
+	if err := commitChange(EventStudentDeleted, student); err != nil {
+		return err
+	}
+	if deletePolicy == DeleteCascade {
+		cascadeRelationsLocked(id)
+	}
+	return nil
+}
+
+// setLegalHold places or lifts a legal hold on a student
+func setLegalHold(id int64, enabled bool) (Student, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	student, ok := findStudent(id)
+	if !ok {
+		return Student{}, errStudentNotFound
+	}
+	student.LegalHold = enabled
+	if err := commitChange(EventStudentUpdated, student); err != nil {
+		return Student{}, err
+	}
+	return student, nil
+}
+
+// equal reports whether two students are the same, attributes and tags
+// included
+func (s Student) equal(other Student) bool {
+	if len(s.Attributes) == 0 {
+		s.Attributes = nil
+	}
+	if len(other.Attributes) == 0 {
+		other.Attributes = nil
+	}
+	if len(s.Tags) == 0 {
+		s.Tags = nil
+	}
+	if len(other.Tags) == 0 {
+		other.Tags = nil
+	}
+	return reflect.DeepEqual(s, other)
+}
+
+// findStudent looks up a student by ID, through studentCache when it is on.
+// Callers must hold mutex.
+func findStudent(id int64) (Student, bool) {
+	if studentCache == nil {
+		return scanStudents(id)
+	}
+	if cached, ok := studentCache.get(id); ok {
+		return cached.student, cached.found
+	}
+	student, found := scanStudents(id)
+	studentCache.put(id, cachedStudent{student: student, found: found})
+	return student, found
+}
+
+// scanStudents finds a student by walking the roster. Callers must hold mutex.
+func scanStudents(id int64) (Student, bool) {
+	for _, student := range students {
+		if student.ID == id {
+			return student, true
+		}
+	}
+	return Student{}, false
+}
+
+// snapshotRoster returns a point-in-time copy of the roster together with the
+// change ID it reflects, so long-running exports never see a half-applied
+// sequence of writes and can report exactly which revision they contain
+func snapshotRoster() ([]Student, int64) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	roster := make([]Student, len(students))
+	copy(roster, students)
+	return roster, changeSeq
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// streamSummary is summarizeStudent handing each token to onToken as the
+// model produces it. Streamed summaries aren't coalesced with
+// generateSummary's: each caller watches its own generation. It returns the
+// whole summary.
+func streamSummary(ctx context.Context, student Student, locale string, onToken func(string)) (string, error) {
+	if err := beginSummary(student); err != nil {
+		return "", err
+	}
+	prompt, err := summaryPrompt(student, locale)
+	if err != nil {
+		return "", err
+	}
+	slog.Debug("Streaming from the LLM", "provider", summaryProvider.Name(), "student", student.ID, "prompt", prompt)
+	return llmStream(ctx, LLMRequest{Prompt: prompt, Canned: mockSummary(student)}, student.Tenant, onToken)
+}
+
+// handleStudentSummaryStream is GET /students/{id}/summary sent as
+// server-sent events: a token event for each piece of the summary as the
+// model writes it, then a done event with the whole response the plain
+// endpoint gives. Failures before the first token are answered with their
+// status as usual; later ones end the stream with an error event. A client
+// that disconnects stops the generation.
+func handleStudentSummaryStream(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled(r.Context(), FlagStreamingSummaries) {
+		http.Error(w, localize(r, "Streamed summaries aren't enabled"), http.StatusNotFound)
+		return
+	}
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	stage := timeStage(r.Context(), "store")
+	mutex.RLock()
+	student, ok := findStudent(id)
+	mutex.RUnlock()
+	stage.stop()
+
+	if !ok || !visibleTo(requestTenantName(r), student) {
+		http.Error(w, localize(r, "Student not found"), http.StatusNotFound)
+		return
+	}
+
+	locale := requestLocale(r)
+	controller := http.NewResponseController(w)
+	started := false
+	send := func(event string, payload interface{}) {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Content-Language", locale)
+			started = true
+		}
+		data, _ := json.Marshal(payload)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, renameEventData(r, data))
+		controller.Flush()
+	}
+
+	stage = timeStage(r.Context(), "llm")
+	summary, err := streamSummary(r.Context(), student, locale, func(token string) {
+		send("token", map[string]string{"token": token})
+	})
+	stage.stop()
+	switch {
+	case err != nil && !started:
+		writeError(w, r, err, "Failed to generate summary")
+	case r.Context().Err() != nil:
+		// the client has gone mid-stream
+	case err != nil:
+		send("error", map[string]interface{}{"status": errorStatus(err), "error": errorMessage(r, err, "Failed to generate summary")})
+	default:
+		send("done", summaryResponse(w, r, student, summary))
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncDelete = "delete"
+)
+
+// Conflict policies decide what happens when an offline edit touches a
+// student that changed on the server since the client's base revision
+const (
+	PolicyLastWriteWins = "last-write-wins" // apply the client's edit anyway
+	PolicyServerWins    = "server-wins"     // keep the server version
+	PolicyManual        = "manual"          // keep the server version and queue the conflict for review
+)
+
+var syncConflictPolicy = PolicyServerWins
+
+func validConflictPolicy(policy string) bool {
+	return policy == PolicyLastWriteWins || policy == PolicyServerWins || policy == PolicyManual
+}
+
+func currentConflictPolicy() string {
+	settings.mu.RLock()
+	defer settings.mu.RUnlock()
+	return syncConflictPolicy
+}
+
+// SyncDelta is what changed between a client's revision and the current one.
+// Several changes to one student are collapsed into its latest state.
+type SyncDelta struct {
+	Revision int64     `json:"revision"`
+	Full     bool      `json:"full"` // the delta is unavailable; Created holds the whole roster
+	Created  []Student `json:"created"`
+	Updated  []Student `json:"updated"`
+	Deleted  []int64   `json:"deleted"`
+}
+
+// SyncOperation is one edit made while offline
+type SyncOperation struct {
+	Op      string  `json:"op"`
+	ID      int64   `json:"id,omitempty"` // for update and delete
+	Student Student `json:"student"`      // for create and update
+}
+
+type SyncRequest struct {
+	BaseRevision int64           `json:"base_revision"` // the revision the edits were made against
+	Operations   []SyncOperation `json:"operations"`
+}
+
+type SyncConflict struct {
+	Index      int      `json:"index"`
+	Op         string   `json:"op"`
+	ID         int64    `json:"id"`
+	Reason     string   `json:"reason"`
+	Server     *Student `json:"server"` // the server version when the conflict was found, nil if deleted
+	Client     Student  `json:"client"`
+	Resolution string   `json:"resolution"`            // "client_applied", "server_kept" or "queued"
+	ConflictID int      `json:"conflict_id,omitempty"` // the review queue entry, for the manual policy
+}
+
+type SyncResult struct {
+	Revision  int64          `json:"revision"`
+	Applied   []SyncApplied  `json:"applied"`
+	Conflicts []SyncConflict `json:"conflicts"`
+	Errors    []SyncError    `json:"errors"`
+}
+
+type SyncApplied struct {
+	Index   int     `json:"index"`
+	Op      string  `json:"op"`
+	Student Student `json:"student"`
+}
+
+type SyncError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// oldestRevisionLocked is the oldest revision the change feed can produce a
+// delta from. Callers must hold mutex.
+func oldestRevisionLocked() int64 {
+	if len(changes) == 0 {
+		return changeSeq
+	}
+	return changes[0].ID - 1
+}
+
+// syncDelta collapses the tenant's part of the change feed since a revision
+// into created, updated and deleted records
+func syncDelta(since int64, tenant string) SyncDelta {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	delta := SyncDelta{Revision: changeSeq, Created: []Student{}, Updated: []Student{}, Deleted: []int64{}}
+	if since < oldestRevisionLocked() {
+		delta.Full = true
+		delta.Created = append(delta.Created, visibleStudents(tenant, students)...)
+		return delta
+	}
+
+	var order []int64
+	first := map[int64]string{}
+	last := map[int64]Change{}
+	for _, change := range changes {
+		if change.ID <= since || !visibleTo(tenant, change.Student) {
+			continue
+		}
+		id := change.Student.ID
+		if _, seen := first[id]; !seen {
+			first[id] = change.Event
+			order = append(order, id)
+		}
+		last[id] = change
+	}
+	for _, id := range order {
+		created := first[id] == EventStudentCreated
+		switch {
+		case last[id].Event == EventStudentDeleted && !created:
+			delta.Deleted = append(delta.Deleted, id)
+		case last[id].Event == EventStudentDeleted:
+			// created and deleted since the client's revision: nothing to send
+		case created:
+			delta.Created = append(delta.Created, last[id].Student)
+		default:
+			delta.Updated = append(delta.Updated, last[id].Student)
+		}
+	}
+	return delta
+}
+
+// changedSinceLocked reports whether a student was written after a revision.
+// Callers must hold mutex and have checked the revision is still covered by
+// the change feed.
+func changedSinceLocked(id int64, revision int64) bool {
+	for i := len(changes) - 1; i >= 0 && changes[i].ID > revision; i-- {
+		if changes[i].Student.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// applySync applies offline edits atomically. Updates and deletes of students
+// that changed on the server since the base revision are reported as
+// conflicts and settled by the conflict policy.
+func applySync(request SyncRequest, tenant string) SyncResult {
+	result := SyncResult{Applied: []SyncApplied{}, Conflicts: []SyncConflict{}, Errors: []SyncError{}}
+	policy := currentConflictPolicy()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	// The operations share one sync of the write-ahead log
+	if err := beginBatchLocked(); err != nil {
+		for i := range request.Operations {
+			result.Errors = append(result.Errors, SyncError{Index: i, Error: err.Error()})
+		}
+		result.Revision = changeSeq
+		return result
+	}
+
+	stale := request.BaseRevision < oldestRevisionLocked()
+	for i, op := range request.Operations {
+		op.Student.Tenant = tenant
+		if op.Op == SyncCreate {
+			student, err := createStudentLocked(op.Student)
+			if err != nil {
+				result.Errors = append(result.Errors, SyncError{Index: i, Error: err.Error()})
+				continue
+			}
+			result.Applied = append(result.Applied, SyncApplied{Index: i, Op: op.Op, Student: student})
+			continue
+		}
+
+		server, exists := findStudent(op.ID)
+		if exists && !visibleTo(tenant, server) {
+			result.Errors = append(result.Errors, SyncError{Index: i, Error: errStudentNotFound.Error()})
+			continue
+		}
+		var reason string
+		switch {
+		case stale:
+			reason = "base revision is older than the change feed; resync first"
+		case !exists && changedSinceLocked(op.ID, request.BaseRevision):
+			reason = "deleted on the server"
+		case !exists:
+			result.Errors = append(result.Errors, SyncError{Index: i, Error: errStudentNotFound.Error()})
+			continue
+		case changedSinceLocked(op.ID, request.BaseRevision):
+			reason = "modified on the server"
+		}
+		if reason != "" {
+			conflict := SyncConflict{Index: i, Op: op.Op, ID: op.ID, Reason: reason, Client: op.Student}
+			if exists {
+				conflict.Server = &server
+			}
+			switch {
+			case policy == PolicyLastWriteWins && exists:
+				conflict.Resolution = "client_applied"
+			case policy == PolicyManual:
+				conflict.Resolution = "queued"
+				conflict.ConflictID = queueConflict(conflict, tenant)
+			default:
+				conflict.Resolution = "server_kept"
+			}
+			result.Conflicts = append(result.Conflicts, conflict)
+			if conflict.Resolution != "client_applied" {
+				continue
+			}
+		}
+
+		student, err := applySyncOperationLocked(op, server)
+		if err != nil {
+			result.Errors = append(result.Errors, SyncError{Index: i, Error: err.Error()})
+			continue
+		}
+		result.Applied = append(result.Applied, SyncApplied{Index: i, Op: op.Op, Student: student})
+	}
+	if err := endBatchLocked(); err != nil {
+		for _, applied := range result.Applied {
+			result.Errors = append(result.Errors, SyncError{Index: applied.Index, Error: err.Error()})
+		}
+		result.Applied = []SyncApplied{}
+	}
+	result.Revision = changeSeq
+	return result
+}
+
+// applySyncOperationLocked applies an update or delete. Callers must hold
+// mutex.
+func applySyncOperationLocked(op SyncOperation, server Student) (Student, error) {
+	if op.Op == SyncUpdate {
+		op.Student.ID = op.ID
+		return updateStudentLocked(op.Student)
+	}
+	return server, deleteStudentLocked(op.ID, op.Student.Tenant)
+}
+
+// handleSyncGet returns what changed since ?since=<revision>. Clients keep the
+// returned revision and pass it next time.
+func handleSyncGet(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseInt(value, 10, 64); err != nil || since < 0 {
+			http.Error(w, "Invalid since: must be a revision number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	delta := syncDelta(since, requestTenantName(r))
+	if since > delta.Revision {
+		http.Error(w, "Invalid since: revision is ahead of the server", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(delta)
+}
+
+// handleSyncPost applies a batch of offline edits and reports what was
+// applied, what conflicted and what failed validation
+func handleSyncPost(w http.ResponseWriter, r *http.Request) {
+	var request SyncRequest
+	if err := decodeJSON(w, r, &request); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, op := range request.Operations {
+		var err error
+		switch op.Op {
+		case SyncCreate, SyncUpdate:
+			err = validateStudent(op.Student)
+		case SyncDelete:
+		default:
+			err = errors.New("op must be create, update or delete")
+		}
+		if err == nil && op.Op != SyncCreate && op.ID <= 0 {
+			err = errors.New("id is required")
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("operations[%d]: %v", i, localize(r, err.Error())), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applySync(request, requestTenantName(r)))
+}
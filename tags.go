@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxTagsPerStudent = 20
+	maxTagLength      = 40 // characters
+)
+
+var errInvalidTags = newError(ErrInvalid, "invalid tags")
+
+// normalizeTags trims and lowercases tags, drops duplicates and sorts them,
+// so the same label is always stored and matched the same way
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.ContainsFunc(tag, unicode.IsControl) {
+			return nil, fmt.Errorf("%w: each tag must be 1-%d characters", errInvalidTags, maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTagsPerStudent {
+		return nil, fmt.Errorf("%w: a student can have at most %d", errInvalidTags, maxTagsPerStudent)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func hasTag(student Student, tag string) bool {
+	for _, candidate := range student.Tags {
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type TagRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// retagStudent adds and removes tags on a student within a tenant scope
+func retagStudent(id int64, tenant string, add, remove []string) (Student, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	student, ok := findStudent(id)
+	if !ok || !visibleTo(tenant, student) {
+		return Student{}, errStudentNotFound
+	}
+	tags := []string{}
+	for _, tag := range append(student.Tags, add...) {
+		if !containsFold(remove, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return Student{}, err
+	}
+	student.Tags = tags
+	if err := commitChange(EventStudentUpdated, student); err != nil {
+		return Student{}, err
+	}
+	return student, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(strings.TrimSpace(candidate), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStudentTagsAdd adds tags to a student, keeping the ones it has
+func handleStudentTagsAdd(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	var request TagRequest
+	if err := decodeJSON(w, r, &request); err != nil {
+		http.Error(w, localize(r, "Invalid JSON data")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Tags) == 0 {
+		http.Error(w, "tags is required", http.StatusBadRequest)
+		return
+	}
+	student, err := retagStudent(id, requestTenantName(r), request.Tags, nil)
+	writeRetagResult(w, r, student, err)
+}
+
+// handleStudentTagRemove removes one tag from a student
+func handleStudentTagRemove(w http.ResponseWriter, r *http.Request) {
+	id, err := studentIDFromPath(r)
+	if err != nil {
+		http.Error(w, localize(r, err.Error()), http.StatusBadRequest)
+		return
+	}
+	student, err := retagStudent(id, requestTenantName(r), nil, []string{r.PathValue("tag")})
+	writeRetagResult(w, r, student, err)
+}
+
+func writeRetagResult(w http.ResponseWriter, r *http.Request, student Student, err error) {
+	if err != nil {
+		writeError(w, r, err, "Failed to save student")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(student.appendJSON(nil))
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	TenantActive    = "active"
+	TenantSuspended = "suspended"
+	TenantPending   = "pending" // self-service signup awaiting email verification
+)
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// Tenant is a school or district sharing the service. Keys issued for a tenant
+// only see and change that tenant's students, and its quotas and prompt
+// template apply on top of the global ones.
+type Tenant struct {
+	Name              string       `json:"name"` // slug, referenced by API keys and students
+	Plan              string       `json:"plan"`
+	MaxStudents       int          `json:"max_students"`          // 0 is unlimited
+	MaxLLMCallsPerDay int          `json:"max_llm_calls_per_day"` // 0 is unlimited
+	PromptTemplate    string       `json:"prompt_template,omitempty"`
+	Timezone          string       `json:"timezone,omitempty"`
+	Locale            string       `json:"locale,omitempty"`
+	Branding          Branding     `json:"branding"`
+	PublicRoster      PublicRoster `json:"public_roster"`
+	EmailPolicy       EmailPolicy  `json:"email_policy"`
+	Status            string       `json:"status"`
+	CreatedAt         time.Time    `json:"created_at"`
+
+	prompt   *template.Template
+	location *time.Location
+	llmCalls int
+	llmDay   string
+}
+
+// Branding is how a tenant's generated content presents the school. Summary
+// language defaults to the tenant's Locale.
+type Branding struct {
+	SchoolName  string `json:"school_name,omitempty"`
+	LogoURL     string `json:"logo_url,omitempty"`
+	SummaryTone string `json:"summary_tone,omitempty"` // e.g. "warm", "formal"
+}
+
+// TenantUpdate is a partial update; omitted fields are left unchanged
+type TenantUpdate struct {
+	Plan              *string       `json:"plan"`
+	MaxStudents       *int          `json:"max_students"`
+	MaxLLMCallsPerDay *int          `json:"max_llm_calls_per_day"`
+	PromptTemplate    *string       `json:"prompt_template"`
+	Timezone          *string       `json:"timezone"`
+	Locale            *string       `json:"locale"`
+	Branding          *Branding     `json:"branding"`
+	PublicRoster      *PublicRoster `json:"public_roster"`
+	EmailPolicy       *EmailPolicy  `json:"email_policy"`
+}
+
+var (
+	tenants      = map[string]*Tenant{}
+	tenantsMutex sync.RWMutex
+
+	errTenantQuotaExceeded = newError(ErrPlanLimit, "Student limit reached for this plan")
+)
+
+// compile validates the tenant's template, timezone and locale and caches the
+// parsed forms
+func (t *Tenant) compile() error {
+	t.prompt = nil
+	if t.PromptTemplate != "" {
+		tmpl, err := template.New("prompt").Parse(t.PromptTemplate)
+		if err == nil {
+			err = tmpl.Execute(&bytes.Buffer{}, Student{})
+		}
+		if err != nil {
+			return fmt.Errorf("invalid prompt_template: %v", err)
+		}
+		t.prompt = tmpl
+	}
+	t.location = time.UTC
+	if t.Timezone != "" {
+		location, err := time.LoadLocation(t.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone: %v", err)
+		}
+		t.location = location
+	}
+	if _, ok := languageNames[t.Locale]; t.Locale != "" && !ok {
+		return fmt.Errorf("unsupported locale %q", t.Locale)
+	}
+	if t.MaxStudents < 0 || t.MaxLLMCallsPerDay < 0 {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	if err := t.Branding.validate(); err != nil {
+		return err
+	}
+	if err := t.PublicRoster.validate(); err != nil {
+		return err
+	}
+	return t.EmailPolicy.validate()
+}
+
+func (b Branding) validate() error {
+	if len(b.SchoolName) > 100 {
+		return fmt.Errorf("branding.school_name must be at most 100 characters")
+	}
+	if len(b.SummaryTone) > 40 || strings.ContainsAny(b.SummaryTone, "\r\n") {
+		return fmt.Errorf("branding.summary_tone must be a single line of at most 40 characters")
+	}
+	if b.LogoURL != "" {
+		u, err := url.Parse(b.LogoURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("branding.logo_url must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// promptStyle is the instruction appended to summary prompts for the tenant's
+// branding, or "" if it has none
+func (b Branding) promptStyle() string {
+	var style string
+	if b.SchoolName != "" {
+		style += " The summary is for " + b.SchoolName + "."
+	}
+	if b.SummaryTone != "" {
+		style += " Use a " + b.SummaryTone + " tone."
+	}
+	return style
+}
+
+// lookupTenant returns a copy of the named tenant
+func lookupTenant(name string) (Tenant, bool) {
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+	tenant, ok := tenants[name]
+	if !ok {
+		return Tenant{}, false
+	}
+	return *tenant, true
+}
+
+// requestTenantName is the tenant the request is scoped to: the tenant of its
+// API key, or "" for global keys and anonymous requests, which see everything
+func requestTenantName(r *http.Request) string {
+	if key := keyFromContext(r.Context()); key != nil {
+		return key.Tenant
+	}
+	return ""
+}
+
+// visibleTo reports whether a student belongs to the given tenant scope
+func visibleTo(tenant string, student Student) bool {
+	return tenant == "" || student.Tenant == tenant
+}
+
+func visibleStudents(tenant string, roster []Student) []Student {
+	if tenant == "" {
+		return roster
+	}
+	visible := []Student{}
+	for _, student := range roster {
+		if student.Tenant == tenant {
+			visible = append(visible, student)
+		}
+	}
+	return visible
+}
+
+// checkTenantStudentQuota rejects creating another student for a full tenant.
+// Callers must hold mutex.
+func checkTenantStudentQuota(name string) error {
+	tenant, ok := lookupTenant(name)
+	if !ok || tenant.MaxStudents == 0 {
+		return nil
+	}
+	count := 0
+	for _, student := range students {
+		if student.Tenant == name {
+			count++
+		}
+	}
+	if count >= tenant.MaxStudents {
+		return errTenantQuotaExceeded
+	}
+	return nil
+}
+
+// reserveTenantLLMCall counts an LLM call against the tenant's daily quota
+func reserveTenantLLMCall(name string) error {
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	tenant, ok := tenants[name]
+	if !ok {
+		return nil
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if tenant.llmDay != today {
+		tenant.llmDay = today
+		tenant.llmCalls = 0
+	}
+	if tenant.MaxLLMCallsPerDay > 0 && tenant.llmCalls >= tenant.MaxLLMCallsPerDay {
+		return errLLMQuotaExceeded
+	}
+	tenant.llmCalls++
+	return nil
+}
+
+func handleTenantCreate(w http.ResponseWriter, r *http.Request) {
+	var tenant Tenant
+	if err := decodeJSON(w, r, &tenant); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !tenantNamePattern.MatchString(tenant.Name) {
+		http.Error(w, "name must be 1-63 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	if err := tenant.compile(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tenant.Status = TenantActive
+	tenant.CreatedAt = time.Now().UTC()
+
+	tenantsMutex.Lock()
+	if _, exists := tenants[tenant.Name]; exists {
+		tenantsMutex.Unlock()
+		http.Error(w, "Tenant already exists", http.StatusConflict)
+		return
+	}
+	tenants[tenant.Name] = &tenant
+	tenantsMutex.Unlock()
+	saveAuthState()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(tenant)
+}
+
+// handleTenantUpdate changes a tenant's plan, quotas, prompt template,
+// timezone, locale or branding
+func handleTenantUpdate(w http.ResponseWriter, r *http.Request) {
+	var update TenantUpdate
+	if err := decodeJSON(w, r, &update); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenantsMutex.Lock()
+	tenant, ok := tenants[r.PathValue("name")]
+	if !ok {
+		tenantsMutex.Unlock()
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	updated := *tenant
+	if update.Plan != nil {
+		updated.Plan = *update.Plan
+	}
+	if update.MaxStudents != nil {
+		updated.MaxStudents = *update.MaxStudents
+	}
+	if update.MaxLLMCallsPerDay != nil {
+		updated.MaxLLMCallsPerDay = *update.MaxLLMCallsPerDay
+	}
+	if update.PromptTemplate != nil {
+		updated.PromptTemplate = *update.PromptTemplate
+	}
+	if update.Timezone != nil {
+		updated.Timezone = *update.Timezone
+	}
+	if update.Locale != nil {
+		updated.Locale = *update.Locale
+	}
+	if update.Branding != nil {
+		updated.Branding = *update.Branding
+	}
+	if update.PublicRoster != nil {
+		updated.PublicRoster = *update.PublicRoster
+	}
+	if update.EmailPolicy != nil {
+		updated.EmailPolicy = *update.EmailPolicy
+	}
+	if err := updated.compile(); err != nil {
+		tenantsMutex.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	*tenant = updated
+	tenantsMutex.Unlock()
+	saveAuthState()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func handleTenantList(w http.ResponseWriter, r *http.Request) {
+	tenantsMutex.RLock()
+	result := []Tenant{}
+	for _, tenant := range tenants {
+		result = append(result, *tenant)
+	}
+	tenantsMutex.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func handleTenantGet(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := lookupTenant(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenant)
+}
+
+// setTenantStatus suspends or reactivates a tenant. Keys of a suspended
+// tenant are rejected with 403.
+func setTenantStatus(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenantsMutex.Lock()
+		tenant, ok := tenants[r.PathValue("name")]
+		if ok {
+			tenant.Status = status
+		}
+		var result Tenant
+		if ok {
+			result = *tenant
+		}
+		tenantsMutex.Unlock()
+
+		if !ok {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		saveAuthState()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// handleTenantDelete removes a tenant together with its students, users,
+// hooks, API keys and report subscriptions. Nothing is deleted if any of its
+// students is under legal hold, or has related records that -delete-policy
+// block protects.
+func handleTenantDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := lookupTenant(name); !ok {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	mutex.Lock()
+	var owned []Student
+	for _, student := range students {
+		if student.Tenant == name {
+			if student.LegalHold {
+				mutex.Unlock()
+				http.Error(w, fmt.Sprintf("Student %d is under legal hold; the tenant cannot be deleted", student.ID), http.StatusLocked)
+				return
+			}
+			owned = append(owned, student)
+		}
+	}
+	for _, student := range owned {
+		var related *RelatedRecordsError
+		if errors.As(checkRelationsLocked(student.ID), &related) {
+			mutex.Unlock()
+			writeRelatedRecordsError(w, related)
+			return
+		}
+	}
+	// The students go in one batch, so a failure leaves all of them in place
+	if err := beginBatchLocked(); err != nil {
+		mutex.Unlock()
+		http.Error(w, "Failed to delete students: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, student := range owned {
+		if err := commitChange(EventStudentDeleted, student); err != nil {
+			abortBatchLocked()
+			mutex.Unlock()
+			http.Error(w, fmt.Sprintf("Failed to delete student %d: %v", student.ID, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := endBatchLocked(); err != nil {
+		mutex.Unlock()
+		http.Error(w, "Failed to delete students: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deletePolicy == DeleteCascade {
+		for _, student := range owned {
+			cascadeRelationsLocked(student.ID)
+		}
+	}
+	mutex.Unlock()
+
+	// Removing the tenant's users and sessions stops them signing in again,
+	// even if the name is later reused
+	removed := map[int]bool{}
+	usersMutex.Lock()
+	keptUsers := users[:0]
+	for _, user := range users {
+		if user.Tenant == name {
+			removed[user.ID] = true
+		} else {
+			keptUsers = append(keptUsers, user)
+		}
+	}
+	users = keptUsers
+	usersMutex.Unlock()
+	revokeSessions(func(s *Session) bool { return removed[s.UserID] })
+
+	hooksMutex.Lock()
+	keptHooks := hooks[:0]
+	for _, hook := range hooks {
+		if hook.Tenant != name {
+			keptHooks = append(keptHooks, hook)
+		}
+	}
+	hooks = keptHooks
+	hooksMutex.Unlock()
+
+	apiKeysMutex.Lock()
+	kept := apiKeys[:0]
+	for _, key := range apiKeys {
+		if key.Tenant != name {
+			kept = append(kept, key)
+		}
+	}
+	apiKeys = kept
+	apiKeysMutex.Unlock()
+
+	reportsMutex.Lock()
+	for id, subscription := range reportSubscriptions {
+		if subscription.Tenant == name {
+			delete(reportSubscriptions, id)
+		}
+	}
+	reportsMutex.Unlock()
+
+	tenantsMutex.Lock()
+	delete(tenants, name)
+	tenantsMutex.Unlock()
+	saveAuthState()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tenantServer serves the real routes behind authenticate, with the given
+// tenants active and no students, users, hooks or keys
+func tenantServer(t *testing.T, names ...string) http.Handler {
+	t.Helper()
+	resetTenantState(names...)
+	t.Cleanup(func() { resetTenantState() })
+	mux := http.NewServeMux()
+	registerRoutes(mux, apiRoutes())
+	return authenticate(mux)
+}
+
+func resetTenantState(names ...string) {
+	mutex.Lock()
+	students, changes, changeSeq, lastStudentID = nil, nil, 0, 0
+	rebuildStatsLocked()
+	mutex.Unlock()
+	tenantsMutex.Lock()
+	tenants = map[string]*Tenant{}
+	for _, name := range names {
+		tenants[name] = &Tenant{Name: name, Status: TenantActive, CreatedAt: time.Now().UTC()}
+	}
+	tenantsMutex.Unlock()
+	apiKeysMutex.Lock()
+	apiKeys = nil
+	apiKeysMutex.Unlock()
+	usersMutex.Lock()
+	users = nil
+	usersMutex.Unlock()
+	hooksMutex.Lock()
+	hooks = nil
+	hooksMutex.Unlock()
+}
+
+// addTestKey issues a key with the role and tenant and returns its secret
+func addTestKey(t *testing.T, role, tenant string) string {
+	t.Helper()
+	secret, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeysMutex.Lock()
+	apiKeys = append(apiKeys, &APIKey{ID: len(apiKeys) + 1, Name: tenant + " " + role, Role: role, Tenant: tenant,
+		Prefix: secret[:8], CreatedAt: time.Now().UTC(), hash: hashAPIKey(secret)})
+	apiKeysMutex.Unlock()
+	return secret
+}
+
+func serveAs(handler http.Handler, secret, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+secret)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestTenantDeleteRemovesUsersAndHooks(t *testing.T) {
+	handler := tenantServer(t, "acme", "globex")
+	admin := addTestKey(t, RoleAdmin, "")
+	acme := addTestKey(t, RoleWrite, "acme")
+	addTestKey(t, RoleWrite, "globex")
+	usersMutex.Lock()
+	users = []*User{{ID: 1, Email: "ada@acme.test", Role: RoleWrite, Tenant: "acme"}, {ID: 2, Email: "grace@globex.test", Role: RoleWrite, Tenant: "globex"}}
+	usersMutex.Unlock()
+	hooksMutex.Lock()
+	hooks = []Hook{{ID: 1, TargetURL: "https://93.184.216.34/", Tenant: "acme"}, {ID: 2, TargetURL: "https://93.184.216.34/", Tenant: "globex"}}
+	hooksMutex.Unlock()
+	if w := serveAs(handler, acme, "POST", "/students", `{"name":"Ada","age":20,"email":"ada@example.com"}`); w.Code != http.StatusCreated {
+		t.Fatalf("creating a student answered %d: %s", w.Code, w.Body)
+	}
+
+	if w := serveAs(handler, admin, "DELETE", "/admin/tenants/acme", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting the tenant answered %d: %s", w.Code, w.Body)
+	}
+	if roster, _ := snapshotRoster(); len(roster) != 0 {
+		t.Error("the tenant's students survived")
+	}
+	usersMutex.Lock()
+	if len(users) != 1 || users[0].Tenant != "globex" {
+		t.Errorf("users left: %v", users)
+	}
+	usersMutex.Unlock()
+	hooksMutex.RLock()
+	if len(hooks) != 1 || hooks[0].Tenant != "globex" {
+		t.Errorf("hooks left: %v", hooks)
+	}
+	hooksMutex.RUnlock()
+}
+
+func TestDeletedTenantKeysAreRejected(t *testing.T) {
+	handler := tenantServer(t, "acme")
+	stale := addTestKey(t, RoleWrite, "acme")
+	tenantsMutex.Lock()
+	delete(tenants, "acme")
+	tenantsMutex.Unlock()
+	if w := serveAs(handler, stale, "GET", "/students", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("a deleted tenant's key answered %d", w.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	_ "time/tzdata" // so tenant timezones resolve on hosts without zoneinfo
+)
+
+// TenantSettings are per-tenant presentation settings from the config file.
+// Timestamps are always stored in UTC and only converted on the way out.
+type TenantSettings struct {
+	Timezone string `json:"timezone"` // IANA name, e.g. "Asia/Kolkata"
+	Locale   string `json:"locale"`   // used when Accept-Language names no supported language
+}
+
+// localTimeLayouts are accepted for timestamps without a UTC offset, which are
+// read in the tenant's timezone
+var localTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", time.DateOnly}
+
+// compileTenantSettings checks the config file's tenant settings and returns
+// them as tenant records, ready for applyTenantSettings
+func compileTenantSettings(settings map[string]TenantSettings) (map[string]Tenant, error) {
+	compiled := map[string]Tenant{}
+	for name, tenant := range settings {
+		if !tenantNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("tenant %q: name must be lowercase letters, digits or dashes", name)
+		}
+		record := Tenant{Name: name, Timezone: tenant.Timezone, Locale: tenant.Locale}
+		if err := record.compile(); err != nil {
+			return nil, fmt.Errorf("tenant %q: %v", name, err)
+		}
+		compiled[name] = record
+	}
+	return compiled, nil
+}
+
+// applyTenantSettings updates the timezone and locale of existing tenants and
+// creates the missing ones
+func applyTenantSettings(compiled map[string]Tenant) {
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	for name, record := range compiled {
+		tenant, ok := tenants[name]
+		if !ok {
+			record.Status = TenantActive
+			record.CreatedAt = time.Now().UTC()
+			tenants[name] = &record
+			continue
+		}
+		tenant.Timezone, tenant.location = record.Timezone, record.location
+		tenant.Locale = record.Locale
+	}
+}
+
+// requestTenant returns the tenant of the request's API key, or a zero
+// Tenant in UTC for unscoped requests
+func requestTenant(r *http.Request) Tenant {
+	if tenant, ok := lookupTenant(requestTenantName(r)); ok {
+		return tenant
+	}
+	return Tenant{location: time.UTC}
+}
+
+// tenantLocation is a tenant's timezone, or UTC
+func tenantLocation(name string) *time.Location {
+	if tenant, ok := lookupTenant(name); ok && tenant.location != nil {
+		return tenant.location
+	}
+	return time.UTC
+}
+
+// localTime renders a stored UTC timestamp in the request's tenant timezone
+func localTime(r *http.Request, t time.Time) time.Time {
+	return t.In(requestTenant(r).location)
+}
+
+// parseTime reads an RFC 3339 timestamp, or a local date/time without offset
+// in the request's tenant timezone, and returns it in UTC
+func parseTime(r *http.Request, value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	location := requestTenant(r).location
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
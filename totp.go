@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP follows RFC 6238 with the parameters every authenticator app supports:
+// HMAC-SHA1, 6 digits and a 30 second step
+const (
+	totpIssuer      = "Fealtyx"
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpSkew        = 1 // steps of clock drift accepted either way
+	backupCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode is the code for a time step
+func totpCode(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(step)))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP checks a code against the steps around now and returns the
+// matching step. Steps at or before lastStep are refused, so a code can't be
+// replayed.
+func verifyTOTP(secret []byte, code string, lastStep int64, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateBackupCodes returns single-use codes formatted as XXXXX-XXXXX and
+// their hashes
+func generateBackupCodes() ([]string, []string) {
+	codes := make([]string, backupCodeCount)
+	hashes := make([]string, backupCodeCount)
+	for i := range codes {
+		buf := make([]byte, 7)
+		rand.Read(buf)
+		code := totpEncoding.EncodeToString(buf)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashBackupCode(code)
+	}
+	return codes, hashes
+}
+
+// checkSecondFactorLocked verifies a TOTP or backup code for a user with TOTP
+// enabled, consuming it. Callers must hold usersMutex.
+func checkSecondFactorLocked(user *User, totp, backup string) bool {
+	if totp != "" {
+		step, ok := verifyTOTP(user.totpSecret, totp, user.totpLastStep, time.Now())
+		if ok {
+			user.totpLastStep = step
+		}
+		return ok
+	}
+	if backup != "" {
+		hash := hashBackupCode(backup)
+		for i, candidate := range user.backupCodes {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+				user.backupCodes = append(user.backupCodes[:i], user.backupCodes[i+1:]...)
+				slog.Info("Backup code used", "user", user.ID, "remaining", len(user.backupCodes))
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// signedInUser returns the user behind a login key, or writes an error
+func signedInUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	key := keyFromContext(r.Context())
+	if key == nil || key.UserID == 0 {
+		http.Error(w, "Sign in as a user first", http.StatusForbidden)
+		return 0, false
+	}
+	return key.UserID, true
+}
+
+// handleTOTPEnroll starts enrollment with a new secret. The provisioning URI
+// is what authenticator apps scan as a QR code.
+func handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	usersMutex.Lock()
+	user := findUserLocked(userID)
+	if user == nil {
+		usersMutex.Unlock()
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.TOTPEnabled {
+		usersMutex.Unlock()
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	user.pendingTOTPSecret = secret
+	email := user.Email
+	usersMutex.Unlock()
+	saveAuthState()
+
+	encoded := totpEncoding.EncodeToString(secret)
+	query := url.Values{}
+	query.Set("secret", encoded)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + totpIssuer + ":" + email, RawQuery: query.Encode()}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"secret": encoded, "provisioning_uri": uri.String()})
+}
+
+// TOTPConfirmRequest is the body of POST /auth/totp/confirm
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// handleTOTPConfirm enables two-factor authentication once the user proves
+// their app produces codes, and returns backup codes, shown only here
+func handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	userID, ok := signedInUser(w, r)
+	if !ok {
+		return
+	}
+	var input TOTPConfirmRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	codes, hashes := generateBackupCodes()
+
+	usersMutex.Lock()
+	user := findUserLocked(userID)
+	if user == nil || user.pendingTOTPSecret == nil {
+		usersMutex.Unlock()
+		http.Error(w, "Start enrollment first", http.StatusConflict)
+		return
+	}
+	step, ok := verifyTOTP(user.pendingTOTPSecret, input.Code, 0, time.Now())
+	if !ok {
+		usersMutex.Unlock()
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	user.totpSecret, user.pendingTOTPSecret = user.pendingTOTPSecret, nil
+	user.totpLastStep = step
+	user.backupCodes = hashes
+	user.TOTPEnabled = true
+	usersMutex.Unlock()
+	slog.Info("Two-factor authentication enabled", "user", userID)
+
+	// Keys issued before enrollment only allowed enrolling
+	apiKeysMutex.Lock()
+	for _, key := range apiKeys {
+		if key.UserID == userID {
+			key.enrollOnly = false
+		}
+	}
+	apiKeysMutex.Unlock()
+	saveAuthState()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"enabled": true, "backup_codes": codes})
+}
+
+// handleTOTPReset turns off two-factor authentication for a user who lost
+// their device. Admin users must enroll again at their next sign-in.
+func handleTOTPReset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	usersMutex.Lock()
+	user := findUserLocked(id)
+	var result User
+	if user != nil {
+		user.TOTPEnabled = false
+		user.totpSecret, user.pendingTOTPSecret, user.backupCodes = nil, nil, nil
+		result = *user
+	}
+	usersMutex.Unlock()
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	revokeUserKeys(id)
+	slog.Info("Two-factor authentication reset", "user", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
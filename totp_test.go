@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 vectors from RFC 6238 appendix B, which uses 8 digits; codes
+// here are their last 6
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	for _, vector := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		want := vector.code[len(vector.code)-totpDigits:]
+		if got := totpCode(secret, vector.unix/totpPeriod); got != want {
+			t.Errorf("code at %d is %s, want %s", vector.unix, got, want)
+		}
+		step, ok := verifyTOTP(secret, want, 0, time.Unix(vector.unix, 0))
+		if !ok || step != vector.unix/totpPeriod {
+			t.Errorf("code at %d didn't verify", vector.unix)
+		}
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	for offset, ok := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		if _, verified := verifyTOTP(secret, totpCode(secret, current+offset), 0, now); verified != ok {
+			t.Errorf("the code %d steps away verified: %v", offset, verified)
+		}
+	}
+	// The replay guard refuses the step already used and any before it
+	if _, ok := verifyTOTP(secret, totpCode(secret, current), current, now); ok {
+		t.Error("a code was accepted twice")
+	}
+	if _, ok := verifyTOTP(secret, totpCode(secret, current-1), current, now); ok {
+		t.Error("an older code was accepted after a newer one")
+	}
+	if _, ok := verifyTOTP(secret, totpCode(secret, current+1), current, now); !ok {
+		t.Error("the next code was refused")
+	}
+}
+
+func TestSecondFactorIsSingleUse(t *testing.T) {
+	codes, hashes := generateBackupCodes()
+	if len(codes) != backupCodeCount {
+		t.Fatalf("generated %d backup codes", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Fatalf("backup code %q is malformed or repeated", code)
+		}
+		seen[code] = true
+	}
+	user := &User{ID: 1, TOTPEnabled: true, totpSecret: []byte("12345678901234567890"), backupCodes: hashes}
+
+	code := totpCode(user.totpSecret, time.Now().Unix()/totpPeriod)
+	if !checkSecondFactorLocked(user, code, "") {
+		t.Fatal("the current code was refused")
+	}
+	if checkSecondFactorLocked(user, code, "") {
+		t.Error("the current code was accepted twice")
+	}
+
+	// Backup codes are read without the dash and in any case
+	if !checkSecondFactorLocked(user, "", strings.ToLower(strings.ReplaceAll(codes[3], "-", ""))) {
+		t.Fatal("a backup code was refused")
+	}
+	if checkSecondFactorLocked(user, "", codes[3]) {
+		t.Error("a backup code was accepted twice")
+	}
+	if len(user.backupCodes) != backupCodeCount-1 {
+		t.Errorf("%d backup codes left after using one", len(user.backupCodes))
+	}
+	if !checkSecondFactorLocked(user, "", codes[4]) {
+		t.Error("another backup code was refused after one was used")
+	}
+	if checkSecondFactorLocked(user, "", "AAAAA-AAAAA") || checkSecondFactorLocked(user, "", "") {
+		t.Error("an unknown backup code was accepted")
+	}
+}
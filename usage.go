@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UsageDay is one tenant's metered usage on one UTC day
+type UsageDay struct {
+	Date         string `json:"date"`
+	APICalls     int64  `json:"api_calls"`
+	LLMTokens    int64  `json:"llm_tokens"`
+	StorageBytes int64  `json:"storage_bytes"` // the largest roster size seen that day
+}
+
+// TenantUsage is a tenant's usage for a month. Storage is billed on the
+// month's peak.
+type TenantUsage struct {
+	Tenant           string     `json:"tenant"`
+	Plan             string     `json:"plan"`
+	APICalls         int64      `json:"api_calls"`
+	LLMTokens        int64      `json:"llm_tokens"`
+	PeakStorageBytes int64      `json:"peak_storage_bytes"`
+	Days             []UsageDay `json:"days"`
+}
+
+// usageRetention is how long daily usage is kept, long enough to re-issue
+// last year's invoices
+const usageRetention = 400 * 24 * time.Hour
+
+var (
+	usage      = map[string]map[string]*UsageDay{} // tenant -> date -> usage
+	usageMutex sync.Mutex
+)
+
+// usageDayLocked returns today's record for a tenant. Callers must hold
+// usageMutex.
+func usageDayLocked(tenant string, now time.Time) *UsageDay {
+	date := now.UTC().Format(time.DateOnly)
+	days := usage[tenant]
+	if days == nil {
+		days = map[string]*UsageDay{}
+		usage[tenant] = days
+	}
+	day := days[date]
+	if day == nil {
+		day = &UsageDay{Date: date}
+		days[date] = day
+	}
+	return day
+}
+
+// meterAPICall counts a request made with a tenant's API key
+func meterAPICall(tenant string) {
+	if tenant == "" {
+		return
+	}
+	usageMutex.Lock()
+	usageDayLocked(tenant, time.Now()).APICalls++
+	usageMutex.Unlock()
+}
+
+// meterLLMTokens counts prompt and completion tokens used for a tenant's student
+func meterLLMTokens(tenant string, tokens int) {
+	if tenant == "" || tokens <= 0 {
+		return
+	}
+	usageMutex.Lock()
+	usageDayLocked(tenant, time.Now()).LLMTokens += int64(tokens)
+	usageMutex.Unlock()
+}
+
+// meterStorage records the JSON size of each tenant's students, keeping the
+// day's peak
+func meterStorage() {
+	sizes := map[string]int64{}
+	roster, _ := snapshotRoster()
+	for _, student := range roster {
+		if student.Tenant == "" {
+			continue
+		}
+		data, _ := json.Marshal(student)
+		sizes[student.Tenant] += int64(len(data))
+	}
+	tenantsMutex.RLock()
+	for name := range tenants {
+		if _, ok := sizes[name]; !ok {
+			sizes[name] = 0
+		}
+	}
+	tenantsMutex.RUnlock()
+
+	now := time.Now()
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+	for tenant, size := range sizes {
+		day := usageDayLocked(tenant, now)
+		day.StorageBytes = max(day.StorageBytes, size)
+	}
+	cutoff := now.Add(-usageRetention).UTC().Format(time.DateOnly)
+	for _, days := range usage {
+		for date := range days {
+			if date < cutoff {
+				delete(days, date)
+			}
+		}
+	}
+}
+
+// runUsageMeter samples storage every interval
+func runUsageMeter(interval time.Duration) {
+	meterStorage()
+	sampleStorage(time.Now())
+	for now := range time.Tick(interval) {
+		meterStorage()
+		sampleStorage(now)
+	}
+}
+
+// monthlyUsage aggregates the daily records of a month ("2006-01")
+func monthlyUsage(month string) []TenantUsage {
+	usageMutex.Lock()
+	var result []TenantUsage
+	for tenant, days := range usage {
+		entry := TenantUsage{Tenant: tenant, Days: []UsageDay{}}
+		for date, day := range days {
+			if !strings.HasPrefix(date, month+"-") {
+				continue
+			}
+			entry.APICalls += day.APICalls
+			entry.LLMTokens += day.LLMTokens
+			entry.PeakStorageBytes = max(entry.PeakStorageBytes, day.StorageBytes)
+			entry.Days = append(entry.Days, *day)
+		}
+		if len(entry.Days) > 0 {
+			result = append(result, entry)
+		}
+	}
+	usageMutex.Unlock()
+
+	for i := range result {
+		if tenant, ok := lookupTenant(result[i].Tenant); ok {
+			result[i].Plan = tenant.Plan
+		}
+		sort.Slice(result[i].Days, func(a, b int) bool { return result[i].Days[a].Date < result[i].Days[b].Date })
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tenant < result[j].Tenant })
+	return result
+}
+
+// usageMonth reads ?month=YYYY-MM, defaulting to the current month
+func usageMonth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		return time.Now().UTC().Format("2006-01"), true
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		http.Error(w, "Invalid month: must be YYYY-MM", http.StatusBadRequest)
+		return "", false
+	}
+	return month, true
+}
+
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	month, ok := usageMonth(w, r)
+	if !ok {
+		return
+	}
+	meterStorage()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month":   month,
+		"tenants": append([]TenantUsage{}, monthlyUsage(month)...),
+	})
+}
+
+// handleUsageCSV serves one row per tenant for the month, for invoicing
+func handleUsageCSV(w http.ResponseWriter, r *http.Request) {
+	month, ok := usageMonth(w, r)
+	if !ok {
+		return
+	}
+	meterStorage()
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+month+`.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"month", "tenant", "plan", "api_calls", "llm_tokens", "peak_storage_bytes"})
+	for _, entry := range monthlyUsage(month) {
+		out.Write([]string{
+			month,
+			entry.Tenant,
+			entry.Plan,
+			strconv.FormatInt(entry.APICalls, 10),
+			strconv.FormatInt(entry.LLMTokens, 10),
+			strconv.FormatInt(entry.PeakStorageBytes, 10),
+		})
+	}
+	out.Flush()
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// User is a person who signs in with an email and password. Signing in
+// starts a session, whose access keys carry the user's role and tenant.
+type User struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+
+	TOTPEnabled bool `json:"totp_enabled"`
+
+	passwordHash      string
+	totpSecret        []byte
+	pendingTOTPSecret []byte // awaiting confirmation with a first code
+	totpLastStep      int64
+	backupCodes       []string // hashes of unused codes
+}
+
+// passwordReset is an outstanding reset token, stored by hash
+type passwordReset struct {
+	userID    int
+	expiresAt time.Time
+}
+
+const (
+	// The argon2id parameters follow the OWASP recommendation: 19 MiB of
+	// memory, two passes and one thread
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2KeyLen  = 32
+
+	minPasswordLength = 12
+	maxPasswordLength = 128
+	passwordResetTTL  = time.Hour
+)
+
+var (
+	users          []*User
+	userSeq        int
+	usersMutex     sync.Mutex
+	passwordResets = map[string]passwordReset{}
+
+	// passwordResetURL is the page that takes a reset token, from the config
+	// file. Without it the email only contains the token.
+	passwordResetURL string
+
+	errInvalidCredentials = errors.New("invalid email or password")
+
+	// dummyPasswordHash is checked against when the email is unknown, so
+	// login takes as long for unknown users as for wrong passwords
+	dummyPasswordHash = hashPassword("not a password anyone has")
+)
+
+// hashPassword returns the password's argon2id hash in the PHC string format,
+// "$argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>"
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+// checkPassword checks a password against a hash from hashPassword. The
+// parameters are read from the hash, so hashes made with older settings keep
+// working.
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil || passes == 0 || threads == 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, passes, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be %d to %d characters", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// findUserLocked looks up a user by ID. Callers must hold usersMutex.
+func findUserLocked(id int) *User {
+	for _, user := range users {
+		if user.ID == id {
+			return user
+		}
+	}
+	return nil
+}
+
+// findUserByEmailLocked matches emails case-insensitively. Callers must hold
+// usersMutex.
+func findUserByEmailLocked(email string) *User {
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	return nil
+}
+
+// revokeUserKeys ends a user's sessions and removes the API keys issued by
+// signing in as them
+func revokeUserKeys(userID int) {
+	revokeSessions(func(s *Session) bool { return s.UserID == userID })
+	apiKeysMutex.Lock()
+	kept := apiKeys[:0]
+	for _, key := range apiKeys {
+		if key.UserID != userID {
+			kept = append(kept, key)
+		}
+	}
+	apiKeys = kept
+	apiKeysMutex.Unlock()
+	saveAuthState()
+}
+
+// RegisterRequest is the body of POST /auth/register
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Tenant   string `json:"tenant"`
+}
+
+// handleRegister creates a user. Only admins can register users; there is no
+// open registration.
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	key := keyFromContext(r.Context())
+	if key == nil || key.Role != RoleAdmin {
+		http.Error(w, "Only admins can register users", http.StatusForbidden)
+		return
+	}
+
+	var input RegisterRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	address, err := mail.ParseAddress(input.Email)
+	if err != nil || address.Name != "" {
+		http.Error(w, "email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(input.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validRole(input.Role) {
+		http.Error(w, "role must be one of admin, write or read", http.StatusBadRequest)
+		return
+	}
+	if input.Tenant == "" {
+		input.Tenant = key.Tenant
+	}
+	if _, ok := lookupTenant(input.Tenant); input.Tenant != "" && !ok {
+		http.Error(w, "Unknown tenant", http.StatusBadRequest)
+		return
+	}
+	// users sign in with their role's scopes, so they get no more than this key has
+	if err := checkGrant(key, input.Role, nil, input.Tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	user := &User{
+		Email:        address.Address,
+		Role:         input.Role,
+		Tenant:       input.Tenant,
+		CreatedAt:    time.Now().UTC(),
+		passwordHash: hashPassword(input.Password),
+	}
+	usersMutex.Lock()
+	if findUserByEmailLocked(user.Email) != nil {
+		usersMutex.Unlock()
+		http.Error(w, "A user with this email already exists", http.StatusConflict)
+		return
+	}
+	userSeq++
+	user.ID = userSeq
+	users = append(users, user)
+	result := *user
+	usersMutex.Unlock()
+	saveAuthState()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// LoginRequest is the body of POST /auth/login
+type LoginRequest struct {
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	TOTPCode   string `json:"totp_code"`
+	BackupCode string `json:"backup_code"`
+	Cookie     bool   `json:"cookie"` // sign in a browser with cookies, see csrf.go
+}
+
+// handleLogin checks a user's password, and second factor if they enrolled
+// one, and starts a session. Admin users without a second factor only get a
+// key that can enroll one.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var input LoginRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usersMutex.Lock()
+	var user User
+	var hash string
+	if found := findUserByEmailLocked(input.Email); found != nil {
+		user, hash = *found, found.passwordHash
+	}
+	usersMutex.Unlock()
+
+	if hash == "" {
+		checkPassword(dummyPasswordHash, input.Password)
+		http.Error(w, errInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !checkPassword(hash, input.Password) || user.Disabled {
+		slog.Warn("Failed login", "user", user.ID, "disabled", user.Disabled)
+		http.Error(w, errInvalidCredentials.Error(), http.StatusUnauthorized)
+		return
+	}
+	if user.TOTPEnabled {
+		if input.TOTPCode == "" && input.BackupCode == "" {
+			http.Error(w, "Two-factor code required", http.StatusUnauthorized)
+			return
+		}
+		usersMutex.Lock()
+		found := findUserLocked(user.ID)
+		ok := found != nil && found.TOTPEnabled && checkSecondFactorLocked(found, input.TOTPCode, input.BackupCode)
+		usersMutex.Unlock()
+		if !ok {
+			slog.Warn("Failed two-factor check", "user", user.ID)
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	tokens, err := startSession(user, r)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	if input.Cookie {
+		// The page only needs the CSRF token; the secrets stay in HttpOnly cookies
+		setSessionCookies(w, r, tokens, tokens.RefreshExpiresAt)
+		tokens.Key, tokens.RefreshToken = "", ""
+	} else {
+		tokens.CSRFToken = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// PasswordResetRequest is the body of POST /auth/password-reset
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// handlePasswordResetRequest emails a reset token. It answers 202 whether or
+// not the email is registered, so it can't be used to discover accounts.
+func handlePasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	var input PasswordResetRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usersMutex.Lock()
+	user := findUserByEmailLocked(input.Email)
+	var token string
+	if user != nil && !user.Disabled {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		token = base64.RawURLEncoding.EncodeToString(buf)
+		now := time.Now()
+		for hash, reset := range passwordResets {
+			if reset.userID == user.ID || now.After(reset.expiresAt) {
+				delete(passwordResets, hash)
+			}
+		}
+		passwordResets[hashAPIKey(token)] = passwordReset{userID: user.ID, expiresAt: now.Add(passwordResetTTL)}
+	}
+	var email string
+	if user != nil {
+		email = user.Email
+	}
+	usersMutex.Unlock()
+
+	if token != "" {
+		body := "Use this token to choose a new password within the next hour:\n\n" + token + "\n"
+		settings.mu.RLock()
+		resetURL := passwordResetURL
+		settings.mu.RUnlock()
+		if resetURL != "" {
+			body = "Choose a new password within the next hour:\n\n" + resetURL + "?token=" + url.QueryEscape(token) + "\n"
+		}
+		body += "\nIf you did not ask to reset your password, ignore this email.\n"
+		if err := sendMail(email, "Reset your password", body); err != nil {
+			slog.Error("Failed to send password reset", "error", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "If the account exists, a reset email has been sent"})
+}
+
+// PasswordResetConfirmRequest is the body of POST /auth/password-reset/confirm
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// handlePasswordResetConfirm sets a new password with a reset token and signs
+// the user out everywhere
+func handlePasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	var input PasswordResetConfirmRequest
+	if err := decodeJSON(w, r, &input); err != nil {
+		http.Error(w, "Invalid JSON data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(input.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passwordHash := hashPassword(input.Password)
+
+	hash := hashAPIKey(input.Token)
+	usersMutex.Lock()
+	reset, ok := passwordResets[hash]
+	delete(passwordResets, hash)
+	var user *User
+	if ok && time.Now().Before(reset.expiresAt) {
+		user = findUserLocked(reset.userID)
+	}
+	if user == nil || user.Disabled {
+		usersMutex.Unlock()
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	user.passwordHash = passwordHash
+	usersMutex.Unlock()
+	saveAuthState()
+
+	revokeUserKeys(user.ID)
+	slog.Info("Password reset", "user", user.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUserList lists the users, only its own tenant's to a tenant's key
+func handleUserList(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenantName(r)
+	usersMutex.Lock()
+	result := make([]User, 0, len(users))
+	for _, user := range users {
+		if tenant == "" || user.Tenant == tenant {
+			result = append(result, *user)
+		}
+	}
+	usersMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// userTenant is the tenant of the user named by the request's {id}
+func userTenant(r *http.Request) (string, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return "", false
+	}
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	if user := findUserLocked(id); user != nil {
+		return user.Tenant, true
+	}
+	return "", false
+}
+
+// setUserDisabled disables or re-enables a user. Disabling also revokes the
+// keys they signed in with.
+func setUserDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+
+		usersMutex.Lock()
+		user := findUserLocked(id)
+		var result User
+		if user != nil {
+			user.Disabled = disabled
+			result = *user
+		}
+		usersMutex.Unlock()
+		if user == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		saveAuthState()
+		if disabled {
+			revokeUserKeys(id)
+		}
+		slog.Info("User updated", "user", id, "disabled", disabled)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash := hashPassword("correct horse battery")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Fatalf("hash %q isn't argon2id with the OWASP parameters", hash)
+	}
+	if !checkPassword(hash, "correct horse battery") {
+		t.Error("the password doesn't match its own hash")
+	}
+	if checkPassword(hash, "correct horse battery ") {
+		t.Error("a different password matched")
+	}
+	if hashPassword("correct horse battery") == hash {
+		t.Error("two hashes of one password share a salt")
+	}
+
+	// Hashes keep the parameters they were made with
+	salt := []byte("0123456789abcdef")
+	old := fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s", base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("correct horse battery"), salt, 1, 64, 1, 32)))
+	if !checkPassword(old, "correct horse battery") {
+		t.Error("a hash with other parameters didn't match")
+	}
+
+	for _, malformed := range []string{
+		"",
+		"pbkdf2-sha256$600000$AAAA$AAAA",
+		"$argon2i$v=19$m=64,t=1,p=1$AAAA$AAAA",
+		"$argon2id$v=16$m=64,t=1,p=1$AAAA$AAAA",
+		"$argon2id$v=19$m=64,t=0,p=1$AAAA$AAAA",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$AAAA",
+		"$argon2id$v=19$m=64,t=1,p=1$AAAA$",
+	} {
+		if checkPassword(malformed, "correct horse battery") {
+			t.Errorf("malformed hash %q matched", malformed)
+		}
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	handler := tenantServer(t, "acme")
+	admin := addTestKey(t, RoleAdmin, "")
+	writer := addTestKey(t, RoleWrite, "")
+
+	register := `{"email":"ada@example.com","password":"correct horse battery","role":"write","tenant":"acme"}`
+	for _, c := range []struct {
+		secret, body string
+		status       int
+	}{
+		{writer, register, http.StatusForbidden},
+		{admin, `{"email":"ada@example.com","password":"short","role":"write"}`, http.StatusBadRequest},
+		{admin, `{"email":"Ada <ada@example.com>","password":"correct horse battery","role":"write"}`, http.StatusBadRequest},
+		{admin, `{"email":"ada@example.com","password":"correct horse battery","role":"owner"}`, http.StatusBadRequest},
+		{admin, `{"email":"ada@example.com","password":"correct horse battery","role":"write","tenant":"globex"}`, http.StatusBadRequest},
+		{admin, register, http.StatusCreated},
+		{admin, strings.Replace(register, "ada@", "ADA@", 1), http.StatusConflict},
+	} {
+		if w := serveAs(handler, c.secret, "POST", "/auth/register", c.body); w.Code != c.status {
+			t.Fatalf("registering %s answered %d, want %d: %s", c.body, w.Code, c.status, w.Body)
+		}
+	}
+
+	for _, body := range []string{
+		`{"email":"ada@example.com","password":"wrong horse battery"}`,
+		`{"email":"grace@example.com","password":"correct horse battery"}`,
+	} {
+		if w := serveAs(handler, "", "POST", "/auth/login", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("logging in with %s answered %d", body, w.Code)
+		}
+	}
+	tokens := login(t, handler, `{"email":"ADA@example.com","password":"correct horse battery"}`)
+	if tokens.Tenant != "acme" || tokens.Role != RoleWrite || !strings.HasPrefix(tokens.RefreshToken, "fxr_") {
+		t.Fatalf("signed in as %+v", tokens)
+	}
+	if w := serveAs(handler, tokens.Key, "POST", "/students", `{"name":"Ada","age":20,"email":"ada@example.com"}`); w.Code != http.StatusCreated {
+		t.Fatalf("the access key couldn't create a student: %d %s", w.Code, w.Body)
+	}
+}
+
+func login(t *testing.T, handler http.Handler, body string) SessionTokens {
+	t.Helper()
+	w := serveAs(handler, "", "POST", "/auth/login", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("logging in answered %d: %s", w.Code, w.Body)
+	}
+	var tokens SessionTokens
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestAuthStateSurvivesRestart(t *testing.T) {
+	handler := tenantServer(t, "acme")
+	log, err := openWAL(filepath.Join(t.TempDir(), "students.wal"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal = log
+	t.Cleanup(func() {
+		wal = nil
+		log.file.Close()
+	})
+
+	admin := addTestKey(t, RoleAdmin, "")
+	w := serveAs(handler, admin, "POST", "/admin/api-keys", `{"name":"ci","role":"read","tenant":"acme"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating a key answered %d: %s", w.Code, w.Body)
+	}
+	var issued IssuedAPIKey
+	json.Unmarshal(w.Body.Bytes(), &issued)
+	if w := serveAs(handler, admin, "POST", "/auth/register",
+		`{"email":"ada@example.com","password":"correct horse battery","role":"write","tenant":"acme"}`); w.Code != http.StatusCreated {
+		t.Fatalf("registering answered %d: %s", w.Code, w.Body)
+	}
+	tokens := login(t, handler, `{"email":"ada@example.com","password":"correct horse battery"}`)
+
+	// A restart starts from nothing but the files beside the log
+	resetTenantState()
+	if err := restoreAuthState(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveAs(handler, issued.Key, "GET", "/students", ""); w.Code != http.StatusOK {
+		t.Errorf("the API key answered %d after a restart", w.Code)
+	}
+	if w := serveAs(handler, tokens.Key, "GET", "/students", ""); w.Code != http.StatusOK {
+		t.Errorf("the access key answered %d after a restart", w.Code)
+	}
+	if w := serveAs(handler, "", "POST", "/auth/refresh", fmt.Sprintf(`{"refresh_token":%q}`, tokens.RefreshToken)); w.Code != http.StatusOK {
+		t.Errorf("the refresh token answered %d after a restart: %s", w.Code, w.Body)
+	}
+	login(t, handler, `{"email":"ada@example.com","password":"correct horse battery"}`)
+}
@@ -0,0 +1,116 @@
+// Package validation holds the rules for student data, apart from any
+// transport, so every API that accepts students checks them the same way.
+// Failures are *FieldError, which map to HTTP 400 Bad Request and gRPC
+// INVALID_ARGUMENT.
+//
+// Rules that depend on server state, such as a tenant's custom fields or
+// email policy, stay with the store.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// MaxAge is the oldest age a student may have
+const MaxAge = 150
+
+// FieldError is a field that breaks a rule. Message is the text clients
+// see, which the server also uses to look up translations.
+type FieldError struct {
+	Field   string // JSON name, e.g. birth_date
+	Rule    string // required, range, format or future
+	Message string
+}
+
+func (e *FieldError) Error() string { return e.Message }
+
+// Code is a gRPC status code, numbered as in google.golang.org/grpc/codes
+type Code uint32
+
+const (
+	CodeOK              Code = 0
+	CodeUnknown         Code = 2
+	CodeInvalidArgument Code = 3
+)
+
+// HTTPStatus is the status a transport should answer err with: 400 for
+// validation failures and 500 for anything else
+func HTTPStatus(err error) int {
+	var invalid *FieldError
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCCode is HTTPStatus for gRPC
+func GRPCCode(err error) Code {
+	var invalid *FieldError
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.As(err, &invalid):
+		return CodeInvalidArgument
+	}
+	return CodeUnknown
+}
+
+// Student is the part of a student these rules check
+type Student struct {
+	Name       string
+	Age        int
+	Email      string
+	BirthDate  string // YYYY-MM-DD, optional
+	EnrolledOn string // YYYY-MM-DD, optional
+
+	HasLocation bool
+	Lat, Lng    float64
+}
+
+// ValidateStudent checks a student and returns the first broken rule
+func ValidateStudent(student Student, now time.Time) error {
+	if student.Name == "" {
+		return &FieldError{Field: "name", Rule: "required", Message: "name is required"}
+	}
+	if student.Age <= 0 || student.Age > MaxAge {
+		return &FieldError{Field: "age", Rule: "range", Message: fmt.Sprintf("age must be between 1 and %d", MaxAge)}
+	}
+	if student.Email == "" {
+		return &FieldError{Field: "email", Rule: "required", Message: "email is required"}
+	}
+	if student.HasLocation {
+		if err := Location("location", student.Lat, student.Lng); err != nil {
+			return err
+		}
+	}
+	if student.BirthDate != "" {
+		birthDate, err := time.Parse(time.DateOnly, student.BirthDate)
+		if err != nil {
+			return &FieldError{Field: "birth_date", Rule: "format", Message: "birth_date must be a date such as 2010-04-23"}
+		}
+		if birthDate.After(now) {
+			return &FieldError{Field: "birth_date", Rule: "future", Message: "birth_date must not be in the future"}
+		}
+	}
+	if student.EnrolledOn != "" {
+		if _, err := time.Parse(time.DateOnly, student.EnrolledOn); err != nil {
+			return &FieldError{Field: "enrolled_on", Rule: "format", Message: "enrolled_on must be a date such as 2024-09-01"}
+		}
+	}
+	return nil
+}
+
+// Location checks a position in decimal degrees
+func Location(field string, lat, lng float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 || math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return &FieldError{Field: field, Rule: "range", Message: "lat must be between -90 and 90 and lng between -180 and 180"}
+	}
+	return nil
+}
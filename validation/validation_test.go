@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestValidateStudent(t *testing.T) {
+	now := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	valid := Student{Name: "Lisa", Age: 8, Email: "lisa@example.com", BirthDate: "2016-05-09", EnrolledOn: "2024-09-01",
+		HasLocation: true, Lat: 44.05, Lng: -123.09}
+
+	for _, tt := range []struct {
+		name   string
+		change func(*Student)
+		field  string // empty if the student is valid
+		rule   string
+	}{
+		{"valid", func(*Student) {}, "", ""},
+		{"optional fields left out", func(s *Student) { *s = Student{Name: "Lisa", Age: 8, Email: "lisa@example.com"} }, "", ""},
+		{"missing name", func(s *Student) { s.Name = "" }, "name", "required"},
+		{"zero age", func(s *Student) { s.Age = 0 }, "age", "range"},
+		{"age past the maximum", func(s *Student) { s.Age = MaxAge + 1 }, "age", "range"},
+		{"oldest age", func(s *Student) { s.Age = MaxAge }, "", ""},
+		{"missing email", func(s *Student) { s.Email = "" }, "email", "required"},
+		{"latitude out of range", func(s *Student) { s.Lat = 91 }, "location", "range"},
+		{"longitude out of range", func(s *Student) { s.Lng = -181 }, "location", "range"},
+		{"NaN latitude", func(s *Student) { s.Lat = math.NaN() }, "location", "range"},
+		{"location not given", func(s *Student) { s.HasLocation, s.Lat = false, 91 }, "", ""},
+		{"malformed birth date", func(s *Student) { s.BirthDate = "09/05/2016" }, "birth_date", "format"},
+		{"birth date in the future", func(s *Student) { s.BirthDate = "2024-09-02" }, "birth_date", "future"},
+		{"birth date today", func(s *Student) { s.BirthDate = "2024-09-01" }, "", ""},
+		{"malformed enrollment date", func(s *Student) { s.EnrolledOn = "2024-13-01" }, "enrolled_on", "format"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			student := valid
+			tt.change(&student)
+			err := ValidateStudent(student, now)
+
+			wantStatus, wantCode := http.StatusOK, CodeOK
+			if tt.field != "" {
+				var invalid *FieldError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("ValidateStudent() = %v, want a *FieldError", err)
+				}
+				if invalid.Field != tt.field || invalid.Rule != tt.rule || invalid.Message == "" {
+					t.Errorf("ValidateStudent() = %+v, want field %s and rule %s", invalid, tt.field, tt.rule)
+				}
+				wantStatus, wantCode = http.StatusBadRequest, CodeInvalidArgument
+			} else if err != nil {
+				t.Fatalf("ValidateStudent() = %v, want nil", err)
+			}
+			if status := HTTPStatus(err); status != wantStatus {
+				t.Errorf("HTTPStatus() = %d, want %d", status, wantStatus)
+			}
+			if code := GRPCCode(err); code != wantCode {
+				t.Errorf("GRPCCode() = %d, want %d", code, wantCode)
+			}
+		})
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	invalid := &FieldError{Field: "name", Rule: "required", Message: "name is required"}
+	for _, tt := range []struct {
+		name   string
+		err    error
+		status int
+		code   Code
+	}{
+		{"no error", nil, http.StatusOK, CodeOK},
+		{"field error", invalid, http.StatusBadRequest, CodeInvalidArgument},
+		{"wrapped field error", fmt.Errorf("student 3: %w", invalid), http.StatusBadRequest, CodeInvalidArgument},
+		{"other error", errors.New("disk full"), http.StatusInternalServerError, CodeUnknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := HTTPStatus(tt.err); status != tt.status {
+				t.Errorf("HTTPStatus() = %d, want %d", status, tt.status)
+			}
+			if code := GRPCCode(tt.err); code != tt.code {
+				t.Errorf("GRPCCode() = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Pages are a layout plus one page template each. Every page defines "title"
+// and "content", and may override "nav". rows.html holds the fragments htmx
+// swaps into the student list.
+//
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var (
+	templateFuncs = template.FuncMap{"asset": assetURL, "htmx": htmxURL}
+
+	views     = map[string]*template.Template{}
+	fragments = template.Must(template.New("").Funcs(templateFuncs).ParseFS(templateFiles, "templates/rows.html"))
+)
+
+func init() {
+	for _, name := range []string{"students", "student", "summary", "public_roster"} {
+		views[name] = template.Must(template.New("").Funcs(templateFuncs).ParseFS(templateFiles, "templates/layout.html", "templates/rows.html", "templates/"+name+".html"))
+	}
+}
+
+// wantsHTML reports whether the client asked for a web page rather than JSON:
+// ?format=html, or a browser's Accept header
+func wantsHTML(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "html"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// negotiateHTML is wantsHTML for routes that serve both JSON and HTML, marking
+// the response as varying by Accept so caches keep the two apart
+func negotiateHTML(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Accept")
+	return wantsHTML(r)
+}
+
+// isHTMX reports whether the request came from htmx on one of our pages,
+// which wants an HTML fragment rather than a whole page
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// renderView writes a page. The template is rendered into memory first so a
+// failure can still become a 500. Pages signed in with cookies get the CSRF
+// token, which htmx sends back on every request.
+func renderView(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	if cookie, err := r.Cookie(csrfCookie); err == nil {
+		data["CSRFToken"] = cookie.Value
+	}
+	render(w, views[name], "layout", data)
+}
+
+// renderFragment writes one of the fragments in rows.html
+func renderFragment(w http.ResponseWriter, name string, data interface{}) {
+	render(w, fragments, name, data)
+}
+
+func render(w http.ResponseWriter, tmpl *template.Template, name string, data interface{}) {
+	var page strings.Builder
+	if err := tmpl.ExecuteTemplate(&page, name, data); err != nil {
+		slog.Error("Failed to render page", "template", name, "error", err)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page.String()))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// writeAheadLog gives the in-memory store durability. Every change is appended
+// to the log and fsynced before it is applied; once the log grows past
+// compactEvery entries the roster is written to a snapshot and the log is
+// truncated. At startup the snapshot is loaded and the log replayed on top.
+type writeAheadLog struct {
+	path         string
+	file         *os.File
+	entries      int
+	compactEvery int
+}
+
+type walSnapshot struct {
+	Seq      int64     `json:"seq"`
+	Students []Student `json:"students"`
+	// LastStudentID keeps the IDs of students deleted before the snapshot
+	// from being assigned again
+	LastStudentID int64 `json:"last_student_id,omitempty"`
+}
+
+var wal *writeAheadLog
+
+func (l *writeAheadLog) snapshotPath() string {
+	return l.path + ".snapshot"
+}
+
+// openWAL restores the roster from the snapshot and log at path and keeps the
+// log open for appending
+func openWAL(path string, compactEvery int) (*writeAheadLog, error) {
+	l := &writeAheadLog{path: path, compactEvery: compactEvery}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	data, err := os.ReadFile(l.snapshotPath())
+	if err == nil {
+		var snapshot walSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("corrupt snapshot %s: %v", l.snapshotPath(), err)
+		}
+		students = snapshot.Students
+		changeSeq = snapshot.Seq
+		rebuildStatsLocked()
+		noteStudentIDLocked(snapshot.LastStudentID)
+		if eventSourced {
+			slog.Warn("The write-ahead log was compacted before; event history starts after the snapshot", "revision", snapshot.Seq)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log: %v", err)
+	}
+	if err := l.replay(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.file = file
+	return l, nil
+}
+
+// replay applies every logged change newer than the snapshot. A torn final
+// line left by a crash mid-write is truncated away.
+func (l *writeAheadLog) replay(file *os.File) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				slog.Warn("Discarding incomplete write-ahead log entry", "offset", offset)
+				return file.Truncate(offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read write-ahead log: %v", err)
+		}
+
+		var change Change
+		if err := json.Unmarshal(bytes.TrimSpace(line), &change); err != nil {
+			return fmt.Errorf("corrupt write-ahead log entry at offset %d: %v", offset, err)
+		}
+		offset += int64(len(line))
+		l.entries++
+
+		// Entries already covered by the snapshot survive a crash between
+		// writing the snapshot and truncating the log
+		if change.ID <= changeSeq {
+			continue
+		}
+		applyChange(change)
+		changes = append(changes, change)
+		changeSeq = change.ID
+	}
+}
+
+func (l *writeAheadLog) append(change Change) error {
+	return l.write(change, true)
+}
+
+// write logs a change, syncing unless the caller will sync a batch of them.
+// If the write or sync fails the log is truncated back to where it was, so a
+// torn or unsynced line is never replayed as a change that was refused.
+func (l *writeAheadLog) write(change Change, sync bool) error {
+	line, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	mark, err := l.mark()
+	if err != nil {
+		return fmt.Errorf("failed to write to write-ahead log: %v", err)
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.rollbackOrLog(mark)
+		return fmt.Errorf("failed to write to write-ahead log: %v", err)
+	}
+	l.entries++
+	if sync {
+		if err := l.sync(); err != nil {
+			l.rollbackOrLog(mark)
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *writeAheadLog) sync() error {
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %v", err)
+	}
+	return nil
+}
+
+// walMark is a position in the log to roll a failed batch back to
+type walMark struct {
+	offset  int64
+	entries int
+}
+
+func (l *writeAheadLog) mark() (walMark, error) {
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	return walMark{offset: offset, entries: l.entries}, err
+}
+
+func (l *writeAheadLog) rollback(mark walMark) error {
+	if err := l.file.Truncate(mark.offset); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(mark.offset, io.SeekStart); err != nil {
+		return err
+	}
+	l.entries = mark.entries
+	return nil
+}
+
+func (l *writeAheadLog) rollbackOrLog(mark walMark) {
+	if err := l.rollback(mark); err != nil {
+		slog.Error("Failed to roll back write-ahead log", "error", err)
+	}
+}
+
+// maybeCompact snapshots the roster once the log is long enough. Callers must
+// hold mutex for writing. A failed compaction leaves the log intact.
+func (l *writeAheadLog) maybeCompact() {
+	if eventSourced || l.compactEvery <= 0 || l.entries < l.compactEvery {
+		return
+	}
+	if err := l.compact(); err != nil {
+		slog.Error("Failed to compact write-ahead log", "error", err)
+	}
+}
+
+func (l *writeAheadLog) compact() error {
+	data, err := json.Marshal(walSnapshot{Seq: changeSeq, Students: students, LastStudentID: lastStudentID})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(l.snapshotPath(), data); err != nil {
+		return err
+	}
+
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.entries = 0
+	return nil
+}
+
+// writeFileAtomic writes a file through a synced temporary file and a
+// rename, so readers and crashes never see half of it
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GET /ws is a WebSocket (RFC 6455) for browser UIs. The server pushes roster
+// changes as they happen, like GET /events?follow=true, and streams summaries
+// the client asks for over the same connection, tagging every message with
+// the request_id the client chose so several can be in flight at once:
+//
+//	-> {"type": "summary", "request_id": "r1", "student_id": 12, "locale": "fr"}
+//	<- {"type": "summary.token", "request_id": "r1", "token": "Alice "}
+//	<- {"type": "summary.done", "request_id": "r1", "summary": "Alice ..."}
+//	-> {"type": "cancel", "request_id": "r1"}
+//	<- {"type": "summary.cancelled", "request_id": "r1"}
+//	<- {"type": "event", "change": {"id": 42, "event": "student.updated", ...}}
+//	<- {"type": "error", "request_id": "r1", "status": 404, "error": "Student not found"}
+
+const (
+	wsMaxMessage      = 64 << 10 // bytes, from the client
+	wsMaxSummaries    = 4        // streaming at once on one connection
+	wsWriteTimeout    = 10 * time.Second
+	wsHandshakeSuffix = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close codes
+const (
+	wsProtocolError   = 1002
+	wsUnsupportedData = 1003
+	wsMessageTooBig   = 1009
+	wsEventsExpired   = 4410 // the change feed no longer reaches back; reload and reconnect
+)
+
+var errWebSocketClosed = errors.New("websocket closed")
+
+// wsConn is the server end of a WebSocket. Reads happen on one goroutine;
+// writes may come from any.
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// WSMessage is any message on /ws, in either direction
+type WSMessage struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	StudentID json.RawMessage `json:"student_id,omitempty"` // a number or a UUID
+	Locale    string          `json:"locale,omitempty"`
+	Token     string          `json:"token,omitempty"`
+	Summary   string          `json:"summary,omitempty"`
+	Warnings  []QuotaWarning  `json:"warnings,omitempty"`
+	Change    *Change         `json:"change,omitempty"`
+	Status    int             `json:"status,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. On failure it has already answered the request.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	challenge := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(challenge); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid websocket key")
+	}
+
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	accept := sha1.Sum([]byte(challenge + wsHandshakeSuffix))
+	header := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(header)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: buffered.Reader}, nil
+}
+
+// writeFrame sends a single unfragmented frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeJSON sends a message as a text frame
+func (c *wsConn) writeJSON(message WSMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, data)
+}
+
+// close sends a close frame and drops the connection
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readMessage returns the next data message, reassembling fragments and
+// answering pings on the way. It returns errWebSocketClosed once the client
+// closes the connection.
+func (c *wsConn) readMessage() (opcode byte, message []byte, err error) {
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			return 0, nil, err
+		}
+		final, frameOpcode := head[0]&0x80 != 0, head[0]&0x0F
+		if head[1]&0x80 == 0 {
+			c.close(wsProtocolError, "client frames must be masked")
+			return 0, nil, errWebSocketClosed
+		}
+		length := uint64(head[1] & 0x7F)
+		switch length {
+		case 126:
+			var extended [2]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(extended[:]))
+		case 127:
+			var extended [8]byte
+			if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+				return 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(extended[:])
+		}
+		control := frameOpcode&0x8 != 0
+		if control && (!final || length > 125) {
+			c.close(wsProtocolError, "invalid control frame")
+			return 0, nil, errWebSocketClosed
+		}
+		if length > uint64(wsMaxMessage-len(message)) {
+			c.close(wsMessageTooBig, "messages are limited to "+strconv.Itoa(wsMaxMessage)+" bytes")
+			return 0, nil, errWebSocketClosed
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return 0, nil, err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return 0, nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch frameOpcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			c.conn.Close()
+			return 0, nil, errWebSocketClosed
+		case wsText, wsBinary:
+			if opcode != 0 {
+				c.close(wsProtocolError, "expected a continuation frame")
+				return 0, nil, errWebSocketClosed
+			}
+			opcode, message = frameOpcode, payload
+		case wsContinuation:
+			if opcode == 0 {
+				c.close(wsProtocolError, "unexpected continuation frame")
+				return 0, nil, errWebSocketClosed
+			}
+			message = append(message, payload...)
+		default:
+			c.close(wsProtocolError, "unknown opcode")
+			return 0, nil, errWebSocketClosed
+		}
+		if final && !control {
+			return opcode, message, nil
+		}
+	}
+}
+
+// sameOrigin reports whether a browser's Origin header names this server.
+// Browsers send session cookies with WebSocket handshakes from any page, and
+// CORS doesn't apply to them, so cross-origin handshakes must bring a key.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsSession is one /ws connection
+type wsSession struct {
+	ws      *wsConn
+	ctx     context.Context
+	request *http.Request // the handshake, for the caller's key and language
+	tenant  string
+	key     *APIKey
+	locale  string // from the handshake's Accept-Language
+
+	mu        sync.Mutex
+	summaries map[string]context.CancelFunc // by request_id
+}
+
+// handleWebSocket serves GET /ws. Roster changes are pushed from ?from=<seq>
+// (inclusive), or from the next change when it's omitted.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if requestAPIKey(r) == "" && !sameOrigin(r) {
+		http.Error(w, "Cross-origin WebSocket connections need an API key", http.StatusForbidden)
+		return
+	}
+	mutex.RLock()
+	from := changeSeq + 1
+	mutex.RUnlock()
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid from: must be a positive sequence number", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+	events, signal, ok := eventsAfter(from - 1)
+	if !ok {
+		http.Error(w, "Events before this sequence are no longer retained; load GET /students/export and continue from its revision", http.StatusGone)
+		return
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	session := &wsSession{ws: ws, ctx: ctx, request: r, tenant: requestTenantName(r), key: keyFromContext(r.Context()),
+		locale: requestLocale(r), summaries: map[string]context.CancelFunc{}}
+	go session.pushEvents(from, events, signal, cancel)
+
+	for {
+		opcode, data, err := ws.readMessage()
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				slog.Debug("WebSocket read failed", "error", err)
+			}
+			ws.conn.Close()
+			return
+		}
+		if opcode != wsText {
+			ws.close(wsUnsupportedData, "messages must be JSON text")
+			return
+		}
+		var message WSMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			ws.writeJSON(WSMessage{Type: "error", Status: http.StatusBadRequest, Error: "Invalid JSON data: " + err.Error()})
+			continue
+		}
+		switch message.Type {
+		case "summary":
+			session.startSummary(message)
+		case "cancel":
+			session.mu.Lock()
+			if stop, ok := session.summaries[message.RequestID]; ok {
+				stop()
+			}
+			session.mu.Unlock()
+		default:
+			ws.writeJSON(WSMessage{Type: "error", RequestID: message.RequestID, Status: http.StatusBadRequest,
+				Error: fmt.Sprintf("Unknown message type %q", message.Type)})
+		}
+	}
+}
+
+// pushEvents sends the tenant's roster changes from from onwards, and pings
+// the client while there are none. It ends the session if a write fails or
+// retention overtakes it.
+func (s *wsSession) pushEvents(from int64, events []Change, signal <-chan struct{}, end context.CancelFunc) {
+	defer end()
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		for _, change := range events {
+			if change.ID >= from && visibleTo(s.tenant, change.Student) {
+				if err := s.ws.writeJSON(WSMessage{Type: "event", Change: &change}); err != nil {
+					s.ws.conn.Close()
+					return
+				}
+			}
+			from = change.ID + 1
+		}
+		select {
+		case <-s.ctx.Done():
+			s.ws.conn.Close()
+			return
+		case <-keepAlive.C:
+			if err := s.ws.writeFrame(wsPing, nil); err != nil {
+				s.ws.conn.Close()
+				return
+			}
+			events = nil
+		case <-signal:
+			var ok bool
+			events, signal, ok = eventsAfter(from - 1)
+			if !ok {
+				s.ws.close(wsEventsExpired, "events are no longer retained; reload and reconnect")
+				return
+			}
+		}
+	}
+}
+
+// startSummary checks a summary request and streams it on its own goroutine
+func (s *wsSession) startSummary(message WSMessage) {
+	fail := func(status int, err string) {
+		s.ws.writeJSON(WSMessage{Type: "error", RequestID: message.RequestID, Status: status, Error: err})
+	}
+	if message.RequestID == "" {
+		fail(http.StatusBadRequest, "request_id is required")
+		return
+	}
+	if !featureEnabled(s.ctx, FlagStreamingSummaries) {
+		fail(http.StatusNotFound, localize(s.request, "Streamed summaries aren't enabled"))
+		return
+	}
+	if s.key != nil && !hasScope(s.key.scopes(), ScopeSummariesGenerate) {
+		fail(http.StatusForbidden, "API key is missing the "+ScopeSummariesGenerate+" scope")
+		return
+	}
+	if loadShedder.active.Load() {
+		fail(http.StatusServiceUnavailable, "The server is under heavy load; summaries are temporarily unavailable.")
+		return
+	}
+	locale := s.locale
+	if message.Locale != "" {
+		if _, ok := languageNames[message.Locale]; !ok {
+			fail(http.StatusBadRequest, "Unsupported locale "+message.Locale)
+			return
+		}
+		locale = message.Locale
+	}
+	id, err := parseStudentID(strings.Trim(string(message.StudentID), `"`))
+	if err != nil {
+		fail(errorStatus(err), localize(s.request, err.Error()))
+		return
+	}
+	mutex.RLock()
+	student, ok := findStudent(id)
+	mutex.RUnlock()
+	if !ok || !visibleTo(s.tenant, student) {
+		fail(errorStatus(errStudentNotFound), localize(s.request, errStudentNotFound.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	if _, running := s.summaries[message.RequestID]; running {
+		s.mu.Unlock()
+		fail(http.StatusConflict, "A summary with this request_id is already streaming")
+		return
+	}
+	if len(s.summaries) >= wsMaxSummaries {
+		s.mu.Unlock()
+		fail(http.StatusTooManyRequests, fmt.Sprintf("At most %d summaries can stream at once", wsMaxSummaries))
+		return
+	}
+	ctx, stop := context.WithCancel(s.ctx)
+	s.summaries[message.RequestID] = stop
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.summaries, message.RequestID)
+			s.mu.Unlock()
+			stop()
+		}()
+		summary, err := streamSummary(ctx, student, locale, func(token string) {
+			s.ws.writeJSON(WSMessage{Type: "summary.token", RequestID: message.RequestID, Token: token})
+		})
+		switch {
+		case ctx.Err() != nil:
+			// cancelled by the client, or the connection is gone
+			if s.ctx.Err() == nil {
+				s.ws.writeJSON(WSMessage{Type: "summary.cancelled", RequestID: message.RequestID})
+			}
+		case err != nil:
+			fail(errorStatus(err), errorMessage(s.request, err, "Failed to generate summary"))
+		default:
+			s.ws.writeJSON(WSMessage{Type: "summary.done", RequestID: message.RequestID, Summary: summary,
+				Warnings: quotaWarnings(s.tenant)})
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// maskedFrame builds a frame as a client sends it
+func maskedFrame(final bool, opcode byte, payload []byte) []byte {
+	head := opcode
+	if final {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func FuzzWebSocketFrames(f *testing.F) {
+	text := maskedFrame(true, wsText, []byte(`{"type":"summary","request_id":"r1","student_id":1}`))
+	f.Add(text)
+	f.Add(append(maskedFrame(false, wsText, []byte("frag")), maskedFrame(true, wsContinuation, []byte("ment"))...))
+	f.Add(append(maskedFrame(true, wsPing, []byte("hi")), text...))
+	f.Add(maskedFrame(true, wsClose, []byte{0x03, 0xe8}))
+	f.Add(maskedFrame(true, wsBinary, make([]byte, 300)))
+	f.Add(maskedFrame(false, wsPing, nil))
+	f.Add(maskedFrame(true, wsContinuation, []byte("orphan")))
+	f.Add([]byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'}) // unmasked
+	f.Add([]byte{0x82, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		server, client := net.Pipe()
+		defer client.Close()
+		go io.Copy(io.Discard, client)
+		ws := &wsConn{conn: server, reader: bufio.NewReader(bytes.NewReader(data))}
+		defer server.Close()
+		for {
+			opcode, message, err := ws.readMessage()
+			if err != nil {
+				return
+			}
+			if opcode != wsText && opcode != wsBinary {
+				t.Fatalf("returned a message with opcode %d", opcode)
+			}
+			if len(message) > wsMaxMessage {
+				t.Fatalf("returned a %d byte message", len(message))
+			}
+		}
+	})
+}